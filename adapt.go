@@ -0,0 +1,35 @@
+package vql
+
+import "reflect"
+
+// A Keyer is a value that provides its own lookup of entries by key. Key,
+// KeyStrict, KeyOr, Exists, and Pick use the QueryKey method of a Keyer in
+// place of looking up a struct field or map entry, which allows them to work
+// on ordered maps, generic containers, and other types that do not store
+// their entries in a Go map.
+//
+// QueryKey reports the value associated with key, and whether it is present.
+type Keyer interface {
+	QueryKey(key interface{}) (interface{}, bool)
+}
+
+// A Seqer is a value that provides its own sequence of elements. Queries that
+// apply to arrays, slices, and maps, such as Each, Select, Index, Range, and
+// Count, use the elements reported by the QuerySeq method of a Seqer in place
+// of the elements of its underlying value, so that it is treated as a slice.
+// A container whose elements are keyed can report them as values of type
+// Entry, as the elements of a map are.
+//
+// QuerySeq is called once each time a query visits the value, and the caller
+// does not modify the slice it returns.
+type Seqer interface {
+	QuerySeq() []interface{}
+}
+
+var (
+	keyerType = reflect.TypeOf((*Keyer)(nil)).Elem()
+	seqerType = reflect.TypeOf((*Seqer)(nil)).Elem()
+)
+
+// isSeqer reports whether obj is a Seqer.
+func isSeqer(obj interface{}) bool { _, ok := obj.(Seqer); return ok }
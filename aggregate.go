@@ -0,0 +1,204 @@
+package vql
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"sync"
+)
+
+// Count returns a Query that yields the number of elements in an array, slice,
+// or map, as an int.
+func Count() Query { return countQuery{} }
+
+type countQuery struct{}
+
+func (countQuery) eval(v *value) (*value, error) {
+	if m, ok := v.val.(*sync.Map); ok {
+		return pushValue(v, len(syncMapEntries(m))), nil
+	} else if s, ok := v.val.(Seqer); ok {
+		return pushValue(v, len(s.QuerySeq())), nil
+	}
+	switch rv := indirect(reflect.ValueOf(v.val)); rv.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map:
+		return pushValue(v, rv.Len()), nil
+	}
+	return nil, fmt.Errorf("value of type %T is %w", v.val, ErrNotCollection)
+}
+
+// Sum returns a Query that evaluates q on each element of an array, slice, or
+// map, and yields the sum of the resulting values, which must be numbers.
+// Values of q that are nil are skipped. If all the values have the same type,
+// the sum has that type; otherwise the sum of integers has type int64, and a
+// sum including any floating-point values has type float64. The sum of no
+// values is int64(0). The sum is computed exactly, and it is an error wrapping
+// ErrOverflow if the sum of integers does not fit in the type of the result.
+func Sum(q Query) Query { return aggQuery{Query: q, agg: aggSum} }
+
+// Min returns a Query that evaluates q on each element of an array, slice, or
+// map, and yields the least of the resulting values, ordered as by Lt. Values
+// of q that are nil are skipped. If there are no values, the result is nil.
+func Min(q Query) Query { return aggQuery{Query: q, agg: aggMin} }
+
+// Max returns a Query that evaluates q on each element of an array, slice, or
+// map, and yields the greatest of the resulting values, ordered as by Lt.
+// Values of q that are nil are skipped. If there are no values, the result is
+// nil.
+func Max(q Query) Query { return aggQuery{Query: q, agg: aggMax} }
+
+// Mean returns a Query that evaluates q on each element of an array, slice, or
+// map, and yields the arithmetic mean of the resulting values, which must be
+// numbers, as a float64. Values of q that are nil are skipped. If there are no
+// values, the result is nil. Unlike Sum, Mean does not convert the sum of the
+// values to their type, so it does not overflow.
+func Mean(q Query) Query { return aggQuery{Query: q, agg: aggMean} }
+
+type aggKind int
+
+const (
+	aggSum aggKind = iota
+	aggMin
+	aggMax
+	aggMean
+)
+
+type aggQuery struct {
+	Query
+	agg aggKind
+}
+
+func (a aggQuery) eval(v *value) (*value, error) {
+	var vals []interface{}
+	if err := forEach(v, func(obj interface{}) error {
+		next, err := evalQuery(a.Query, pushValue(v, obj))
+		if err != nil {
+			return err
+		} else if next.val != nil {
+			vals = append(vals, next.val)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	switch a.agg {
+	case aggMin, aggMax:
+		if len(vals) == 0 {
+			return pushValue(v, nil), nil
+		}
+		best := vals[0]
+		for _, val := range vals[1:] {
+			x, y := val, best
+			if a.agg == aggMax {
+				x, y = y, x
+			}
+			less, err := isLessThan(x, y, false)
+			if err != nil {
+				return nil, err
+			} else if less {
+				best = val
+			}
+		}
+		return pushValue(v, best), nil
+
+	case aggMean:
+		if len(vals) == 0 {
+			return pushValue(v, nil), nil
+		}
+		var s numSum
+		if err := s.add(vals); err != nil {
+			return nil, err
+		}
+		return pushValue(v, s.float()/float64(len(vals))), nil
+	}
+	sum, err := sumNumbers(vals)
+	if err != nil {
+		return nil, err
+	}
+	return pushValue(v, sum), nil
+}
+
+// sumNumbers returns the sum of vals, all of which must be numbers. If all the
+// values have the same type, the sum has that type; otherwise it is an int64
+// if all the values are integers, or a float64. It reports an error wrapping
+// ErrOverflow if the sum of integers does not fit in the type of the result.
+func sumNumbers(vals []interface{}) (interface{}, error) {
+	var s numSum
+	if err := s.add(vals); err != nil {
+		return nil, err
+	}
+	if s.isFloat {
+		sum := reflect.ValueOf(s.float())
+		if s.common != nil {
+			sum = sum.Convert(s.common)
+		}
+		return sum.Interface(), nil
+	}
+	t := s.common
+	if t == nil {
+		t = reflect.TypeOf(int64(0))
+	}
+	sum := reflect.New(t).Elem()
+	switch {
+	case isIntLike(t.Kind()) && s.isum.IsInt64() && !sum.OverflowInt(s.isum.Int64()):
+		sum.SetInt(s.isum.Int64())
+	case isUintLike(t.Kind()) && s.isum.IsUint64() && !sum.OverflowUint(s.isum.Uint64()):
+		sum.SetUint(s.isum.Uint64())
+	default:
+		return nil, fmt.Errorf("%w: sum %v does not fit in %v", ErrOverflow, &s.isum, t)
+	}
+	return sum.Interface(), nil
+}
+
+// numSum is the exact sum of a collection of numbers.
+type numSum struct {
+	isum    big.Int      // the sum of the integer values
+	fsum    float64      // the sum of the floating-point values
+	isFloat bool         // whether any of the values is floating-point
+	common  reflect.Type // the type of all the values, or nil if they differ
+}
+
+// add adds vals, all of which must be numbers, to s.
+func (s *numSum) add(vals []interface{}) error {
+	var n big.Int
+	for i, val := range vals {
+		rv := reflect.ValueOf(val)
+		switch k := rv.Kind(); {
+		case isIntLike(k):
+			s.isum.Add(&s.isum, n.SetInt64(rv.Int()))
+		case isUintLike(k):
+			s.isum.Add(&s.isum, n.SetUint64(rv.Uint()))
+		case isFloatLike(k):
+			s.isFloat = true
+			s.fsum += rv.Float()
+		default:
+			return fmt.Errorf("value of type %T is %w", val, ErrNotNumber)
+		}
+		if i == 0 {
+			s.common = rv.Type()
+		} else if rv.Type() != s.common {
+			s.common = nil
+		}
+	}
+	return nil
+}
+
+// float returns the value of s as a float64.
+func (s *numSum) float() float64 {
+	f, _ := new(big.Float).SetInt(&s.isum).Float64()
+	return f + s.fsum
+}
+
+// toFloat reports the value of obj as a float64, if obj is a number.
+func toFloat(obj interface{}) (float64, bool) {
+	rv := reflect.ValueOf(obj)
+	switch k := rv.Kind(); {
+	case isIntLike(k):
+		return float64(rv.Int()), true
+	case isUintLike(k):
+		return float64(rv.Uint()), true
+	case isFloatLike(k):
+		return rv.Float(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,129 @@
+package vql
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Add returns a Query that evaluates each of qs on its input and yields the
+// sum of their values, which must be numbers. The result is 0 if qs is empty.
+//
+// The arithmetic queries Add, Sub, Mul, Div, and Mod combine numbers of any
+// kind. If all the operands are integers, the arithmetic is done in int64,
+// with integer division; otherwise it is done in float64. If all the operands
+// have the same type, the result is converted to that type; otherwise it has
+// type int64 or float64. It is an error if an operand is not a number, or if
+// an integer is divided by zero.
+//
+// For example, Mul(Key("Price"), Key("Quantity")) computes the total cost of
+// an order line.
+func Add(qs ...Query) Query { return arithQuery{op: "+", name: "add", qs: qs} }
+
+// Sub returns a Query that yields the value of a minus the value of b,
+// evaluated on its input. See Add for the rules for arithmetic.
+func Sub(a, b Query) Query { return arithQuery{op: "-", name: "sub", qs: []Query{a, b}} }
+
+// Mul returns a Query that evaluates each of qs on its input and yields the
+// product of their values, which must be numbers. The result is 1 if qs is
+// empty. See Add for the rules for arithmetic.
+func Mul(qs ...Query) Query { return arithQuery{op: "*", name: "mul", qs: qs} }
+
+// Div returns a Query that yields the value of a divided by the value of b,
+// evaluated on its input. See Add for the rules for arithmetic.
+func Div(a, b Query) Query { return arithQuery{op: "/", name: "div", qs: []Query{a, b}} }
+
+// Mod returns a Query that yields the remainder of the value of a divided by
+// the value of b, evaluated on its input. The result has the sign of a. See
+// Add for the rules for arithmetic.
+func Mod(a, b Query) Query { return arithQuery{op: "%", name: "mod", qs: []Query{a, b}} }
+
+type arithQuery struct {
+	op   string // the operator, one of + - * / %
+	name string // the name of the combinator
+	qs   []Query
+}
+
+// errDivZero is reported for an integer division by zero.
+var errDivZero = errors.New("integer division by zero")
+
+func (a arithQuery) eval(v *value) (*value, error) {
+	if len(a.qs) == 0 {
+		if a.op == "*" {
+			return pushValue(v, 1), nil
+		}
+		return pushValue(v, 0), nil
+	}
+	var acc interface{}
+	for i, q := range a.qs {
+		w, err := evalQuery(q, v)
+		if err != nil {
+			return nil, err
+		} else if !isNumberKind(reflect.ValueOf(w.val).Kind()) {
+			return nil, fmt.Errorf("%s operand of type %T is %w", a.name, w.val, ErrNotNumber)
+		}
+		if i == 0 {
+			acc = w.val
+		} else if acc, err = arith(a.op, acc, w.val); err != nil {
+			return nil, err
+		}
+	}
+	return pushValue(v, acc), nil
+}
+
+// arith applies the operator op to the numbers x and y.
+func arith(op string, x, y interface{}) (interface{}, error) {
+	rx, ry := reflect.ValueOf(x), reflect.ValueOf(y)
+	var out reflect.Value
+	if isFloatLike(rx.Kind()) || isFloatLike(ry.Kind()) {
+		fx, _ := toFloat(x)
+		fy, _ := toFloat(y)
+		var r float64
+		switch op {
+		case "+":
+			r = fx + fy
+		case "-":
+			r = fx - fy
+		case "*":
+			r = fx * fy
+		case "/":
+			r = fx / fy
+		case "%":
+			r = math.Mod(fx, fy)
+		}
+		out = reflect.ValueOf(r)
+	} else {
+		ix, iy := toInt64(rx), toInt64(ry)
+		if iy == 0 && (op == "/" || op == "%") {
+			return nil, errDivZero
+		}
+		var r int64
+		switch op {
+		case "+":
+			r = ix + iy
+		case "-":
+			r = ix - iy
+		case "*":
+			r = ix * iy
+		case "/":
+			r = ix / iy
+		case "%":
+			r = ix % iy
+		}
+		out = reflect.ValueOf(r)
+	}
+	if t := rx.Type(); t == ry.Type() {
+		out = out.Convert(t)
+	}
+	return out.Interface(), nil
+}
+
+// toInt64 returns the value of rv, which must have an integer kind, as an
+// int64.
+func toInt64(rv reflect.Value) int64 {
+	if isUintLike(rv.Kind()) {
+		return int64(rv.Uint())
+	}
+	return rv.Int()
+}
@@ -0,0 +1,79 @@
+package vql
+
+// Build returns a Query that constructs a new value from the template tmpl,
+// replacing each Query within the template by its value on the input. The
+// template may be a Query, a map of type map[string]interface{} or Values, or
+// a slice of type []interface{}, whose elements are themselves templates. The
+// maps and slices of the template are copied into the result, with the same
+// types. Any other value in the template is a constant, and is copied to the
+// result unchanged.
+//
+// For example, given an input with fields Name and Email,
+//
+//	vql.Build(map[string]interface{}{
+//	   "user": map[string]interface{}{
+//	      "name":     vql.Key("Name"),
+//	      "contacts": []interface{}{vql.Key("Email")},
+//	   },
+//	   "version": 2,
+//	})
+//
+// yields a nested map with the name and email filled in. Unlike Map, which
+// constructs a single level of named values, Build can reshape a value into
+// an arbitrary nested document.
+func Build(tmpl interface{}) Query { return buildQuery{tmpl} }
+
+type buildQuery struct{ tmpl interface{} }
+
+func (b buildQuery) eval(v *value) (*value, error) {
+	out, err := buildValue(v, b.tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return pushValue(v, out), nil
+}
+
+// buildValue constructs the value of the template tmpl for the input v.
+func buildValue(v *value, tmpl interface{}) (interface{}, error) {
+	switch t := tmpl.(type) {
+	case Query:
+		next, err := evalQuery(t, v)
+		if err != nil {
+			return nil, err
+		}
+		return next.val, nil
+
+	case map[string]interface{}:
+		return buildMap(v, t, make(map[string]interface{}, len(t)))
+
+	case Values:
+		m, err := buildMap(v, t, make(Values, len(t)))
+		if err != nil {
+			return nil, err
+		}
+		return Values(m), nil
+
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, elt := range t {
+			val, err := buildValue(v, elt)
+			if err != nil {
+				return nil, wrapError([]Query{indexQuery(i)}, v.val, err)
+			}
+			out[i] = val
+		}
+		return out, nil
+	}
+	return tmpl, nil
+}
+
+func buildMap(v *value, tmpl, out map[string]interface{}) (map[string]interface{}, error) {
+	for key, elt := range tmpl {
+		val, err := buildValue(v, elt)
+		if err != nil {
+			return nil, wrapError([]Query{keyQuery{key: key}}, v.val, err)
+		}
+		out[key] = val
+	}
+	return out, nil
+}
@@ -0,0 +1,206 @@
+package vql
+
+import (
+	"context"
+	"fmt"
+)
+
+// Require returns a Query that evaluates q on its input, and yields the input
+// unchanged if the value of q is true. If the value of q is false, Require
+// fails with an error wrapping ErrFailed whose message includes msg. It is an
+// error if q does not yield a bool.
+//
+// Require allows a query to check its input along the way, for example to
+// validate a configuration document:
+//
+//	vql.Seq{
+//	   vql.Key("Server"),
+//	   vql.Require(vql.Exists("Port"), "server port is required"),
+//	   vql.Key("Port"),
+//	}
+func Require(q Query, msg string) Query { return requireQuery{q: q, msg: msg} }
+
+type requireQuery struct {
+	q   Query
+	msg string
+}
+
+func (r requireQuery) eval(v *value) (*value, error) {
+	w, err := evalQuery(r.q, v)
+	if err != nil {
+		return nil, err
+	} else if ok, isBool := w.val.(bool); !isBool {
+		return nil, fmt.Errorf("require query yielded %T, %w", w.val, ErrNotBool)
+	} else if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrFailed, r.msg)
+	}
+	return v, nil
+}
+
+// NonNil returns a Query that yields the value of q on its input, and fails
+// with an error wrapping ErrFailed if that value is nil.
+//
+// For example, NonNil(Key("Name")) yields the "Name" of its input, but
+// reports an error if the name is missing.
+func NonNil(q Query) Query { return nonNilQuery{q} }
+
+type nonNilQuery struct{ Query }
+
+func (n nonNilQuery) eval(v *value) (*value, error) {
+	res, err := evalQuery(n.Query, v)
+	if err != nil {
+		return nil, err
+	} else if res.val == nil {
+		return nil, fmt.Errorf("%w: %v yielded nil", ErrFailed, n.Query)
+	}
+	return pushResult(v, res), nil
+}
+
+// Fail returns a Query that always fails with err. It is useful as the Default
+// of a Switch, or as the last arm of an Or, to report that none of the
+// expected cases applied to the input. Fail panics if err is nil.
+//
+// For example:
+//
+//	vql.Or{vql.Key("id"), vql.Key("ID"), vql.Fail(ErrMissingID)}
+//
+// When a Fail query is encoded by MarshalQuery, only the text of err is
+// recorded, so the decoded query fails with a different error having the
+// same message.
+func Fail(err error) Query {
+	if err == nil {
+		panic("fail: nil error")
+	}
+	return failQuery{err}
+}
+
+// Failf returns a Query that always fails with an error whose message is
+// formatted from format and args, as fmt.Errorf. See Fail.
+func Failf(format string, args ...interface{}) Query {
+	return failQuery{fmt.Errorf(format, args...)}
+}
+
+type failQuery struct{ err error }
+
+func (f failQuery) eval(*value) (*value, error) { return nil, f.err }
+
+// A NamedCheck is a named predicate query, for use with Validate.
+type NamedCheck struct {
+	// Name describes the check, and is recorded in its failures.
+	Name string
+
+	// Query is evaluated on the input to be validated. It passes if it yields
+	// true. If it yields a slice of bools selected from the input, such as the
+	// result of Each, each false element is reported as a separate failure.
+	Query Query
+}
+
+// A Report is the outcome of validating a value with Validate.
+type Report struct {
+	// Failures records the failures of the checks, in the order the checks
+	// were given.
+	Failures []Failure
+}
+
+// OK reports whether r records no failures.
+func (r Report) OK() bool { return len(r.Failures) == 0 }
+
+// Err returns an error of concrete type Errors containing the failures of r,
+// or nil if r records no failures.
+func (r Report) Err() error {
+	if r.OK() {
+		return nil
+	}
+	errs := make(Errors, len(r.Failures))
+	for i, f := range r.Failures {
+		errs[i] = f
+	}
+	return errs
+}
+
+// A Failure describes a single failure of a check.
+type Failure struct {
+	Check string      // the name of the check that failed
+	Path  Seq         // the location of Value in the input
+	Value interface{} // the value that failed the check
+	Err   error       // the reason for the failure
+}
+
+// Error satisfies the error interface.
+func (f Failure) Error() string {
+	if len(f.Path) == 0 {
+		return fmt.Sprintf("check %q: %v", f.Check, f.Err)
+	}
+	return fmt.Sprintf("check %q at %v: %v", f.Check, f.Path, f.Err)
+}
+
+// Unwrap returns the reason for the failure, for use with errors.Is and
+// errors.As.
+func (f Failure) Unwrap() error { return f.Err }
+
+// Validate evaluates each of the checks on v, and reports all the failures
+// found. Unlike Eval, validation does not stop at the first error: A check
+// that yields false fails with ErrFailed, a check that reports an error fails
+// with that error, and the remaining checks are still evaluated. If a check
+// reports an error, that is its only failure, and its Path and Value are the
+// location and input of the step that failed, as recorded by Error.
+//
+// For example, to check that every server in a configuration document has a
+// valid port:
+//
+//	r := vql.Validate(config, vql.NamedCheck{
+//	   Name:  "valid port",
+//	   Query: vql.Seq{vql.Key("Servers"), vql.Each(vql.Seq{vql.Key("Port"), vql.Between(1, 65535)})},
+//	})
+//	for _, f := range r.Failures {
+//	   log.Print(f) // e.g., check "valid port" at Servers[2].Port: check failed
+//	}
+func Validate(v interface{}, checks ...NamedCheck) Report {
+	var r Report
+	e := &env{ctx: context.Background(), paths: true}
+	for _, c := range checks {
+		r.Failures = append(r.Failures, runCheck(v, c, e)...)
+	}
+	return r
+}
+
+// runCheck evaluates c on v and returns its failures, if any.
+func runCheck(v interface{}, c NamedCheck, e *env) []Failure {
+	fail := func(path *pathStep, err error) Failure {
+		f := Failure{Check: c.Name, Path: path.seq(), Err: err}
+		if len(f.Path) != 0 {
+			f.Value, _ = evalEnv(f.Path, v, e)
+		} else {
+			f.Value = v
+		}
+		return f
+	}
+
+	res, err := evalValue(c.Query, v, e)
+	if err != nil {
+		// The value recorded by an error is the input of the step that failed.
+		ve := err.(*Error)
+		f := Failure{Check: c.Name, Value: ve.Value, Err: ve.Err}
+		if n := len(ve.Path); n > 1 {
+			f.Path = Seq(ve.Path[:n-1])
+		}
+		return []Failure{f}
+	}
+	if vs, ok := res.val.([]interface{}); ok && res.elems != nil {
+		var out []Failure
+		for i, elt := range vs {
+			if pass, ok := elt.(bool); !ok {
+				out = append(out, fail(res.elems[i], fmt.Errorf("check yielded %T, %w", elt, ErrNotBool)))
+			} else if !pass {
+				out = append(out, fail(res.elems[i], ErrFailed))
+			}
+		}
+		return out
+	}
+	if pass, ok := res.val.(bool); !ok {
+		return []Failure{fail(res.path, fmt.Errorf("check yielded %T, %w", res.val, ErrNotBool))}
+	} else if !pass {
+		return []Failure{fail(res.path, ErrFailed)}
+	}
+	return nil
+}
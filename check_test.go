@@ -0,0 +1,87 @@
+package vql_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/creachadair/vql"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidate(t *testing.T) {
+	type server struct {
+		Name string
+		Port interface{}
+	}
+	config := map[string]interface{}{
+		"Version": 2,
+		"Servers": []server{
+			{Name: "alpha", Port: 80},
+			{Name: "", Port: 0},
+			{Name: "gamma", Port: "http"},
+		},
+	}
+	r := vql.Validate(config,
+		vql.NamedCheck{Name: "version", Query: vql.Seq{vql.Key("Version"), vql.Ge(1)}},
+		vql.NamedCheck{Name: "named", Query: vql.Seq{
+			vql.Key("Servers"), vql.Each(vql.Key("Name")), vql.Each(vql.Not(vql.Eq(""))),
+		}},
+		vql.NamedCheck{Name: "port", Query: vql.Seq{
+			vql.Key("Servers"), vql.Range(0, 2), vql.Each(vql.Seq{vql.Key("Port"), vql.Between(1, 65535)}),
+		}},
+		vql.NamedCheck{Name: "all ports", Query: vql.Seq{
+			vql.Key("Servers"), vql.Each(vql.Seq{vql.Key("Port"), vql.Between(1, 65535)}),
+		}},
+		vql.NamedCheck{Name: "owner", Query: vql.Seq{
+			vql.Key("Servers"), vql.Index(1), vql.Require(vql.Exists("Owner"), "no owner"),
+			vql.Const(true),
+		}},
+		vql.NamedCheck{Name: "count", Query: vql.Seq{vql.Key("Servers"), vql.Count(), vql.Gt(3)}},
+		vql.NamedCheck{Name: "root", Query: vql.Index(0)},
+		vql.NamedCheck{Name: "result", Query: vql.Key("Version")},
+	)
+	if r.OK() {
+		t.Fatal("Validate: report is OK, want failures")
+	}
+
+	type failure struct {
+		Check, Path string
+		Value       interface{}
+		Err         error
+	}
+	want := []failure{
+		{"named", "Servers[1].Name", "", vql.ErrFailed},
+		{"port", "Servers[1].Port", 0, vql.ErrFailed},
+		{"all ports", "Servers[2].Port", "http", vql.ErrNotComparable},
+		{"owner", "Servers[1]", server{Port: 0}, vql.ErrFailed},
+		{"count", "Servers", config["Servers"], vql.ErrFailed},
+		{"root", "self", config, vql.ErrNotSequence},
+		{"result", "Version", 2, vql.ErrNotBool},
+	}
+	var got []failure
+	for _, f := range r.Failures {
+		got = append(got, failure{f.Check, f.Path.String(), f.Value, f.Err})
+		t.Logf("Failure: %v", f)
+	}
+	opt := cmp.Comparer(func(a, b error) bool { return errors.Is(a, b) || errors.Is(b, a) })
+	if diff := cmp.Diff(want, got, opt); diff != "" {
+		t.Errorf("Validate: wrong failures (-want, +got)\n%s", diff)
+	}
+
+	err := r.Err()
+	var errs vql.Errors
+	if !errors.As(err, &errs) {
+		t.Errorf("Err: got %T, want vql.Errors", err)
+	} else if len(errs) != len(r.Failures) {
+		t.Errorf("Err: got %d errors, want %d", len(errs), len(r.Failures))
+	}
+	if !errors.Is(err, vql.ErrNotComparable) {
+		t.Errorf("Err: got %v, want %v", err, vql.ErrNotComparable)
+	}
+
+	if r := vql.Validate(config, vql.NamedCheck{Name: "ok", Query: vql.Exists("Servers")}); !r.OK() {
+		t.Errorf("Validate: got failures %v, want none", r.Failures)
+	} else if err := r.Err(); err != nil {
+		t.Errorf("Err: got %v, want nil", err)
+	}
+}
@@ -0,0 +1,100 @@
+// Package output implements the result formats shared by the vql commands.
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/creachadair/vql"
+)
+
+// Printers maps output format names to the functions that print values in
+// those formats:
+//
+//	json  -- indented JSON
+//	text  -- strings without quotes, and slices one element per line
+//	go    -- Go syntax, as by the %#v verb of the fmt package
+var Printers = map[string]func(io.Writer, interface{}) error{
+	"json": JSON,
+	"text": Text,
+	"go":   Go,
+}
+
+// JSON writes v to w as indented JSON.
+func JSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jsonValue(v))
+}
+
+// Text writes v to w as text. A slice is written one element per line.
+func Text(w io.Writer, v interface{}) error {
+	if vs, ok := v.([]interface{}); ok {
+		for _, elt := range vs {
+			if _, err := fmt.Fprintln(w, textValue(elt)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	_, err := fmt.Fprintln(w, textValue(v))
+	return err
+}
+
+// Go writes v to w in Go syntax.
+func Go(w io.Writer, v interface{}) error {
+	_, err := fmt.Fprintf(w, "%#v\n", v)
+	return err
+}
+
+// FormatOf returns the input format, "json" or "yaml", implied by the name of
+// a file.
+func FormatOf(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	}
+	return "json"
+}
+
+// textValue renders v as text: strings are unquoted, and other values are
+// rendered as compact JSON, or by the %v verb if they are not valid JSON.
+func textValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	} else if data, err := json.Marshal(jsonValue(v)); err == nil {
+		return string(data)
+	}
+	return fmt.Sprint(v)
+}
+
+// jsonValue converts maps with non-string keys, as decoded from YAML, into
+// maps with string keys, so that they can be encoded as JSON.
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for key, val := range t {
+			m[fmt.Sprint(key)] = jsonValue(val)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for key, val := range t {
+			m[key] = jsonValue(val)
+		}
+		return m
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, elt := range t {
+			out[i] = jsonValue(elt)
+		}
+		return out
+	case vql.Entry:
+		return vql.Entry{Key: jsonValue(t.Key), Value: jsonValue(t.Value)}
+	}
+	return v
+}
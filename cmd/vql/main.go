@@ -0,0 +1,109 @@
+// Program vql evaluates a vql query on JSON or YAML input and prints the
+// result.
+//
+// Usage:
+//
+//	vql [flags] <query> [file ...]
+//
+// The query is given in the text syntax accepted by vql.Parse. Each named
+// file is decoded and the query is evaluated on its contents; with no files,
+// the query is evaluated on the standard input. A file is decoded as YAML if
+// its name ends in .yaml or .yml, and as JSON otherwise, unless the -in flag
+// says otherwise.
+//
+// The result is printed in the format selected by the -out flag:
+//
+//	json  -- indented JSON (the default)
+//	text  -- strings without quotes, and slices one element per line
+//	go    -- Go syntax, as by the %#v verb of the fmt package
+//
+// Example:
+//
+//	vql 'People.select(Age > 30).each(Name)' people.json
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/creachadair/vql"
+	"github.com/creachadair/vql/cmd/internal/output"
+	"github.com/creachadair/vql/yaml"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "vql: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the program with the given arguments, reading the standard
+// input from stdin and writing results to stdout.
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("vql", flag.ContinueOnError)
+	inFormat := fs.String("in", "", "input format: json or yaml (default: by file name)")
+	outFormat := fs.String("out", "json", "output format: json, text, or go")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: vql [flags] <query> [file ...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing query")
+	}
+	switch *inFormat {
+	case "", "json", "yaml":
+	default:
+		return fmt.Errorf("unknown input format %q", *inFormat)
+	}
+	printResult, ok := output.Printers[*outFormat]
+	if !ok {
+		return fmt.Errorf("unknown output format %q", *outFormat)
+	}
+
+	q, err := vql.Parse(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("parsing query: %w", err)
+	}
+	files := fs.Args()[1:]
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, name := range files {
+		data, err := readInput(name, stdin)
+		if err != nil {
+			return err
+		}
+		format := *inFormat
+		if format == "" {
+			format = output.FormatOf(name)
+		}
+		var result interface{}
+		if format == "yaml" {
+			result, err = yaml.Eval(q, data)
+		} else {
+			result, err = vql.EvalJSON(q, data)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := printResult(stdout, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readInput reads the contents of the named file, or of stdin if name is "-".
+func readInput(name string, stdin io.Reader) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(name)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "people.yaml")
+	if err := os.WriteFile(yamlFile, []byte("people:\n- name: alice\n  age: 30\n- name: bob\n  age: 25\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	const input = `{"people": [{"name": "alice", "age": 30}, {"name": "bob", "age": 25}]}`
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{`people.each name`}, "[\n  \"alice\",\n  \"bob\"\n]\n"},
+		{[]string{"-out", "text", `people.each name`}, "alice\nbob\n"},
+		{[]string{"-out", "text", `people[0]`}, `{"age":30,"name":"alice"}` + "\n"},
+		{[]string{"-out", "go", `people[1].age`}, "25\n"},
+		{[]string{"-out", "text", `people.select(age > 26).each name`, yamlFile}, "alice\n"},
+		{[]string{"-in", "yaml", "-out", "go", `people.count()`}, "2\n"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		if err := run(test.args, strings.NewReader(input), &out); err != nil {
+			t.Errorf("run(%q): unexpected error: %v", test.args, err)
+		} else if got := out.String(); got != test.want {
+			t.Errorf("run(%q): got %q, want %q", test.args, got, test.want)
+		}
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"-out", "xml", "people"},
+		{"-in", "toml", "people"},
+		{"people["},
+		{"people", filepath.Join(dir, "nonesuch.json")},
+		{"people.each(strict(name2))"},
+	} {
+		var out bytes.Buffer
+		if err := run(args, strings.NewReader(input), &out); err == nil {
+			t.Errorf("run(%q): got %q, want error", args, out.String())
+		}
+	}
+}
@@ -0,0 +1,35 @@
+// Package example defines types used to test and demonstrate the queries
+// generated by vqlgen.
+package example
+
+//go:generate go run github.com/creachadair/vql/cmd/vqlgen -type Order CustomerName=Customer.Name FirstSKU=Items[0].SKU LastQty=Items[-1].Qty Region=Customer.Address.Region Note=Notes.gift ID=ID
+
+// An Order is a customer order.
+type Order struct {
+	Record
+	Customer *Customer
+	Items    []Item
+	Notes    map[string]string
+}
+
+// A Record carries common bookkeeping fields.
+type Record struct {
+	ID string
+}
+
+// A Customer places orders.
+type Customer struct {
+	Name    string
+	Address Address
+}
+
+// An Address is a postal address.
+type Address struct {
+	Street, Region string
+}
+
+// An Item is a line item in an order.
+type Item struct {
+	SKU string
+	Qty int
+}
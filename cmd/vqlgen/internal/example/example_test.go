@@ -0,0 +1,59 @@
+package example_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/creachadair/vql"
+	"github.com/creachadair/vql/cmd/vqlgen/internal/example"
+	"github.com/google/go-cmp/cmp"
+)
+
+var testOrder = &example.Order{
+	Record:   example.Record{ID: "o-1"},
+	Customer: &example.Customer{Name: "ann", Address: example.Address{Region: "west"}},
+	Items:    []example.Item{{SKU: "a", Qty: 1}, {SKU: "b", Qty: 3}},
+	Notes:    map[string]string{"gift": "yes"},
+}
+
+func TestGenerated(t *testing.T) {
+	queries := []vql.Query{
+		example.CustomerName, example.FirstSKU, example.ID,
+		example.LastQty, example.Note, example.Region,
+	}
+	inputs := []interface{}{
+		testOrder,
+		*testOrder,
+		&example.Order{},                  // nil pointers, empty slices, nil map
+		(*example.Order)(nil),             // nil input
+		map[string]interface{}{"ID": "m"}, // not an Order
+	}
+	for _, q := range queries {
+		general := vql.Path(fmt.Sprint(q))
+		for _, in := range inputs {
+			want, werr := vql.Eval(general, in)
+			got, gerr := vql.Eval(q, in)
+			if (werr == nil) != (gerr == nil) {
+				t.Errorf("Eval(%v, %T): got error %v, want %v", q, in, gerr, werr)
+			} else if werr != nil && gerr.Error() != werr.Error() {
+				t.Errorf("Eval(%v, %T): got error %q, want %q", q, in, gerr, werr)
+			} else if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("Eval(%v, %T): (-want, +got)\n%s", q, in, diff)
+			}
+		}
+	}
+}
+
+func BenchmarkGenerated(b *testing.B) {
+	b.Run("General", func(b *testing.B) {
+		q := vql.Path("Customer.Address.Region")
+		for i := 0; i < b.N; i++ {
+			vql.Eval(q, testOrder)
+		}
+	})
+	b.Run("Generated", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			vql.Eval(example.Region, testOrder)
+		}
+	})
+}
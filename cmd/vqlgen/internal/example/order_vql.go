@@ -0,0 +1,142 @@
+// Code generated by vqlgen; DO NOT EDIT.
+
+package example
+
+import "github.com/creachadair/vql"
+
+// CustomerName is equivalent to vql.Path("Customer.Name"),
+// specialized for inputs of type Order or *Order.
+var CustomerName = vql.Specialize(vql.Path("Customer.Name"), func(v interface{}) (interface{}, bool) {
+	var x0 *Order
+	switch t := v.(type) {
+	case *Order:
+		x0 = t
+	case Order:
+		x0 = &t
+	default:
+		return nil, false
+	}
+	if x0 == nil {
+		return nil, false
+	}
+	x1 := x0.Customer
+	if x1 == nil {
+		return nil, false
+	}
+	x2 := x1.Name
+	return x2, true
+})
+
+// FirstSKU is equivalent to vql.Path("Items[0].SKU"),
+// specialized for inputs of type Order or *Order.
+var FirstSKU = vql.Specialize(vql.Path("Items[0].SKU"), func(v interface{}) (interface{}, bool) {
+	var x0 *Order
+	switch t := v.(type) {
+	case *Order:
+		x0 = t
+	case Order:
+		x0 = &t
+	default:
+		return nil, false
+	}
+	if x0 == nil {
+		return nil, false
+	}
+	x1 := x0.Items
+	if len(x1) <= 0 {
+		return nil, false
+	}
+	x2 := x1[0]
+	x3 := x2.SKU
+	return x3, true
+})
+
+// ID is equivalent to vql.Path("ID"),
+// specialized for inputs of type Order or *Order.
+var ID = vql.Specialize(vql.Path("ID"), func(v interface{}) (interface{}, bool) {
+	var x0 *Order
+	switch t := v.(type) {
+	case *Order:
+		x0 = t
+	case Order:
+		x0 = &t
+	default:
+		return nil, false
+	}
+	if x0 == nil {
+		return nil, false
+	}
+	x1 := x0.Record.ID
+	return x1, true
+})
+
+// LastQty is equivalent to vql.Path("Items[-1].Qty"),
+// specialized for inputs of type Order or *Order.
+var LastQty = vql.Specialize(vql.Path("Items[-1].Qty"), func(v interface{}) (interface{}, bool) {
+	var x0 *Order
+	switch t := v.(type) {
+	case *Order:
+		x0 = t
+	case Order:
+		x0 = &t
+	default:
+		return nil, false
+	}
+	if x0 == nil {
+		return nil, false
+	}
+	x1 := x0.Items
+	if len(x1) < 1 {
+		return nil, false
+	}
+	x2 := x1[len(x1)-1]
+	x3 := x2.Qty
+	return x3, true
+})
+
+// Note is equivalent to vql.Path("Notes.gift"),
+// specialized for inputs of type Order or *Order.
+var Note = vql.Specialize(vql.Path("Notes.gift"), func(v interface{}) (interface{}, bool) {
+	var x0 *Order
+	switch t := v.(type) {
+	case *Order:
+		x0 = t
+	case Order:
+		x0 = &t
+	default:
+		return nil, false
+	}
+	if x0 == nil {
+		return nil, false
+	}
+	x1 := x0.Notes
+	x2, ok := x1["gift"]
+	if !ok {
+		return nil, true
+	}
+	return x2, true
+})
+
+// Region is equivalent to vql.Path("Customer.Address.Region"),
+// specialized for inputs of type Order or *Order.
+var Region = vql.Specialize(vql.Path("Customer.Address.Region"), func(v interface{}) (interface{}, bool) {
+	var x0 *Order
+	switch t := v.(type) {
+	case *Order:
+		x0 = t
+	case Order:
+		x0 = &t
+	default:
+		return nil, false
+	}
+	if x0 == nil {
+		return nil, false
+	}
+	x1 := x0.Customer
+	if x1 == nil {
+		return nil, false
+	}
+	x2 := x1.Address
+	x3 := x2.Region
+	return x3, true
+})
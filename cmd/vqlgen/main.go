@@ -0,0 +1,364 @@
+// Program vqlgen generates type-specialized implementations of fixed vql
+// queries, for use on hot paths where the cost of reflection dominates.
+//
+// Usage:
+//
+//	vqlgen -type T [-o file] name=path ...
+//
+// vqlgen reads the Go source files of the package in the current directory,
+// and for each name=path argument, emits a package-level variable with the
+// given name whose value is a vql.Query equivalent to vql.Path(path). The
+// query is specialized with vql.Specialize for inputs of type T or *T, which
+// must be a type declared in the package: it reads the fields and elements
+// named by the path directly, without using reflection, and defers to the
+// general evaluator for other inputs and for unusual cases such as a nil
+// pointer or an index out of range.
+//
+// A path is a sequence of field names and indices, as accepted by vql.Path,
+// such as "Owner.Pets[0].Name". Each field must be an exported field of a
+// struct type declared in the package, including promoted fields of embedded
+// structs, or a key of a map with string keys. Each index must apply to an
+// array or a slice. A path cannot continue through a value of interface type,
+// or of a type declared in another package.
+//
+// vqlgen is meant to be run by go generate, for example:
+//
+//	//go:generate go run github.com/creachadair/vql/cmd/vqlgen -type Order CustomerName=Customer.Name
+//
+// The output is written to the file named by -o, which defaults to the name
+// of the type in lower case followed by "_vql.go".
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/vql"
+)
+
+func main() {
+	if err := run(os.Args[1:], ".", os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "vqlgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the program with the given arguments on the package in dir.
+func run(args []string, dir string, stderr io.Writer) error {
+	fs := flag.NewFlagSet("vqlgen", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	typeName := fs.String("type", "", "the input type of the queries (required)")
+	outFile := fs.String("o", "", "output file (default: <type>_vql.go)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: vqlgen -type T [-o file] name=path ...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *typeName == "" {
+		fs.Usage()
+		return errors.New("missing -type")
+	} else if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no queries to generate")
+	}
+	if *outFile == "" {
+		*outFile = strings.ToLower(*typeName) + "_vql.go"
+	}
+
+	var defs []def
+	for _, arg := range fs.Args() {
+		name, path, ok := strings.Cut(arg, "=")
+		if !ok || !token.IsIdentifier(name) {
+			return fmt.Errorf("invalid query definition %q (want name=path)", arg)
+		}
+		defs = append(defs, def{name: name, path: path})
+	}
+
+	pkg, err := loadPackage(dir, filepath.Base(*outFile))
+	if err != nil {
+		return err
+	}
+	src, err := pkg.generate(*typeName, defs)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, *outFile), src, 0644)
+}
+
+// A def is the definition of a query to generate.
+type def struct {
+	name string // the name of the generated variable
+	path string // the path of the query, as for vql.Path
+}
+
+// A step is one step of a path: a field name, or an index if isIndex.
+type step struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+// parsePath parses a path in the syntax accepted by vql.Path.
+func parsePath(path string) (_ []step, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = fmt.Errorf("invalid path %q: %v", path, x)
+		}
+	}()
+	vql.Path(path) // check the syntax
+
+	var steps []step
+	for _, part := range strings.Split(path, ".") {
+		name := part
+		if i := strings.Index(part, "["); i >= 0 {
+			name, part = part[:i], part[i:]
+		} else {
+			part = ""
+		}
+		if name != "" {
+			steps = append(steps, step{name: name})
+		}
+		for part != "" {
+			end := strings.Index(part, "]")
+			n, _ := strconv.Atoi(part[1:end])
+			steps = append(steps, step{index: n, isIndex: true})
+			part = part[end+1:]
+		}
+	}
+	return steps, nil
+}
+
+// A pkgInfo records the declarations of a package.
+type pkgInfo struct {
+	name    string              // the package name
+	types   map[string]ast.Expr // the types declared in the package
+	adapted map[string]bool     // types with methods that change lookups
+	fset    *token.FileSet
+}
+
+// adapterMethods are the names of methods that change how vql looks up the
+// contents of a value, as for vql.Keyer, vql.Seqer, and protocol buffers.
+var adapterMethods = map[string]bool{"QueryKey": true, "QuerySeq": true, "ProtoReflect": true}
+
+// loadPackage parses the Go source files in dir, other than tests and the
+// file named skip, and records the types they declare.
+func loadPackage(dir, skip string) (*pkgInfo, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		name := fi.Name()
+		return !strings.HasSuffix(name, "_test.go") && name != skip
+	}, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	} else if len(pkgs) != 1 {
+		return nil, fmt.Errorf("found %d packages in %s, want 1", len(pkgs), dir)
+	}
+	info := &pkgInfo{types: make(map[string]ast.Expr), adapted: make(map[string]bool), fset: fset}
+	for name, pkg := range pkgs {
+		info.name = name
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				if fd, ok := decl.(*ast.FuncDecl); ok && fd.Recv != nil && adapterMethods[fd.Name.Name] {
+					rt := fd.Recv.List[0].Type
+					if ptr, ok := rt.(*ast.StarExpr); ok {
+						rt = ptr.X
+					}
+					if id, ok := rt.(*ast.Ident); ok {
+						info.adapted[id.Name] = true
+					}
+					continue
+				}
+				gd, ok := decl.(*ast.GenDecl)
+				if !ok || gd.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range gd.Specs {
+					ts := spec.(*ast.TypeSpec)
+					if ts.TypeParams == nil {
+						info.types[ts.Name.Name] = ts.Type
+					}
+				}
+			}
+		}
+	}
+	return info, nil
+}
+
+// underlying returns the type expression underlying t, following the names
+// of types declared in the package.
+func (p *pkgInfo) underlying(t ast.Expr) ast.Expr {
+	for {
+		id, ok := t.(*ast.Ident)
+		if !ok {
+			return t
+		}
+		u, ok := p.types[id.Name]
+		if !ok {
+			return t
+		}
+		t = u
+	}
+}
+
+// findField returns the expression that selects the field with the given name
+// from a value of struct type st, and the type of the field.
+func (p *pkgInfo) findField(st *ast.StructType, name string) (string, ast.Expr, bool) {
+	var embedded []*ast.Field
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			embedded = append(embedded, f)
+		}
+		for _, id := range f.Names {
+			if id.Name == name {
+				return "." + name, f.Type, true
+			}
+		}
+	}
+	for _, f := range embedded {
+		id, ok := f.Type.(*ast.Ident) // pointers are not followed
+		if !ok {
+			continue
+		} else if id.Name == name {
+			return "." + name, f.Type, true
+		} else if est, ok := p.underlying(id).(*ast.StructType); ok {
+			if sel, ft, ok := p.findField(est, name); ok {
+				return "." + id.Name + sel, ft, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+// generate returns the source of a file defining the queries in defs for
+// inputs of the named type.
+func (p *pkgInfo) generate(typeName string, defs []def) ([]byte, error) {
+	if _, ok := p.types[typeName]; !ok {
+		return nil, fmt.Errorf("type %s is not declared in package %s", typeName, p.name)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by vqlgen; DO NOT EDIT.\n\npackage %s\n\n", p.name)
+	fmt.Fprintln(&buf, `import "github.com/creachadair/vql"`)
+
+	sort.Slice(defs, func(i, j int) bool { return defs[i].name < defs[j].name })
+	for _, d := range defs {
+		body, err := p.generateQuery(typeName, d)
+		if err != nil {
+			return nil, fmt.Errorf("query %s: %w", d.name, err)
+		}
+		fmt.Fprintf(&buf, "\n// %s is equivalent to vql.Path(%q),\n// specialized for inputs of type %s or *%s.\n",
+			d.name, d.path, typeName, typeName)
+		fmt.Fprintf(&buf, "var %s = vql.Specialize(vql.Path(%q), func(v interface{}) (interface{}, bool) {\n%s})\n",
+			d.name, d.path, body)
+	}
+	return format.Source(buf.Bytes())
+}
+
+// generateQuery returns the body of the specialized function for d.
+func (p *pkgInfo) generateQuery(typeName string, d def) (string, error) {
+	steps, err := parsePath(d.path)
+	if err != nil {
+		return "", err
+	} else if len(steps) == 0 {
+		return "", errors.New("empty path")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "var x0 *%s\n", typeName)
+	fmt.Fprintf(&buf, "switch t := v.(type) {\ncase *%[1]s:\nx0 = t\ncase %[1]s:\nx0 = &t\ndefault:\nreturn nil, false\n}\n", typeName)
+
+	// Each variable xN holds the value reached by the first N steps; its
+	// type is cur. The input is a pointer, checked by the first step.
+	var cur ast.Expr = &ast.StarExpr{X: ast.NewIdent(typeName)}
+	for i, s := range steps {
+		in, out := fmt.Sprintf("x%d", i), fmt.Sprintf("x%d", i+1)
+
+		// Follow a pointer, deferring to the general evaluator if it is nil.
+		if ptr, ok := p.underlying(cur).(*ast.StarExpr); ok {
+			fmt.Fprintf(&buf, "if %s == nil {\nreturn nil, false\n}\n", in)
+			cur = ptr.X
+			if _, ok := p.underlying(cur).(*ast.StructType); !ok {
+				fmt.Fprintf(&buf, "%[1]sv := *%[1]s\n", in)
+				in += "v"
+			}
+		}
+
+		if id, ok := cur.(*ast.Ident); ok && p.adapted[id.Name] {
+			return "", fmt.Errorf("type %s has methods that change its lookups", id.Name)
+		}
+		switch t := p.underlying(cur).(type) {
+		case *ast.StructType:
+			if s.isIndex {
+				return "", fmt.Errorf("cannot index %s", p.typeString(cur))
+			} else if !token.IsExported(s.name) {
+				return "", fmt.Errorf("field %q is not exported", s.name)
+			}
+			sel, ft, ok := p.findField(t, s.name)
+			if !ok {
+				return "", fmt.Errorf("type %s has no field %q", p.typeString(cur), s.name)
+			}
+			fmt.Fprintf(&buf, "%s := %s%s\n", out, in, sel)
+			cur = ft
+
+		case *ast.MapType:
+			if s.isIndex {
+				return "", fmt.Errorf("cannot index %s", p.typeString(cur))
+			} else if id, ok := t.Key.(*ast.Ident); !ok || id.Name != "string" {
+				return "", fmt.Errorf("map type %s does not have string keys", p.typeString(cur))
+			}
+			fmt.Fprintf(&buf, "%s, ok := %s[%q]\nif !ok {\nreturn nil, true\n}\n", out, in, s.name)
+			cur = t.Value
+
+		case *ast.ArrayType:
+			if !s.isIndex {
+				return "", fmt.Errorf("cannot look up field %q in %s", s.name, p.typeString(cur))
+			} else if n, ok := arrayLen(t); ok && (s.index >= n || s.index < -n) {
+				return "", fmt.Errorf("index %d out of range for %s", s.index, p.typeString(cur))
+			}
+			if s.index >= 0 {
+				fmt.Fprintf(&buf, "if len(%s) <= %d {\nreturn nil, false\n}\n", in, s.index)
+				fmt.Fprintf(&buf, "%s := %s[%d]\n", out, in, s.index)
+			} else {
+				fmt.Fprintf(&buf, "if len(%s) < %d {\nreturn nil, false\n}\n", in, -s.index)
+				fmt.Fprintf(&buf, "%s := %s[len(%s)-%d]\n", out, in, in, -s.index)
+			}
+			cur = t.Elt
+
+		default:
+			return "", fmt.Errorf("path cannot continue through type %s", p.typeString(cur))
+		}
+	}
+	fmt.Fprintf(&buf, "return x%d, true\n", len(steps))
+	return buf.String(), nil
+}
+
+// arrayLen returns the length of the array type t, if t is an array type whose
+// length is given by an integer literal.
+func arrayLen(t *ast.ArrayType) (int, bool) {
+	lit, ok := t.Len.(*ast.BasicLit)
+	if !ok || lit.Kind != token.INT {
+		return 0, false
+	}
+	n, err := strconv.Atoi(lit.Value)
+	return n, err == nil
+}
+
+// typeString renders the type expression t as Go source.
+func (p *pkgInfo) typeString(t ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, p.fset, t)
+	return buf.String()
+}
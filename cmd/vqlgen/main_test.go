@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestExample checks that the generated code for the example package is up
+// to date with the generator.
+func TestExample(t *testing.T) {
+	const dir = "internal/example"
+	want, err := os.ReadFile(filepath.Join(dir, "order_vql.go"))
+	if err != nil {
+		t.Fatalf("Reading generated file: %v", err)
+	}
+
+	tmp := t.TempDir()
+	src, err := os.ReadFile(filepath.Join(dir, "example.go"))
+	if err != nil {
+		t.Fatalf("Reading source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "example.go"), src, 0644); err != nil {
+		t.Fatalf("Writing source: %v", err)
+	}
+
+	// Run the generator with the arguments given by the go:generate comment.
+	var args []string
+	for _, line := range strings.Split(string(src), "\n") {
+		if strings.HasPrefix(line, "//go:generate ") {
+			_, rest, _ := strings.Cut(line, "/cmd/vqlgen ")
+			args = strings.Fields(rest)
+		}
+	}
+	if len(args) == 0 {
+		t.Fatal("No go:generate comment found")
+	}
+	if err := run(args, tmp, os.Stderr); err != nil {
+		t.Fatalf("run: unexpected error: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(tmp, "order_vql.go"))
+	if err != nil {
+		t.Fatalf("Reading output: %v", err)
+	}
+	if diff := cmp.Diff(string(want), string(got)); diff != "" {
+		t.Errorf("Generated code is out of date; run go generate (-want, +got):\n%s", diff)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	const src = `package p
+
+type T struct {
+	Name  string
+	Any   interface{}
+	Pair  [2]int
+	Codes map[int]string
+	Adapt Adapted
+	inner int
+}
+
+type Adapted struct{ X int }
+
+func (Adapted) QueryKey(key interface{}) (interface{}, bool) { return nil, false }
+`
+	tmp := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmp, "p.go"), []byte(src), 0644); err != nil {
+		t.Fatalf("Writing source: %v", err)
+	}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "missing -type"},
+		{[]string{"-type", "T"}, "no queries"},
+		{[]string{"-type", "T", "bad"}, "invalid query definition"},
+		{[]string{"-type", "U", "X=Name"}, "not declared"},
+		{[]string{"-type", "T", "X=Nonesuch"}, "has no field"},
+		{[]string{"-type", "T", "X=inner"}, "not exported"},
+		{[]string{"-type", "T", "X=Name.Len"}, "cannot continue"},
+		{[]string{"-type", "T", "X=Any.Y"}, "cannot continue"},
+		{[]string{"-type", "T", "X=Pair[2]"}, "out of range"},
+		{[]string{"-type", "T", "X=Codes.a"}, "string keys"},
+		{[]string{"-type", "T", "X=Adapt.X"}, "change its lookups"},
+		{[]string{"-type", "T", "X=a..b"}, "invalid path"},
+		{[]string{"-type", "T", "X="}, "empty path"},
+	}
+	for _, test := range tests {
+		var stderr bytes.Buffer
+		err := run(test.args, tmp, &stderr)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("run %q: got error %v, want %q", test.args, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Program vqlsh is an interactive shell for developing vql queries.
+//
+// Usage:
+//
+//	vqlsh [flags] <file>
+//
+// The file is decoded once, as YAML if its name ends in .yaml or .yml and as
+// JSON otherwise, unless the -in flag says otherwise. Then each line typed at
+// the prompt is parsed as a query in the text syntax accepted by vql.Parse,
+// and evaluated on the current value, which is initially the whole document.
+// The result is printed as indented JSON, unless another format is chosen.
+//
+// Lines beginning with a colon are commands:
+//
+//	:cd <query>    -- make the result of query the current value
+//	:up            -- return to the previous current value
+//	:top           -- return to the whole document
+//	:pwd           -- print the query from the document to the current value
+//	:type [query]  -- print the type of the current value, or of a result
+//	:out <format>  -- print results as json, text, or go
+//	:save <file>   -- write the text of the last query, from the document, to file
+//	:help          -- print a summary of commands
+//	:quit          -- exit the shell (or end the input)
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/creachadair/vql"
+	"github.com/creachadair/vql/cmd/internal/output"
+	"github.com/creachadair/vql/yaml"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "vqlsh: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+const helpText = `Type a query to evaluate it on the current value, or a command:
+  :cd <query>    make the result of query the current value
+  :up            return to the previous current value
+  :top           return to the whole document
+  :pwd           print the query from the document to the current value
+  :type [query]  print the type of the current value, or of a result
+  :out <format>  print results as json, text, or go
+  :save <file>   write the text of the last query, from the document, to file
+  :help          print this summary
+  :quit          exit the shell`
+
+// run executes the program with the given arguments, reading commands from
+// stdin and writing results to stdout.
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("vqlsh", flag.ContinueOnError)
+	inFormat := fs.String("in", "", "input format: json or yaml (default: by file name)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: vqlsh [flags] <file>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("exactly one input file is required")
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	format := *inFormat
+	if format == "" {
+		format = output.FormatOf(fs.Arg(0))
+	}
+	var doc interface{}
+	switch format {
+	case "json":
+		doc, err = vql.EvalJSON(vql.Self, data)
+	case "yaml":
+		doc, err = yaml.Eval(vql.Self, data)
+	default:
+		return fmt.Errorf("unknown input format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	sh := &shell{out: stdout, root: doc, print: output.JSON}
+	in := bufio.NewScanner(stdin)
+	for {
+		fmt.Fprintf(stdout, "%s> ", sh.prompt())
+		if !in.Scan() {
+			fmt.Fprintln(stdout)
+			return in.Err()
+		}
+		line := strings.TrimSpace(in.Text())
+		if line == "" {
+			continue
+		} else if line == ":quit" || line == ":q" {
+			return nil
+		} else if err := sh.exec(line); err != nil {
+			fmt.Fprintf(stdout, "error: %v\n", err)
+		}
+	}
+}
+
+// A shell holds the state of an interactive session.
+type shell struct {
+	out   io.Writer
+	root  interface{}                        // the whole document
+	path  []vql.Query                        // queries from root to the current value
+	stack []interface{}                      // values along path, excluding root
+	last  vql.Query                          // the last query evaluated successfully
+	print func(io.Writer, interface{}) error // prints results
+}
+
+// current returns the current value.
+func (s *shell) current() interface{} {
+	if len(s.stack) == 0 {
+		return s.root
+	}
+	return s.stack[len(s.stack)-1]
+}
+
+// prompt returns the prompt string, which shows the path of the current value.
+func (s *shell) prompt() string {
+	if len(s.path) == 0 {
+		return "vql"
+	}
+	return "vql:" + vql.Seq(s.path).String()
+}
+
+// exec executes a single line of input.
+func (s *shell) exec(line string) error {
+	if !strings.HasPrefix(line, ":") {
+		q, res, err := s.eval(line)
+		if err != nil {
+			return err
+		}
+		s.last = q
+		return s.print(s.out, res)
+	}
+	cmd, arg, _ := strings.Cut(line[1:], " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case "cd":
+		q, res, err := s.eval(arg)
+		if err != nil {
+			return err
+		}
+		s.path = append(s.path, q)
+		s.stack = append(s.stack, res)
+	case "up":
+		if len(s.path) == 0 {
+			return errors.New("already at the top")
+		}
+		s.path = s.path[:len(s.path)-1]
+		s.stack = s.stack[:len(s.stack)-1]
+	case "top":
+		s.path, s.stack = nil, nil
+	case "pwd":
+		fmt.Fprintln(s.out, vql.Seq(s.path))
+	case "type":
+		v := s.current()
+		if arg != "" {
+			_, res, err := s.eval(arg)
+			if err != nil {
+				return err
+			}
+			v = res
+		}
+		kind, _ := vql.Eval(vql.KindOf(), v)
+		fmt.Fprintf(s.out, "%T (%v)\n", v, kind)
+	case "out":
+		p, ok := output.Printers[arg]
+		if !ok {
+			return fmt.Errorf("unknown output format %q", arg)
+		}
+		s.print = p
+	case "save":
+		if arg == "" {
+			return errors.New("missing file name")
+		} else if s.last == nil {
+			return errors.New("no query to save")
+		}
+		full := append(append(vql.Seq{}, s.path...), s.last)
+		return os.WriteFile(arg, []byte(full.String()+"\n"), 0644)
+	case "help":
+		fmt.Fprintln(s.out, helpText)
+	default:
+		return fmt.Errorf("unknown command %q (try :help)", cmd)
+	}
+	return nil
+}
+
+// eval parses the query text and evaluates it on the current value.
+func (s *shell) eval(text string) (vql.Query, interface{}, error) {
+	if text == "" {
+		return nil, nil, errors.New("missing query")
+	}
+	q, err := vql.Parse(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := vql.Eval(q, s.current())
+	if err != nil {
+		return nil, nil, err
+	}
+	return q, res, nil
+}
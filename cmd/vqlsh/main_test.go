@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShell(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "org.json")
+	if err := os.WriteFile(input, []byte(`{"people": [{"name": "alice", "age": 30}, {"name": "bob", "age": 25}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	saved := filepath.Join(dir, "query.vql")
+
+	script := strings.Join([]string{
+		`people.count()`,
+		`:cd people`,
+		`:type`,
+		`:out text`,
+		`select(age > 26).each name`,
+		`:save ` + saved,
+		`:cd [1]`,
+		`:pwd`,
+		`name`,
+		`:up`,
+		`:top`,
+		`:type people[0].age`,
+		`nonesuch(`,
+		`:bogus`,
+		`:up`,
+		`:quit`,
+		`unreached`,
+	}, "\n")
+
+	var out bytes.Buffer
+	if err := run([]string{input}, strings.NewReader(script), &out); err != nil {
+		t.Fatalf("run: unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"vql> 2\n",
+		"[]interface {} (slice)\n",
+		"vql:people> alice\n",
+		"vql:people[1]> people[1]\n",
+		"vql:people[1]> bob\n",
+		"float64 (float64)\n",
+		"error: unknown command \"bogus\"",
+		"error: already at the top\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Output does not contain %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "unreached") {
+		t.Errorf("Input after :quit was evaluated:\n%s", out.String())
+	}
+
+	text, err := os.ReadFile(saved)
+	if err != nil {
+		t.Fatalf("Reading saved query: %v", err)
+	} else if got, want := string(text), "people.select(age > 26).each(name)\n"; got != want {
+		t.Errorf("Saved query: got %q, want %q", got, want)
+	}
+}
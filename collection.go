@@ -0,0 +1,781 @@
+package vql
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// elements returns a slice of the elements of the array, map, or slice v.val,
+// in the order they would be visited by forEach.
+func elements(v *value) ([]interface{}, error) {
+	var elts []interface{}
+	err := forEach(v, func(obj interface{}) error {
+		elts = append(elts, obj)
+		return nil
+	})
+	return elts, err
+}
+
+// Sort returns a Query that sorts the elements of an array, slice, or map and
+// yields a slice of concrete type []interface{} containing the elements in
+// order. The query less is given inputs of concrete type []interface{}
+// containing two elements, and must yield true if the first is less than the
+// second. The sort is stable. It is an error if less does not yield a bool.
+// If the input value is a map, its elements are of concrete type Entry.
+func Sort(less Query) Query { return sortQuery{less: less} }
+
+// SortBy returns a Query that sorts the elements of an array, slice, or map in
+// increasing order of the values of key on each element, and yields a slice
+// of concrete type []interface{} containing the elements in order. Keys are
+// ordered as by Lt. The sort is stable. It is an error if the keys cannot be
+// compared. If the input value is a map, key is given inputs of concrete type
+// Entry.
+func SortBy(key Query) Query { return sortQuery{key: key} }
+
+type sortQuery struct {
+	less Query // if set, compare pairs of elements
+	key  Query // if set, compare keys of elements
+}
+
+func (s sortQuery) eval(v *value) (*value, error) {
+	elts, err := elements(v)
+	if err != nil {
+		return nil, err
+	}
+	st := &sorter{elts: elts}
+	if s.key != nil {
+		st.keys = make([]interface{}, len(elts))
+		for i, elt := range elts {
+			k, err := evalQuery(s.key, pushValue(v, elt))
+			if err != nil {
+				return nil, wrapError([]Query{indexQuery(i)}, elt, err)
+			}
+			st.keys[i] = k.val
+		}
+		st.less = func(a, b interface{}) (bool, error) { return isLessThan(a, b, false) }
+	} else {
+		st.less = func(a, b interface{}) (bool, error) {
+			res, err := evalQuery(s.less, pushValue(v, []interface{}{a, b}))
+			if err != nil {
+				return false, err
+			} else if ok, isBool := res.val.(bool); isBool {
+				return ok, nil
+			}
+			return false, fmt.Errorf("sort query yielded %T, %w", res.val, ErrNotBool)
+		}
+	}
+	sort.Stable(st)
+	if st.err != nil {
+		return nil, st.err
+	}
+	return pushValue(v, st.elts), nil
+}
+
+// sorter implements sort.Interface for a slice of elements, optionally
+// ordered by a parallel slice of keys. Since sort.Interface has no way to
+// report an error, the first error from less is recorded in err.
+type sorter struct {
+	elts, keys []interface{} // if keys == nil, elements are compared directly
+	less       func(a, b interface{}) (bool, error)
+	err        error
+}
+
+func (s *sorter) Len() int { return len(s.elts) }
+
+func (s *sorter) Less(i, j int) bool {
+	if s.err != nil {
+		return false
+	}
+	a, b := s.elts[i], s.elts[j]
+	if s.keys != nil {
+		a, b = s.keys[i], s.keys[j]
+	}
+	ok, err := s.less(a, b)
+	if err != nil {
+		s.err = err
+	}
+	return ok
+}
+
+func (s *sorter) Swap(i, j int) {
+	s.elts[i], s.elts[j] = s.elts[j], s.elts[i]
+	if s.keys != nil {
+		s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	}
+}
+
+// Groups is the concrete type of the result of a GroupBy query. Each key is a
+// value of the grouping query, mapped to the elements that produced it, in
+// their original order.
+type Groups map[interface{}][]interface{}
+
+// GroupBy returns a Query that evaluates key on each element of an array,
+// slice, or map, and yields a value of concrete type Groups that maps each
+// distinct value of key to the elements for which key yielded that value. It
+// is an error if key yields a value that cannot be used as a map key, such as
+// a slice. If the input value is a map, key is given inputs of concrete type
+// Entry.
+func GroupBy(key Query) Query { return groupQuery{key} }
+
+type groupQuery struct{ Query }
+
+func (g groupQuery) eval(v *value) (*value, error) {
+	groups := make(Groups)
+	err := forEach(v, func(obj interface{}) error {
+		k, err := evalQuery(g.Query, pushValue(v, obj))
+		if err != nil {
+			return err
+		} else if !isHashable(k.val) {
+			return fmt.Errorf("%w: value of type %T cannot be a group key", ErrBadKey, k.val)
+		}
+		groups[k.val] = append(groups[k.val], obj)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pushValue(v, groups), nil
+}
+
+// isHashable reports whether obj can be used as a key in a Go map.
+func isHashable(obj interface{}) bool {
+	return obj == nil || isHashableValue(reflect.ValueOf(obj))
+}
+
+func isHashableValue(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Func:
+		return false
+	case reflect.Interface:
+		return rv.IsNil() || isHashableValue(rv.Elem())
+	case reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if !isHashableValue(rv.Index(i)) {
+				return false
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			if !isHashableValue(rv.Field(i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Distinct returns a Query that yields a slice of concrete type []interface{}
+// containing the elements of an array, slice, or map with duplicates removed,
+// keeping the first occurrence of each. Two elements are duplicates if the
+// values of Seq(key) on them are equal. With no key, the elements themselves
+// are compared. Values are compared with == if they can be used as map keys,
+// and otherwise with reflect.DeepEqual. If the input value is a map, the key
+// query is given inputs of concrete type Entry.
+func Distinct(key ...Query) Query { return distinctQuery{Seq(key)} }
+
+type distinctQuery struct{ Query }
+
+func (d distinctQuery) eval(v *value) (*value, error) {
+	var vs []interface{}
+	var seen keySet
+	err := forEach(v, func(obj interface{}) error {
+		k, err := evalQuery(d.Query, pushValue(v, obj))
+		if err != nil {
+			return err
+		} else if seen.add(k.val) {
+			vs = append(vs, obj)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pushValue(v, vs), nil
+}
+
+// A keySet is a set of arbitrary values. Values that can be used as map keys
+// are compared with ==; others are compared with reflect.DeepEqual.
+// The zero value is ready for use.
+type keySet struct {
+	hashed map[interface{}]bool
+	other  []interface{}
+}
+
+// add adds obj to the set, and reports whether it was not already present.
+func (s *keySet) add(obj interface{}) bool {
+	if s.has(obj) {
+		return false
+	} else if isHashable(obj) {
+		if s.hashed == nil {
+			s.hashed = make(map[interface{}]bool)
+		}
+		s.hashed[obj] = true
+	} else {
+		s.other = append(s.other, obj)
+	}
+	return true
+}
+
+// has reports whether obj is present in the set.
+func (s *keySet) has(obj interface{}) bool {
+	if isHashable(obj) {
+		return s.hashed[obj]
+	}
+	for _, elt := range s.other {
+		if reflect.DeepEqual(elt, obj) {
+			return true
+		}
+	}
+	return false
+}
+
+// Union returns a Query that evaluates each of qs, which must yield arrays,
+// slices, or maps, and yields a slice of concrete type []interface{}
+// containing the elements of all the results, in order, with duplicates
+// removed. Elements are compared as by Distinct. The elements of a map are
+// its entries, of concrete type Entry.
+func Union(qs ...Query) Query { return setQuery{op: "union", qs: qs} }
+
+// UnionBy returns a Query that yields the union of the values of qs, as Union
+// does, but compares elements by the values of key on them, keeping the first
+// element for each distinct key.
+func UnionBy(key Query, qs ...Query) Query { return setQuery{op: "union", key: key, qs: qs} }
+
+// Intersect returns a Query that evaluates each of qs, which must yield
+// arrays, slices, or maps, and yields a slice of concrete type []interface{}
+// containing the elements of the first result that are also elements of each
+// of the others, in order, with duplicates removed. Elements are compared as
+// for Union.
+func Intersect(qs ...Query) Query { return setQuery{op: "intersect", qs: qs} }
+
+// IntersectBy returns a Query that yields the intersection of the values of
+// qs, as Intersect does, but compares elements by the values of key on them.
+func IntersectBy(key Query, qs ...Query) Query {
+	return setQuery{op: "intersect", key: key, qs: qs}
+}
+
+// Difference returns a Query that evaluates each of qs, which must yield
+// arrays, slices, or maps, and yields a slice of concrete type []interface{}
+// containing the elements of the first result that are not elements of any of
+// the others, in order, with duplicates removed. Elements are compared as for
+// Union. For example, Difference(Key("A"), Key("B")) lists the elements of A
+// that are missing from B.
+func Difference(qs ...Query) Query { return setQuery{op: "difference", qs: qs} }
+
+// DifferenceBy returns a Query that yields the difference of the values of
+// qs, as Difference does, but compares elements by the values of key on them.
+func DifferenceBy(key Query, qs ...Query) Query {
+	return setQuery{op: "difference", key: key, qs: qs}
+}
+
+type setQuery struct {
+	op  string // union, intersect, or difference
+	key Query  // if nil, compare the elements themselves
+	qs  []Query
+}
+
+// A keyedElem is an element of an operand of a set operation, with its key.
+type keyedElem struct {
+	elt, key interface{}
+}
+
+func (s setQuery) eval(v *value) (*value, error) {
+	key := s.key
+	if key == nil {
+		key = Self
+	}
+	elems := make([][]keyedElem, len(s.qs))
+	keys := make([]keySet, len(s.qs))
+	for i, q := range s.qs {
+		w, err := evalQuery(q, v)
+		if err != nil {
+			return nil, err
+		}
+		err = forEachValue(w, func(elt *value) error {
+			k, err := evalQuery(key, elt)
+			if err != nil {
+				return err
+			}
+			elems[i] = append(elems[i], keyedElem{elt: elt.val, key: k.val})
+			keys[i].add(k.val)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vs := []interface{}{}
+	var seen keySet
+	if s.op == "union" {
+		for _, es := range elems {
+			for _, e := range es {
+				if seen.add(e.key) {
+					vs = append(vs, e.elt)
+				}
+			}
+		}
+	} else if len(elems) != 0 {
+		for _, e := range elems[0] {
+			if s.inOthers(keys[1:], e.key) && seen.add(e.key) {
+				vs = append(vs, e.elt)
+			}
+		}
+	}
+	return pushValue(v, vs), nil
+}
+
+// inOthers reports whether an element of the first operand with the given key
+// belongs in the result of s, given the keys of the other operands.
+func (s setQuery) inOthers(others []keySet, key interface{}) bool {
+	for i := range others {
+		if others[i].has(key) != (s.op == "intersect") {
+			return false
+		}
+	}
+	return true
+}
+
+// Flatten returns a Query that yields a slice of concrete type []interface{}
+// containing the elements of an array or slice, with each element that is
+// itself an array or slice replaced by its elements, recursively to the given
+// depth. Flatten(1) removes one level of nesting, as Cat does for the values
+// of its subqueries, and a negative depth removes all levels. For example,
+// Flatten(-1) on [[1, [2]], 3] yields [1, 2, 3].
+func Flatten(depth int) Query { return flattenQuery(depth) }
+
+type flattenQuery int
+
+func (f flattenQuery) eval(v *value) (*value, error) {
+	rv, err := seqValue(v.val)
+	if err != nil {
+		return nil, err
+	}
+	return pushValue(v, appendFlat([]interface{}{}, rv, int(f))), nil
+}
+
+// appendFlat appends the elements of the array or slice rv to vs, flattening
+// nested arrays and slices to the given depth, and returns the result.
+func appendFlat(vs []interface{}, rv reflect.Value, depth int) []interface{} {
+	for i := 0; i < rv.Len(); i++ {
+		elt := rv.Index(i).Interface()
+		if ev := reflect.ValueOf(elt); depth != 0 && (ev.Kind() == reflect.Array || ev.Kind() == reflect.Slice) {
+			vs = appendFlat(vs, ev, depth-1)
+		} else {
+			vs = append(vs, elt)
+		}
+	}
+	return vs
+}
+
+// Chunk returns a Query that splits an array or slice into consecutive groups
+// of n elements, and yields a slice of concrete type []interface{} whose
+// elements are the groups, each also of type []interface{}. The last group
+// has fewer than n elements if the length of the input is not a multiple of
+// n. It is an error if n is not positive.
+func Chunk(n int) Query { return chunkQuery(n) }
+
+type chunkQuery int
+
+func (c chunkQuery) eval(v *value) (*value, error) {
+	if c <= 0 {
+		return nil, fmt.Errorf("chunk size %d is not positive", int(c))
+	}
+	rv, err := seqValue(v.val)
+	if err != nil {
+		return nil, err
+	}
+	n := int(c)
+	out := make([]interface{}, 0, (rv.Len()+n-1)/n)
+	for lo := 0; lo < rv.Len(); lo += n {
+		hi := lo + n
+		if hi > rv.Len() {
+			hi = rv.Len()
+		}
+		group := make([]interface{}, hi-lo)
+		for i := range group {
+			group[i] = rv.Index(lo + i).Interface()
+		}
+		out = append(out, group)
+	}
+	return pushValue(v, out), nil
+}
+
+// Partition returns a Query that evaluates pred for each element of an
+// array, slice, or map, and yields a slice of concrete type []interface{}
+// with two elements: a []interface{} of the elements for which pred is true,
+// followed by a []interface{} of those for which it is false, each in their
+// original order. It is an error if pred does not yield a bool. If the input
+// value is a map, pred is given inputs of concrete type Entry.
+func Partition(pred ...Query) Query { return partitionQuery{Seq(pred)} }
+
+type partitionQuery struct{ Query }
+
+func (p partitionQuery) eval(v *value) (*value, error) {
+	yes, no := []interface{}{}, []interface{}{}
+	err := forEachValue(v, func(elt *value) error {
+		w, err := evalQuery(p.Query, elt)
+		if err != nil {
+			return err
+		} else if keep, ok := w.val.(bool); !ok {
+			return fmt.Errorf("partition query yielded %T, %w", w.val, ErrNotBool)
+		} else if keep {
+			yes = append(yes, elt.val)
+		} else {
+			no = append(no, elt.val)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pushValue(v, []interface{}{yes, no}), nil
+}
+
+// Zip returns a Query that evaluates each of qs, which must yield arrays or
+// slices, and yields a slice of concrete type []interface{} whose elements
+// combine the elements of those results at the same offset. Each element is a
+// []interface{} with one value for each of qs, in order. If the results have
+// different lengths, the extra elements of the longer ones are ignored.
+//
+// For example, Zip(Key("Names"), Key("Scores")) on an input whose Names are
+// ["a", "b"] and whose Scores are [1, 2] yields [["a", 1], ["b", 2]].
+func Zip(qs ...Query) Query { return zipQuery(qs) }
+
+type zipQuery []Query
+
+func (z zipQuery) eval(v *value) (*value, error) {
+	seqs := make([]reflect.Value, len(z))
+	n := -1
+	for i, q := range z {
+		next, err := evalQuery(q, v)
+		if err != nil {
+			return nil, err
+		}
+		rv, err := seqValue(next.val)
+		if err != nil {
+			return nil, fmt.Errorf("zip argument %d: %w", i+1, err)
+		}
+		if n < 0 || rv.Len() < n {
+			n = rv.Len()
+		}
+		seqs[i] = rv
+	}
+	if n < 0 {
+		n = 0 // no subqueries
+	}
+	out := make([]interface{}, n)
+	for i := range out {
+		tuple := make([]interface{}, len(seqs))
+		for j, rv := range seqs {
+			tuple[j] = rv.Index(i).Interface()
+		}
+		out[i] = tuple
+	}
+	return pushValue(v, out), nil
+}
+
+// Keys returns a Query that yields a slice of concrete type []interface{}
+// containing the keys of a map, or the names of the exported fields of a
+// struct. Map keys are ordered as described for Entry, and field names are in
+// order of declaration.
+func Keys() Query { return entriesQuery{part: entryKey} }
+
+// Vals returns a Query that yields a slice of concrete type []interface{}
+// containing the values of a map, or the values of the exported fields of a
+// struct, in the same order as Keys.
+func Vals() Query { return entriesQuery{part: entryValue} }
+
+// Entries returns a Query that yields a slice of concrete type []interface{}
+// containing values of concrete type Entry for each entry of a map, or each
+// exported field of a struct, in the same order as Keys. The key of a struct
+// field entry is its name.
+func Entries() Query { return entriesQuery{part: entryBoth} }
+
+type entryPart int
+
+const (
+	entryKey entryPart = iota
+	entryValue
+	entryBoth
+)
+
+type entriesQuery struct{ part entryPart }
+
+func (e entriesQuery) eval(v *value) (*value, error) {
+	es, err := fieldEntries(v.val)
+	if err != nil {
+		return nil, err
+	}
+	vs := make([]interface{}, len(es))
+	for i, elt := range es {
+		switch e.part {
+		case entryKey:
+			vs[i] = elt.Key
+		case entryValue:
+			vs[i] = elt.Value
+		default:
+			vs[i] = elt
+		}
+	}
+	return pushValue(v, vs), nil
+}
+
+// fieldEntries returns the entries of the map obj, or the names and values of
+// the exported fields of the struct obj, in the order described for Keys.
+func fieldEntries(obj interface{}) ([]Entry, error) {
+	if m, ok := obj.(*sync.Map); ok {
+		return syncMapEntries(m), nil
+	}
+	var es []Entry
+	rv := reflect.Indirect(reflect.ValueOf(obj))
+	switch rv.Kind() {
+	case reflect.Map:
+		for _, key := range mapKeys(rv) {
+			es = append(es, Entry{Key: key.Interface(), Value: rv.MapIndex(key).Interface()})
+		}
+	case reflect.Struct:
+		t := rv.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" { // exported
+				es = append(es, Entry{Key: f.Name, Value: rv.Field(i).Interface()})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("value of type %T is %w", obj, ErrNotStruct)
+	}
+	return es, nil
+}
+
+// syncMapEntries returns a snapshot of the entries of m, in the order of the
+// keys of a map with interface keys as described for Entry.
+func syncMapEntries(m *sync.Map) []Entry {
+	var es []Entry
+	m.Range(func(key, val interface{}) bool {
+		es = append(es, Entry{Key: key, Value: val})
+		return true
+	})
+	sort.SliceStable(es, func(i, j int) bool {
+		return keyLess(reflect.ValueOf(es[i].Key), reflect.ValueOf(es[j].Key))
+	})
+	return es
+}
+
+// Pick returns a Query that projects a struct or map down to the named fields
+// or keys, and yields a value of concrete type Values mapping each name to
+// its value. As with Map, names that are not present map to nil. It is an
+// error if the value is not a struct or a map with string keys.
+func Pick(names ...string) Query { return projectQuery{names: names} }
+
+// Omit returns a Query that projects a struct or map down to all but the
+// named fields or keys, and yields a value of concrete type Values mapping
+// each remaining name to its value. Only the exported fields of a struct are
+// included. It is an error if the value is not a struct or a map with string
+// keys.
+func Omit(names ...string) Query { return projectQuery{names: names, omit: true} }
+
+type projectQuery struct {
+	names []string
+	omit  bool // if true, omit names rather than picking them
+}
+
+func (p projectQuery) eval(v *value) (*value, error) {
+	out := make(Values)
+	if !p.omit {
+		for _, name := range p.names {
+			f, err := lookupKey(v.val, name, v.env.unexported)
+			if err != nil {
+				return nil, err
+			} else if f.IsValid() {
+				out[name] = f.Interface()
+			} else {
+				out[name] = nil
+			}
+		}
+		return pushValue(v, out), nil
+	}
+
+	es, err := fieldEntries(v.val)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range es {
+		key, ok := e.Key.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: value of type %T cannot be a field name", ErrBadKey, e.Key)
+		}
+		out[key] = e.Value
+	}
+	for _, name := range p.names {
+		delete(out, name)
+	}
+	return pushValue(v, out), nil
+}
+
+// SelectMap returns a Query that evaluates Seq(q) for each entry of a map, and
+// yields a new map of the same type containing the entries for which the
+// value of q on that entry is true. The query is given inputs of concrete type
+// Entry. It is an error if q does not yield a bool, or if the input is not a
+// map.
+//
+// Unlike Select, which yields a slice of Entry values, SelectMap preserves
+// the shape of its input, so the result may be used with Key.
+func SelectMap(q ...Query) Query { return selectMapQuery{Seq(q)} }
+
+type selectMapQuery struct{ Query }
+
+func (s selectMapQuery) eval(v *value) (*value, error) {
+	rv := indirect(reflect.ValueOf(v.val))
+	if rv.Kind() != reflect.Map {
+		return nil, fmt.Errorf("value of type %T is %w", v.val, ErrNotMap)
+	}
+	out := reflect.MakeMapWithSize(rv.Type(), rv.Len())
+	err := forEach(v, func(obj interface{}) error {
+		w, err := evalQuery(s.Query, pushValue(v, obj))
+		if err != nil {
+			return err
+		} else if keep, ok := w.val.(bool); !ok {
+			return fmt.Errorf("select query yielded %T, %w", w.val, ErrNotBool)
+		} else if keep {
+			e := obj.(Entry)
+			out.SetMapIndex(valueOf(e.Key, rv.Type().Key()), valueOf(e.Value, rv.Type().Elem()))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pushValue(v, out.Interface()), nil
+}
+
+// MapValues returns a Query that applies q to each value of a map, and yields
+// a new map with the same keys, whose values are the results. The result has
+// the same key type as the input, with values of type interface{}; for
+// example, given a map[string]int the result is a map[string]interface{}. It
+// is an error if the input is not a map.
+func MapValues(q Query) Query { return remapQuery{Query: q} }
+
+// MapKeys returns a Query that applies q to each key of a map, and yields a
+// new map whose keys are the results, each associated with the value of the
+// key it was computed from. The result has keys of type interface{}, with the
+// same value type as the input; for example, given a map[string]int the
+// result is a map[interface{}]int. It is an error if the input is not a map,
+// if q yields a value that cannot be used as a map key, or if q yields the
+// same key for two different entries.
+func MapKeys(q Query) Query { return remapQuery{Query: q, keys: true} }
+
+type remapQuery struct {
+	Query
+	keys bool // if true, transform keys rather than values
+}
+
+func (m remapQuery) eval(v *value) (*value, error) {
+	rv := indirect(reflect.ValueOf(v.val))
+	if rv.Kind() != reflect.Map {
+		return nil, fmt.Errorf("value of type %T is %w", v.val, ErrNotMap)
+	}
+	var out reflect.Value
+	if m.keys {
+		out = reflect.MakeMapWithSize(reflect.MapOf(anyType, rv.Type().Elem()), rv.Len())
+	} else {
+		out = reflect.MakeMapWithSize(reflect.MapOf(rv.Type().Key(), anyType), rv.Len())
+	}
+	err := forEach(v, func(obj interface{}) error {
+		e := obj.(Entry)
+		if !m.keys {
+			w, err := evalQuery(m.Query, pushValue(v, e.Value))
+			if err != nil {
+				return err
+			}
+			out.SetMapIndex(valueOf(e.Key, rv.Type().Key()), valueOf(w.val, anyType))
+			return nil
+		}
+		w, err := evalQuery(m.Query, pushValue(v, e.Key))
+		if err != nil {
+			return err
+		} else if !isHashable(w.val) {
+			return fmt.Errorf("%w: value of type %T cannot be a map key", ErrBadKey, w.val)
+		}
+		key := valueOf(w.val, anyType)
+		if out.MapIndex(key).IsValid() {
+			return fmt.Errorf("%w: duplicate key %v", ErrBadKey, w.val)
+		}
+		out.SetMapIndex(key, valueOf(e.Value, rv.Type().Elem()))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pushValue(v, out.Interface()), nil
+}
+
+var anyType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// valueOf returns a reflect.Value for obj, or the zero value of type t if obj
+// is nil.
+func valueOf(obj interface{}, t reflect.Type) reflect.Value {
+	if obj == nil {
+		return reflect.Zero(t)
+	}
+	return reflect.ValueOf(obj)
+}
+
+// Contains returns a Query that reports whether its input contains needle. An
+// array or slice contains needle if any of its elements is equal to needle; a
+// map contains needle if it has needle as a key; and a string contains needle
+// if needle is a string and a substring of it. It is an error if the input is
+// not one of these.
+func Contains(needle interface{}) Query { return containsQuery{needle} }
+
+type containsQuery struct{ needle interface{} }
+
+func (c containsQuery) eval(v *value) (*value, error) {
+	needle, err := resolveArg(v, c.needle)
+	if err != nil {
+		return nil, err
+	}
+	rv := indirect(reflect.ValueOf(v.val))
+	switch rv.Kind() {
+	case reflect.String:
+		s, ok := needle.(string)
+		return pushValue(v, ok && strings.Contains(rv.String(), s)), nil
+	case reflect.Map:
+		kt := rv.Type().Key()
+		if needle == nil || !reflect.TypeOf(needle).AssignableTo(kt) || !isHashable(needle) {
+			return pushValue(v, false), nil
+		}
+		return pushValue(v, rv.MapIndex(reflect.ValueOf(needle)).IsValid()), nil
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < rv.Len(); i++ {
+			if isEqual(rv.Index(i).Interface(), needle) {
+				return pushValue(v, true), nil
+			}
+		}
+		return pushValue(v, false), nil
+	}
+	return nil, fmt.Errorf("value of type %T is %w", v.val, ErrNotCollection)
+}
+
+// In returns a Query that reports whether its input is equal to any of the
+// given values.
+//
+// For example, Seq{Key("Status"), In("active", "pending")} selects values
+// whose status is either "active" or "pending".
+func In(set ...interface{}) Query { return inQuery(set) }
+
+type inQuery []interface{}
+
+func (q inQuery) eval(v *value) (*value, error) {
+	for _, elt := range q {
+		elt, err := resolveArg(v, elt)
+		if err != nil {
+			return nil, err
+		} else if isEqual(v.val, elt) {
+			return pushValue(v, true), nil
+		}
+	}
+	return pushValue(v, false), nil
+}
@@ -0,0 +1,211 @@
+package vql
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// RegisterCompare registers cmp as the comparison function for values of the
+// concrete type T. The function must return a negative number if a < b, zero
+// if a == b, and a positive number if a > b. Once registered, the comparison
+// queries Eq, Lt, Le, Gt, Ge, and Between, as well as Sort, SortBy, Min, and
+// Max, use cmp to compare two values of type T. This allows domain types such
+// as decimals, versions, or *big.Int to be compared and ordered. Registering
+// a function for a type replaces any function previously registered for it.
+// RegisterCompare panics if T is an interface type.
+//
+// For example:
+//
+//	vql.RegisterCompare(func(a, b *big.Int) int { return a.Cmp(b) })
+func RegisterCompare[T any](cmp func(a, b T) int) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() == reflect.Interface {
+		panic("RegisterCompare: " + t.String() + " is an interface type")
+	}
+	comparers.Store(t, func(a, b interface{}) int { return cmp(a.(T), b.(T)) })
+}
+
+// comparers maps each type registered by RegisterCompare to its comparison
+// function, of type func(a, b interface{}) int.
+var comparers sync.Map
+
+// compareCustom compares a and b using a function registered by
+// RegisterCompare, if a and b have the same type and a function is registered
+// for it. It reports false if no function applies.
+func compareCustom(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	t := reflect.TypeOf(a)
+	if reflect.TypeOf(b) != t {
+		return 0, false
+	} else if cmp, ok := comparers.Load(t); ok {
+		return cmp.(func(a, b interface{}) int)(a, b), true
+	}
+	return 0, false
+}
+
+// isEqual reports whether a and b are equal. Numbers are equal if they have
+// the same numeric value, regardless of their types, and times are equal if
+// they denote the same instant. Values of a type registered with
+// RegisterCompare are compared with the registered function. Otherwise, values
+// are equal if they are equal as interface values. Values that cannot be
+// compared are not equal to anything.
+func isEqual(a, b interface{}) bool {
+	if c, ok := compareCustom(a, b); ok {
+		return c == 0
+	} else if c, ok := compareTimes(a, b); ok {
+		return c == 0
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if isNumberKind(va.Kind()) && isNumberKind(vb.Kind()) {
+		c, ok := compareNumbers(va, vb)
+		return ok && c == 0
+	}
+	return isHashable(a) && isHashable(b) && a == b
+}
+
+// isLessThan reports whether x is less than y, or if ifEQ is true, whether x
+// is less than or equal to y. Numbers of any kind are compared by their
+// numeric values, strings are compared lexicographically, and times are
+// compared chronologically. A NaN is not less than, greater than, or equal to
+// any number. Values of a type registered with RegisterCompare are compared
+// with the registered function. It is an error if x and y cannot be compared.
+func isLessThan(x, y interface{}, ifEQ bool) (bool, error) {
+	if c, ok := compareCustom(x, y); ok {
+		return c < 0 || (ifEQ && c == 0), nil
+	} else if c, ok := compareTimes(x, y); ok {
+		return c < 0 || (ifEQ && c == 0), nil
+	} else if isHashable(x) && x == y {
+		return ifEQ, nil
+	}
+	vx, vy := reflect.ValueOf(x), reflect.ValueOf(y)
+	kx, ky := vx.Kind(), vy.Kind()
+	switch {
+	case isNumberKind(kx) && isNumberKind(ky):
+		c, ok := compareNumbers(vx, vy)
+		return ok && (c < 0 || (ifEQ && c == 0)), nil
+	case kx == reflect.String && ky == reflect.String:
+		return vx.String() < vy.String(), nil
+	}
+	return false, fmt.Errorf("values of type %T and %T are %w", x, y, ErrNotComparable)
+}
+
+// compareTimes compares a and b chronologically, if both are time.Time values,
+// and returns -1, 0, or 1 as a is before, equal to, or after b. It reports
+// false if either value is not a time.Time.
+func compareTimes(a, b interface{}) (int, bool) {
+	ta, ok := a.(time.Time)
+	if !ok {
+		return 0, false
+	}
+	tb, ok := b.(time.Time)
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case ta.Before(tb):
+		return -1, true
+	case ta.After(tb):
+		return 1, true
+	}
+	return 0, true
+}
+
+// compareNumbers compares the numeric values of x and y, which must have
+// numeric kinds, and returns -1, 0, or 1 as x is less than, equal to, or
+// greater than y. Integers are compared exactly, including with floats. The
+// comparison reports false if either value is a NaN.
+func compareNumbers(x, y reflect.Value) (int, bool) {
+	kx, ky := x.Kind(), y.Kind()
+	switch {
+	case isIntLike(kx) && isIntLike(ky):
+		return compareInts(x.Int(), y.Int()), true
+	case isUintLike(kx) && isUintLike(ky):
+		return compareUints(x.Uint(), y.Uint()), true
+	case isFloatLike(kx) && isFloatLike(ky):
+		a, b := x.Float(), y.Float()
+		if math.IsNaN(a) || math.IsNaN(b) {
+			return 0, false
+		}
+		return compareFloats(a, b), true
+	case isIntLike(kx) && isUintLike(ky):
+		return compareIntUint(x.Int(), y.Uint()), true
+	case isUintLike(kx) && isIntLike(ky):
+		return -compareIntUint(y.Int(), x.Uint()), true
+	case isFloatLike(kx):
+		return compareFloatInteger(x.Float(), y)
+	default:
+		c, ok := compareFloatInteger(y.Float(), x)
+		return -c, ok
+	}
+}
+
+// compareFloatInteger compares f to the integer value of v, which must have
+// an integer kind.
+func compareFloatInteger(f float64, v reflect.Value) (int, bool) {
+	if math.IsNaN(f) {
+		return 0, false
+	}
+	t := math.Trunc(f)
+	var c int
+	if isIntLike(v.Kind()) {
+		switch {
+		case t < math.MinInt64:
+			return -1, true
+		case t >= 1<<63:
+			return 1, true
+		}
+		c = compareInts(int64(t), v.Int())
+	} else {
+		switch {
+		case t < 0:
+			return -1, true
+		case t >= 1<<64:
+			return 1, true
+		}
+		c = compareUints(uint64(t), v.Uint())
+	}
+	if c != 0 {
+		return c, true
+	}
+	return compareFloats(f-t, 0), true // the fractional part breaks a tie
+}
+
+// compareIntUint compares a signed and an unsigned integer.
+func compareIntUint(i int64, u uint64) int {
+	if i < 0 {
+		return -1
+	}
+	return compareUints(uint64(i), u)
+}
+
+func compareInts(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareUints(a, b uint64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
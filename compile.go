@@ -0,0 +1,405 @@
+package vql
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Prepared is a query that has been specialized by Compile for inputs of a
+// particular type. A Prepared value is itself a Query, and may be used
+// anywhere a Query is accepted.
+type Prepared struct {
+	q Query
+	t reflect.Type
+}
+
+// Compile prepares q for evaluation on inputs of type t. Where the types of
+// the values visited by q can be determined from t, Compile resolves field
+// lookups to field indices, and checks that keys, indices, and function
+// arguments have suitable types. An error is reported for any step that is
+// statically known to fail, such as a lookup of a field that does not exist.
+// Steps whose input types cannot be determined statically, such as values of
+// interface type, are evaluated as usual.
+//
+// The resulting query produces the same results as q, but avoids repeating
+// the work of resolving field names when it is evaluated many times.
+//
+// The protobuf Struct, ListValue, and Value types are unwrapped when they are
+// evaluated, so a query compiled for one of these types is not checked
+// statically, and accepts inputs of any type.
+func Compile(q Query, t reflect.Type) (Prepared, error) {
+	cq, _, err := compile(q, staticType(t))
+	if err != nil {
+		return Prepared{}, err
+	}
+	return Prepared{q: cq, t: t}, nil
+}
+
+// Check reports whether q can be evaluated on inputs of type t, and returns
+// the type of its result. It applies the same checks as Compile, so it reports
+// an error for a lookup of a field that t does not have, an index into a value
+// that is not a sequence, and any other step that is statically known to
+// fail. Steps whose input types cannot be determined are not checked. The
+// result type is nil if it cannot be determined statically; otherwise it is
+// the dynamic type of every non-nil result of q.
+//
+// Check is useful to validate a stored query when it is saved, rather than
+// when it is evaluated.
+func Check(q Query, t reflect.Type) (reflect.Type, error) {
+	_, rt, err := compile(q, staticType(t))
+	return rt, err
+}
+
+// Type returns the input type for which p was compiled.
+func (p Prepared) Type() reflect.Type { return p.t }
+
+// Eval evaluates p starting from v, as Eval. It reports an error wrapping
+// ErrArgType if v is not assignable to the type for which p was compiled. If
+// that type is an interface, v may be any value that implements it, or nil.
+func (p Prepared) Eval(v interface{}) (interface{}, error) { return Eval(p, v) }
+
+func (p Prepared) eval(v *value) (*value, error) {
+	if !p.accepts(reflect.TypeOf(v.val)) {
+		return nil, fmt.Errorf("%w: query compiled for %v, got %v", ErrArgType, p.t, reflect.TypeOf(v.val))
+	}
+	return p.q.eval(v)
+}
+
+// accepts reports whether p can be evaluated on an input of type t.
+func (p Prepared) accepts(t reflect.Type) bool {
+	switch {
+	case t == p.t || isProtoType(p.t):
+		return true
+	case p.t == nil:
+		return false
+	case t == nil:
+		return p.t.Kind() == reflect.Interface
+	}
+	return t.AssignableTo(p.t)
+}
+
+var (
+	entryType  = reflect.TypeOf(Entry{})
+	valuesType = reflect.TypeOf(Values(nil))
+	listType   = reflect.TypeOf([]interface{}(nil))
+	boolType   = reflect.TypeOf(false)
+
+	syncMapType = reflect.TypeOf((*sync.Map)(nil)).Elem()
+)
+
+// compile returns a version of q specialized for inputs of type t, along with
+// the type of its result. A nil type means the type is not statically known.
+func compile(q Query, t reflect.Type) (Query, reflect.Type, error) {
+	switch q := q.(type) {
+	case selfQuery:
+		return q, t, nil
+
+	case constQuery:
+		return q, staticType(reflect.TypeOf(q.obj)), nil
+
+	case Seq:
+		out := make(Seq, len(q))
+		for i, elt := range q {
+			cq, ct, err := compile(elt, t)
+			if _, ok := err.(*Error); ok {
+				return nil, nil, wrapError(q[:i], nil, err)
+			} else if err != nil {
+				return nil, nil, wrapError(q[:i+1], nil, err)
+			}
+			out[i], t = cq, ct
+		}
+		return out, t, nil
+
+	case keyQuery:
+		return compileKey(q, t)
+
+	case indexQuery:
+		t = derefType(t)
+		if t == nil {
+			return q, nil, nil
+		} else if t.Kind() == reflect.Map {
+			if _, ok := intMapKey(t.Key(), int(q)); ok {
+				return q, staticType(t.Elem()), nil
+			}
+		}
+		if k := t.Kind(); k != reflect.Array && k != reflect.Slice {
+			return nil, nil, fmt.Errorf("value of type %v is %w", t, ErrNotSequence)
+		} else if k == reflect.Array {
+			if n, i := t.Len(), int(q); i >= n || i < -n {
+				return nil, nil, fmt.Errorf("%w: %d is not in 0..%d", ErrBadIndex, i, n)
+			}
+		}
+		return q, staticType(t.Elem()), nil
+
+	case rangeQuery, flattenQuery, chunkQuery:
+		if t = derefType(t); t != nil {
+			if k := t.Kind(); k != reflect.Array && k != reflect.Slice {
+				return nil, nil, fmt.Errorf("value of type %v is %w", t, ErrNotSequence)
+			}
+		}
+		return q, listType, nil
+
+	case mapQuery:
+		cq, err := compileElem(q.Query, t)
+		return mapQuery{cq}, listType, err
+
+	case indexedMapQuery:
+		if t = derefType(t); t != nil {
+			switch t.Kind() {
+			case reflect.Array, reflect.Slice, reflect.Map:
+			default:
+				return nil, nil, fmt.Errorf("value of type %v is %w", t, ErrNotCollection)
+			}
+		}
+		cq, _, err := compile(q.Query, entryType)
+		return indexedMapQuery{cq}, listType, err
+
+	case lenientMapQuery:
+		cq, err := compileElem(q.Query, t)
+		return lenientMapQuery{cq}, listType, err
+
+	case typedMapQuery:
+		cq, err := compileElem(q.Query, t)
+		return typedMapQuery{cq}, nil, err
+
+	case parMapQuery:
+		cq, err := compileElem(q.Query, t)
+		return parMapQuery{Query: cq, workers: q.workers}, listType, err
+
+	case selectQuery:
+		cq, err := compileElem(q.Query, t)
+		return selectQuery{cq}, listType, err
+
+	case typedSelectQuery:
+		cq, err := compileElem(q.Query, t)
+		return typedSelectQuery{cq}, nil, err
+
+	case partitionQuery:
+		cq, err := compileElem(q.Query, t)
+		return partitionQuery{cq}, listType, err
+
+	case firstQuery:
+		cq, err := compileElem(q.Query, t)
+		return firstQuery{cq}, nil, err
+
+	case selectMapQuery:
+		if t != nil && t.Kind() != reflect.Map {
+			return nil, nil, fmt.Errorf("value of type %v is %w", t, ErrNotMap)
+		}
+		cq, err := compileElem(q.Query, t)
+		return selectMapQuery{cq}, t, err
+
+	case quantQuery:
+		cq, err := compileElem(q.Query, t)
+		q.Query = cq
+		return q, boolType, err
+
+	case specialQuery:
+		_, rt, err := compile(q.q, t)
+		return q, rt, err
+
+	case boundFuncQuery:
+		cq, rt, err := compile(q.fn, t)
+		if err != nil {
+			return nil, nil, err
+		}
+		q.fn = cq.(fnQuery)
+		return q, rt, nil
+
+	case fnQuery:
+		if t != nil && !fnArgType(t, q.argType) {
+			return nil, nil, fmt.Errorf("%w: %v is not assignable to %v", ErrArgType, t, q.argType)
+		}
+		return q, staticType(q.fn.Type().Out(0)), nil
+
+	case Map:
+		out := make(Map, len(q))
+		for key, sub := range q {
+			cq, _, err := compile(sub, t)
+			if err != nil {
+				return nil, nil, fmt.Errorf("compiling subquery %q: %w", key, err)
+			}
+			out[key] = cq
+		}
+		return out, valuesType, nil
+
+	case List:
+		out, err := compileAll(q, t)
+		return List(out), listType, err
+
+	case Cat:
+		out, err := compileAll(q, t)
+		return Cat(out), listType, err
+
+	case zipQuery:
+		out, err := compileAll(q, t)
+		return zipQuery(out), listType, err
+
+	case Or:
+		// Errors in the arms of an Or are not fatal, so an arm that cannot be
+		// compiled is retained as written.
+		out := make(Or, len(q))
+		for i, arm := range q {
+			if cq, _, err := compile(arm, t); err == nil {
+				out[i] = cq
+			} else {
+				out[i] = arm
+			}
+		}
+		return out, nil, nil
+
+	case cmpQuery, approxQuery, betweenQuery, existsQuery, containsQuery, inQuery, matchQuery, globQuery,
+		typeIsQuery:
+		return q, boolType, nil
+
+	case entriesQuery:
+		if t != nil && t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t != nil && t.Kind() != reflect.Map && t.Kind() != reflect.Struct {
+			return nil, nil, fmt.Errorf("value of type %v is %w", t, ErrNotStruct)
+		}
+		return q, listType, nil
+
+	case notQuery:
+		cq, _, err := compile(q.Query, t)
+		return notQuery{cq}, boolType, err
+
+	case logicQuery:
+		out, err := compileAll(q.qs, t)
+		return logicQuery{qs: out, stopOn: q.stopOn, name: q.name}, boolType, err
+	}
+
+	// Other queries are evaluated as written, and their result types are not
+	// statically known.
+	return q, nil, nil
+}
+
+// compileKey compiles a key lookup for inputs of type t.
+func compileKey(q keyQuery, t reflect.Type) (Query, reflect.Type, error) {
+	if t != nil && (t.Implements(keyerType) || t.Implements(seqerType)) {
+		return q, nil, nil // resolved at evaluation
+	}
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if _, ok := q.key.(paramQuery); ok || t == nil || t == syncMapType {
+		return q, nil, nil
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		name, ok := q.key.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: value of type %T cannot be a field name", ErrBadKey, q.key)
+		}
+		f, ok := fieldByName(t, name)
+		if !ok && isProtoStruct(t) {
+			return q, nil, nil // possibly a proto field name, resolved at evaluation
+		}
+		if !ok {
+			return nil, nil, fmt.Errorf("type %v has no field %q", t, name)
+		} else if f.PkgPath != "" {
+			return nil, nil, fmt.Errorf("field %q of type %v is not exported", name, t)
+		} else if f.Type == syncMapType {
+			return q, nil, nil // resolved at evaluation, see structField
+		}
+		return fieldQuery{name: name, index: f.Index, typ: t, strict: q.strict}, staticType(f.Type), nil
+
+	case reflect.Array, reflect.Slice:
+		if k := reflect.ValueOf(q.key).Kind(); isIntLike(k) || isUintLike(k) {
+			return q, staticType(t.Elem()), nil
+		}
+
+	case reflect.Map:
+		if q.key == nil {
+			return q, staticType(t.Elem()), nil // a nil key is resolved at evaluation
+		} else if !reflect.TypeOf(q.key).AssignableTo(t.Key()) {
+			return nil, nil, fmt.Errorf("%w: value of type %T cannot be a key in this map", ErrBadKey, q.key)
+		}
+		return q, staticType(t.Elem()), nil
+	}
+	return nil, nil, fmt.Errorf("value of type %v is %w", t, ErrNotStruct)
+}
+
+// compileElem compiles q for the elements of a collection of type t.
+func compileElem(q Query, t reflect.Type) (Query, error) {
+	var et reflect.Type
+	if t = derefType(t); t == syncMapType {
+		et = entryType
+	} else if t != nil {
+		switch t.Kind() {
+		case reflect.Array, reflect.Slice:
+			et = staticType(t.Elem())
+		case reflect.Map:
+			et = entryType
+		default:
+			return nil, fmt.Errorf("value of type %v is %w", t, ErrNotCollection)
+		}
+	}
+	cq, _, err := compile(q, et)
+	return cq, err
+}
+
+// compileAll compiles each of qs for inputs of type t.
+func compileAll(qs []Query, t reflect.Type) ([]Query, error) {
+	out := make([]Query, len(qs))
+	for i, q := range qs {
+		cq, _, err := compile(q, t)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = cq
+	}
+	return out, nil
+}
+
+// staticType returns t if it is the type of a value whose concrete type is
+// known statically, or nil if t is an interface type or a protobuf type that
+// is unwrapped when it is evaluated.
+func staticType(t reflect.Type) reflect.Type {
+	if t == nil || t.Kind() == reflect.Interface || isProtoType(t) {
+		return nil
+	}
+	return t
+}
+
+// derefType returns the type reached by following pointers from t, as the
+// queries over sequences and maps do when they are evaluated. The result is
+// nil if t is a Seqer, whose elements are known only when it is evaluated.
+func derefType(t reflect.Type) reflect.Type {
+	if t != nil && t.Implements(seqerType) {
+		return nil
+	}
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return staticType(t)
+}
+
+// fieldQuery is a lookup of a struct field whose index has been resolved.
+type fieldQuery struct {
+	name   string
+	index  []int        // the index of the field in typ
+	typ    reflect.Type // the struct type for which index was resolved
+	strict bool         // report an error if the field is missing, as KeyStrict
+}
+
+// key returns the uncompiled query for f.
+func (f fieldQuery) key() keyQuery { return keyQuery{key: f.name, strict: f.strict} }
+
+func (f fieldQuery) eval(v *value) (*value, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v.val))
+	if !rv.IsValid() || rv.Type() != f.typ {
+		return f.key().eval(v)
+	}
+	fv, err := rv.FieldByIndexErr(f.index)
+	if err != nil {
+		// A nil embedded pointer along the path; treat the field as missing.
+		if f.strict {
+			return nil, fmt.Errorf("%w: %#v", ErrNoKey, f.name)
+		}
+		return v.child(keyQuery{key: f.name}, nil), nil
+	}
+	return v.child(keyQuery{key: f.name}, fv.Interface()), nil
+}
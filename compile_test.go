@@ -0,0 +1,287 @@
+package vql_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/vql"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+type Base struct{ ID int }
+
+type compItem struct {
+	Base
+	Name  string
+	Tags  []string
+	Attrs map[string]interface{}
+	Next  *compItem
+	Any   interface{}
+	Pair  [2]int
+	PTags *[]string
+}
+
+func TestCompile(t *testing.T) {
+	input := &compItem{
+		Base:  Base{ID: 1},
+		Name:  "first",
+		Tags:  []string{"a", "b"},
+		Attrs: map[string]interface{}{"color": "red", "size": 3},
+		Next:  &compItem{Name: "second", Any: map[string]int{"k": 5}},
+		Any:   struct{ Q string }{Q: "hidden"},
+		Pair:  [2]int{4, 5},
+	}
+	input.PTags = &input.Tags
+	ptype := reflect.TypeOf(input)
+
+	tests := []struct {
+		query vql.Query
+		want  interface{}
+	}{
+		{vql.Key("Name"), "first"},
+		{vql.Key("ID"), 1},
+		{vql.Key("Next", "Name"), "second"},
+		{vql.Key("Next", "Next"), (*compItem)(nil)},
+		{vql.Key("Next", "Any", "k"), 5},
+		{vql.Key("Any", "Q"), "hidden"},
+		{vql.Key("Attrs", "color"), "red"},
+		{vql.Key("Attrs", nil), nil},
+		{vql.Seq{vql.Key("Tags"), vql.Index(-1)}, "b"},
+		{vql.Seq{vql.Key("Pair"), vql.Index(1)}, 5},
+		{vql.Seq{vql.Key("Tags"), vql.Each(vql.Func(strings.ToUpper))}, []interface{}{"A", "B"}},
+		{vql.Seq{vql.Key("Attrs"), vql.Select(vql.Key("Key"), vql.Eq("size")), vql.Each(vql.Key("Value"))},
+			[]interface{}{3}},
+		{vql.Seq{vql.Key("Tags"), vql.EachIndexed(vql.Key("Key"))}, []interface{}{0, 1}},
+		{vql.Seq{vql.Key("PTags"), vql.Index(1)}, "b"},
+		{vql.Key("Tags", -1), "b"},
+		{vql.Key("Pair", 0), 4},
+		{vql.Seq{vql.Key("PTags"), vql.Each(vql.Func(strings.ToUpper))}, []interface{}{"A", "B"}},
+		{vql.Map{"n": vql.Key("Name"), "id": vql.Key("ID")}, vql.Values{"n": "first", "id": 1}},
+		{vql.List{vql.Key("Name"), vql.Key("Next", "Name")}, []interface{}{"first", "second"}},
+		{vql.Or{vql.Key("Nonesuch"), vql.Key("Name")}, "first"},
+		{vql.Seq{vql.Key("Tags"), vql.Every(vql.Func(func(s string) bool { return s != "" }))}, true},
+	}
+	for _, test := range tests {
+		p, err := vql.Compile(test.query, ptype)
+		if err != nil {
+			t.Errorf("Compile(%v): unexpected error: %v", test.query, err)
+			continue
+		}
+		got, err := p.Eval(input)
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("Eval(%v): (-want, +got)\n%s", test.query, diff)
+		}
+	}
+
+	// A prepared query rejects inputs of the wrong type.
+	p, err := vql.Compile(vql.Key("Name"), ptype)
+	if err != nil {
+		t.Fatalf("Compile: unexpected error: %v", err)
+	}
+	if got, err := p.Eval(*input); !errors.Is(err, vql.ErrArgType) {
+		t.Errorf("Eval: got (%v, %v), want %v", got, err, vql.ErrArgType)
+	}
+
+	// A query compiled for an interface type accepts any value that
+	// implements it.
+	stype := reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	sp, err := vql.Compile(vql.Method("String"), stype)
+	if err != nil {
+		t.Fatalf("Compile: unexpected error: %v", err)
+	}
+	if got, err := sp.Eval(stype); err != nil || got != "fmt.Stringer" {
+		t.Errorf("Eval: got (%v, %v), want (fmt.Stringer, nil)", got, err)
+	}
+	if got, err := sp.Eval(25); !errors.Is(err, vql.ErrArgType) {
+		t.Errorf("Eval: got (%v, %v), want %v", got, err, vql.ErrArgType)
+	}
+
+	// A prepared query can be composed with other queries.
+	got, err := vql.Eval(vql.Each(p), []*compItem{input, input.Next})
+	if err != nil {
+		t.Errorf("Eval: unexpected error: %v", err)
+	} else if diff := cmp.Diff([]interface{}{"first", "second"}, got); diff != "" {
+		t.Errorf("Eval: (-want, +got)\n%s", diff)
+	}
+}
+
+func TestCompileStrict(t *testing.T) {
+	type item struct {
+		*Base
+		Name string
+	}
+	itype := reflect.TypeOf(item{})
+	p, err := vql.Compile(vql.KeyStrict("ID"), itype)
+	if err != nil {
+		t.Fatalf("Compile: unexpected error: %v", err)
+	}
+	if got := fmt.Sprint(p); got != `strict("ID")` {
+		t.Errorf("Compile: formats as %q", got)
+	}
+	if got, err := p.Eval(item{Base: &Base{ID: 3}}); err != nil || got != 3 {
+		t.Errorf("Eval: got (%v, %v), want (3, nil)", got, err)
+	}
+	if got, err := p.Eval(item{}); !errors.Is(err, vql.ErrNoKey) {
+		t.Errorf("Eval: got (%v, %v), want %v", got, err, vql.ErrNoKey)
+	}
+
+	// The same lookup without KeyStrict treats the field as missing.
+	p, err = vql.Compile(vql.Key("ID"), itype)
+	if err != nil {
+		t.Fatalf("Compile: unexpected error: %v", err)
+	}
+	if got, err := p.Eval(item{}); err != nil || got != nil {
+		t.Errorf("Eval: got (%v, %v), want (nil, nil)", got, err)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	ptype := reflect.TypeOf(&compItem{})
+	tests := []vql.Query{
+		vql.Key("Nonesuch"),
+		vql.Key("Next", "Nonesuch"),
+		vql.Key(25),
+		vql.Key("Attrs", 25),
+		vql.Key("Tags", nil),
+		vql.Key("Name", "X"),
+		vql.Seq{vql.Key("Name"), vql.Index(0)},
+		vql.Seq{vql.Key("Pair"), vql.Index(2)},
+		vql.Seq{vql.Key("Attrs"), vql.Index(0)},
+		vql.Seq{vql.Key("Tags", 0), vql.Each(vql.Self)},
+		vql.Seq{vql.Key("Name"), vql.Each(vql.Self)},
+		vql.Seq{vql.Key("Tags"), vql.Each(vql.Key("X"))},
+		vql.Seq{vql.Key("Name"), vql.Func(func(int) bool { return true })},
+		vql.Map{"x": vql.Key("Nonesuch")},
+		vql.List{vql.Key("Name"), vql.Key("Nonesuch")},
+	}
+	for _, q := range tests {
+		p, err := vql.Compile(q, ptype)
+		if err == nil {
+			t.Errorf("Compile(%v): got %v, want error", q, p)
+		} else {
+			t.Logf("Compile(%v): got expected error: %v", q, err)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ptype := reflect.TypeOf(&compItem{})
+	tests := []struct {
+		query vql.Query
+		want  reflect.Type
+	}{
+		{vql.Self, ptype},
+		{vql.Key("Name"), reflect.TypeOf("")},
+		{vql.Key("ID"), reflect.TypeOf(0)},
+		{vql.Key("Next", "Next", "Tags"), reflect.TypeOf([]string(nil))},
+		{vql.Seq{vql.Key("Tags"), vql.Index(-1)}, reflect.TypeOf("")},
+		{vql.Seq{vql.Key("Tags"), vql.Each(vql.Self)}, reflect.TypeOf([]interface{}(nil))},
+		{vql.Seq{vql.Key("Name"), vql.Eq("x")}, reflect.TypeOf(true)},
+		{vql.Seq{vql.Key("Name"), vql.Func(strings.ToUpper)}, reflect.TypeOf("")},
+		{vql.Key("Any"), nil},
+		{vql.Key("Any", "X", "Y"), nil},
+		{vql.Key("Attrs", "k"), nil},
+	}
+	for _, test := range tests {
+		got, err := vql.Check(test.query, ptype)
+		if err != nil {
+			t.Errorf("Check(%v): unexpected error: %v", test.query, err)
+		} else if got != test.want {
+			t.Errorf("Check(%v): got type %v, want %v", test.query, got, test.want)
+		}
+	}
+
+	// Check reports the same errors as Compile, with the query path.
+	got, err := vql.Check(vql.Key("Next", "Nonesuch"), ptype)
+	var qerr *vql.Error
+	if !errors.As(err, &qerr) {
+		t.Fatalf("Check: got (%v, %v), want *vql.Error", got, err)
+	} else if got, want := fmt.Sprint(qerr.Path), "[Next Nonesuch]"; got != want {
+		t.Errorf("Check: error path is %s, want %s", got, want)
+	}
+}
+
+func TestInferShape(t *testing.T) {
+	ptype := reflect.TypeOf(&compItem{})
+	tests := []struct {
+		query vql.Query
+		want  string
+	}{
+		{vql.Key("Name"), "string"},
+		{vql.Key("Any"), "any"},
+		{vql.Key("Tags"), "[]string"},
+		{vql.Seq{vql.Key("Tags"), vql.Each(vql.Self)}, "[]string"},
+		{vql.Seq{vql.Key("Tags"), vql.Each(vql.Func(strings.ToUpper)), vql.Index(0)}, "string"},
+		{vql.Seq{vql.Key("Tags"), vql.Select(vql.Eq("x"))}, "[]string"},
+		{vql.Seq{vql.Key("Tags"), vql.Each(vql.Eq("x"))}, "[]bool"},
+		{vql.Seq{vql.Key("Attrs"), vql.Each(vql.Key("Key"))}, "[]any"},
+		{vql.Map{"name": vql.Key("Name"), "id": vql.Key("ID"), "two words": vql.Key("Pair")},
+			`Values{id: int, name: string, "two words": [2]int}`},
+		{vql.Seq{
+			vql.Key("Next", "Tags"),
+			vql.Each(vql.Map{"tag": vql.Self, "upper": vql.Func(strings.ToUpper)}),
+			vql.Select(vql.Key("upper"), vql.Eq("X")),
+		}, "[]Values{tag: string, upper: string}"},
+		{vql.Seq{vql.Map{"n": vql.Key("Name")}, vql.Key("n")}, "string"},
+		{vql.List{vql.Key("Name"), vql.Key("Name")}, "[]string"},
+		{vql.List{vql.Key("Name"), vql.Key("ID")}, "[]any"},
+	}
+	for _, test := range tests {
+		got, err := vql.InferShape(test.query, ptype)
+		if err != nil {
+			t.Errorf("InferShape(%v): unexpected error: %v", test.query, err)
+		} else if s := got.String(); s != test.want {
+			t.Errorf("InferShape(%v): got %s, want %s", test.query, s, test.want)
+		}
+	}
+
+	if got, err := vql.InferShape(vql.Key("Nonesuch"), ptype); err == nil {
+		t.Errorf("InferShape: got %v, want error", got)
+	}
+}
+
+func TestValueShape(t *testing.T) {
+	tests := []struct {
+		input interface{}
+		want  string
+	}{
+		{nil, "any"},
+		{"x", "string"},
+		{[]interface{}{1, nil, 2}, "[]int"},
+		{[]interface{}{1, "x"}, "[]any"},
+		{[]interface{}{}, "[]any"},
+		{vql.Values{"a": 1, "b": []interface{}{"x"}}, "Values{a: int, b: []string}"},
+		{[]string{"x"}, "[]string"},
+	}
+	for _, test := range tests {
+		if got := vql.ValueShape(test.input).String(); got != test.want {
+			t.Errorf("ValueShape(%v): got %s, want %s", test.input, got, test.want)
+		}
+	}
+}
+
+func BenchmarkCompile(b *testing.B) {
+	input := &compItem{Name: "x", Next: &compItem{Name: "y"}}
+	q := vql.Key("Next", "Name")
+	b.Run("Dynamic", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			vql.Eval(q, input)
+		}
+	})
+	b.Run("Compiled", func(b *testing.B) {
+		p, err := vql.Compile(q, reflect.TypeOf(input))
+		if err != nil {
+			b.Fatalf("Compile: %v", err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			p.Eval(input)
+		}
+	})
+}
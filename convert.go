@@ -0,0 +1,131 @@
+package vql
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ToInt returns a Query that converts its input to an int64. An integer is
+// converted if it is in range, a floating-point number is truncated toward
+// zero, and a string is parsed as a decimal integer or floating-point number,
+// ignoring leading and trailing white space. It is an error if the input is
+// any other value, or if it is out of the range of int64.
+//
+// The conversion queries ToInt, ToFloat, and ToString are useful for data
+// whose types are not consistent, such as decoded YAML, in which a number may
+// appear as a string. For example, Seq{Key("port"), ToInt(), Gt(1024)} works
+// whether the port is written as 8080 or "8080".
+func ToInt() Query { return convertQuery("toInt") }
+
+// ToFloat returns a Query that converts its input to a float64. A number is
+// converted to the nearest float64, and a string is parsed as a decimal
+// number, ignoring leading and trailing white space. It is an error if the
+// input is any other value.
+func ToFloat() Query { return convertQuery("toFloat") }
+
+// ToString returns a Query that converts its input to a string. A string or
+// byte slice is converted directly, a number or bool is formatted in the style
+// of the strconv package, and a value that implements fmt.Stringer is
+// rendered by its String method. It is an error if the input is any other
+// value.
+func ToString() Query { return convertQuery("toString") }
+
+// convertQuery is a conversion, identified by the name of its combinator.
+type convertQuery string
+
+func (c convertQuery) eval(v *value) (*value, error) {
+	var out interface{}
+	var err error
+	switch c {
+	case "toInt":
+		out, err = convertInt(v.val)
+	case "toFloat":
+		out, err = convertFloat(v.val)
+	case "toString":
+		out, err = convertString(v.val)
+	default:
+		panic("unknown conversion " + string(c))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pushValue(v, out), nil
+}
+
+// convertInt converts obj to an int64, as described by ToInt.
+func convertInt(obj interface{}) (int64, error) {
+	rv := reflect.ValueOf(obj)
+	switch k := rv.Kind(); {
+	case isIntLike(k):
+		return rv.Int(), nil
+	case isUintLike(k):
+		if u := rv.Uint(); u <= math.MaxInt64 {
+			return int64(u), nil
+		}
+		return 0, fmt.Errorf("value %v is out of range for int64", obj)
+	case isFloatLike(k):
+		return truncFloat(rv.Float())
+	case k == reflect.String:
+		s := strings.TrimSpace(rv.String())
+		if z, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return z, nil
+		} else if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return truncFloat(f)
+		}
+		return 0, fmt.Errorf("cannot convert %q to int64: %w", rv.String(), ErrNotNumber)
+	}
+	return 0, fmt.Errorf("cannot convert value of type %T to int64: %w", obj, ErrNotNumber)
+}
+
+// truncFloat truncates f toward zero, reporting an error if the result is not
+// in the range of int64.
+func truncFloat(f float64) (int64, error) {
+	t := math.Trunc(f)
+	if math.IsNaN(t) || t < math.MinInt64 || t >= math.MaxInt64 {
+		return 0, fmt.Errorf("value %v is out of range for int64", f)
+	}
+	return int64(t), nil
+}
+
+// convertFloat converts obj to a float64, as described by ToFloat.
+func convertFloat(obj interface{}) (float64, error) {
+	if f, ok := toFloat(obj); ok {
+		return f, nil
+	} else if rv := reflect.ValueOf(obj); rv.Kind() == reflect.String {
+		f, err := strconv.ParseFloat(strings.TrimSpace(rv.String()), 64)
+		if err == nil {
+			return f, nil
+		}
+		return 0, fmt.Errorf("cannot convert %q to float64: %w", rv.String(), ErrNotNumber)
+	}
+	return 0, fmt.Errorf("cannot convert value of type %T to float64: %w", obj, ErrNotNumber)
+}
+
+// convertString converts obj to a string, as described by ToString.
+func convertString(obj interface{}) (string, error) {
+	switch t := obj.(type) {
+	case string:
+		return t, nil
+	case []byte:
+		return string(t), nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case fmt.Stringer:
+		return t.String(), nil
+	}
+	rv := reflect.ValueOf(obj)
+	switch k := rv.Kind(); {
+	case k == reflect.String:
+		return rv.String(), nil
+	case isIntLike(k):
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case isUintLike(k):
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case isFloatLike(k):
+		return strconv.FormatFloat(rv.Float(), 'g', -1, rv.Type().Bits()), nil
+	}
+	return "", fmt.Errorf("cannot convert value of type %T to string: %w", obj, ErrNotString)
+}
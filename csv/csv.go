@@ -0,0 +1,111 @@
+// Package csv adapts tabular data in comma-separated values (CSV) format so
+// that it can be traversed by vql queries.
+//
+// Each row of a table is represented as a Record, whose fields are looked up
+// by the names of the columns given in the header of the table. A table is a
+// slice of records, so that queries such as vql.Each and vql.Select apply to
+// its rows, for example:
+//
+//	rows, err := csv.Read(strings.NewReader("name,age\nalice,30\nbob,25\n"))
+//	...
+//	names, err := vql.Eval(vql.Seq{
+//	   vql.Select(vql.Key("age"), vql.ToInt(), vql.Gt(26)),
+//	   vql.Each(vql.Key("name")),
+//	}, rows)
+//
+// The fields of a record are strings; use vql.ToInt or vql.ToFloat to compare
+// them as numbers.
+package csv
+
+import (
+	stdcsv "encoding/csv"
+	"fmt"
+	"io"
+
+	"github.com/creachadair/vql"
+)
+
+// A Record is one row of a table, whose fields are named by the columns of
+// the table. A Record satisfies vql.Keyer and vql.Seqer, so that vql.Key
+// looks up the field in a named column, and queries that iterate, such as
+// vql.Each, visit the fields in column order as values of type vql.Entry.
+type Record struct {
+	cols   map[string]int // column name to offset
+	names  []string       // column names, in order
+	fields []string
+}
+
+// Records constructs a table from the given column names and rows. If a name
+// occurs more than once in header, only the first such column is visible by
+// that name. A row with fewer fields than header has no value for the
+// remaining columns, and fields beyond the end of the header are ignored.
+func Records(header []string, rows [][]string) []Record {
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		if _, ok := cols[name]; !ok {
+			cols[name] = i
+		}
+	}
+	out := make([]Record, len(rows))
+	for i, row := range rows {
+		out[i] = Record{cols: cols, names: header, fields: row}
+	}
+	return out
+}
+
+// Read reads a table in CSV format from r, as by a csv.Reader from the
+// encoding/csv package with its default settings. The first record of the
+// input is the header that names the columns, and the remaining records are
+// the rows of the table. Records with varying numbers of fields are allowed,
+// as described for Records. It is an error if the input is empty.
+//
+// To read input with other settings, such as a different field delimiter,
+// read the records with a csv.Reader and pass them to Records.
+func Read(r io.Reader) ([]Record, error) {
+	cr := stdcsv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	recs, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	} else if len(recs) == 0 {
+		return nil, fmt.Errorf("missing header: %w", io.ErrUnexpectedEOF)
+	}
+	return Records(recs[0], recs[1:]), nil
+}
+
+// Get returns the value of the field in the named column, and reports whether
+// the record has a value for that column.
+func (r Record) Get(name string) (string, bool) {
+	i, ok := r.cols[name]
+	if !ok || i >= len(r.fields) {
+		return "", false
+	}
+	return r.fields[i], true
+}
+
+// QueryKey satisfies vql.Keyer. The key must be a column name.
+func (r Record) QueryKey(key interface{}) (interface{}, bool) {
+	name, ok := key.(string)
+	if !ok {
+		return nil, false
+	}
+	s, ok := r.Get(name)
+	if !ok {
+		return nil, false
+	}
+	return s, true
+}
+
+// QuerySeq satisfies vql.Seqer. The elements are the fields of r, in column
+// order, as values of type vql.Entry whose keys are the column names.
+func (r Record) QuerySeq() []interface{} {
+	n := len(r.fields)
+	if len(r.names) < n {
+		n = len(r.names)
+	}
+	out := make([]interface{}, n)
+	for i := range out {
+		out[i] = vql.Entry{Key: r.names[i], Value: r.fields[i]}
+	}
+	return out
+}
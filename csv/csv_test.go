@@ -0,0 +1,66 @@
+package csv_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/vql"
+	"github.com/creachadair/vql/csv"
+	"github.com/google/go-cmp/cmp"
+)
+
+const table = `name,age,city
+alice,30,paris
+bob,25
+carol,41,oslo,extra
+`
+
+func TestQueries(t *testing.T) {
+	rows, err := csv.Read(strings.NewReader(table))
+	if err != nil {
+		t.Fatalf("Read: unexpected error: %v", err)
+	}
+	tests := []struct {
+		query vql.Query
+		want  interface{}
+	}{
+		{vql.Count(), 3},
+		{vql.Key(0, "name"), "alice"},
+		{vql.Seq{vql.Index(1), vql.Key("city")}, nil},
+		{vql.Seq{vql.Index(2), vql.Count()}, 3},
+		{vql.Each(vql.Key("city")), []interface{}{"paris", nil, "oslo"}},
+		{vql.Seq{
+			vql.Select(vql.Key("age"), vql.ToInt(), vql.Gt(26)),
+			vql.Each(vql.Key("name")),
+		}, []interface{}{"alice", "carol"}},
+		{vql.Seq{vql.Index(0), vql.Each(vql.Key("Key"))}, []interface{}{"name", "age", "city"}},
+		{vql.Seq{vql.Each(vql.Key("age")), vql.Each(vql.ToInt()), vql.Sum(vql.Self)}, int64(96)},
+		{vql.Seq{vql.Index(1), vql.Exists("city")}, false},
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(test.query, rows)
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval(%v): (-want, +got)\n%s", test.query, diff)
+		}
+	}
+}
+
+func TestRecords(t *testing.T) {
+	rows := csv.Records([]string{"a", "b", "a"}, [][]string{{"1", "2", "3"}})
+	if got, ok := rows[0].Get("a"); !ok || got != "1" {
+		t.Errorf("Get(a): got (%q, %v), want (1, true)", got, ok)
+	}
+	if got, ok := rows[0].Get("c"); ok {
+		t.Errorf("Get(c): got (%q, %v), want false", got, ok)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, input := range []string{"", "a,\"b\n"} {
+		if got, err := csv.Read(strings.NewReader(input)); err == nil {
+			t.Errorf("Read(%q): got %v, want error", input, got)
+		}
+	}
+}
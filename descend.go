@@ -0,0 +1,194 @@
+package vql
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+)
+
+// Descend returns a Query that evaluates q at every value reachable from its
+// input, including the input itself, and yields a slice of concrete type
+// []interface{} containing each non-nil result. Values are visited in
+// depth-first order, with each value visited before its contents. The
+// contents of a value are the exported fields of a struct, the values of a
+// map (in the order of their keys), and the elements of an array or slice;
+// pointers and interfaces are followed to the values they refer to. Errors in
+// evaluating q are ignored.
+//
+// For example, Descend(Key("id")) finds the values of all the "id" keys in a
+// structure, at any depth.
+//
+// If the input contains a cycle, such as a pointer to a struct that refers
+// back to itself, Descend does not terminate unless evaluation uses the
+// DetectCycles option, or ends by the context passed to EvalContext.
+func Descend(q Query) Query { return descendQuery{q} }
+
+type descendQuery struct{ Query }
+
+func (d descendQuery) eval(v *value) (*value, error) {
+	var vs []interface{}
+	var elems []*pathStep
+	var active map[refKey]bool // values being visited, if detecting cycles
+	if v.env.cycles {
+		active = make(map[refKey]bool)
+	}
+	// Errors in evaluating q are ignored, but exceeding a limit on the
+	// evaluation stops the walk.
+	var walk func(*value) error
+	walk = func(cur *value) error {
+		if cur.env.ctx.Err() != nil {
+			return nil
+		}
+		if active != nil {
+			if key, ok := refKeyOf(cur.val); ok {
+				if active[key] {
+					return nil // a cycle; this value is already being visited
+				}
+				active[key] = true
+				defer delete(active, key)
+			}
+		}
+		if next, err := evalQuery(d.Query, cur); err == nil && next.val != nil {
+			vs = append(vs, next.val)
+			elems = v.appendElem(elems, next.path)
+		} else if errors.Is(err, ErrLimit) {
+			return err
+		}
+		rv := indirect(reflect.ValueOf(cur.val))
+		switch rv.Kind() {
+		case reflect.Struct, reflect.Map, reflect.Array, reflect.Slice:
+			if cur.env.limits != nil {
+				if err := cur.env.descend(); err != nil {
+					return err
+				}
+				defer cur.env.leave()
+			}
+		}
+		switch rv.Kind() {
+		case reflect.Struct:
+			t := rv.Type()
+			for i := 0; i < t.NumField(); i++ {
+				if f := t.Field(i); f.PkgPath == "" { // exported
+					if err := walk(cur.child(keyQuery{key: f.Name}, rv.Field(i).Interface())); err != nil {
+						return err
+					}
+				}
+			}
+		case reflect.Map:
+			for _, key := range mapKeys(rv) {
+				if err := walk(cur.child(keyQuery{key: key.Interface()}, rv.MapIndex(key).Interface())); err != nil {
+					return err
+				}
+			}
+		case reflect.Array, reflect.Slice:
+			for i := 0; i < rv.Len(); i++ {
+				if err := walk(cur.elem(i, rv.Index(i).Interface())); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(v); err != nil {
+		return nil, err
+	}
+	if err := v.env.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return pushValue(v, vs).withElems(elems), nil
+}
+
+// DetectCycles returns an Option that makes Descend detect cycles in its
+// input. A value that refers to itself, directly or through other values, is
+// visited once, and the reference that closes the cycle is skipped. The
+// values compared are pointers, maps, and slices, which are the same if they
+// have the same type and refer to the same location. Values that are shared
+// without forming a cycle are visited at each place they occur.
+func DetectCycles() Option { return detectCycles{} }
+
+type detectCycles struct{}
+
+func (detectCycles) apply(e *env) { e.cycles = true }
+
+// A refKey identifies the location referred to by a pointer, map, or slice.
+type refKey struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+// refKeyOf returns the refKey of obj, and reports whether obj is a non-nil
+// pointer, map, or slice.
+func refKeyOf(obj interface{}) (refKey, bool) {
+	rv := reflect.ValueOf(obj)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map:
+		if !rv.IsNil() {
+			return refKey{typ: rv.Type(), ptr: rv.Pointer()}, true
+		}
+	case reflect.Slice:
+		if !rv.IsNil() {
+			return refKey{typ: rv.Type(), ptr: rv.Pointer(), len: rv.Len()}, true
+		}
+	}
+	return refKey{}, false
+}
+
+// mapKeys returns the keys of the map rv, in the order described for Entry.
+func mapKeys(rv reflect.Value) []reflect.Value {
+	keys := rv.MapKeys()
+	var less func(a, b reflect.Value) bool
+	switch k := rv.Type().Key().Kind(); {
+	case k == reflect.String:
+		less = func(a, b reflect.Value) bool { return a.String() < b.String() }
+	case isIntLike(k):
+		less = func(a, b reflect.Value) bool { return a.Int() < b.Int() }
+	case isUintLike(k):
+		less = func(a, b reflect.Value) bool { return a.Uint() < b.Uint() }
+	case isFloatLike(k):
+		less = func(a, b reflect.Value) bool { return a.Float() < b.Float() }
+	case k == reflect.Bool:
+		less = func(a, b reflect.Value) bool { return !a.Bool() && b.Bool() }
+	case k == reflect.Interface:
+		less = func(a, b reflect.Value) bool { return keyLess(a.Elem(), b.Elem()) }
+	default:
+		return keys
+	}
+	sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+	return keys
+}
+
+// keyLess reports whether the dynamic map key a is ordered before b, in the
+// order described for mapKeys.
+func keyLess(a, b reflect.Value) bool {
+	ra, rb := keyRank(a), keyRank(b)
+	if ra != rb {
+		return ra < rb
+	}
+	switch ra {
+	case 1:
+		return !a.Bool() && b.Bool()
+	case 2:
+		c, ok := compareNumbers(a, b)
+		return ok && c < 0
+	case 3:
+		return a.String() < b.String()
+	}
+	return false
+}
+
+// keyRank returns the position of the kind of key in the order described for
+// mapKeys.
+func keyRank(key reflect.Value) int {
+	switch k := key.Kind(); {
+	case k == reflect.Invalid:
+		return 0
+	case k == reflect.Bool:
+		return 1
+	case isNumberKind(k):
+		return 2
+	case k == reflect.String:
+		return 3
+	}
+	return 4
+}
@@ -0,0 +1,268 @@
+package vql
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Errors reported during evaluation wrap one of these sentinel values when
+// the cause is one of the conditions they describe. Use errors.Is to check
+// for them.
+var (
+	// ErrNotStruct indicates that a field or key lookup was applied to a value
+	// that is not a struct or map.
+	ErrNotStruct = errors.New("not a struct or map")
+
+	// ErrNotSequence indicates that an indexing operation was applied to a
+	// value that is not an array or slice.
+	ErrNotSequence = errors.New("not an array or slice")
+
+	// ErrNotCollection indicates that an iteration was applied to a value that
+	// is not an array, map, or slice.
+	ErrNotCollection = errors.New("not an array, map, or slice")
+
+	// ErrNotMap indicates that an operation on maps was applied to a value
+	// that is not a map.
+	ErrNotMap = errors.New("not a map")
+
+	// ErrBadKey indicates that a key has the wrong type for the value it was
+	// used to look up.
+	ErrBadKey = errors.New("invalid key")
+
+	// ErrNoKey indicates that a strict lookup found no field or map entry
+	// with the requested key.
+	ErrNoKey = errors.New("key not found")
+
+	// ErrBadIndex indicates that an index is out of range.
+	ErrBadIndex = errors.New("index out of range")
+
+	// ErrNotBool indicates that a predicate query yielded a non-bool value.
+	ErrNotBool = errors.New("not a bool")
+
+	// ErrArgType indicates that a function was applied to an input value that
+	// is not compatible with its argument type.
+	ErrArgType = errors.New("invalid argument type")
+
+	// ErrNotNumber indicates that an arithmetic operation was applied to a
+	// value that is not a number.
+	ErrNotNumber = errors.New("not a number")
+
+	// ErrNotString indicates that a string operation was applied to a value
+	// that is not a string.
+	ErrNotString = errors.New("not a string")
+
+	// ErrNotComparable indicates that two values could not be compared.
+	ErrNotComparable = errors.New("not comparable")
+
+	// ErrFailed indicates that a value did not satisfy a requirement stated
+	// by the query, as for Require.
+	ErrFailed = errors.New("check failed")
+
+	// ErrResultType indicates that the result of a query does not have the
+	// type requested by the caller.
+	ErrResultType = errors.New("wrong result type")
+
+	// ErrLimit indicates that evaluation exceeded a limit set by an option
+	// such as MaxSteps or MaxDepth.
+	ErrLimit = errors.New("evaluation limit exceeded")
+
+	// ErrOverflow indicates that the result of an arithmetic operation does
+	// not fit in the type of its result, as for Sum.
+	ErrOverflow = errors.New("numeric overflow")
+)
+
+// Error is the concrete type of errors reported by Eval when evaluation of a
+// query fails. It records where in the structure of the query the failure
+// occurred.
+type Error struct {
+	// Path records the steps of the query that led to the failure, in order
+	// from the start of the query. The last step is the one that failed.
+	// Steps that iterate over a collection record the position of the element
+	// that failed as a Key or Index step.
+	Path []Query
+
+	// Value is the input value to the step that failed.
+	Value interface{}
+
+	// Err is the underlying error reported by the failing step.
+	Err error
+}
+
+// Error satisfies the error interface.
+func (e *Error) Error() string {
+	if len(e.Path) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("at %v: %v", Seq(e.Path), e.Err)
+}
+
+// Unwrap returns the underlying error, for use with errors.Is and errors.As.
+func (e *Error) Unwrap() error { return e.Err }
+
+// DecodeError is the concrete type of errors reported by EvalJSON and similar
+// functions when their input document cannot be decoded. It distinguishes a
+// malformed input from a failure of the query, which is reported as an *Error.
+type DecodeError struct {
+	Format string // the format of the input, such as "JSON"
+	Err    error  // the error reported by the decoder
+}
+
+// Error satisfies the error interface.
+func (e *DecodeError) Error() string { return "decoding " + e.Format + ": " + e.Err.Error() }
+
+// Unwrap returns the underlying error, for use with errors.Is and errors.As.
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// PanicError is the concrete type of the error reported when a function
+// applied by Func or FuncRef, or a method called by Method, panics. Evaluation
+// recovers from the panic, and reports a *PanicError as the cause of the
+// failing step, so the *Error that contains it gives the location of the
+// function in the query.
+type PanicError struct {
+	Func  string      // a description of the function that panicked
+	Value interface{} // the value passed to panic
+	Stack []byte      // the stack trace of the panic, as from debug.Stack
+}
+
+// Error satisfies the error interface.
+func (e *PanicError) Error() string { return fmt.Sprintf("panic in %s: %v", e.Func, e.Value) }
+
+// Unwrap returns the value passed to panic, if it is an error, for use with
+// errors.Is and errors.As. Otherwise it returns nil.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// callFunc calls fn with args, using CallSlice if spread is true, and returns
+// its results. If fn panics, the panic is recovered, and callFunc reports a
+// *PanicError for the function described by name, which is only called if
+// that happens.
+func callFunc(fn reflect.Value, spread bool, args []reflect.Value, name func() string) (_ []reflect.Value, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = &PanicError{Func: name(), Value: p, Stack: debug.Stack()}
+		}
+	}()
+	if spread {
+		return fn.CallSlice(args), nil
+	}
+	return fn.Call(args), nil
+}
+
+// funcName returns a description of the function fn for use in errors.
+func funcName(fn reflect.Value) string {
+	if f := runtime.FuncForPC(fn.Pointer()); f != nil {
+		return "function " + f.Name()
+	}
+	return "function of type " + fn.Type().String()
+}
+
+// wrapError returns an *Error for err, with path prepended to its path. If
+// err is not already an *Error, obj is recorded as the offending value.
+func wrapError(path []Query, obj interface{}, err error) error {
+	if e, ok := err.(*Error); ok {
+		return &Error{Path: flatten(path, e.Path...), Value: e.Value, Err: e.Err}
+	}
+	return &Error{Path: flatten(path), Value: obj, Err: err}
+}
+
+// flatten returns a new slice containing the steps of path followed by those
+// of rest, with any nested Seq values expanded in place.
+func flatten(path []Query, rest ...Query) []Query {
+	var out []Query
+	var add func([]Query)
+	add = func(qs []Query) {
+		for _, q := range qs {
+			if s, ok := q.(Seq); ok {
+				add(s)
+			} else {
+				out = append(out, q)
+			}
+		}
+	}
+	add(path)
+	add(rest)
+	return out
+}
+
+// Errors is an error that combines several errors reported together, such as
+// the failures recorded by a Report, or the errors collected by the
+// CollectErrors option. The order of the errors is significant.
+type Errors []error
+
+// Error satisfies the error interface.
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strconv.Itoa(len(e)) + " errors: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the errors in e, for use with errors.Is and errors.As.
+func (e Errors) Unwrap() []error { return e }
+
+// Is reports whether any of the errors in e matches target, as errors.Is. It
+// allows errors.Is to match the errors in e with versions of Go before 1.20,
+// which do not use the Unwrap method.
+func (e Errors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors in e that matches target, as errors.As,
+// and if one is found, sets target to that error value and returns true.
+func (e Errors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// CollectErrors returns an Option that records in *errs the errors of the
+// elements skipped by EachLenient during an evaluation. Each recorded error is
+// an *Error whose Path gives the location of the skipped element in the
+// input, followed by the steps of the query that failed on it. If no elements
+// were skipped, *errs is not modified.
+//
+// For example:
+//
+//	var errs vql.Errors
+//	names, err := vql.EvalWith(vql.EachLenient(vql.KeyStrict("Name")), input, vql.CollectErrors(&errs))
+//	for _, e := range errs {
+//	   log.Printf("skipped: %v", e)
+//	}
+func CollectErrors(errs *Errors) Option { return errorLog{errs: errs, mu: new(sync.Mutex)} }
+
+// An errorLog is a concurrency-safe record of errors.
+type errorLog struct {
+	mu   *sync.Mutex
+	errs *Errors
+}
+
+func (l errorLog) apply(e *env) {
+	e.errs = &l
+	e.paths = true
+}
+
+func (l *errorLog) add(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.errs = append(*l.errs, err)
+}
@@ -235,3 +235,29 @@ func ExampleCat() {
 	// Output:
 	// [some assembly required]
 }
+
+func ExampleParse() {
+	type Person struct {
+		Name  string
+		Title string
+	}
+	input := map[string]interface{}{
+		"People": []Person{
+			{Name: "Alice", Title: "CEO"},
+			{Name: "Bob", Title: "MGR"},
+			{Name: "Carol", Title: "CFO"},
+		},
+	}
+
+	q, err := vql.Parse(`People.select(Title == "CEO").each Name`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	res, err := vql.Eval(q, input)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(res)
+	// Output:
+	// [Alice]
+}
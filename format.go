@@ -0,0 +1,446 @@
+package vql
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The String methods of the query types render a query in the text syntax
+// accepted by Parse, so that for queries that can be expressed in that syntax,
+// Parse(q.String()) yields an equivalent query. Queries with no equivalent in
+// the syntax, such as those constructed by Func, are rendered in a similar
+// notation for diagnostic purposes.
+
+func (selfQuery) String() string { return "self" }
+
+func (c constQuery) String() string { return "const(" + formatLiteral(c.obj) + ")" }
+
+func (s Seq) String() string {
+	steps := flatten(s)
+	if len(steps) == 0 {
+		return "self"
+	}
+	var sb strings.Builder
+	for i, step := range steps {
+		switch t := step.(type) {
+		case indexQuery, rangeQuery:
+			sb.WriteString(formatStep(t))
+			continue
+		}
+		if op, lit, ok := comparison(step); ok && i == len(steps)-1 {
+			if sb.Len() != 0 {
+				sb.WriteString(" ")
+			}
+			sb.WriteString(op + " " + lit)
+			continue
+		}
+		if sb.Len() != 0 {
+			sb.WriteString(".")
+		}
+		sb.WriteString(formatStep(step))
+	}
+	return sb.String()
+}
+
+func (k keyQuery) String() string {
+	if k.strict {
+		return "strict(" + formatLiteral(k.key) + ")"
+	}
+	return formatKey(k.key)
+}
+
+func (f fieldQuery) String() string { return f.key().String() }
+
+func (k keyOrQuery) String() string { return "keyOr(" + formatLiterals(k) + ")" }
+
+func (k tagKeyQuery) String() string {
+	if k.tag == "json" {
+		return "json(" + formatLiteral(k.key) + ")"
+	}
+	return "tag(" + formatLiterals([]interface{}{k.tag, k.key}) + ")"
+}
+
+func (e existsQuery) String() string { return "exists(" + formatLiterals(e) + ")" }
+
+func (p projectQuery) String() string {
+	args := make([]interface{}, len(p.names))
+	for i, name := range p.names {
+		args[i] = name
+	}
+	if p.omit {
+		return "omit(" + formatLiterals(args) + ")"
+	}
+	return "pick(" + formatLiterals(args) + ")"
+}
+
+func (q indexQuery) String() string { return "[" + strconv.Itoa(int(q)) + "]" }
+
+func (q rangeQuery) String() string {
+	lo, hi := "", ""
+	if q.lo != 0 {
+		lo = strconv.Itoa(q.lo)
+	}
+	if !q.toEnd {
+		hi = strconv.Itoa(q.hi)
+	}
+	return "[" + lo + ":" + hi + "]"
+}
+
+func (m mapQuery) String() string { return formatCall("each", m.Query) }
+
+func (m lenientMapQuery) String() string { return formatCall("eachLenient", m.Query) }
+
+func (m indexedMapQuery) String() string { return formatCall("eachIndexed", m.Query) }
+
+func (m typedMapQuery) String() string { return formatCall("eachTyped", m.Query) }
+
+func (s typedSelectQuery) String() string { return formatCall("selectTyped", s.Query) }
+
+func (m parMapQuery) String() string {
+	return fmt.Sprintf("eachN(%s, %d)", formatQuery(m.Query), m.workers)
+}
+
+func (s selectQuery) String() string { return formatCall("select", s.Query) }
+
+func (f firstQuery) String() string { return formatCall("first", f.Query) }
+
+func (s selectMapQuery) String() string { return formatCall("selectMap", s.Query) }
+
+func (m remapQuery) String() string {
+	if m.keys {
+		return formatCall("mapKeys", m.Query)
+	}
+	return formatCall("mapValues", m.Query)
+}
+
+func (s quantQuery) String() string { return formatCall(s.name, s.Query) }
+
+func (n notQuery) String() string {
+	if c, ok := n.Query.(cmpQuery); ok && c.op == "==" {
+		return "!= " + formatLiteral(c.needle)
+	}
+	return formatCall("not", n.Query)
+}
+
+func (l logicQuery) String() string { return formatCall(l.name, l.qs...) }
+
+func (m Map) String() string {
+	parts := make([]string, len(m))
+	for i, name := range sortedKeys(m) {
+		key := name
+		if !isIdent(name) {
+			key = strconv.Quote(name)
+		}
+		parts[i] = key + ": " + formatQuery(m[name])
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func (a fnQuery) String() string { return a.fn.Type().String() }
+
+func (q typeIsQuery) String() string { return "typeIs(" + q.t.String() + ")" }
+
+func (q asTypeQuery) String() string { return "asType(" + q.t.String() + ")" }
+
+func (q typeInfoQuery) String() string { return string(q) + "()" }
+
+func (m *memoQuery) String() string { return formatCall("memo", m.Query) }
+
+func (f funcRefQuery) String() string { return "@" + string(f) }
+
+func (b boundFuncQuery) String() string { return "@" + b.name }
+
+func (m methodQuery) String() string {
+	return "method(" + formatLiterals(append([]interface{}{m.name}, m.args...)) + ")"
+}
+
+func (o Or) String() string { return formatCall("or", o...) }
+
+func (r requireQuery) String() string {
+	return "require(" + formatQuery(r.q) + ", " + strconv.Quote(r.msg) + ")"
+}
+
+func (n nonNilQuery) String() string { return formatCall("nonNil", n.Query) }
+
+func (f failQuery) String() string { return "fail(" + strconv.Quote(f.err.Error()) + ")" }
+
+func (d defaultQuery) String() string {
+	name := "default"
+	if d.onErr {
+		name = "defaultErr"
+	}
+	args := []string{formatQuery(d.q), formatLiteral(d.fallback)}
+	for _, target := range d.targets {
+		args = append(args, strconv.Quote(target.Error()))
+	}
+	return name + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (q List) String() string { return formatCall("list", q...) }
+
+func (c Cat) String() string { return formatCall("cat", c...) }
+
+func (c cmpQuery) String() string { return c.op + " " + formatLiteral(c.needle) }
+
+func (d descendQuery) String() string { return formatCall("descend", d.Query) }
+
+func (s sortQuery) String() string {
+	if s.key != nil {
+		return formatCall("sortBy", s.key)
+	}
+	return formatCall("sort", s.less)
+}
+
+func (g groupQuery) String() string { return formatCall("groupBy", g.Query) }
+
+func (d distinctQuery) String() string {
+	if s, ok := d.Query.(Seq); ok {
+		return formatCall("distinct", s...)
+	}
+	return formatCall("distinct", d.Query)
+}
+
+func (countQuery) String() string { return "count()" }
+
+func (f flattenQuery) String() string { return fmt.Sprintf("flatten(%d)", int(f)) }
+
+func (z zipQuery) String() string { return formatCall("zip", z...) }
+
+func (s setQuery) String() string {
+	if s.key != nil {
+		return formatCall(s.op+"By", append([]Query{s.key}, s.qs...)...)
+	}
+	return formatCall(s.op, s.qs...)
+}
+
+func (c chunkQuery) String() string { return fmt.Sprintf("chunk(%d)", int(c)) }
+
+func (p partitionQuery) String() string {
+	if s, ok := p.Query.(Seq); ok {
+		return formatCall("partition", s...)
+	}
+	return formatCall("partition", p.Query)
+}
+
+func (c convertQuery) String() string { return string(c) + "()" }
+
+func (a arithQuery) String() string { return formatCall(a.name, a.qs...) }
+
+func (m matchQuery) String() string { return "match(" + strconv.Quote(m.re.String()) + ")" }
+
+func (c captureQuery) String() string { return "capture(" + strconv.Quote(c.re.String()) + ")" }
+
+func (g globQuery) String() string { return "glob(" + strconv.Quote(string(g)) + ")" }
+
+func (b betweenQuery) String() string {
+	name := "between"
+	if b.exclusive {
+		name = "betweenExclusive"
+	}
+	return name + "(" + formatLiterals([]interface{}{b.lo, b.hi}) + ")"
+}
+
+func (a approxQuery) String() string {
+	return "eqApprox(" + formatLiterals([]interface{}{a.want, a.epsilon}) + ")"
+}
+
+func (q stringQuery) String() string {
+	if builtins[q.name].max == 0 {
+		return q.name + "()"
+	}
+	return q.name + "(" + strconv.Quote(q.arg) + ")"
+}
+
+func (f sprintfQuery) String() string {
+	parts := []string{strconv.Quote(f.format)}
+	for _, arg := range f.args {
+		parts = append(parts, formatQuery(arg))
+	}
+	return "format(" + strings.Join(parts, ", ") + ")"
+}
+
+func (c containsQuery) String() string { return "contains(" + formatLiteral(c.needle) + ")" }
+
+func (q inQuery) String() string { return "in(" + formatLiterals(q) + ")" }
+
+func (a aggQuery) String() string {
+	return formatCall([...]string{aggSum: "sum", aggMin: "min", aggMax: "max", aggMean: "mean"}[a.agg], a.Query)
+}
+
+func (e entriesQuery) String() string {
+	return [...]string{entryKey: "keys", entryValue: "vals", entryBoth: "entries"}[e.part] + "()"
+}
+
+func (m mutateQuery) String() string { return formatCall(m.name, m.path) }
+
+func (b buildQuery) String() string { return "build(" + formatTemplate(b.tmpl) + ")" }
+
+// formatTemplate renders a template for Build.
+func formatTemplate(tmpl interface{}) string {
+	var m map[string]interface{}
+	switch t := tmpl.(type) {
+	case Query:
+		return formatQuery(t)
+	case map[string]interface{}:
+		m = t
+	case Values:
+		m = t
+	case []interface{}:
+		parts := make([]string, len(t))
+		for i, elt := range t {
+			parts[i] = formatTemplate(elt)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	default:
+		return formatLiteral(tmpl)
+	}
+	parts := make([]string, len(m))
+	for i, name := range sortedKeys(m) {
+		parts[i] = strconv.Quote(name) + ": " + formatTemplate(m[name])
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func (l letQuery) String() string {
+	return fmt.Sprintf("let(%q, %s, %s)", l.name, formatQuery(l.q), formatQuery(l.body))
+}
+
+func (q varQuery) String() string { return fmt.Sprintf("var(%q)", string(q)) }
+
+func (p paramQuery) String() string { return "$" + string(p) }
+
+func (s Switch) String() string {
+	parts := []string{formatQuery(s.On)}
+	for _, key := range s.caseKeys() {
+		parts = append(parts, formatLiteral(key)+": "+formatQuery(s.Cases[key]))
+	}
+	if s.Default != nil {
+		parts = append(parts, "default: "+formatQuery(s.Default))
+	}
+	return "switch(" + strings.Join(parts, ", ") + ")"
+}
+
+func (j Join) String() string { return formatCall(j.joinName(), j.args()...) }
+
+// String renders the query from which p was compiled.
+func (p Prepared) String() string { return formatQuery(p.q) }
+
+func (s specialQuery) String() string { return formatQuery(s.q) }
+
+// formatQuery renders q as an expression.
+func formatQuery(q Query) string {
+	if s, ok := q.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", q), "vql.")
+}
+
+// formatStep renders q as a step of a path. A comparison is parenthesized,
+// since in the text syntax it can only appear at the end of a path.
+func formatStep(q Query) string {
+	s := formatQuery(q)
+	if _, _, ok := comparison(q); ok {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// formatCall renders a call of the named combinator with the given arguments.
+func formatCall(name string, args ...Query) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = formatQuery(arg)
+	}
+	return name + "(" + strings.Join(parts, ", ") + ")"
+}
+
+// comparison reports whether q is a comparison, and if so returns its
+// operator and operand in the text syntax.
+func comparison(q Query) (op, lit string, ok bool) {
+	switch t := q.(type) {
+	case cmpQuery:
+		return t.op, formatLiteral(t.needle), true
+	case notQuery:
+		if c, ok := t.Query.(cmpQuery); ok && c.op == "==" {
+			return "!=", formatLiteral(c.needle), true
+		}
+	}
+	return "", "", false
+}
+
+// formatKey renders a key lookup as a step of a path.
+func formatKey(key interface{}) string {
+	if s, ok := key.(string); ok && isIdent(s) && s != "self" {
+		return s
+	} else if p, ok := key.(paramQuery); ok {
+		return "key(" + p.String() + ")"
+	}
+	return formatLiteral(key)
+}
+
+// formatLiteral renders obj as a literal in the text syntax, if possible.
+func formatLiteral(obj interface{}) string {
+	switch t := obj.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return strconv.Quote(t)
+	case bool:
+		return strconv.FormatBool(t)
+	case paramQuery:
+		return t.String()
+	}
+	rv := reflect.ValueOf(obj)
+	switch k := rv.Kind(); {
+	case isIntLike(k):
+		return strconv.FormatInt(rv.Int(), 10)
+	case isUintLike(k):
+		return strconv.FormatUint(rv.Uint(), 10)
+	case isFloatLike(k):
+		f := rv.Float()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			break
+		}
+		s := strconv.FormatFloat(f, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	}
+	return fmt.Sprintf("%#v", obj)
+}
+
+func formatLiterals(objs []interface{}) string {
+	parts := make([]string, len(objs))
+	for i, obj := range objs {
+		parts[i] = formatLiteral(obj)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sortedKeys returns the keys of m in increasing order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isIdent reports whether s is a valid identifier in the text syntax.
+func isIdent(s string) bool {
+	if s == "" || !isIdentStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentStart(s[i]) && !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package vql
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// A FuncRegistry is a set of named functions that queries in text form can
+// refer to as "@name". Each function must have a signature accepted by Func.
+// The zero value is an empty registry ready for use. A FuncRegistry is safe
+// for concurrent use.
+//
+// A query parsed by ParseFuncs binds the functions it refers to when it is
+// parsed, so that an unknown name or a function with an invalid signature is
+// reported as a parse error rather than when the query is evaluated.
+type FuncRegistry struct {
+	mu  sync.RWMutex
+	fns map[string]fnQuery
+}
+
+// DefaultFuncs is the global registry of named functions. ParseFuncs binds
+// references to the functions it contains, and FuncRef queries use it when
+// they are evaluated without functions supplied by EvalWithFuncs or the Funcs
+// option.
+var DefaultFuncs = new(FuncRegistry)
+
+// RegisterFunc registers fn under name in DefaultFuncs. It is intended to be
+// called during program initialization, and panics if Register reports an
+// error.
+func RegisterFunc(name string, fn interface{}) {
+	if err := DefaultFuncs.Register(name, fn); err != nil {
+		panic("registerFunc: " + err.Error())
+	}
+}
+
+// Register adds fn to r under the given name. It reports an error if name is
+// not a valid identifier, if name is already registered in r, or if fn does
+// not have a signature accepted by Func.
+func (r *FuncRegistry) Register(name string, fn interface{}) error {
+	if !isIdent(name) {
+		return fmt.Errorf("invalid function name %q", name)
+	}
+	q, err := newFnQuery(fn, nil)
+	if err != nil {
+		return fmt.Errorf("function %q: %w", name, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.fns[name]; ok {
+		return fmt.Errorf("function %q is already registered", name)
+	}
+	if r.fns == nil {
+		r.fns = make(map[string]fnQuery)
+	}
+	r.fns[name] = q
+	return nil
+}
+
+// Names returns the names of the functions registered in r, in sorted order.
+func (r *FuncRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.fns))
+	for name := range r.fns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookup returns the function registered under name in r, if any.
+func (r *FuncRegistry) lookup(name string) (fnQuery, bool) {
+	if r == nil {
+		return fnQuery{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	q, ok := r.fns[name]
+	return q, ok
+}
+
+// ParseFuncs parses s as a query, as Parse does, and binds each function
+// reference "@name" in s to the function registered under name in r or in
+// DefaultFuncs. It is an error if s refers to a name that is registered in
+// neither, or in both. If r == nil, only DefaultFuncs is used.
+func ParseFuncs(s string, r *FuncRegistry) (Query, error) {
+	return parse(s, &funcBinder{local: r, strict: true})
+}
+
+// A funcBinder resolves the function references of a query during parsing.
+type funcBinder struct {
+	local  *FuncRegistry // if non-nil, a registry consulted with DefaultFuncs
+	strict bool          // if false, references are left for evaluation
+}
+
+// bind returns the query for a reference to the function with the given name.
+func (b *funcBinder) bind(name string) (Query, error) {
+	if !b.strict {
+		// Leave the reference for evaluation, so that the functions given to
+		// EvalWithFuncs are used instead of those in DefaultFuncs.
+		return FuncRef(name), nil
+	}
+	local, inLocal := b.local.lookup(name)
+	global, inGlobal := DefaultFuncs.lookup(name)
+	switch {
+	case inLocal && inGlobal && b.local != DefaultFuncs:
+		return nil, fmt.Errorf("function %q is registered both locally and globally", name)
+	case inLocal:
+		return boundFuncQuery{name: name, fn: local}, nil
+	case inGlobal:
+		return boundFuncQuery{name: name, fn: global}, nil
+	}
+	return nil, errors.New("undefined function " + name)
+}
+
+// boundFuncQuery is a reference to a named function bound when its query was
+// parsed. It formats and encodes as a FuncRef with the same name.
+type boundFuncQuery struct {
+	name string
+	fn   fnQuery
+}
+
+func (b boundFuncQuery) eval(v *value) (*value, error) { return b.fn.eval(v) }
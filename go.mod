@@ -2,4 +2,7 @@ module github.com/creachadair/vql
 
 go 1.18
 
-require github.com/google/go-cmp v0.5.9
+require (
+	github.com/google/go-cmp v0.5.9
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,221 @@
+// Package httpquery serves the evaluation of vql queries over HTTP.
+//
+// A Handler evaluates queries written in the text syntax accepted by
+// vql.Parse on a document supplied by a Source, and replies with the result
+// encoded as JSON. This allows a service to expose read-only introspection of
+// its configuration or state, for example:
+//
+//	h := httpquery.NewHandler(httpquery.Static(cfg), &httpquery.Options{
+//	   Timeout: time.Second,
+//	})
+//	http.Handle("/debug/query", h)
+//
+// A client sends the query as the body of a POST request, or as the value of
+// the "q" parameter of a GET request:
+//
+//	curl -d 'Servers.select(Port > 8000).each Name' http://localhost:8080/debug/query
+//
+// A successful reply is a JSON object {"result": value}. If the query cannot
+// be parsed or evaluated, the reply is a JSON object {"error": message} with a
+// 4xx or 5xx status.
+//
+// To expose live values of a program on a debug page, in the manner of the
+// expvar package, register them with Publish or PublishFunc and serve the
+// handler returned by DebugHandler.
+package httpquery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/creachadair/vql"
+)
+
+// DefaultMaxQueryLen is the limit on the length of a query, in bytes, used if
+// Options.MaxQueryLen is zero.
+const DefaultMaxQueryLen = 4096
+
+// DefaultTimeout is the limit on the time to spend evaluating a query, used if
+// Options.Timeout is zero.
+const DefaultTimeout = 10 * time.Second
+
+// A Source supplies the document on which to evaluate the query of a request.
+type Source func(*http.Request) (interface{}, error)
+
+// Static returns a Source that supplies doc for every request. If doc is a
+// pointer, queries see the current contents of the value it points to; the
+// caller is responsible for synchronizing concurrent changes to it.
+func Static(doc interface{}) Source {
+	return func(*http.Request) (interface{}, error) { return doc, nil }
+}
+
+// Options are settings for a Handler. A nil *Options provides defaults.
+type Options struct {
+	// The maximum length of a query, in bytes. Longer queries are rejected.
+	// If zero, DefaultMaxQueryLen is used.
+	MaxQueryLen int
+
+	// The maximum length of an encoded result, in bytes. Larger results are
+	// rejected. If zero, results of any length are allowed.
+	MaxResultLen int
+
+	// The maximum time to spend evaluating a query. If zero, DefaultTimeout
+	// is used. If negative, evaluation is bounded only by the lifetime of the
+	// request.
+	Timeout time.Duration
+
+	// The maximum number of steps and the maximum depth of an evaluation, as
+	// for vql.MaxSteps and vql.MaxDepth. If zero, there is no limit.
+	MaxSteps int
+	MaxDepth int
+
+	// If true, permit queries that call methods of the document. Methods may
+	// have side-effects, so by default such queries are rejected.
+	AllowMethods bool
+}
+
+func (o *Options) maxQueryLen() int {
+	if o == nil || o.MaxQueryLen == 0 {
+		return DefaultMaxQueryLen
+	}
+	return o.MaxQueryLen
+}
+
+func (o *Options) maxResultLen() int {
+	if o == nil {
+		return 0
+	}
+	return o.MaxResultLen
+}
+
+func (o *Options) timeout() time.Duration {
+	if o == nil || o.Timeout == 0 {
+		return DefaultTimeout
+	}
+	return o.Timeout
+}
+
+func (o *Options) allowMethods() bool { return o != nil && o.AllowMethods }
+
+// evalOptions returns the options for evaluating a query under ctx.
+func (o *Options) evalOptions(ctx context.Context) []vql.Option {
+	opts := []vql.Option{vql.WithContext(ctx)}
+	if o != nil && o.MaxSteps > 0 {
+		opts = append(opts, vql.MaxSteps(o.MaxSteps))
+	}
+	if o != nil && o.MaxDepth > 0 {
+		opts = append(opts, vql.MaxDepth(o.MaxDepth))
+	}
+	return opts
+}
+
+// NewHandler returns an http.Handler that evaluates queries on the documents
+// supplied by src, subject to the settings in opts.
+func NewHandler(src Source, opts *Options) http.Handler {
+	if src == nil {
+		panic("httpquery: nil source")
+	}
+	return handler{src: src, opts: opts}
+}
+
+type handler struct {
+	src  Source
+	opts *Options
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	text, code, err := h.readQuery(req)
+	if err != nil {
+		writeError(w, code, err)
+		return
+	}
+	q, err := vql.Parse(text)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid query: %w", err))
+		return
+	} else if !h.opts.allowMethods() && vql.CallsMethod(q) {
+		writeError(w, http.StatusForbidden, errors.New("method calls are not permitted"))
+		return
+	}
+	doc, err := h.src(req)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("loading document: %w", err))
+		return
+	}
+
+	ctx := req.Context()
+	if d := h.opts.timeout(); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	result, err := vql.EvalWith(q, doc, h.opts.evalOptions(ctx)...)
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	data, err := json.Marshal(struct {
+		Result interface{} `json:"result"`
+	}{Result: result})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("encoding result: %w", err))
+		return
+	} else if n := h.opts.maxResultLen(); n > 0 && len(data) > n {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Errorf("result is too large (%d > %d bytes)", len(data), n))
+		return
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+// readQuery returns the text of the query from req. If it fails, it also
+// returns the HTTP status to report.
+func (h handler) readQuery(req *http.Request) (string, int, error) {
+	limit := h.opts.maxQueryLen()
+	var text string
+	switch req.Method {
+	case http.MethodGet:
+		text = req.URL.Query().Get("q")
+	case http.MethodPost:
+		data, err := io.ReadAll(io.LimitReader(req.Body, int64(limit)+1))
+		if err != nil {
+			return "", http.StatusBadRequest, fmt.Errorf("reading query: %w", err)
+		}
+		text = string(data)
+	default:
+		return "", http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", req.Method)
+	}
+	if len(text) > limit {
+		return "", http.StatusRequestEntityTooLarge, fmt.Errorf("query is too long (limit %d bytes)", limit)
+	} else if strings.TrimSpace(text) == "" {
+		return "", http.StatusBadRequest, errors.New("missing query")
+	}
+	return text, 0, nil
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	if code == http.StatusMethodNotAllowed {
+		w.Header().Set("Allow", "GET, POST")
+	}
+	data, _ := json.Marshal(struct {
+		Error string `json:"error"`
+	}{Error: err.Error()})
+	writeJSON(w, code, data)
+}
+
+func writeJSON(w http.ResponseWriter, code int, data []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(bytes.TrimSpace(data), '\n'))
+}
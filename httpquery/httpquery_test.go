@@ -0,0 +1,229 @@
+package httpquery_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creachadair/vql/httpquery"
+	"github.com/google/go-cmp/cmp"
+)
+
+type server struct {
+	Name  string
+	Port  int
+	calls int
+}
+
+func (s *server) Reset() bool { s.calls++; return true }
+
+type config struct {
+	Servers []*server
+}
+
+var testConfig = &config{
+	Servers: []*server{
+		{Name: "alpha", Port: 8080},
+		{Name: "bravo", Port: 443},
+		{Name: "charlie", Port: 9000},
+	},
+}
+
+// do sends a request to h and decodes its JSON reply.
+func do(t *testing.T, h http.Handler, req *http.Request) (int, map[string]interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type: got %q, want application/json", ct)
+	}
+	var reply map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("Decoding reply %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, reply
+}
+
+func post(query string) *http.Request {
+	return httptest.NewRequest(http.MethodPost, "/", strings.NewReader(query))
+}
+
+func TestHandler(t *testing.T) {
+	h := httpquery.NewHandler(httpquery.Static(testConfig), nil)
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want interface{}
+	}{
+		{"POST", post(`Servers.select(Port > 1000).each Name`), []interface{}{"alpha", "charlie"}},
+		{"GET", httptest.NewRequest(http.MethodGet, "/?q="+url.QueryEscape(`Servers[1].Port`), nil), 443.0},
+		{"Map", post(`Servers[0].{n: Name, p: Port}`), map[string]interface{}{"n": "alpha", "p": 8080.0}},
+		{"Count", post(`Servers.count()`), 3.0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, reply := do(t, h, test.req)
+			if code != http.StatusOK {
+				t.Fatalf("Status: got %d, want %d (%v)", code, http.StatusOK, reply)
+			}
+			if diff := cmp.Diff(map[string]interface{}{"result": test.want}, reply); diff != "" {
+				t.Errorf("Reply: (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	h := httpquery.NewHandler(httpquery.Static(testConfig), &httpquery.Options{
+		MaxQueryLen:  32,
+		MaxResultLen: 64,
+	})
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		code int
+	}{
+		{"BadMethod", httptest.NewRequest(http.MethodPut, "/", strings.NewReader("Servers")), http.StatusMethodNotAllowed},
+		{"Empty", post("  "), http.StatusBadRequest},
+		{"NoParam", httptest.NewRequest(http.MethodGet, "/", nil), http.StatusBadRequest},
+		{"TooLong", post(strings.Repeat("Servers.", 10)), http.StatusRequestEntityTooLarge},
+		{"Syntax", post("Servers.("), http.StatusBadRequest},
+		{"EvalError", post("Servers[10]"), http.StatusUnprocessableEntity},
+		{"ResultTooLarge", post("Servers"), http.StatusUnprocessableEntity},
+		{"Method", post(`Servers.each method("Reset")`), http.StatusForbidden},
+		{"NestedMethod", post(`Servers[0].{r: method("Reset")}`), http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, reply := do(t, h, test.req)
+			if code != test.code {
+				t.Errorf("Status: got %d, want %d (%v)", code, test.code, reply)
+			}
+			if msg, ok := reply["error"].(string); !ok || msg == "" {
+				t.Errorf("Reply: got %v, want an error message", reply)
+			}
+		})
+	}
+	for _, s := range testConfig.Servers {
+		if s.calls != 0 {
+			t.Errorf("Server %q: Reset called %d times, want 0", s.Name, s.calls)
+		}
+	}
+}
+
+func TestAllowMethods(t *testing.T) {
+	doc := &server{Name: "delta"}
+	h := httpquery.NewHandler(httpquery.Static(doc), &httpquery.Options{AllowMethods: true})
+	code, reply := do(t, h, post(`method("Reset")`))
+	if code != http.StatusOK || reply["result"] != true {
+		t.Errorf("Reply: got %d %v, want %d with result true", code, reply, http.StatusOK)
+	}
+	if doc.calls != 1 {
+		t.Errorf("Reset called %d times, want 1", doc.calls)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *httpquery.Options
+		code int
+	}{
+		{"NoLimits", nil, http.StatusOK},
+		{"StepsOK", &httpquery.Options{MaxSteps: 100}, http.StatusOK},
+		{"StepsExceeded", &httpquery.Options{MaxSteps: 5}, http.StatusUnprocessableEntity},
+		{"DepthOK", &httpquery.Options{MaxDepth: 10}, http.StatusOK},
+		{"DepthExceeded", &httpquery.Options{MaxDepth: 3}, http.StatusUnprocessableEntity},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := httpquery.NewHandler(httpquery.Static(testConfig), test.opts)
+			code, reply := do(t, h, post("Servers.each Name"))
+			if code != test.code {
+				t.Errorf("Status: got %d, want %d (%v)", code, test.code, reply)
+			}
+		})
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	h := httpquery.NewHandler(func(*http.Request) (interface{}, error) {
+		return nil, errors.New("unavailable")
+	}, nil)
+	code, reply := do(t, h, post("Servers"))
+	if code != http.StatusInternalServerError {
+		t.Errorf("Status: got %d, want %d (%v)", code, http.StatusInternalServerError, reply)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	h := httpquery.NewHandler(func(req *http.Request) (interface{}, error) {
+		// Simulate a slow evaluation by waiting out the deadline before the
+		// query runs, so that evaluation observes an expired context.
+		<-req.Context().Done()
+		return testConfig, nil
+	}, &httpquery.Options{Timeout: 10 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	code, reply := do(t, h, post("Servers.each Name").WithContext(ctx))
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Status: got %d, want %d (%v)", code, http.StatusServiceUnavailable, reply)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	stats := map[string]int{"hits": 5, "misses": 2}
+	polls := 0
+	httpquery.Publish("test.config", testConfig)
+	httpquery.PublishFunc("test.stats", func() interface{} {
+		polls++
+		return map[string]int{"hits": stats["hits"] + polls}
+	})
+
+	mustPanic := func(name string, f func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s: did not panic", name)
+			}
+		}()
+		f()
+	}
+	mustPanic("Publish", func() { httpquery.Publish("test.config", nil) })
+	mustPanic("PublishFunc", func() { httpquery.PublishFunc("test.new", nil) })
+
+	names := httpquery.PublishedNames()
+	if diff := cmp.Diff([]string{"test.config", "test.stats"}, names); diff != "" {
+		t.Errorf("PublishedNames: (-want, +got)\n%s", diff)
+	}
+
+	h := httpquery.DebugHandler(nil)
+	tests := []struct {
+		query string
+		want  interface{}
+	}{
+		{`keys()`, []interface{}{"test.config", "test.stats"}},
+		{`"test.config".Servers[0].Name`, "alpha"},
+
+		// The function is polled once for each request.
+		{`"test.stats".hits`, 8.0},
+		{`"test.stats".hits`, 9.0},
+	}
+	for _, test := range tests {
+		code, reply := do(t, h, post(test.query))
+		if code != http.StatusOK {
+			t.Errorf("Query %q: got status %d, want %d (%v)", test.query, code, http.StatusOK, reply)
+		} else if diff := cmp.Diff(test.want, reply["result"]); diff != "" {
+			t.Errorf("Query %q: (-want, +got)\n%s", test.query, diff)
+		}
+	}
+}
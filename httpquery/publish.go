@@ -0,0 +1,90 @@
+package httpquery
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+var published struct {
+	sync.RWMutex
+	vals map[string]func() interface{}
+}
+
+// Publish registers v under the given name, so that it can be queried by the
+// handler returned by DebugHandler. The value is shared with the caller, so
+// a pointer to a live value such as a configuration or a table of statistics
+// allows queries to observe its current state. Publish panics if name is
+// already published.
+//
+// Publish is intended to be called during program initialization, in the
+// manner of expvar.Publish.
+func Publish(name string, v interface{}) {
+	PublishFunc(name, func() interface{} { return v })
+}
+
+// PublishFunc registers f under the given name, so that it can be queried by
+// the handler returned by DebugHandler. The function is called once for each
+// query, and its result is the value of name. This permits the caller to
+// take a snapshot of a value that is not safe to read concurrently.
+// PublishFunc panics if name is already published.
+func PublishFunc(name string, f func() interface{}) {
+	if f == nil {
+		panic("httpquery: nil function")
+	}
+	published.Lock()
+	defer published.Unlock()
+	if _, ok := published.vals[name]; ok {
+		panic(fmt.Sprintf("httpquery: name %q is already published", name))
+	}
+	if published.vals == nil {
+		published.vals = make(map[string]func() interface{})
+	}
+	published.vals[name] = f
+}
+
+// PublishedNames returns the names of all published values in sorted order.
+func PublishedNames() []string {
+	published.RLock()
+	defer published.RUnlock()
+	names := make([]string, 0, len(published.vals))
+	for name := range published.vals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Published returns a Source that supplies a map from the name of each
+// published value to its current value. Each function registered by
+// PublishFunc is called once per request.
+func Published() Source {
+	return func(*http.Request) (interface{}, error) {
+		published.RLock()
+		fs := make(map[string]func() interface{}, len(published.vals))
+		for name, f := range published.vals {
+			fs[name] = f
+		}
+		published.RUnlock()
+
+		doc := make(map[string]interface{}, len(fs))
+		for name, f := range fs {
+			doc[name] = f()
+		}
+		return doc, nil
+	}
+}
+
+// DebugHandler returns an http.Handler that evaluates queries on the values
+// registered by Publish and PublishFunc, subject to the settings in opts. The
+// input to each query is a map from names to values, so a query begins with
+// the name of the value it addresses, for example:
+//
+//	httpquery.Publish("config", &cfg)
+//	http.Handle("/debug/vql", httpquery.DebugHandler(nil))
+//
+//	curl -d 'config.Servers.each Name' http://localhost:8080/debug/vql
+//
+// Use the query "keys()" to list the published names.
+func DebugHandler(opts *Options) http.Handler { return NewHandler(Published(), opts) }
@@ -0,0 +1,146 @@
+package vql
+
+import "reflect"
+
+// Join is a Query that matches the elements of two collections by key, in the
+// manner of a relational join. Left and Right are evaluated on the input, and
+// must yield arrays, slices, or maps; the elements of a map are its entries,
+// of concrete type Entry. LeftKey is evaluated on each element of Left and
+// RightKey on each element of Right to obtain their keys. If RightKey is nil,
+// LeftKey is used for both, and if both are nil, the elements themselves are
+// the keys. Keys are compared as for Distinct, and a nil key matches nothing.
+//
+// The result is a slice of concrete type []interface{} with one Values for
+// each pair of elements whose keys are equal, in which "Left" and "Right" are
+// bound to the elements of the pair. Pairs are in order of their elements in
+// Left, and then in Right. If Outer is true, each element of Left that has no
+// match is also included, with Right bound to nil.
+//
+// For example, to list the names of users alongside each of their roles:
+//
+//	vql.Seq{
+//	   vql.Join{
+//	      Left:     vql.Key("users"),
+//	      Right:    vql.Key("roles"),
+//	      LeftKey:  vql.Key("id"),
+//	      RightKey: vql.Key("user_id"),
+//	   },
+//	   vql.Each(vql.List{vql.Key("Left", "name"), vql.Key("Right", "role")}),
+//	}
+type Join struct {
+	Left, Right       Query
+	LeftKey, RightKey Query
+	Outer             bool
+}
+
+func (j Join) eval(v *value) (*value, error) {
+	lkey, rkey := j.LeftKey, j.RightKey
+	if lkey == nil {
+		lkey = Self
+	}
+	if rkey == nil {
+		rkey = lkey
+	}
+
+	// Index the elements of Right by their keys.
+	right, err := evalQuery(j.Right, v)
+	if err != nil {
+		return nil, err
+	}
+	var rvals []interface{}
+	hashed := make(map[interface{}][]int)
+	var other []int // offsets in rvals of elements with keys that are not hashable
+	var rkeys []interface{}
+	if err := forEachValue(right, func(elt *value) error {
+		k, err := evalQuery(rkey, elt)
+		if err != nil {
+			return err
+		}
+		if k.val != nil {
+			if isHashable(k.val) {
+				hashed[k.val] = append(hashed[k.val], len(rvals))
+			} else {
+				other = append(other, len(rvals))
+			}
+		}
+		rvals = append(rvals, elt.val)
+		rkeys = append(rkeys, k.val)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	left, err := evalQuery(j.Left, v)
+	if err != nil {
+		return nil, err
+	}
+	vs := []interface{}{}
+	err = forEachValue(left, func(elt *value) error {
+		k, err := evalQuery(lkey, elt)
+		if err != nil {
+			return err
+		}
+		var matches []int
+		if k.val == nil {
+			// A nil key matches nothing.
+		} else if isHashable(k.val) {
+			matches = hashed[k.val]
+		} else {
+			for _, i := range other {
+				if reflect.DeepEqual(rkeys[i], k.val) {
+					matches = append(matches, i)
+				}
+			}
+		}
+		for _, i := range matches {
+			vs = append(vs, Values{"Left": elt.val, "Right": rvals[i]})
+		}
+		if len(matches) == 0 && j.Outer {
+			vs = append(vs, Values{"Left": elt.val, "Right": nil})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pushValue(v, vs), nil
+}
+
+// args returns the subqueries of j in the order they are written in the text
+// syntax: Left, Right, and the keys, if any. If only RightKey is set, LeftKey
+// is written as Self.
+func (j Join) args() []Query {
+	qs := []Query{j.Left, j.Right}
+	if j.LeftKey != nil || j.RightKey != nil {
+		lkey := j.LeftKey
+		if lkey == nil {
+			lkey = Self
+		}
+		qs = append(qs, lkey)
+	}
+	if j.RightKey != nil {
+		qs = append(qs, j.RightKey)
+	}
+	return qs
+}
+
+// joinName returns the name of j in the text syntax.
+func (j Join) joinName() string {
+	if j.Outer {
+		return "outerJoin"
+	}
+	return "join"
+}
+
+// newJoin constructs a Join from the subqueries qs in the order returned by
+// the args method, of which there must be from 2 to 4.
+func newJoin(qs []Query, outer bool) Join {
+	j := Join{Left: qs[0], Right: qs[1], Outer: outer}
+	if len(qs) > 2 {
+		j.LeftKey = qs[2]
+	}
+	if len(qs) > 3 {
+		j.RightKey = qs[3]
+	}
+	return j
+}
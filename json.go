@@ -0,0 +1,72 @@
+package vql
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// EvalJSON decodes data as a JSON document and evaluates q starting from the
+// decoded value, as EvalWith. The document is decoded as by json.Unmarshal
+// into an interface{}, so objects become map[string]interface{}, arrays
+// become []interface{}, and numbers become float64.
+//
+// If data cannot be decoded, EvalJSON reports a *DecodeError; if the query
+// fails, it reports an *Error, as Eval does.
+func EvalJSON(q Query, data []byte, opts ...Option) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, &DecodeError{Format: "JSON", Err: err}
+	}
+	return EvalWith(q, v, opts...)
+}
+
+// DecodeRawJSON returns an Option that decodes raw JSON values as they are
+// reached by the steps of a query, so that traversal can continue into them.
+// A value of type json.RawMessage that holds valid JSON, or a []byte that
+// holds a valid JSON object or array, is replaced by the result of decoding
+// it with json.Unmarshal into an interface{}. The input of the query is
+// decoded in the same way. Other values, including raw values that are not
+// valid JSON, are unchanged.
+//
+// This is useful for data that embed JSON blobs to be decoded later, for
+// example:
+//
+//	type Event struct {
+//	   Kind    string
+//	   Payload json.RawMessage
+//	}
+//
+//	user, err := vql.EvalWith(vql.Key("Payload", "user", "id"), ev, vql.DecodeRawJSON())
+//
+// Decoding is done each time a raw value is reached, and decoded values are
+// not cached.
+func DecodeRawJSON() Option { return decodeRawJSON{} }
+
+type decodeRawJSON struct{}
+
+func (decodeRawJSON) apply(e *env) { e.rawJSON = true }
+
+// decodeRaw returns the decoded value of obj if it is raw JSON and e enables
+// decoding it, as described by DecodeRawJSON; otherwise it returns obj.
+func (e *env) decodeRaw(obj interface{}) interface{} {
+	if !e.rawJSON {
+		return obj
+	}
+	var data []byte
+	switch t := obj.(type) {
+	case json.RawMessage:
+		data = t
+	case []byte:
+		if b := bytes.TrimSpace(t); len(b) == 0 || (b[0] != '{' && b[0] != '[') {
+			return obj
+		}
+		data = t
+	default:
+		return obj
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return obj
+	}
+	return out
+}
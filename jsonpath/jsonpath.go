@@ -0,0 +1,470 @@
+// Package jsonpath translates JSONPath expressions into vql queries.
+//
+// Parse accepts a useful subset of the JSONPath syntax:
+//
+//	$                 -- the root of the input
+//	.name, ['name']   -- a field or map entry, as vql.Key
+//	[n]               -- an array element, as vql.Index; n may be negative
+//	[lo:hi]           -- a range of array elements, as vql.Range
+//	.*, [*]           -- every element of an array, or value of an object
+//	['a','b'], [0,1]  -- a union of names or indices
+//	..name, ..*       -- recursive descent, as vql.Descend
+//	[?(expr)]         -- the elements of an array, or values of an object,
+//	                     for which the filter expression is true
+//
+// A filter expression compares the value of a path relative to the current
+// element, written @, with a literal, as in @.price < 10. The comparison
+// operators are ==, !=, <, <=, >, and >=; a path alone tests whether the
+// value is present and not null. Literals are numbers, strings in single or
+// double quotes, true, false, and null. Expressions can be combined with &&,
+// ||, and !, and grouped with parentheses. A comparison that cannot be made,
+// for example because a value is missing, is false.
+//
+// A path that contains only names and single indices is definite: its query
+// yields the single value it refers to. Any other path yields a slice of
+// concrete type []interface{} containing the values it matches, omitting
+// missing and null values. Values are listed in the order of the elements of
+// arrays, and of the keys of objects, as vql.Each visits them.
+package jsonpath
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/creachadair/vql"
+)
+
+// Parse translates a JSONPath expression into an equivalent vql.Query.
+func Parse(expr string) (vql.Query, error) {
+	p := &parser{src: expr}
+	p.skipSpace()
+	if !p.consume("$") {
+		return nil, p.errorf("path must begin with $")
+	}
+	steps, err := p.parseSteps(false)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos:])
+	}
+	return build(steps), nil
+}
+
+// MustParse translates a JSONPath expression as Parse, but panics if the
+// expression is not valid.
+func MustParse(expr string) vql.Query {
+	q, err := Parse(expr)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// A step is one step of a path. A definite step yields a single value from
+// its input; an indefinite step yields a slice of values.
+type step struct {
+	query    vql.Query
+	definite bool
+}
+
+// build constructs the query for a sequence of steps. The steps following an
+// indefinite step are applied to each of the values it yields, and their
+// results are combined into a single slice.
+func build(steps []step) vql.Query {
+	var seq vql.Seq
+	for i, s := range steps {
+		seq = append(seq, s.query)
+		if s.definite {
+			continue
+		}
+		if rest := steps[i+1:]; isDefinite(rest) {
+			seq = append(seq, vql.EachLenient(build(rest)), dropNil)
+		} else {
+			seq = append(seq, vql.EachLenient(build(rest)), flatten)
+		}
+		break
+	}
+	if len(seq) == 1 {
+		return seq[0]
+	} else if len(seq) == 0 {
+		return vql.Self
+	}
+	return seq
+}
+
+func isDefinite(steps []step) bool {
+	for _, s := range steps {
+		if !s.definite {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	// dropNil removes nil values from a slice.
+	dropNil = vql.Select(vql.Func(vql.NotNil))
+
+	// flatten concatenates a slice of slices.
+	flatten = vql.Func(func(vss []interface{}) []interface{} {
+		var out []interface{}
+		for _, vs := range vss {
+			out = append(out, vs.([]interface{})...)
+		}
+		return out
+	})
+
+	// children yields the elements of an array or the values of an object.
+	children = vql.Switch{
+		On: vql.KindOf(),
+		Cases: map[interface{}]vql.Query{
+			"slice":  vql.Each(vql.Self),
+			"array":  vql.Each(vql.Self),
+			"map":    vql.Vals(),
+			"struct": vql.Vals(),
+			"ptr":    vql.DefaultErr(vql.Vals(), []interface{}{}),
+		},
+		Default: vql.Const([]interface{}{}),
+	}
+)
+
+type parser struct {
+	src string
+	pos int
+}
+
+func (p *parser) errorf(msg string, args ...interface{}) error {
+	return fmt.Errorf("jsonpath: offset %d: %s", p.pos, fmt.Sprintf(msg, args...))
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume reports whether the input at the current position begins with s,
+// and if so advances past it.
+func (p *parser) consume(s string) bool {
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+// parseSteps parses a sequence of steps. If relative is true, the steps are
+// part of a filter expression, and only definite steps are allowed.
+func (p *parser) parseSteps(relative bool) ([]step, error) {
+	var steps []step
+	for {
+		start := p.pos
+		var s step
+		var err error
+		switch {
+		case p.consume(".."):
+			if relative {
+				return nil, p.errorf("recursive descent is not allowed in a filter")
+			} else if p.consume("*") {
+				s = step{query: vql.Seq{vql.Descend(vql.Self), vql.Skip(1)}}
+			} else if name := p.parseName(); name != "" {
+				s = step{query: vql.Descend(vql.Key(name))}
+			} else {
+				return nil, p.errorf("missing name after ..")
+			}
+		case p.consume("."):
+			if p.consume("*") {
+				s = step{query: children}
+			} else if name := p.parseName(); name != "" {
+				s = step{query: vql.Key(name), definite: true}
+			} else {
+				return nil, p.errorf("missing name after .")
+			}
+		case p.consume("["):
+			s, err = p.parseBracket()
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return steps, nil
+		}
+		if relative && !s.definite {
+			p.pos = start
+			return nil, p.errorf("only names and indices are allowed in a filter path")
+		}
+		steps = append(steps, s)
+	}
+}
+
+// parseName parses an unquoted name, or returns "" if there is none.
+func (p *parser) parseName() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		// Bytes of non-ASCII characters are accepted as part of a name.
+		c := rune(p.src[p.pos])
+		if c < utf8.RuneSelf && c != '_' && c != '-' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// parseBracket parses the contents of a bracketed step, following "[".
+func (p *parser) parseBracket() (step, error) {
+	p.skipSpace()
+	var s step
+	switch {
+	case p.consume("*"):
+		s = step{query: children}
+	case p.consume("?("):
+		pred, err := p.parseOr()
+		if err != nil {
+			return step{}, err
+		}
+		p.skipSpace()
+		if !p.consume(")") {
+			return step{}, p.errorf("missing ) after filter")
+		}
+		s = step{query: vql.Seq{children, vql.Select(pred)}}
+	default:
+		var err error
+		s, err = p.parseSelectors()
+		if err != nil {
+			return step{}, err
+		}
+	}
+	p.skipSpace()
+	if !p.consume("]") {
+		return step{}, p.errorf("missing ]")
+	}
+	return s, nil
+}
+
+// parseSelectors parses a name, index, range, or union of names and indices.
+func (p *parser) parseSelectors() (step, error) {
+	start := p.pos
+	if lo, ok := p.parseInt(); ok || p.peekIs(':') {
+		p.skipSpace()
+		if p.consume(":") {
+			return p.parseRange(lo, ok)
+		}
+		p.pos = start
+	}
+	var qs []vql.Query
+	for {
+		p.skipSpace()
+		if s, ok, err := p.parseString(); err != nil {
+			return step{}, err
+		} else if ok {
+			qs = append(qs, vql.Key(s))
+		} else if n, ok := p.parseInt(); ok {
+			qs = append(qs, vql.Index(n))
+		} else {
+			return step{}, p.errorf("invalid selector")
+		}
+		p.skipSpace()
+		if !p.consume(",") {
+			break
+		}
+	}
+	if len(qs) == 1 {
+		return step{query: qs[0], definite: true}, nil
+	}
+	return step{query: vql.Seq{vql.List(qs), dropNil}}, nil
+}
+
+// parseRange parses the remainder of a range following the ":".
+func (p *parser) parseRange(lo int, hasLo bool) (step, error) {
+	if !hasLo {
+		lo = 0
+	}
+	p.skipSpace()
+	hi, ok := p.parseInt()
+	if !ok {
+		hi = math.MaxInt
+	}
+	p.skipSpace()
+	if p.consume(":") {
+		p.skipSpace()
+		if n, ok := p.parseInt(); ok && n != 1 {
+			return step{}, p.errorf("range step %d is not supported", n)
+		}
+	}
+	return step{query: vql.Range(lo, hi)}, nil
+}
+
+func (p *parser) peekIs(c byte) bool {
+	p.skipSpace()
+	return p.pos < len(p.src) && p.src[p.pos] == c
+}
+
+// parseInt parses a decimal integer, and reports whether one was found.
+func (p *parser) parseInt() (int, bool) {
+	p.skipSpace()
+	start := p.pos
+	if p.pos < len(p.src) && p.src[p.pos] == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+	n, err := strconv.Atoi(p.src[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return 0, false
+	}
+	return n, true
+}
+
+// parseString parses a string in single or double quotes, and reports
+// whether one was found.
+func (p *parser) parseString() (string, bool, error) {
+	if p.pos >= len(p.src) || (p.src[p.pos] != '\'' && p.src[p.pos] != '"') {
+		return "", false, nil
+	}
+	quote := p.src[p.pos]
+	var sb strings.Builder
+	for i := p.pos + 1; i < len(p.src); i++ {
+		switch c := p.src[i]; c {
+		case quote:
+			p.pos = i + 1
+			return sb.String(), true, nil
+		case '\\':
+			if i+1 < len(p.src) {
+				i++
+				c = p.src[i]
+			}
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", false, p.errorf("unterminated string")
+}
+
+// parseOr parses a disjunction of filter expressions.
+func (p *parser) parseOr() (vql.Query, error) {
+	var qs []vql.Query
+	for {
+		q, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		qs = append(qs, q)
+		p.skipSpace()
+		if !p.consume("||") {
+			break
+		}
+	}
+	if len(qs) == 1 {
+		return qs[0], nil
+	}
+	return vql.OrBool(qs...), nil
+}
+
+// parseAnd parses a conjunction of filter expressions.
+func (p *parser) parseAnd() (vql.Query, error) {
+	var qs []vql.Query
+	for {
+		q, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		qs = append(qs, q)
+		p.skipSpace()
+		if !p.consume("&&") {
+			break
+		}
+	}
+	if len(qs) == 1 {
+		return qs[0], nil
+	}
+	return vql.And(qs...), nil
+}
+
+// comparisons maps filter operators to the vql comparisons they denote.
+var comparisons = map[string]func(interface{}) vql.Query{
+	"==": vql.Eq,
+	"!=": func(x interface{}) vql.Query { return vql.Not(vql.Eq(x)) },
+	"<=": vql.Le,
+	">=": vql.Ge,
+	"<":  vql.Lt,
+	">":  vql.Gt,
+}
+
+// parseUnary parses a negation, a parenthesized expression, or a comparison.
+func (p *parser) parseUnary() (vql.Query, error) {
+	p.skipSpace()
+	if p.consume("!") {
+		q, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return vql.Not(q), nil
+	} else if p.consume("(") {
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.consume(")") {
+			return nil, p.errorf("missing )")
+		}
+		return q, nil
+	} else if !p.consume("@") {
+		return nil, p.errorf("filter must begin with @")
+	}
+	steps, err := p.parseSteps(true)
+	if err != nil {
+		return nil, err
+	}
+	path := build(steps)
+
+	p.skipSpace()
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if !p.consume(op) {
+			continue
+		}
+		p.skipSpace()
+		lit, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		return vql.DefaultErr(vql.Seq{path, comparisons[op](lit)}, false), nil
+	}
+	return vql.DefaultErr(vql.Seq{path, vql.Func(vql.NotNil)}, false), nil
+}
+
+// parseLiteral parses a literal value in a filter expression.
+func (p *parser) parseLiteral() (interface{}, error) {
+	if s, ok, err := p.parseString(); err != nil {
+		return nil, err
+	} else if ok {
+		return s, nil
+	}
+	for _, kw := range []struct {
+		name  string
+		value interface{}
+	}{{"true", true}, {"false", false}, {"null", nil}} {
+		if p.consume(kw.name) {
+			return kw.value, nil
+		}
+	}
+	start := p.pos
+	for p.pos < len(p.src) && strings.IndexByte("+-.0123456789eE", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	f, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("invalid literal")
+	}
+	return f, nil
+}
@@ -0,0 +1,110 @@
+package jsonpath_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/creachadair/vql"
+	"github.com/creachadair/vql/jsonpath"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+const store = `{
+  "store": {
+    "book": [
+      {"category": "reference", "author": "Nigel Rees", "title": "Sayings of the Century", "price": 8.95},
+      {"category": "fiction", "author": "Evelyn Waugh", "title": "Sword of Honour", "price": 12.99},
+      {"category": "fiction", "author": "Herman Melville", "title": "Moby Dick", "isbn": "0-553-21311-3", "price": 8.99},
+      {"category": "fiction", "author": "J. R. R. Tolkien", "title": "The Lord of the Rings", "isbn": "0-395-19395-8", "price": 22.99}
+    ],
+    "bicycle": {"color": "red", "price": 19.95}
+  },
+  "expensive": 10
+}`
+
+func TestParse(t *testing.T) {
+	var input interface{}
+	if err := json.Unmarshal([]byte(store), &input); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	tests := []struct {
+		path string
+		want interface{}
+	}{
+		{`$`, input},
+		{`$.expensive`, 10.0},
+		{`$.store.bicycle.color`, "red"},
+		{`$['store']["bicycle"]['color']`, "red"},
+		{`$.store.book[0].author`, "Nigel Rees"},
+		{`$.store.book[-1].title`, "The Lord of the Rings"},
+		{`$.store.book[*].author`, []interface{}{
+			"Nigel Rees", "Evelyn Waugh", "Herman Melville", "J. R. R. Tolkien",
+		}},
+		{`$.store.book[*].isbn`, []interface{}{"0-553-21311-3", "0-395-19395-8"}},
+		{`$..author`, []interface{}{
+			"Nigel Rees", "Evelyn Waugh", "Herman Melville", "J. R. R. Tolkien",
+		}},
+		{`$.store..price`, []interface{}{19.95, 8.95, 12.99, 8.99, 22.99}},
+		{`$.store.*.color`, []interface{}{"red"}},
+		{`$.store.book[1:3].title`, []interface{}{"Sword of Honour", "Moby Dick"}},
+		{`$.store.book[-2:].price`, []interface{}{8.99, 22.99}},
+		{`$.store.book[:1].price`, []interface{}{8.95}},
+		{`$.store.book[0,2].title`, []interface{}{"Sayings of the Century", "Moby Dick"}},
+		{`$.store.bicycle['color','price']`, []interface{}{"red", 19.95}},
+		{`$.store.book[?(@.price < 10)].title`, []interface{}{"Sayings of the Century", "Moby Dick"}},
+		{`$.store.book[?(@.isbn)].title`, []interface{}{"Moby Dick", "The Lord of the Rings"}},
+		{`$.store.book[?(!@.isbn)].price`, []interface{}{8.95, 12.99}},
+		{`$.store.book[?(@.category == 'fiction' && @.price >= 12)].author`, []interface{}{
+			"Evelyn Waugh", "J. R. R. Tolkien",
+		}},
+		{`$.store.book[?(@.author == "Nigel Rees" || (@.price > 20))].price`, []interface{}{8.95, 22.99}},
+		{`$.store.book[?(@.category != "fiction")].title`, []interface{}{"Sayings of the Century"}},
+		{`$.store.book[?(@.nonesuch > 1)]`, []interface{}{}},
+		{`$.store.book[*].price[?(@ > 1)]`, []interface{}{}},
+		{`$.store.book[?(@.isbn)][*]`, []interface{}{
+			"Herman Melville", "fiction", "0-553-21311-3", 8.99, "Moby Dick",
+			"J. R. R. Tolkien", "fiction", "0-395-19395-8", 22.99, "The Lord of the Rings",
+		}},
+		{`$.expensive..*`, []interface{}{}},
+	}
+	for _, test := range tests {
+		q, err := jsonpath.Parse(test.path)
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error: %v", test.path, err)
+			continue
+		}
+		got, err := vql.Eval(q, input)
+		if err != nil {
+			t.Errorf("Eval(%q): unexpected error: %v", test.path, err)
+		} else if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("Eval(%q): (-want, +got)\n%s", test.path, diff)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`store`,
+		`$.`,
+		`$..`,
+		`$[`,
+		`$[0`,
+		`$['a`,
+		`$[?(@.a < )]`,
+		`$[?(@.a < 1]`,
+		`$[?(@..a)]`,
+		`$[?(@[*])]`,
+		`$[?(x)]`,
+		`$[0:4:2]`,
+		`$.a b`,
+	}
+	for _, test := range tests {
+		if q, err := jsonpath.Parse(test); err == nil {
+			t.Errorf("Parse(%q): got %v, want error", test, q)
+		} else {
+			t.Logf("Parse(%q): got expected error: %v", test, err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package vql
+
+import "fmt"
+
+// Let returns a Query that evaluates q on its input, binds the result to the
+// variable name, and then yields the value of body on the same input. Within
+// body, including any subqueries it applies to other values, Var(name) yields
+// the bound value. A binding shadows any outer binding of the same name, and
+// is not visible outside of body.
+//
+// For example, the following query pairs the name of each person in an
+// organization with the name of the organization:
+//
+//	vql.Let("org", vql.Key("Name"), vql.Seq{
+//	   vql.Key("People"),
+//	   vql.Each(vql.Map{"name": vql.Key("Name"), "org": vql.Var("org")}),
+//	})
+func Let(name string, q, body Query) Query { return letQuery{name: name, q: q, body: body} }
+
+type letQuery struct {
+	name    string
+	q, body Query
+}
+
+func (l letQuery) eval(v *value) (*value, error) {
+	bound, err := evalQuery(l.q, v)
+	if err != nil {
+		return nil, err
+	}
+	inner := *v
+	inner.vars = &binding{name: l.name, val: bound.val, next: v.vars}
+	inner.env = v.env.unshared() // the body depends on the binding
+	res, err := evalQuery(l.body, &inner)
+	if err != nil {
+		return nil, err
+	}
+	return pushResult(v, res), nil
+}
+
+// Var returns a Query that yields the value bound to the variable name by an
+// enclosing Let. It is an error if name is not bound.
+func Var(name string) Query { return varQuery(name) }
+
+type varQuery string
+
+func (q varQuery) eval(v *value) (*value, error) {
+	for b := v.vars; b != nil; b = b.next {
+		if b.name == string(q) {
+			return pushValue(v, b.val), nil
+		}
+	}
+	return nil, fmt.Errorf("variable %q is not bound", string(q))
+}
@@ -0,0 +1,87 @@
+package vql
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// MaxSteps returns an Option that limits an evaluation to at most n steps.
+// Each evaluation of a query or subquery, as observed by an EvalObserver,
+// counts as one step, so the count grows with both the size of the query and
+// the size of the input it traverses. If the limit is exceeded, evaluation
+// stops and reports an error wrapping ErrLimit. If n ≤ 0, there is no limit.
+//
+// This is useful to bound the cost of evaluating queries from untrusted
+// sources on large inputs, where nested iterations such as an Each inside an
+// Each can require many steps.
+func MaxSteps(n int) Option { return maxStepsOption(n) }
+
+type maxStepsOption int
+
+func (m maxStepsOption) apply(e *env) { e.limitsOf().maxSteps = int64(m) }
+
+// MaxDepth returns an Option that limits the depth of an evaluation to at
+// most n. The depth counts both the nesting of queries and the traversal of
+// the input: The query being evaluated has depth 1, and each subquery it
+// evaluates, as observed by an EvalObserver, has a depth one greater than the
+// query that contains it. Likewise, a query that visits the elements of a
+// collection, such as Each, or the contents of a value, such as Descend,
+// visits them at a depth one greater than that of the collection or value
+// that contains them. If the limit is exceeded, evaluation stops and reports
+// an error wrapping ErrLimit. If n ≤ 0, there is no limit.
+func MaxDepth(n int) Option { return maxDepthOption(n) }
+
+type maxDepthOption int
+
+func (m maxDepthOption) apply(e *env) { e.limitsOf().maxDepth = int(m) }
+
+// evalLimits records the limits on an evaluation and its progress toward them.
+type evalLimits struct {
+	maxSteps int64 // if positive, the maximum number of steps
+	maxDepth int   // if positive, the maximum depth of nesting
+	steps    int64 // the number of steps started (atomic)
+}
+
+// limitsOf returns the limits of e, creating them if necessary.
+func (e *env) limitsOf() *evalLimits {
+	if e.limits == nil {
+		e.limits = new(evalLimits)
+	}
+	return e.limits
+}
+
+// enter records the start of a step of evaluation in e, and reports an error
+// if doing so exceeds the limits of e. If enter succeeds, the caller must call
+// e.leave when the step is complete.
+func (e *env) enter() error {
+	lim := e.limits
+	if n := atomic.AddInt64(&lim.steps, 1); lim.maxSteps > 0 && n > lim.maxSteps {
+		return fmt.Errorf("%w: more than %d steps", ErrLimit, lim.maxSteps)
+	}
+	return e.descend()
+}
+
+// descend records the start of a traversal into the contents of a value in e,
+// and reports an error if doing so exceeds the depth limit of e. Unlike enter,
+// it does not count as a step. If descend succeeds, the caller must call
+// e.leave when the traversal is complete.
+func (e *env) descend() error {
+	if lim := e.limits; lim.maxDepth > 0 && e.depth >= lim.maxDepth {
+		return fmt.Errorf("%w: nesting deeper than %d", ErrLimit, lim.maxDepth)
+	}
+	e.depth++
+	return nil
+}
+
+// leave records the end of a step of evaluation or traversal in e.
+func (e *env) leave() { e.depth-- }
+
+// fork returns an env for evaluation concurrent with other evaluations in e.
+// The result shares the limits of e, but tracks its own depth.
+func (e *env) fork() *env {
+	if e.limits == nil {
+		return e
+	}
+	f := *e
+	return &f
+}
@@ -0,0 +1,665 @@
+package vql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+)
+
+// MarshalQuery encodes q as JSON, so that it can be stored or exchanged and
+// later restored by UnmarshalQuery.
+//
+// Each query is encoded as a JSON object whose "op" field names the kind of
+// query, generally matching the name of the combinator in the syntax accepted
+// by Parse. The other fields of the object depend on the op: "arg" holds a
+// single subquery, "args" a list of subqueries, "fields" the named subqueries
+// of a Map, and "value" or "keys" the literal operands of a query. For
+// example, Seq{Key("People"), Each(Key("Name"))} is encoded as
+//
+//	{"op":"seq","args":[
+//	  {"op":"key","value":"People"},
+//	  {"op":"each","arg":{"op":"key","value":"Name"}}
+//	]}
+//
+// Queries that refer to Go values not expressible in JSON, such as those
+// constructed by Func, Update, or Sort, cannot be encoded, and MarshalQuery
+// reports an error for them. The keys and comparison operands of a query must
+// be nil, bool, string, int, or float64, or refer to a Param. The value of a
+// Const may be any value that can be encoded by encoding/json, but is decoded
+// as a generic JSON value. A query constructed by Compile is encoded as the
+// query it was compiled from, without its input type.
+func MarshalQuery(q Query) ([]byte, error) {
+	node, err := encodeQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(node)
+}
+
+// UnmarshalQuery decodes a query from the JSON encoding produced by
+// MarshalQuery. Numeric literals that are integers are decoded as values of
+// type int, and other numbers as float64.
+func UnmarshalQuery(data []byte) (Query, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var node queryNode
+	if err := dec.Decode(&node); err != nil {
+		return nil, err
+	}
+	return decodeQuery(&node)
+}
+
+// A queryNode is the JSON encoding of a single query.
+type queryNode struct {
+	Op     string                `json:"op"`
+	Arg    *queryNode            `json:"arg,omitempty"`
+	Args   []*queryNode          `json:"args,omitempty"`
+	Fields map[string]*queryNode `json:"fields,omitempty"`
+	Value  interface{}           `json:"value,omitempty"`
+	Keys   []interface{}         `json:"keys,omitempty"`
+	Name   string                `json:"name,omitempty"`
+	N      int                   `json:"n,omitempty"`
+	Hi     *int                  `json:"hi,omitempty"`
+}
+
+// unaryOps maps the ops of queries having a single subquery to their
+// constructors.
+var unaryOps = map[string]func(Query) Query{
+	"each":      Each,
+	"select":    func(q Query) Query { return selectQuery{q} },
+	"selectMap": func(q Query) Query { return selectMapQuery{q} },
+	"partition": func(q Query) Query { return partitionQuery{q} },
+	"first":     func(q Query) Query { return firstQuery{q} },
+	"mapValues": MapValues,
+	"mapKeys":   MapKeys,
+	"every":     Every,
+	"any":       Any,
+	"none":      None,
+	"not":       Not,
+	"memo":      Memoize,
+	"nonNil":    NonNil,
+	"descend":   Descend,
+	"sortBy":    SortBy,
+	"groupBy":   GroupBy,
+	"distinct":  func(q Query) Query { return distinctQuery{q} },
+	"sum":       Sum,
+	"min":       Min,
+	"max":       Max,
+	"mean":      Mean,
+
+	"eachLenient": EachLenient,
+	"eachIndexed": EachIndexed,
+	"eachTyped":   EachTyped,
+	"selectTyped": func(q Query) Query { return typedSelectQuery{q} },
+}
+
+// listOps maps the ops of queries having a list of subqueries to their
+// constructors.
+var listOps = map[string]func([]Query) Query{
+	"seq":    func(qs []Query) Query { return Seq(qs) },
+	"and":    func(qs []Query) Query { return And(qs...) },
+	"orBool": func(qs []Query) Query { return OrBool(qs...) },
+	"or":     func(qs []Query) Query { return Or(qs) },
+	"list":   func(qs []Query) Query { return List(qs) },
+	"cat":    func(qs []Query) Query { return Cat(qs) },
+	"zip":    func(qs []Query) Query { return zipQuery(qs) },
+	"add":    func(qs []Query) Query { return Add(qs...) },
+	"mul":    func(qs []Query) Query { return Mul(qs...) },
+	"sub":    func(qs []Query) Query { return arithQuery{op: "-", name: "sub", qs: qs} },
+	"div":    func(qs []Query) Query { return arithQuery{op: "/", name: "div", qs: qs} },
+	"mod":    func(qs []Query) Query { return arithQuery{op: "%", name: "mod", qs: qs} },
+
+	"union":      func(qs []Query) Query { return Union(qs...) },
+	"intersect":  func(qs []Query) Query { return Intersect(qs...) },
+	"difference": func(qs []Query) Query { return Difference(qs...) },
+}
+
+// cmpOps maps the ops of comparison queries to their operators.
+var cmpOps = map[string]string{"eq": "==", "lt": "<", "le": "<=", "gt": ">", "ge": ">="}
+
+func encodeQuery(q Query) (*queryNode, error) {
+	unary := func(op string, arg Query) (*queryNode, error) {
+		sub, err := encodeQuery(arg)
+		if err != nil {
+			return nil, err
+		}
+		return &queryNode{Op: op, Arg: sub}, nil
+	}
+	list := func(op string, args []Query) (*queryNode, error) {
+		node := &queryNode{Op: op, Args: make([]*queryNode, len(args))}
+		for i, arg := range args {
+			sub, err := encodeQuery(arg)
+			if err != nil {
+				return nil, err
+			}
+			node.Args[i] = sub
+		}
+		return node, nil
+	}
+	literals := func(op string, vals []interface{}) (*queryNode, error) {
+		for _, val := range vals {
+			if !isLiteral(val) {
+				return nil, fmt.Errorf("cannot marshal %s operand of type %T", op, val)
+			}
+		}
+		return &queryNode{Op: op, Keys: vals}, nil
+	}
+
+	switch t := q.(type) {
+	case selfQuery:
+		return &queryNode{Op: "self"}, nil
+	case constQuery:
+		return operandNode("const", t.obj), nil
+	case nonNilQuery:
+		return unary("nonNil", t.Query)
+	case failQuery:
+		return &queryNode{Op: "fail", Name: t.err.Error()}, nil
+	case requireQuery:
+		node, err := unary("require", t.q)
+		if err == nil {
+			node.Name = t.msg
+		}
+		return node, err
+	case defaultQuery:
+		if len(t.targets) != 0 {
+			return nil, errors.New("cannot marshal defaultErr with error targets")
+		} else if !isOperand(t.fallback) {
+			return nil, fmt.Errorf("cannot marshal default value of type %T", t.fallback)
+		}
+		sub, err := encodeQuery(t.q)
+		if err != nil {
+			return nil, err
+		}
+		node := operandNode("default", t.fallback)
+		if t.onErr {
+			node.Op = "defaultErr"
+		}
+		node.Args = []*queryNode{sub}
+		return node, nil
+	case Seq:
+		if len(t) == 1 {
+			return encodeQuery(t[0])
+		}
+		return list("seq", t)
+	case keyQuery:
+		op := "key"
+		if t.strict {
+			op = "keyStrict"
+		}
+		if !isOperand(t.key) {
+			return nil, fmt.Errorf("cannot marshal key of type %T", t.key)
+		}
+		return operandNode(op, t.key), nil
+	case fieldQuery:
+		return encodeQuery(t.key())
+	case keyOrQuery:
+		return literals("keyOr", t)
+	case tagKeyQuery:
+		if !isLiteral(t.key) {
+			return nil, fmt.Errorf("cannot marshal key of type %T", t.key)
+		}
+		return &queryNode{Op: "tagKey", Name: t.tag, Value: t.key}, nil
+	case existsQuery:
+		return literals("exists", t)
+	case containsQuery:
+		if !isOperand(t.needle) {
+			return nil, fmt.Errorf("cannot marshal contains operand of type %T", t.needle)
+		}
+		return operandNode("contains", t.needle), nil
+	case inQuery:
+		return literals("in", t)
+	case approxQuery:
+		return literals("eqApprox", []interface{}{t.want, t.epsilon})
+	case betweenQuery:
+		op := "between"
+		if t.exclusive {
+			op = "betweenExclusive"
+		}
+		return literals(op, []interface{}{t.lo, t.hi})
+	case matchQuery:
+		return &queryNode{Op: "match", Name: t.re.String()}, nil
+	case captureQuery:
+		return &queryNode{Op: "capture", Name: t.re.String()}, nil
+	case globQuery:
+		return &queryNode{Op: "glob", Name: string(t)}, nil
+	case stringQuery:
+		return &queryNode{Op: t.name, Name: t.arg}, nil
+	case sprintfQuery:
+		node, err := list("format", t.args)
+		if err != nil {
+			return nil, err
+		}
+		node.Name = t.format
+		return node, nil
+	case projectQuery:
+		op := "pick"
+		if t.omit {
+			op = "omit"
+		}
+		node := &queryNode{Op: op}
+		for _, name := range t.names {
+			node.Keys = append(node.Keys, name)
+		}
+		return node, nil
+	case indexQuery:
+		return &queryNode{Op: "index", N: int(t)}, nil
+	case rangeQuery:
+		node := &queryNode{Op: "range", N: t.lo}
+		if !t.toEnd {
+			hi := t.hi
+			node.Hi = &hi
+		}
+		return node, nil
+	case mapQuery:
+		return unary("each", t.Query)
+	case lenientMapQuery:
+		return unary("eachLenient", t.Query)
+	case indexedMapQuery:
+		return unary("eachIndexed", t.Query)
+	case typedMapQuery:
+		return unary("eachTyped", t.Query)
+	case typedSelectQuery:
+		return unary("selectTyped", t.Query)
+	case parMapQuery:
+		node, err := unary("eachN", t.Query)
+		if err == nil {
+			node.N = t.workers
+		}
+		return node, err
+	case selectQuery:
+		return unary("select", t.Query)
+	case selectMapQuery:
+		return unary("selectMap", t.Query)
+	case firstQuery:
+		return unary("first", t.Query)
+	case remapQuery:
+		if t.keys {
+			return unary("mapKeys", t.Query)
+		}
+		return unary("mapValues", t.Query)
+	case quantQuery:
+		return unary(t.name, t.Query)
+	case notQuery:
+		return unary("not", t.Query)
+	case arithQuery:
+		return list(t.name, t.qs)
+	case logicQuery:
+		return list(t.name, t.qs)
+	case Map:
+		node := &queryNode{Op: "map", Fields: make(map[string]*queryNode, len(t))}
+		for name, sub := range t {
+			enc, err := encodeQuery(sub)
+			if err != nil {
+				return nil, err
+			}
+			node.Fields[name] = enc
+		}
+		return node, nil
+	case Or:
+		return list("or", t)
+	case List:
+		return list("list", t)
+	case Cat:
+		return list("cat", t)
+	case zipQuery:
+		return list("zip", t)
+	case Join:
+		return list(t.joinName(), t.args())
+	case setQuery:
+		if t.key != nil {
+			return list(t.op+"By", append([]Query{t.key}, t.qs...))
+		}
+		return list(t.op, t.qs)
+	case cmpQuery:
+		if !isOperand(t.needle) {
+			return nil, fmt.Errorf("cannot marshal comparison with operand of type %T", t.needle)
+		}
+		for op, sym := range cmpOps {
+			if sym == t.op {
+				return operandNode(op, t.needle), nil
+			}
+		}
+	case paramQuery:
+		return &queryNode{Op: "param", Name: string(t)}, nil
+	case Switch:
+		// The arguments are the discriminator, the cases in the order of their
+		// keys, and the default, if any.
+		keys := t.caseKeys()
+		args := []Query{t.On}
+		for _, key := range keys {
+			args = append(args, t.Cases[key])
+		}
+		if t.Default != nil {
+			args = append(args, t.Default)
+		}
+		node, err := literals("switch", keys)
+		if err != nil {
+			return nil, err
+		}
+		sub, err := list("switch", args)
+		if err != nil {
+			return nil, err
+		}
+		node.Args = sub.Args
+		return node, nil
+	case funcRefQuery:
+		return &queryNode{Op: "funcRef", Name: string(t)}, nil
+	case boundFuncQuery:
+		return &queryNode{Op: "funcRef", Name: t.name}, nil
+	case letQuery:
+		node, err := list("let", []Query{t.q, t.body})
+		if err == nil {
+			node.Name = t.name
+		}
+		return node, err
+	case varQuery:
+		return &queryNode{Op: "var", Name: string(t)}, nil
+	case methodQuery:
+		node, err := literals("method", t.args)
+		if err == nil {
+			node.Name = t.name
+		}
+		return node, err
+	case *memoQuery:
+		return unary("memo", t.Query)
+	case descendQuery:
+		return unary("descend", t.Query)
+	case sortQuery:
+		if t.key != nil {
+			return unary("sortBy", t.key)
+		}
+	case groupQuery:
+		return unary("groupBy", t.Query)
+	case distinctQuery:
+		return unary("distinct", t.Query)
+	case countQuery:
+		return &queryNode{Op: "count"}, nil
+	case flattenQuery:
+		return &queryNode{Op: "flatten", N: int(t)}, nil
+	case chunkQuery:
+		return &queryNode{Op: "chunk", N: int(t)}, nil
+	case partitionQuery:
+		return unary("partition", t.Query)
+	case convertQuery:
+		return &queryNode{Op: string(t)}, nil
+	case typeInfoQuery:
+		return &queryNode{Op: string(t)}, nil
+	case aggQuery:
+		return unary([...]string{aggSum: "sum", aggMin: "min", aggMax: "max", aggMean: "mean"}[t.agg], t.Query)
+	case entriesQuery:
+		return &queryNode{Op: [...]string{entryKey: "keys", entryValue: "vals", entryBoth: "entries"}[t.part]}, nil
+	case Prepared:
+		return encodeQuery(t.q)
+	case specialQuery:
+		return encodeQuery(t.q)
+	}
+	return nil, fmt.Errorf("cannot marshal query of type %T", q)
+}
+
+func decodeQuery(node *queryNode) (Query, error) {
+	if node == nil {
+		return nil, errors.New("missing query")
+	}
+	decodeArgs := func() ([]Query, error) {
+		qs := make([]Query, len(node.Args))
+		for i, arg := range node.Args {
+			q, err := decodeQuery(arg)
+			if err != nil {
+				return nil, err
+			}
+			qs[i] = q
+		}
+		return qs, nil
+	}
+	if f, ok := unaryOps[node.Op]; ok {
+		arg, err := decodeQuery(node.Arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", node.Op, err)
+		}
+		return f(arg), nil
+	} else if f, ok := listOps[node.Op]; ok {
+		args, err := decodeArgs()
+		if err != nil {
+			return nil, err
+		}
+		return f(args), nil
+	} else if f, ok := stringOps[node.Op]; ok {
+		return f(node.Name), nil
+	} else if op, ok := cmpOps[node.Op]; ok {
+		return cmpQuery{op: op, needle: decodeOperand(node)}, nil
+	}
+
+	switch node.Op {
+	case "self":
+		return Self, nil
+	case "const":
+		return Const(decodeOperand(node)), nil
+	case "fail":
+		return Fail(errors.New(node.Name)), nil
+	case "require":
+		q, err := decodeQuery(node.Arg)
+		if err != nil {
+			return nil, fmt.Errorf("require: %w", err)
+		}
+		return Require(q, node.Name), nil
+	case "default", "defaultErr":
+		if len(node.Args) != 1 {
+			return nil, fmt.Errorf("%s: got %d arguments, want 1", node.Op, len(node.Args))
+		}
+		q, err := decodeQuery(node.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		return defaultQuery{q: q, fallback: decodeOperand(node), onErr: node.Op == "defaultErr"}, nil
+	case "key":
+		return keyQuery{key: decodeOperand(node)}, nil
+	case "keyStrict":
+		return keyQuery{key: decodeOperand(node), strict: true}, nil
+	case "keyOr":
+		return keyOrQuery(jsonValues(node.Keys)), nil
+	case "tagKey":
+		return tagKeyQuery{tag: node.Name, key: jsonValue(node.Value)}, nil
+	case "exists":
+		return existsQuery(jsonValues(node.Keys)), nil
+	case "contains":
+		return Contains(decodeOperand(node)), nil
+	case "in":
+		return In(jsonValues(node.Keys)...), nil
+	case "eqApprox":
+		if len(node.Keys) != 2 {
+			return nil, fmt.Errorf("eqApprox: got %d operands, want 2", len(node.Keys))
+		}
+		want, ok1 := toFloat(jsonValue(node.Keys[0]))
+		eps, ok2 := toFloat(jsonValue(node.Keys[1]))
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("eqApprox: invalid operands %v", node.Keys)
+		}
+		return EqApprox(want, eps), nil
+	case "between", "betweenExclusive":
+		if len(node.Keys) != 2 {
+			return nil, fmt.Errorf("%s: got %d bounds, want 2", node.Op, len(node.Keys))
+		}
+		bounds := jsonValues(node.Keys)
+		return betweenQuery{lo: bounds[0], hi: bounds[1], exclusive: node.Op == "betweenExclusive"}, nil
+	case "match", "capture":
+		re, err := regexp.Compile(node.Name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", node.Op, err)
+		} else if node.Op == "match" {
+			return matchQuery{re}, nil
+		}
+		return captureQuery{re}, nil
+	case "glob":
+		if _, err := path.Match(node.Name, ""); err != nil {
+			return nil, fmt.Errorf("glob: %w", err)
+		}
+		return globQuery(node.Name), nil
+	case "pick", "omit":
+		names := make([]string, len(node.Keys))
+		for i, key := range node.Keys {
+			s, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: invalid name %v", node.Op, key)
+			}
+			names[i] = s
+		}
+		return projectQuery{names: names, omit: node.Op == "omit"}, nil
+	case "index":
+		return Index(node.N), nil
+	case "range":
+		if node.Hi == nil {
+			return rangeQuery{lo: node.N, toEnd: true}, nil
+		}
+		return Range(node.N, *node.Hi), nil
+	case "eachN":
+		arg, err := decodeQuery(node.Arg)
+		if err != nil {
+			return nil, fmt.Errorf("eachN: %w", err)
+		}
+		return EachN(arg, node.N), nil
+	case "map":
+		m := make(Map, len(node.Fields))
+		for name, sub := range node.Fields {
+			q, err := decodeQuery(sub)
+			if err != nil {
+				return nil, fmt.Errorf("map field %q: %w", name, err)
+			}
+			m[name] = q
+		}
+		return m, nil
+	case "funcRef":
+		return FuncRef(node.Name), nil
+	case "let":
+		args, err := decodeArgs()
+		if err != nil {
+			return nil, err
+		} else if len(args) != 2 {
+			return nil, fmt.Errorf("let: got %d arguments, want 2", len(args))
+		}
+		return Let(node.Name, args[0], args[1]), nil
+	case "var":
+		return Var(node.Name), nil
+	case "param":
+		return Param(node.Name), nil
+	case "format":
+		args, err := decodeArgs()
+		if err != nil {
+			return nil, err
+		}
+		return Format(node.Name, args...), nil
+	case "switch":
+		args, err := decodeArgs()
+		if err != nil {
+			return nil, err
+		} else if n := len(args) - len(node.Keys); n != 1 && n != 2 {
+			return nil, fmt.Errorf("switch: got %d arguments for %d cases", len(args), len(node.Keys))
+		}
+		s := Switch{On: args[0], Cases: make(map[interface{}]Query, len(node.Keys))}
+		for i, key := range jsonValues(node.Keys) {
+			s.Cases[key] = args[i+1]
+		}
+		if len(args) > len(node.Keys)+1 {
+			s.Default = args[len(args)-1]
+		}
+		return s, nil
+	case "unionBy", "intersectBy", "differenceBy":
+		args, err := decodeArgs()
+		if err != nil {
+			return nil, err
+		} else if len(args) == 0 {
+			return nil, fmt.Errorf("%s: missing key", node.Op)
+		}
+		return setQuery{op: node.Op[:len(node.Op)-len("By")], key: args[0], qs: args[1:]}, nil
+	case "join", "outerJoin":
+		args, err := decodeArgs()
+		if err != nil {
+			return nil, err
+		} else if len(args) < 2 || len(args) > 4 {
+			return nil, fmt.Errorf("%s: got %d arguments, want 2 to 4", node.Op, len(args))
+		}
+		return newJoin(args, node.Op == "outerJoin"), nil
+	case "method":
+		return Method(node.Name, jsonValues(node.Keys)...), nil
+	case "count":
+		return Count(), nil
+	case "flatten":
+		return Flatten(node.N), nil
+	case "chunk":
+		return Chunk(node.N), nil
+	case "keys":
+		return Keys(), nil
+	case "vals":
+		return Vals(), nil
+	case "entries":
+		return Entries(), nil
+	case "toInt", "toFloat", "toString":
+		return convertQuery(node.Op), nil
+	case "kindOf", "typeName":
+		return typeInfoQuery(node.Op), nil
+	}
+	return nil, fmt.Errorf("unknown query op %q", node.Op)
+}
+
+// isLiteral reports whether obj is a literal that can be encoded in JSON and
+// decoded to an equivalent value.
+func isLiteral(obj interface{}) bool {
+	switch obj.(type) {
+	case nil, bool, string, int, float64:
+		return true
+	}
+	return false
+}
+
+// isOperand reports whether obj can be encoded as the operand of a query,
+// either as a literal or as a reference to a parameter.
+func isOperand(obj interface{}) bool {
+	_, ok := obj.(paramQuery)
+	return ok || isLiteral(obj)
+}
+
+// operandNode encodes a query with the given op and operand. An operand that
+// refers to a parameter is encoded as a "param" query in the arg field.
+func operandNode(op string, obj interface{}) *queryNode {
+	if p, ok := obj.(paramQuery); ok {
+		return &queryNode{Op: op, Arg: &queryNode{Op: "param", Name: string(p)}}
+	}
+	return &queryNode{Op: op, Value: obj}
+}
+
+// decodeOperand decodes the operand of node, as encoded by operandNode.
+func decodeOperand(node *queryNode) interface{} {
+	if node.Arg != nil && node.Arg.Op == "param" {
+		return paramQuery(node.Arg.Name)
+	}
+	return jsonValue(node.Value)
+}
+
+// jsonValue converts the numbers in a decoded JSON value to int or float64.
+func jsonValue(obj interface{}) interface{} {
+	switch t := obj.(type) {
+	case json.Number:
+		if n, err := strconv.Atoi(string(t)); err == nil {
+			return n
+		}
+		f, _ := t.Float64()
+		return f
+	case []interface{}:
+		return jsonValues(t)
+	case map[string]interface{}:
+		for key, val := range t {
+			t[key] = jsonValue(val)
+		}
+	}
+	return obj
+}
+
+func jsonValues(objs []interface{}) []interface{} {
+	for i, obj := range objs {
+		objs[i] = jsonValue(obj)
+	}
+	return objs
+}
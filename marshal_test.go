@@ -0,0 +1,169 @@
+package vql_test
+
+import (
+	"testing"
+
+	"github.com/creachadair/vql"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMarshalQuery(t *testing.T) {
+	type person struct {
+		Name  string `json:"name"`
+		Title string
+		Age   int
+		Tags  map[string]int
+	}
+	input := map[string]interface{}{
+		"People": []*person{
+			{Name: "Alice", Title: "CEO", Age: 35, Tags: map[string]int{"x": 1}},
+			{Name: "Bob", Title: "MGR", Age: 38},
+			{Name: "Carol", Title: "MGR", Age: 19},
+		},
+		"Count": 3,
+	}
+	upper := func(s string) string { return s + "!" }
+
+	tests := []vql.Query{
+		vql.Self,
+		vql.Const(map[string]interface{}{"a": []interface{}{1, 2.5, "x", nil, true}}),
+		vql.Seq{vql.Key("People"), vql.Index(1), vql.Key("Name")},
+		vql.Seq{vql.Key("People"), vql.Index(0), vql.Key("Tags", "x")},
+		vql.Seq{vql.Key("People"), vql.Index(-1), vql.KeyStrict("Title")},
+		vql.Seq{vql.Key("People"), vql.Range(0, 2), vql.Each(vql.TagKey("json", "name"))},
+		vql.Seq{vql.Key("People"), vql.Skip(1), vql.EachN(vql.Key("Age"), 2)},
+		vql.Seq{vql.Key("People"), vql.Select(vql.Key("Title"), vql.Eq("MGR")), vql.Count()},
+		vql.Seq{vql.Key("People"), vql.Select(vql.And(
+			vql.Seq{vql.Key("Age"), vql.Ge(20)},
+			vql.Not(vql.Seq{vql.Key("Age"), vql.Gt(36)}),
+		)), vql.Each(vql.Key("Name"))},
+		vql.Seq{vql.Key("People"), vql.Every(vql.Seq{vql.Key("Age"), vql.Lt(40)})},
+		vql.Seq{vql.Key("People"), vql.None(vql.Seq{vql.Key("Age"), vql.Gt(40)})},
+		vql.Seq{vql.Key("People"), vql.Select(vql.Key("Title"), vql.In("CEO", "CTO")), vql.Count()},
+		vql.Seq{vql.Key("People"), vql.Index(0), vql.Key("Tags"), vql.Contains(vql.Param("key"))},
+		vql.Seq{vql.Key("People"), vql.Each(vql.Key("Name")), vql.Select(vql.Match(`^[AC]`))},
+		vql.Seq{vql.Key("People"), vql.Index(1), vql.Key("Name"), vql.Capture(`(.)(.)`)},
+		vql.Seq{vql.Key("People"), vql.Each(vql.Key("Name")), vql.Select(vql.Glob("*o*"))},
+		vql.Seq{vql.Key("People"), vql.Select(vql.Key("Age"), vql.Between(19, 35)), vql.Count()},
+		vql.Seq{vql.Key("People"), vql.Select(vql.Key("Age"), vql.BetweenExclusive(19, 38)), vql.Count()},
+		vql.Seq{vql.Key("People"), vql.Select(vql.Key("Age"), vql.EqApprox(36, 2)), vql.Count()},
+		vql.Seq{vql.Key("People"), vql.Each(vql.Sub(vql.Mul(vql.Key("Age"), vql.Const(2)), vql.Div(vql.Key("Age"), vql.Const(3))))},
+		vql.Seq{vql.Key("People"), vql.Each(vql.Add(vql.Mod(vql.Key("Age"), vql.Const(7)), vql.Const(0.5)))},
+		vql.Seq{vql.Key("People"), vql.Each(vql.Seq{vql.Key("Title"), vql.ToLower()}), vql.JoinWith(",")},
+		vql.Seq{vql.Key("People"), vql.Each(vql.List{vql.Seq{vql.Key("Age"), vql.ToString(), vql.ToFloat(), vql.ToInt()}})},
+		vql.Seq{vql.Key("People"), vql.List{vql.KindOf(), vql.TypeName()}},
+		vql.Seq{vql.Cat{vql.Key("People"), vql.Key("Name")}, vql.EachLenient(vql.Key("Age"))},
+		vql.Seq{vql.Key("People"), vql.EachIndexed(vql.List{vql.Key("Key"), vql.Key("Value", "Name")})},
+		vql.Seq{vql.Key("People"), vql.Each(vql.List{vql.Key("Name"), vql.List{vql.Key("Age")}}), vql.Flatten(2)},
+		vql.Seq{vql.Join{Left: vql.Key("People"), Right: vql.Key("People"), LeftKey: vql.Key("Age"), Outer: true}, vql.Count()},
+		vql.Intersect(vql.Key("People"), vql.Seq{vql.Key("People"), vql.Range(0, 1)}),
+		vql.UnionBy(vql.Key("Age"), vql.Key("People"), vql.Key("People")),
+		vql.Seq{vql.Key("People"), vql.Zip(vql.Each(vql.Key("Name")), vql.Each(vql.Key("Age")))},
+		vql.Seq{vql.Key("People"), vql.Partition(vql.Key("Age"), vql.Gt(30)), vql.Each(vql.Chunk(1))},
+		vql.Seq{vql.Key("People"), vql.SelectTyped(vql.Key("Age"), vql.Gt(20)), vql.EachTyped(vql.Key("Name"))},
+		vql.Seq{vql.Key("People"), vql.Each(vql.Or{vql.Key("Name"), vql.Failf("no name")})},
+		vql.Seq{vql.Key("People"), vql.Each(vql.Require(vql.Seq{vql.NonNil(vql.Key("Age")), vql.Gt(0)}, "age must be positive"))},
+		vql.Seq{vql.Key("People"), vql.Each(vql.List{vql.Default(vql.Key("Nope"), vql.Param("age")), vql.DefaultErr(vql.Index(0), "x")})},
+		vql.Seq{vql.Key("People"), vql.Select(vql.Key("Name"), vql.HasSuffix("b")), vql.Count()},
+		vql.Seq{vql.Key("People"), vql.Index(0), vql.Key("Name"), vql.Split("i")},
+		vql.Seq{vql.Key("People"), vql.Each(vql.Format("%s/%d", vql.Key("Name"), vql.Key("Age")))},
+		vql.Seq{vql.Key("People"), vql.First(vql.Key("Age"), vql.Lt(30)), vql.Key("Name")},
+		vql.Seq{vql.Key("People"), vql.Any(vql.OrBool(vql.Seq{vql.Key("Age"), vql.Le(19)}))},
+		vql.Seq{vql.Key("People"), vql.Each(vql.Exists("Tags", "x"))},
+		vql.Seq{vql.Key("People"), vql.Index(0), vql.Map{
+			"who":  vql.KeyOr("Nick", "Name"),
+			"tags": vql.Seq{vql.Key("Tags"), vql.Keys()},
+		}},
+		vql.Or{vql.Key("Nonesuch"), vql.Key("Count")},
+		vql.List{vql.Key("Count"), vql.Const(nil)},
+		vql.Cat{vql.Seq{vql.Key("People"), vql.Each(vql.Key("Age"))}, vql.Key("Count")},
+		vql.Seq{vql.Key("People"), vql.SortBy(vql.Key("Age")), vql.Each(vql.Memoize(vql.Key("Name")))},
+		vql.Seq{vql.Key("People"), vql.GroupBy(vql.Key("Title"))},
+		vql.Seq{vql.Key("People"), vql.Distinct(vql.Key("Title")), vql.Count()},
+		vql.Seq{vql.Key("People"), vql.List{
+			vql.Sum(vql.Key("Age")), vql.Min(vql.Key("Age")),
+			vql.Max(vql.Key("Age")), vql.Mean(vql.Key("Age")),
+		}},
+		vql.Seq{vql.Key("People"), vql.Index(0), vql.Key("Name"), vql.FuncRef("upper")},
+		vql.Descend(vql.Key("Tags")),
+		vql.Let("n", vql.Key("Count"), vql.Seq{vql.Key("People"), vql.Each(vql.List{vql.Key("Name"), vql.Var("n")})}),
+		vql.Seq{vql.Key("People"), vql.Each(vql.Pick("Name", "Age"))},
+		vql.Seq{vql.Key("People"), vql.Index(1), vql.Omit("Tags")},
+		vql.Seq{vql.Key("People"), vql.Select(vql.Key("Age"), vql.Gt(vql.Param("age"))), vql.Each(vql.Key(vql.Param("key")))},
+		vql.List{vql.Param("age"), vql.Const(vql.Param("key"))},
+		vql.Seq{vql.Key("People"), vql.Each(vql.Switch{
+			On:      vql.Key("Title"),
+			Cases:   map[interface{}]vql.Query{"CEO": vql.Key("Age"), "MGR": vql.Key("Name")},
+			Default: vql.Const(nil),
+		})},
+		vql.Switch{On: vql.Key("Count"), Cases: map[interface{}]vql.Query{3: vql.Const("three")}},
+	}
+	fns := vql.Funcs{"upper": upper}
+	args := vql.Args{"age": 20, "key": "Name"}
+	for _, q := range tests {
+		data, err := vql.MarshalQuery(q)
+		if err != nil {
+			t.Errorf("MarshalQuery(%v): unexpected error: %v", q, err)
+			continue
+		}
+		dq, err := vql.UnmarshalQuery(data)
+		if err != nil {
+			t.Errorf("UnmarshalQuery(%s): unexpected error: %v", data, err)
+			continue
+		}
+		want, err := vql.EvalWith(q, input, fns, args)
+		if err != nil {
+			t.Fatalf("Eval(%v): unexpected error: %v", q, err)
+		}
+		got, err := vql.EvalWith(dq, input, fns, args)
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", dq, err)
+		} else if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Eval(%s): (-want, +got)\n%s", data, diff)
+		}
+	}
+}
+
+func TestMarshalQueryFormat(t *testing.T) {
+	q := vql.Seq{vql.Key("People"), vql.Each(vql.Key("Name"))}
+	const want = `{"op":"seq","args":[{"op":"key","value":"People"},{"op":"each","arg":{"op":"key","value":"Name"}}]}`
+	data, err := vql.MarshalQuery(q)
+	if err != nil {
+		t.Fatalf("MarshalQuery: unexpected error: %v", err)
+	} else if got := string(data); got != want {
+		t.Errorf("MarshalQuery: got %s, want %s", got, want)
+	}
+}
+
+func TestMarshalQueryErrors(t *testing.T) {
+	for _, q := range []vql.Query{
+		vql.Func(func(s string) bool { return s == "" }),
+		vql.Seq{vql.Key("A"), vql.Each(vql.Func(func(int) int { return 0 }))},
+		vql.Key(struct{}{}),
+		vql.Eq([]int{1}),
+		vql.Set(vql.Key("A"), 1),
+		vql.Sort(vql.Const(true)),
+		vql.TypeIs[int](),
+		vql.DefaultErr(vql.Self, 0, vql.ErrNoKey),
+		vql.Default(vql.Self, []int{1}),
+	} {
+		if data, err := vql.MarshalQuery(q); err == nil {
+			t.Errorf("MarshalQuery(%v): got %s, want error", q, data)
+		}
+	}
+
+	for _, in := range []string{
+		``,
+		`[]`,
+		`{"op":"nonesuch"}`,
+		`{"op":"each"}`,
+		`{"op":"seq","args":[{"op":"key"},{"op":"bogus"}]}`,
+		`{"op":"map","fields":{"a":{"op":"bogus"}}}`,
+		`{"op":"match","name":"("}`,
+		`{"op":"glob","name":"["}`,
+	} {
+		if q, err := vql.UnmarshalQuery([]byte(in)); err == nil {
+			t.Errorf("UnmarshalQuery(%q): got %v, want error", in, q)
+		}
+	}
+}
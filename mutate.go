@@ -0,0 +1,265 @@
+package vql
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Set returns a Query that assigns val to the location identified by path,
+// and yields its input. The path must be composed of Key and Index queries,
+// possibly combined with Seq; as when reading, an integer Key selects an
+// element of an array or slice. The location must be addressable: To modify a
+// struct, the input must be a pointer to it. Map entries are created if they
+// do not already exist. If val is a Param query, the value of the parameter
+// is assigned.
+func Set(path Query, val interface{}) Query {
+	return mutateQuery{name: "set", path: path, op: func(v *value, _ reflect.Value) (interface{}, bool, error) {
+		nv, err := resolveArg(v, val)
+		return nv, false, err
+	}}
+}
+
+// Delete returns a Query that removes the location identified by path, and
+// yields its input. The path must have the form described for Set.  Deleting
+// a map key removes the entry from the map; deleting a slice element removes
+// the element from the slice, shifting any later elements down; and deleting
+// a struct field sets it to its zero value. It is not an error to delete a map
+// key that does not exist.
+func Delete(path Query) Query {
+	return mutateQuery{name: "delete", path: path, op: func(*value, reflect.Value) (interface{}, bool, error) {
+		return nil, true, nil
+	}}
+}
+
+// Update returns a Query that replaces the value at the location identified
+// by path with the result of applying the function fn to it, and yields its
+// input. The path must have the form described for Set, and fn must have one
+// of the signatures accepted by Func; otherwise Update will panic. If the
+// location does not exist, fn is given the zero value of its argument type.
+func Update(path Query, fn interface{}) Query {
+	fq, err := newFnQuery(fn, nil)
+	if err != nil {
+		panic("update: " + err.Error())
+	}
+	return mutateQuery{name: "update", path: path, op: func(v *value, old reflect.Value) (interface{}, bool, error) {
+		var arg interface{}
+		if old.IsValid() {
+			arg = old.Interface()
+		}
+		next, err := evalQuery(fq, pushValue(v, arg))
+		if err != nil {
+			return nil, false, err
+		}
+		return next.val, false, nil
+	}}
+}
+
+// A mutation computes the new value for a location given its old value. The
+// old value is invalid if the location does not exist. If del is true, the
+// location is deleted and the new value is ignored.
+type mutation func(v *value, old reflect.Value) (_ interface{}, del bool, _ error)
+
+type mutateQuery struct {
+	name string // for diagnostics
+	path Query
+	op   mutation
+}
+
+func (m mutateQuery) eval(v *value) (*value, error) {
+	steps, err := pathSteps(m.path)
+	if err != nil {
+		return nil, err
+	} else if len(steps) == 0 {
+		return nil, errors.New("cannot modify an empty path")
+	}
+	if err := mutate(v, reflect.ValueOf(v.val), steps, m.op); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// pathSteps flattens q into a sequence of Key and Index steps, or reports an
+// error if q is not composed only of such steps.
+func pathSteps(q Query) ([]Query, error) {
+	switch t := q.(type) {
+	case keyQuery, indexQuery:
+		return []Query{t}, nil
+	case selfQuery:
+		return nil, nil
+	case Seq:
+		var steps []Query
+		for _, elt := range t {
+			sub, err := pathSteps(elt)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, sub...)
+		}
+		return steps, nil
+	}
+	return nil, fmt.Errorf("query of type %T is not a path", q)
+}
+
+// mutate applies op to the location reached by following steps from rv.
+func mutate(v *value, rv reflect.Value, steps []Query, op mutation) error {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return fmt.Errorf("cannot modify through a nil %v", rv.Type())
+		}
+		elem := rv.Elem()
+
+		// An interface holding a value type cannot be modified in place, so copy
+		// its contents into an addressable location and write them back.
+		if k := elem.Kind(); rv.Kind() == reflect.Interface && rv.CanSet() &&
+			(k == reflect.Struct || k == reflect.Array || k == reflect.Slice) {
+			cp := reflect.New(elem.Type()).Elem()
+			cp.Set(elem)
+			if err := mutate(v, cp, steps, op); err != nil {
+				return err
+			}
+			rv.Set(cp)
+			return nil
+		}
+		rv = elem
+	}
+
+	switch step := steps[0].(type) {
+	case keyQuery:
+		pkey, err := resolveArg(v, step.key)
+		if err != nil {
+			return err
+		}
+		if k := rv.Kind(); k == reflect.Array || k == reflect.Slice {
+			kv := reflect.ValueOf(pkey)
+			switch {
+			case isIntLike(kv.Kind()):
+				return mutateIndex(v, rv, int(kv.Int()), steps, op)
+			case isUintLike(kv.Kind()) && kv.Uint() <= uint64(rv.Len()):
+				return mutateIndex(v, rv, int(kv.Uint()), steps, op)
+			case isUintLike(kv.Kind()):
+				return fmt.Errorf("%w: %d is not in 0..%d", ErrBadIndex, kv.Uint(), rv.Len())
+			}
+			return fmt.Errorf("%w: value of type %T cannot index a sequence", ErrBadKey, pkey)
+		} else if rv.Kind() == reflect.Struct {
+			name, ok := pkey.(string)
+			if !ok {
+				return fmt.Errorf("%w: value of type %T cannot be a field name", ErrBadKey, pkey)
+			}
+			sf, ok := fieldByName(rv.Type(), name)
+			if !ok {
+				return fmt.Errorf("type %v has no field %q", rv.Type(), name)
+			}
+			f, err := rv.FieldByIndexErr(sf.Index)
+			if err != nil {
+				return fmt.Errorf("cannot modify field %q through a nil embedded pointer", name)
+			} else if !f.CanInterface() {
+				return fmt.Errorf("field %q of %v is not exported", name, rv.Type())
+			} else if len(steps) > 1 {
+				return mutate(v, f, steps[1:], op)
+			} else if !f.CanSet() {
+				return fmt.Errorf("field %q of %v is not addressable", name, rv.Type())
+			}
+			return assign(v, f, op)
+		} else if rv.Kind() == reflect.Map {
+			kt := rv.Type().Key()
+			if pkey == nil && kt.Kind() != reflect.Interface {
+				return fmt.Errorf("%w: nil cannot be a key in this map", ErrBadKey)
+			} else if pkey != nil && !reflect.TypeOf(pkey).AssignableTo(kt) {
+				return fmt.Errorf("%w: value of type %T cannot be a key in this map", ErrBadKey, pkey)
+			} else if rv.IsNil() {
+				return errors.New("cannot modify a nil map")
+			}
+			key := valueOf(pkey, kt)
+			cp := reflect.New(rv.Type().Elem()).Elem()
+			old := rv.MapIndex(key)
+			if len(steps) > 1 {
+				if !old.IsValid() {
+					return fmt.Errorf("key %v not found", pkey)
+				}
+				cp.Set(old)
+				if err := mutate(v, cp, steps[1:], op); err != nil {
+					return err
+				}
+			} else {
+				nv, del, err := op(v, old)
+				if err != nil {
+					return err
+				} else if del {
+					rv.SetMapIndex(key, reflect.Value{})
+					return nil
+				} else if err := setValue(cp, nv); err != nil {
+					return err
+				}
+			}
+			rv.SetMapIndex(key, cp)
+			return nil
+		}
+		return fmt.Errorf("value of type %v is %w", rv.Type(), ErrNotStruct)
+
+	case indexQuery:
+		if k := rv.Kind(); k != reflect.Array && k != reflect.Slice {
+			return fmt.Errorf("value of type %v is %w", rv.Type(), ErrNotSequence)
+		}
+		return mutateIndex(v, rv, int(step), steps, op)
+	}
+	panic("unreachable")
+}
+
+// mutateIndex applies op to the location reached by following steps from the
+// element at offset in the array or slice rv, where steps[0] selects that
+// element. A negative offset counts from the end of rv.
+func mutateIndex(v *value, rv reflect.Value, offset int, steps []Query, op mutation) error {
+	n := rv.Len()
+	if offset < 0 {
+		offset += n
+	}
+	if offset >= n || offset < 0 {
+		return fmt.Errorf("%w: %d is not in 0..%d", ErrBadIndex, offset, n)
+	}
+	elem := rv.Index(offset)
+	if len(steps) > 1 {
+		return mutate(v, elem, steps[1:], op)
+	} else if !elem.CanSet() {
+		return fmt.Errorf("element %d of %v is not addressable", offset, rv.Type())
+	}
+	nv, del, err := op(v, elem)
+	if err != nil {
+		return err
+	} else if !del {
+		return setValue(elem, nv)
+	} else if rv.Kind() != reflect.Slice || !rv.CanSet() {
+		return fmt.Errorf("cannot delete an element of %v", rv.Type())
+	}
+	reflect.Copy(rv.Slice(offset, n), rv.Slice(offset+1, n))
+	rv.Index(n - 1).Set(reflect.Zero(rv.Type().Elem()))
+	rv.SetLen(n - 1)
+	return nil
+}
+
+// assign applies op to the settable location rv.
+func assign(v *value, rv reflect.Value, op mutation) error {
+	nv, del, err := op(v, rv)
+	if err != nil {
+		return err
+	} else if del {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+	return setValue(rv, nv)
+}
+
+// setValue assigns obj to the settable location rv. A nil obj assigns the
+// zero value of the location.
+func setValue(rv reflect.Value, obj interface{}) error {
+	if obj == nil {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+	ov := reflect.ValueOf(obj)
+	if !ov.Type().AssignableTo(rv.Type()) {
+		return fmt.Errorf("value of type %T is not assignable to %v", obj, rv.Type())
+	}
+	rv.Set(ov)
+	return nil
+}
@@ -0,0 +1,141 @@
+package vql_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/vql"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMutate(t *testing.T) {
+	type inner struct {
+		N    int
+		Tags []string
+	}
+	type config struct {
+		Name  string
+		Inner inner
+		Ptr   *inner
+		Opts  map[string]interface{}
+		List  []inner
+		Any   map[interface{}]int
+	}
+	newConfig := func() *config {
+		return &config{
+			Name:  "alpha",
+			Inner: inner{N: 1, Tags: []string{"a", "b", "c"}},
+			Ptr:   &inner{N: 2},
+			Opts: map[string]interface{}{
+				"debug": false,
+				"sub":   map[string]interface{}{"level": 3},
+				"list":  []interface{}{"x", "y"},
+				"inner": inner{N: 5},
+			},
+			List: []inner{{N: 10}, {N: 11}},
+			Any:  map[interface{}]int{"a": 1},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query vql.Query
+		check vql.Query
+		want  interface{}
+	}{
+		{"SetField", vql.Set(vql.Key("Name"), "beta"), vql.Key("Name"), "beta"},
+		{"SetNested", vql.Set(vql.Key("Inner", "N"), 7), vql.Key("Inner", "N"), 7},
+		{"SetPointer", vql.Set(vql.Key("Ptr", "N"), 8), vql.Key("Ptr", "N"), 8},
+		{"SetIndex", vql.Set(vql.Seq{vql.Key("Inner", "Tags"), vql.Index(-1)}, "z"),
+			vql.Key("Inner", "Tags"), []string{"a", "b", "z"}},
+		{"SetMapNew", vql.Set(vql.Key("Opts", "new"), 1), vql.Key("Opts", "new"), 1},
+		{"SetMapNested", vql.Set(vql.Key("Opts", "sub", "level"), 4), vql.Key("Opts", "sub", "level"), 4},
+		{"SetMapStruct", vql.Set(vql.Key("Opts", "inner", "N"), 6), vql.Key("Opts", "inner", "N"), 6},
+		{"SetMapSlice", vql.Set(vql.Seq{vql.Key("Opts", "list"), vql.Index(0)}, "w"),
+			vql.Key("Opts", "list"), []interface{}{"w", "y"}},
+		{"SetParamKey", vql.Set(vql.Key("Opts", vql.Param("key")), 2), vql.Key("Opts", "key"), 2},
+		{"SetNilKey", vql.Set(vql.Key("Any", nil), 2), vql.Key("Any"), map[interface{}]int{nil: 2, "a": 1}},
+		{"SetSliceField", vql.Set(vql.Seq{vql.Key("List"), vql.Index(1), vql.Key("N")}, 12),
+			vql.Seq{vql.Key("List"), vql.Each(vql.Key("N"))}, []interface{}{10, 12}},
+		{"SetIntKey", vql.Set(vql.Key("List", 1, "N"), 13),
+			vql.Seq{vql.Key("List"), vql.Each(vql.Key("N"))}, []interface{}{10, 13}},
+		{"SetIntKeyEnd", vql.Set(vql.Key("Inner", "Tags", -1), "z"), vql.Key("Inner", "Tags"), []string{"a", "b", "z"}},
+		{"SetParamVal", vql.Set(vql.Key("Name"), vql.Param("key")), vql.Key("Name"), "key"},
+
+		{"DeleteMapKey", vql.Delete(vql.Key("Opts", "debug")), vql.Key("Opts", "debug"), nil},
+		{"DeleteMissing", vql.Delete(vql.Key("Opts", "nonesuch")), vql.Key("Opts", "nonesuch"), nil},
+		{"DeleteField", vql.Delete(vql.Key("Ptr")), vql.Key("Ptr"), (*inner)(nil)},
+		{"DeleteElem", vql.Delete(vql.Seq{vql.Key("Inner", "Tags"), vql.Index(1)}),
+			vql.Key("Inner", "Tags"), []string{"a", "c"}},
+		{"DeleteMapElem", vql.Delete(vql.Seq{vql.Key("Opts", "list"), vql.Index(0)}),
+			vql.Key("Opts", "list"), []interface{}{"y"}},
+		{"DeleteIntKey", vql.Delete(vql.Key("Inner", "Tags", uint8(0))), vql.Key("Inner", "Tags"), []string{"b", "c"}},
+
+		{"UpdateField", vql.Update(vql.Key("Name"), strings.ToUpper), vql.Key("Name"), "ALPHA"},
+		{"UpdateMissing", vql.Update(vql.Key("Opts", "count"), func(n int) int { return n + 1 }),
+			vql.Key("Opts", "count"), 1},
+		{"UpdateEach", vql.Update(vql.Seq{vql.Key("Inner"), vql.Key("Tags")}, func(ss []string) []string {
+			return append(ss, "d")
+		}), vql.Key("Inner", "Tags"), []string{"a", "b", "c", "d"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := newConfig()
+			got, err := vql.EvalWith(test.query, cfg, vql.Args(map[string]interface{}{"key": "key"}))
+			if err != nil {
+				t.Fatalf("Eval: unexpected error: %v", err)
+			} else if got != cfg {
+				t.Errorf("Eval: got %v, want input %v", got, cfg)
+			}
+			res, err := vql.Eval(test.check, cfg)
+			if err != nil {
+				t.Fatalf("Check: unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(test.want, res); diff != "" {
+				t.Errorf("Check: (-want, +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMutateErrors(t *testing.T) {
+	type thing struct {
+		A int
+		M map[string]int
+		m map[string]int
+		p *thing
+	}
+	tests := []struct {
+		query vql.Query
+		input interface{}
+	}{
+		{vql.Set(vql.Key("A"), 1), thing{}},                          // not addressable
+		{vql.Set(vql.Key("A"), "one"), &thing{}},                     // wrong type
+		{vql.Set(vql.Key("B"), 1), &thing{}},                         // no such field
+		{vql.Set(vql.Key("M", "x"), 1), &thing{}},                    // nil map
+		{vql.Set(vql.Self, 1), &thing{}},                             // empty path
+		{vql.Set(vql.Each(vql.Key("A")), 1), []*thing{{}}},           // not a path
+		{vql.Set(vql.Index(3), 1), []int{1, 2}},                      // out of range
+		{vql.Delete(vql.Index(0)), [2]int{1, 2}},                     // cannot delete
+		{vql.Delete(vql.Index(0)), []int{1, 2}},                      // not addressable
+		{vql.Set(vql.Key("x", "y"), 1), map[string]int{}},            // missing key
+		{vql.Update(vql.Key("A"), strings.ToUpper), &thing{}},        // wrong argument type
+		{vql.Set(vql.Key("m", "x"), 1), &thing{m: map[string]int{}}}, // unexported field
+		{vql.Set(vql.Key("p", "A"), 1), &thing{p: &thing{}}},         // unexported field
+		{vql.Set(vql.Key(nil), 1), map[string]int{}},                 // nil key
+		{vql.Set(vql.Key(vql.Param("k")), 1), map[string]int{}},      // unbound parameter
+
+		{vql.Set(vql.Key("x"), vql.Param("v")), map[string]int{}},
+		{vql.Set(vql.Key(3), 1), &[]int{1, 2}},
+		{vql.Set(vql.Key(uint(3)), 1), &[]int{1, 2}},
+		{vql.Set(vql.Key("x"), 1), &[]int{1, 2}},
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(test.query, test.input)
+		if err == nil {
+			t.Errorf("Eval(%v): got %v, want error", test.query, got)
+		} else {
+			t.Logf("Eval(%v): got expected error: %v", test.query, err)
+		}
+	}
+}
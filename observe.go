@@ -0,0 +1,61 @@
+package vql
+
+// An EvalObserver is notified of the evaluation of each query and subquery
+// during an evaluation by EvalWith with the Observe option. This allows the
+// caller to collect metrics, enforce policies, or build debugging tools.
+//
+// The queries observed include the query being evaluated, the steps of each
+// Seq, and the subqueries applied by combinators such as Each and Select, for
+// each value to which they are applied. A Seq of a single step is observed as
+// the Seq alone.
+//
+// If the query evaluates subqueries concurrently, as EachN does, the
+// methods of an EvalObserver may be called concurrently.
+type EvalObserver interface {
+	// OnEnter is called before q is evaluated on the input in. If OnEnter
+	// reports an error, q is not evaluated, and its evaluation fails with
+	// that error.
+	OnEnter(q Query, in interface{}) error
+
+	// OnExit is called after q is evaluated on the input in, with the result
+	// out or the error err reported by q.
+	OnExit(q Query, in, out interface{}, err error)
+}
+
+// Observe returns an Option that notifies obs of the evaluation of each
+// query. If more than one Observe option is given, each observer is notified
+// in the order given.
+func Observe(obs EvalObserver) Option { return observeOption{obs} }
+
+type observeOption struct{ obs EvalObserver }
+
+func (o observeOption) apply(e *env) { e.observers = append(e.observers, o.obs) }
+
+// evalQuery evaluates q on v, notifying the observers of v, if any, and
+// enforcing its limits.
+func evalQuery(q Query, v *value) (*value, error) {
+	if v.env.limits != nil {
+		if err := v.env.enter(); err != nil {
+			return nil, err
+		}
+		defer v.env.leave()
+	}
+	obs := v.env.observers
+	if len(obs) == 0 {
+		return q.eval(v)
+	}
+	for _, o := range obs {
+		if err := o.OnEnter(q, v.val); err != nil {
+			return nil, err
+		}
+	}
+	out, err := q.eval(v)
+	var res interface{}
+	if err == nil {
+		res = out.val
+	}
+	for _, o := range obs {
+		o.OnExit(q, v.val, res, err)
+	}
+	return out, err
+}
@@ -0,0 +1,102 @@
+package vql
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// EachN returns a Query that behaves like Each(q), but evaluates q on up to
+// workers elements of its input concurrently. The order of the results is the
+// same as for Each. If workers ≤ 0, the value of runtime.GOMAXPROCS is used.
+//
+// If q fails for any element, no further elements are started, and the error
+// reported is the one for the earliest failing element. Because elements are
+// evaluated concurrently, q must be safe for concurrent use; all the queries
+// provided by this package are, provided the functions given to Func are.
+func EachN(q Query, workers int) Query { return parMapQuery{Query: q, workers: workers} }
+
+type parMapQuery struct {
+	Query
+	workers int
+}
+
+func (m parMapQuery) eval(v *value) (*value, error) {
+	var elts []interface{}
+	if err := forEach(v, func(obj interface{}) error {
+		elts = append(elts, obj)
+		return nil
+	}); err != nil {
+		return nil, err
+	} else if len(elts) == 0 {
+		return pushValue(v, []interface{}(nil)), nil
+	}
+
+	nw := m.workers
+	if nw <= 0 {
+		nw = runtime.GOMAXPROCS(0)
+	}
+	if nw > len(elts) {
+		nw = len(elts)
+	}
+
+	vs := make([]interface{}, len(elts))
+	errs := make([]error, len(elts))
+	next := int64(-1)  // the index of the last element claimed (atomic)
+	failed := int32(0) // set to 1 when any element fails (atomic)
+
+	var wg sync.WaitGroup
+	for w := 0; w < nw; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			we := v.env.fork()
+			for atomic.LoadInt32(&failed) == 0 {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(elts) {
+					return
+				}
+				err := v.env.ctx.Err()
+				if err == nil {
+					in := pushValue(v, elts[i])
+					in.env = we
+
+					var out *value
+					if out, err = evalElem(m.Query, in); err == nil {
+						vs[i] = out.val
+					}
+				}
+				if err != nil {
+					errs[i] = err
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every element before the first failure was evaluated, so the earliest
+	// error recorded is the same one a sequential evaluation would report.
+	isMap := isMapValue(v.val)
+	for i, err := range errs {
+		if err == nil {
+			continue
+		} else if isMap {
+			return nil, wrapError([]Query{keyQuery{key: elts[i].(Entry).Key}}, elts[i], err)
+		}
+		return nil, wrapError([]Query{indexQuery(i)}, elts[i], err)
+	}
+	return pushValue(v, vs), nil
+}
+
+// evalElem evaluates q on the element in, counting the traversal into it
+// toward the depth limit of its evaluation, as forEachValue does.
+func evalElem(q Query, in *value) (*value, error) {
+	if in.env.limits != nil {
+		if err := in.env.descend(); err != nil {
+			return nil, err
+		}
+		defer in.env.leave()
+	}
+	return evalQuery(q, in)
+}
@@ -0,0 +1,71 @@
+package vql_test
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/creachadair/vql"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEachN(t *testing.T) {
+	var input []int
+	var want []interface{}
+	for i := 0; i < 100; i++ {
+		input = append(input, i)
+		want = append(want, fmt.Sprint(i*i))
+	}
+	square := vql.Func(func(n int) string { return fmt.Sprint(n * n) })
+
+	for _, workers := range []int{-1, 0, 1, 3, 16, 500} {
+		got, err := vql.Eval(vql.EachN(square, workers), input)
+		if err != nil {
+			t.Errorf("EachN(%d): unexpected error: %v", workers, err)
+		} else if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("EachN(%d): (-want, +got)\n%s", workers, diff)
+		}
+	}
+
+	// Maps are visited as entries, as with Each.
+	got, err := vql.Eval(vql.EachN(vql.Key("Value"), 4), map[string]int{"x": 1})
+	if err != nil {
+		t.Errorf("EachN: unexpected error: %v", err)
+	} else if diff := cmp.Diff([]interface{}{1}, got); diff != "" {
+		t.Errorf("EachN: (-want, +got)\n%s", diff)
+	}
+
+	// Empty inputs yield an empty result.
+	if got, err := vql.Eval(vql.EachN(square, 4), []int{}); err != nil || got.([]interface{}) != nil {
+		t.Errorf("EachN: got (%v, %v), want empty", got, err)
+	}
+}
+
+func TestEachNError(t *testing.T) {
+	errBad := errors.New("bad element")
+	var calls int64
+	q := vql.EachN(vql.Func(func(n int) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		if n%10 == 7 {
+			return 0, errBad
+		}
+		return n, nil
+	}), 4)
+
+	var input []int
+	for i := 0; i < 1000; i++ {
+		input = append(input, i)
+	}
+	_, err := vql.Eval(q, input)
+	var e *vql.Error
+	if !errors.As(err, &e) || !errors.Is(err, errBad) {
+		t.Fatalf("EachN: got %v, want %v", err, errBad)
+	}
+	if e.Value != 7 {
+		t.Errorf("EachN: failed at %v, want 7", e.Value)
+	}
+	if n := atomic.LoadInt64(&calls); n == int64(len(input)) {
+		t.Errorf("EachN: evaluated all %d elements despite failure", n)
+	}
+}
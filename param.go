@@ -0,0 +1,101 @@
+package vql
+
+import (
+	"context"
+	"fmt"
+)
+
+// An Option is a setting that affects the evaluation of a query by EvalWith.
+type Option interface {
+	apply(*env)
+}
+
+// EvalWith evaluates q starting from v, as Eval, with the settings given by
+// opts applied to the evaluation.
+func EvalWith(q Query, v interface{}, opts ...Option) (interface{}, error) {
+	e := &env{ctx: context.Background(), memo: len(opts) == 0}
+	for _, opt := range opts {
+		opt.apply(e)
+	}
+	return evalEnv(q, v, e)
+}
+
+// Args is an Option that supplies the values of the parameters referenced by
+// Param queries, indexed by name. If more than one Args option is given, their
+// values are merged, with later values replacing earlier ones.
+type Args map[string]interface{}
+
+func (a Args) apply(e *env) {
+	if e.args == nil {
+		e.args = make(map[string]interface{}, len(a))
+	}
+	for name, val := range a {
+		e.args[name] = val
+	}
+}
+
+// Funcs is an Option that supplies the functions referenced by FuncRef
+// queries, indexed by name, as EvalWithFuncs does.
+type Funcs map[string]interface{}
+
+func (f Funcs) apply(e *env) { e.funcs = prepareFuncs(f) }
+
+// AllowUnexported returns an Option that allows Key and related queries to
+// read the values of unexported struct fields, which are otherwise treated as
+// missing. It is meant for debugging and diagnostics, to inspect the internal
+// state of values whose types do not export it. Compile does not observe this
+// option, and reports an error for a Key that names an unexported field.
+func AllowUnexported() Option { return allowUnexported{} }
+
+type allowUnexported struct{}
+
+func (allowUnexported) apply(e *env) { e.unexported = true }
+
+// WithContext returns an Option that makes ctx govern the evaluation, as
+// EvalContext does.
+func WithContext(ctx context.Context) Option { return contextOption{ctx} }
+
+type contextOption struct{ ctx context.Context }
+
+func (c contextOption) apply(e *env) { e.ctx = c.ctx }
+
+// Param returns a Query that yields the value of the named parameter, as
+// supplied by an Args option to EvalWith. It is an error if no value is
+// supplied for name.
+//
+// A Param query may also be used as the operand of a comparison such as Eq or
+// Lt, or as the argument of Key or Const, in which case its value is
+// substituted when the query is evaluated. This allows one query to be reused
+// with different thresholds or key names, for example:
+//
+//	q := vql.Seq{vql.Key("People"), vql.Select(vql.Key("Age"), vql.Ge(vql.Param("min")))}
+//	adults, err := vql.EvalWith(q, org, vql.Args{"min": 18})
+func Param(name string) Query { return paramQuery(name) }
+
+type paramQuery string
+
+func (p paramQuery) eval(v *value) (*value, error) {
+	val, err := p.lookup(v)
+	if err != nil {
+		return nil, err
+	}
+	return pushValue(v, val), nil
+}
+
+func (p paramQuery) lookup(v *value) (interface{}, error) {
+	val, ok := v.env.args[string(p)]
+	if !ok {
+		return nil, fmt.Errorf("parameter %q is not bound", string(p))
+	}
+	return val, nil
+}
+
+// resolveArg returns the value of obj in the context of v. If obj is a
+// parameter reference, its value is looked up; otherwise obj is returned
+// unchanged.
+func resolveArg(v *value, obj interface{}) (interface{}, error) {
+	if p, ok := obj.(paramQuery); ok {
+		return p.lookup(v)
+	}
+	return obj, nil
+}
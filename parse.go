@@ -0,0 +1,911 @@
+package vql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parse parses s as a query in the text syntax described below, and returns
+// the equivalent Query.
+//
+// A query is a path of steps separated by periods, optionally followed by a
+// comparison. Each step is one of:
+//
+//	Name              -- a field or map key (vql.Key("Name"))
+//	"any string"      -- a field or map key given as a quoted string
+//	25                -- a map key given as a number (vql.Key(25))
+//	[2]               -- an index into an array or slice (vql.Index(2))
+//	[1:3]             -- a range of an array or slice (vql.Range(1, 3))
+//	(query)           -- a parenthesized subquery
+//	{a: query, ...}   -- a map of named subqueries (vql.Map)
+//	@name             -- a named function reference (see below)
+//	$name             -- a parameter (vql.Param("name"))
+//	self              -- the input value (vql.Self)
+//	fn(args...)       -- a built-in combinator (see below)
+//
+// Index steps may follow the previous step without a period, as in "A.B[2]".
+// Either bound of a range may be omitted: "[:n]" is vql.Take(n) and "[n:]" is
+// vql.Skip(n), while "[-n:]" selects the last n items.
+// A comparison has the form "op literal", where op is one of == != < <= > >=,
+// and the literal is a quoted string, a number, true, false, nil, or a
+// parameter $name whose value is supplied when the query is evaluated. A
+// comparison with an empty path compares the input value itself.
+//
+// The built-in combinators are:
+//
+//	each(q)           -- vql.Each(q)
+//	eachLenient(q)    -- vql.EachLenient(q)
+//	eachIndexed(q)    -- vql.EachIndexed(q)
+//	eachTyped(q)      -- vql.EachTyped(q)
+//	select(q, ...)    -- vql.Select(q, ...)
+//	selectTyped(q, ...) -- vql.SelectTyped(q, ...)
+//	selectMap(q, ...) -- vql.SelectMap(q, ...)
+//	first(q, ...)     -- vql.First(q, ...)
+//	mapValues(q)      -- vql.MapValues(q)
+//	mapKeys(q)        -- vql.MapKeys(q)
+//	every(q)          -- vql.Every(q)
+//	any(q)            -- vql.Any(q)
+//	all(q)            -- vql.All(q)
+//	none(q)           -- vql.None(q)
+//	not(q)            -- vql.Not(q)
+//	and(q, ...)       -- vql.And(q, ...)
+//	orBool(q, ...)    -- vql.OrBool(q, ...)
+//	or(q, ...)        -- vql.Or{q, ...}
+//	list(q, ...)      -- vql.List{q, ...}
+//	cat(q, ...)       -- vql.Cat{q, ...}
+//	zip(q, ...)       -- vql.Zip(q, ...)
+//	join(l, r, k, rk) -- vql.Join{Left: l, Right: r, LeftKey: k, RightKey: rk}
+//	outerJoin(...)    -- vql.Join{..., Outer: true}
+//	union(q, ...)     -- vql.Union(q, ...)
+//	unionBy(...)      -- vql.UnionBy(k, q, ...)
+//	intersect(q, ...) -- vql.Intersect(q, ...)
+//	intersectBy(...)  -- vql.IntersectBy(k, q, ...)
+//	difference(...)   -- vql.Difference(q, ...)
+//	differenceBy(...) -- vql.DifferenceBy(k, q, ...)
+//	add(q, ...)       -- vql.Add(q, ...)
+//	sub(a, b)         -- vql.Sub(a, b)
+//	mul(q, ...)       -- vql.Mul(q, ...)
+//	div(a, b)         -- vql.Div(a, b)
+//	mod(a, b)         -- vql.Mod(a, b)
+//	memo(q)           -- vql.Memoize(q)
+//	nonNil(q)         -- vql.NonNil(q)
+//	descend(q)        -- vql.Descend(q)
+//	sortBy(q)         -- vql.SortBy(q)
+//	sort(q)           -- vql.Sort(q)
+//	groupBy(q)        -- vql.GroupBy(q)
+//	count()           -- vql.Count()
+//	flatten(n)        -- vql.Flatten(n)
+//	chunk(n)          -- vql.Chunk(n)
+//	partition(q, ...) -- vql.Partition(q, ...)
+//	keys()            -- vql.Keys()
+//	vals()            -- vql.Vals()
+//	entries()         -- vql.Entries()
+//	toLower()         -- vql.ToLower()
+//	toUpper()         -- vql.ToUpper()
+//	trimSpace()       -- vql.TrimSpace()
+//	toInt()           -- vql.ToInt()
+//	toFloat()         -- vql.ToFloat()
+//	toString()        -- vql.ToString()
+//	kindOf()          -- vql.KindOf()
+//	typeName()        -- vql.TypeName()
+//	distinct(q, ...)  -- vql.Distinct(q, ...)
+//	sum(q)            -- vql.Sum(q)
+//	min(q)            -- vql.Min(q)
+//	max(q)            -- vql.Max(q)
+//	mean(q)           -- vql.Mean(q)
+//	const(lit)        -- vql.Const(lit)
+//	key(lit, ...)     -- vql.Key(lit, ...)
+//	exists(lit, ...)  -- vql.Exists(lit, ...)
+//	contains(lit)     -- vql.Contains(lit)
+//	in(lit, ...)      -- vql.In(lit, ...)
+//	between(lo, hi)   -- vql.Between(lo, hi)
+//	eqApprox(x, eps)  -- vql.EqApprox(x, eps)
+//	betweenExclusive(lo, hi) -- vql.BetweenExclusive(lo, hi)
+//	pick(lit, ...)    -- vql.Pick(lit, ...)
+//	omit(lit, ...)    -- vql.Omit(lit, ...)
+//	strict(lit, ...)  -- vql.KeyStrict(lit, ...)
+//	keyOr(lit, ...)   -- vql.KeyOr(lit, ...)
+//	json(lit, ...)    -- vql.TagKey("json", lit, ...)
+//	tag(t, lit, ...)  -- vql.TagKey(t, lit, ...)
+//	method(n, ...)    -- vql.Method(n, lit, ...)
+//	match(re)         -- vql.Match(re)
+//	capture(re)       -- vql.Capture(re)
+//	glob(pat)         -- vql.Glob(pat)
+//	fail(msg)         -- vql.Fail(errors.New(msg))
+//	split(sep)        -- vql.Split(sep)
+//	joinWith(sep)     -- vql.JoinWith(sep)
+//	hasPrefix(s)      -- vql.HasPrefix(s)
+//	hasSuffix(s)      -- vql.HasSuffix(s)
+//
+// A switch has the form "switch(q, lit: q, ..., default: q)", and denotes a
+// vql.Switch whose cases are labelled by the literals. The default case is
+// optional. A formatted string has the form "format(s, q, ...)", where s is a
+// quoted format string, and denotes vql.Format(s, q, ...). A default has the
+// form "default(q, lit)" or "defaultErr(q, lit)", and denotes vql.Default(q,
+// lit) or vql.DefaultErr(q, lit) respectively. A requirement has the form
+// "require(q, s)", where s is a quoted message, and denotes vql.Require(q, s).
+//
+// A combinator that takes a single query argument may omit the parentheses if
+// the argument is a single step, as in "People.each Name". Otherwise, the name
+// of a combinator not followed by an argument is treated as a key, so that
+// "Stats.count" is equivalent to vql.Key("Stats", "count"). The name self is
+// reserved: To use it as a key, quote it.
+//
+// A function reference "@name" denotes vql.FuncRef("name"), whose function is
+// resolved when the query is evaluated. To bind references to the functions
+// of a FuncRegistry when parsing, and to report references to unknown
+// functions as errors, use ParseFuncs.
+//
+// For example, the query
+//
+//	People.select(Title == "CEO").each Name
+//
+// is equivalent to
+//
+//	vql.Seq{
+//	   vql.Key("People"),
+//	   vql.Select(vql.Key("Title"), vql.Eq("CEO")),
+//	   vql.Each(vql.Key("Name")),
+//	}
+//
+// An empty string is equivalent to vql.Self.
+func Parse(s string) (Query, error) { return parse(s, &funcBinder{}) }
+
+// parse parses s as a query, using funcs to resolve function references.
+func parse(s string, funcs *funcBinder) (Query, error) {
+	toks, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks, funcs: funcs}
+	if p.peek().kind == tokEOF {
+		return Self, nil
+	}
+	q, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	} else if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	return q, nil
+}
+
+// MustParse is as Parse, but panics if s is not a valid query.
+func MustParse(s string) Query {
+	q, err := Parse(s)
+	if err != nil {
+		panic("parse: " + err.Error())
+	}
+	return q
+}
+
+// Path returns a Query for a simple path of field or map keys and indices,
+// written as names separated by periods, each optionally followed by one or
+// more bracketed integer indices. For example,
+//
+//	vql.Path("a.b[2].c")
+//
+// is equivalent to
+//
+//	vql.Seq{vql.Key("a", "b"), vql.Index(2), vql.Key("c")}
+//
+// Keys are always strings, and are taken literally: Unlike Parse, Path does
+// not recognize quoting, combinators, or comparisons. An empty path is
+// equivalent to vql.Self. Path panics if s is not a valid path.
+func Path(s string) Query {
+	if s == "" {
+		return Self
+	}
+	var q Seq
+	for i, seg := range strings.Split(s, ".") {
+		name := seg
+		if j := strings.IndexByte(seg, '['); j >= 0 {
+			name = seg[:j]
+		}
+		if name == "" && (i != 0 || len(name) == len(seg)) {
+			panic(fmt.Sprintf("path: empty key in %q", s))
+		} else if strings.ContainsAny(name, "]") {
+			panic(fmt.Sprintf("path: invalid key %q in %q", name, s))
+		} else if name != "" {
+			q = append(q, keyQuery{key: name})
+		}
+		for rest := seg[len(name):]; rest != ""; {
+			j := strings.IndexByte(rest, ']')
+			if rest[0] != '[' || j < 0 {
+				panic(fmt.Sprintf("path: invalid index %q in %q", rest, s))
+			}
+			n, err := strconv.Atoi(rest[1:j])
+			if err != nil {
+				panic(fmt.Sprintf("path: invalid index %q in %q", rest[:j+1], s))
+			}
+			q = append(q, indexQuery(n))
+			rest = rest[j+1:]
+		}
+	}
+	return q
+}
+
+// builtins maps the names of built-in combinators to their constructors.
+var builtins = map[string]struct {
+	min, max int  // bounds on the number of arguments; max < 0 means no limit
+	lit      bool // whether the arguments are literals rather than queries
+	build    func(args []interface{}) Query
+}{
+	"each":      {1, 1, false, func(a []interface{}) Query { return Each(a[0].(Query)) }},
+	"select":    {1, -1, false, func(a []interface{}) Query { return Select(queries(a)...) }},
+	"selectMap": {1, -1, false, func(a []interface{}) Query { return SelectMap(queries(a)...) }},
+	"first":     {1, -1, false, func(a []interface{}) Query { return First(queries(a)...) }},
+	"mapValues": {1, 1, false, func(a []interface{}) Query { return MapValues(a[0].(Query)) }},
+	"mapKeys":   {1, 1, false, func(a []interface{}) Query { return MapKeys(a[0].(Query)) }},
+	"every":     {1, 1, false, func(a []interface{}) Query { return Every(a[0].(Query)) }},
+	"any":       {1, 1, false, func(a []interface{}) Query { return Any(a[0].(Query)) }},
+	"all":       {1, 1, false, func(a []interface{}) Query { return All(a[0].(Query)) }},
+	"none":      {1, 1, false, func(a []interface{}) Query { return None(a[0].(Query)) }},
+	"not":       {1, 1, false, func(a []interface{}) Query { return Not(a[0].(Query)) }},
+	"and":       {0, -1, false, func(a []interface{}) Query { return And(queries(a)...) }},
+	"orBool":    {0, -1, false, func(a []interface{}) Query { return OrBool(queries(a)...) }},
+	"or":        {0, -1, false, func(a []interface{}) Query { return Or(queries(a)) }},
+	"list":      {0, -1, false, func(a []interface{}) Query { return List(queries(a)) }},
+	"cat":       {0, -1, false, func(a []interface{}) Query { return Cat(queries(a)) }},
+	"zip":       {0, -1, false, func(a []interface{}) Query { return Zip(queries(a)...) }},
+	"join":      {2, 4, false, func(a []interface{}) Query { return newJoin(queries(a), false) }},
+	"outerJoin": {2, 4, false, func(a []interface{}) Query { return newJoin(queries(a), true) }},
+	"add":       {0, -1, false, func(a []interface{}) Query { return Add(queries(a)...) }},
+	"sub":       {2, 2, false, func(a []interface{}) Query { return Sub(a[0].(Query), a[1].(Query)) }},
+	"mul":       {0, -1, false, func(a []interface{}) Query { return Mul(queries(a)...) }},
+	"div":       {2, 2, false, func(a []interface{}) Query { return Div(a[0].(Query), a[1].(Query)) }},
+	"mod":       {2, 2, false, func(a []interface{}) Query { return Mod(a[0].(Query), a[1].(Query)) }},
+	"memo":      {1, 1, false, func(a []interface{}) Query { return Memoize(a[0].(Query)) }},
+	"nonNil":    {1, 1, false, func(a []interface{}) Query { return NonNil(a[0].(Query)) }},
+	"descend":   {1, 1, false, func(a []interface{}) Query { return Descend(a[0].(Query)) }},
+	"sortBy":    {1, 1, false, func(a []interface{}) Query { return SortBy(a[0].(Query)) }},
+	"sort":      {1, 1, false, func(a []interface{}) Query { return Sort(a[0].(Query)) }},
+	"groupBy":   {1, 1, false, func(a []interface{}) Query { return GroupBy(a[0].(Query)) }},
+	"count":     {0, 0, false, func(a []interface{}) Query { return Count() }},
+	"flatten":   {1, 1, true, func(a []interface{}) Query { return Flatten(int(number(a[0]))) }},
+	"chunk":     {1, 1, true, func(a []interface{}) Query { return Chunk(int(number(a[0]))) }},
+	"partition": {1, -1, false, func(a []interface{}) Query { return Partition(queries(a)...) }},
+	"keys":      {0, 0, false, func(a []interface{}) Query { return Keys() }},
+	"vals":      {0, 0, false, func(a []interface{}) Query { return Vals() }},
+	"entries":   {0, 0, false, func(a []interface{}) Query { return Entries() }},
+	"toLower":   {0, 0, false, func(a []interface{}) Query { return ToLower() }},
+	"toUpper":   {0, 0, false, func(a []interface{}) Query { return ToUpper() }},
+	"trimSpace": {0, 0, false, func(a []interface{}) Query { return TrimSpace() }},
+	"toInt":     {0, 0, false, func(a []interface{}) Query { return ToInt() }},
+	"toFloat":   {0, 0, false, func(a []interface{}) Query { return ToFloat() }},
+	"toString":  {0, 0, false, func(a []interface{}) Query { return ToString() }},
+	"kindOf":    {0, 0, false, func(a []interface{}) Query { return KindOf() }},
+	"typeName":  {0, 0, false, func(a []interface{}) Query { return TypeName() }},
+	"distinct":  {0, -1, false, func(a []interface{}) Query { return Distinct(queries(a)...) }},
+	"sum":       {1, 1, false, func(a []interface{}) Query { return Sum(a[0].(Query)) }},
+	"min":       {1, 1, false, func(a []interface{}) Query { return Min(a[0].(Query)) }},
+	"max":       {1, 1, false, func(a []interface{}) Query { return Max(a[0].(Query)) }},
+	"mean":      {1, 1, false, func(a []interface{}) Query { return Mean(a[0].(Query)) }},
+	"const":     {1, 1, true, func(a []interface{}) Query { return Const(a[0]) }},
+	"key":       {1, -1, true, func(a []interface{}) Query { return Key(a...) }},
+	"exists":    {1, -1, true, func(a []interface{}) Query { return Exists(a...) }},
+	"contains":  {1, 1, true, func(a []interface{}) Query { return Contains(a[0]) }},
+	"in":        {0, -1, true, func(a []interface{}) Query { return In(a...) }},
+	"between":   {2, 2, true, func(a []interface{}) Query { return Between(a[0], a[1]) }},
+	"eqApprox":  {2, 2, true, func(a []interface{}) Query { return EqApprox(number(a[0]), number(a[1])) }},
+	"pick":      {0, -1, true, func(a []interface{}) Query { return Pick(names(a)...) }},
+	"omit":      {0, -1, true, func(a []interface{}) Query { return Omit(names(a)...) }},
+	"strict":    {1, -1, true, func(a []interface{}) Query { return KeyStrict(a...) }},
+	"keyOr":     {1, -1, true, func(a []interface{}) Query { return KeyOr(a...) }},
+	"json":      {1, -1, true, func(a []interface{}) Query { return TagKey("json", a...) }},
+	"tag":       {2, -1, true, func(a []interface{}) Query { return TagKey(fmt.Sprint(a[0]), a[1:]...) }},
+	"method":    {1, -1, true, func(a []interface{}) Query { return Method(fmt.Sprint(a[0]), a[1:]...) }},
+	"match":     {1, 1, true, func(a []interface{}) Query { return Match(fmt.Sprint(a[0])) }},
+	"capture":   {1, 1, true, func(a []interface{}) Query { return Capture(fmt.Sprint(a[0])) }},
+	"glob":      {1, 1, true, func(a []interface{}) Query { return Glob(fmt.Sprint(a[0])) }},
+	"fail":      {1, 1, true, func(a []interface{}) Query { return Fail(errors.New(fmt.Sprint(a[0]))) }},
+	"split":     {1, 1, true, func(a []interface{}) Query { return Split(fmt.Sprint(a[0])) }},
+	"joinWith":  {1, 1, true, func(a []interface{}) Query { return JoinWith(fmt.Sprint(a[0])) }},
+	"hasPrefix": {1, 1, true, func(a []interface{}) Query { return HasPrefix(fmt.Sprint(a[0])) }},
+	"hasSuffix": {1, 1, true, func(a []interface{}) Query { return HasSuffix(fmt.Sprint(a[0])) }},
+
+	"betweenExclusive": {2, 2, true, func(a []interface{}) Query { return BetweenExclusive(a[0], a[1]) }},
+	"eachLenient":      {1, 1, false, func(a []interface{}) Query { return EachLenient(a[0].(Query)) }},
+	"eachIndexed":      {1, 1, false, func(a []interface{}) Query { return EachIndexed(a[0].(Query)) }},
+	"eachTyped":        {1, 1, false, func(a []interface{}) Query { return EachTyped(a[0].(Query)) }},
+	"selectTyped":      {1, -1, false, func(a []interface{}) Query { return SelectTyped(queries(a)...) }},
+
+	"union":        {0, -1, false, func(a []interface{}) Query { return Union(queries(a)...) }},
+	"unionBy":      {1, -1, false, func(a []interface{}) Query { return UnionBy(a[0].(Query), queries(a[1:])...) }},
+	"intersect":    {0, -1, false, func(a []interface{}) Query { return Intersect(queries(a)...) }},
+	"intersectBy":  {1, -1, false, func(a []interface{}) Query { return IntersectBy(a[0].(Query), queries(a[1:])...) }},
+	"difference":   {0, -1, false, func(a []interface{}) Query { return Difference(queries(a)...) }},
+	"differenceBy": {1, -1, false, func(a []interface{}) Query { return DifferenceBy(a[0].(Query), queries(a[1:])...) }},
+}
+
+func queries(args []interface{}) []Query {
+	qs := make([]Query, len(args))
+	for i, arg := range args {
+		qs[i] = arg.(Query)
+	}
+	return qs
+}
+
+// number returns the value of a numeric literal argument. It panics if arg is
+// not a number; the panic is reported as a parse error.
+func number(arg interface{}) float64 {
+	f, ok := toFloat(arg)
+	if !ok {
+		panic(fmt.Sprintf("argument %s is not a number", formatLiteral(arg)))
+	}
+	return f
+}
+
+func names(args []interface{}) []string {
+	ss := make([]string, len(args))
+	for i, arg := range args {
+		ss[i] = fmt.Sprint(arg)
+	}
+	return ss
+}
+
+type parser struct {
+	toks  []token
+	pos   int
+	funcs *funcBinder
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token and reports true if it is the punctuation
+// text; otherwise it reports false without consuming input.
+func (p *parser) accept(text string) bool {
+	if t := p.peek(); t.kind == tokPunct && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return p.errorf(t, "got %s, want %q", t, text)
+	}
+	return nil
+}
+
+func (p *parser) errorf(t token, msg string, args ...interface{}) error {
+	return fmt.Errorf("offset %d: %s", t.pos, fmt.Sprintf(msg, args...))
+}
+
+// parseExpr parses a path optionally followed by a comparison.
+func (p *parser) parseExpr() (Query, error) {
+	start := p.peek()
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokPunct {
+		if cmp, ok := comparisons[t.text]; ok {
+			p.next()
+			lit, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, cmp(lit))
+		}
+	}
+	switch len(path) {
+	case 0:
+		return nil, p.errorf(start, "missing query before %s", start)
+	case 1:
+		return path[0], nil
+	}
+	return path, nil
+}
+
+var comparisons = map[string]func(interface{}) Query{
+	"==": Eq, "<": Lt, "<=": Le, ">": Gt, ">=": Ge,
+	"!=": func(lit interface{}) Query { return Not(Eq(lit)) },
+}
+
+// parsePath parses a possibly-empty sequence of steps.
+func (p *parser) parsePath() (Seq, error) {
+	var path Seq
+	p.accept(".") // a leading period is optional
+	for {
+		t := p.peek()
+		if t.kind == tokPunct && t.text == "[" {
+			step, err := p.parseIndex()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, step)
+			continue
+		} else if len(path) != 0 {
+			if !p.accept(".") {
+				return path, nil
+			}
+		} else if !startsStep(t) {
+			return path, nil
+		}
+		step, err := p.parseStep()
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, step)
+	}
+}
+
+func startsStep(t token) bool {
+	switch t.kind {
+	case tokIdent, tokString, tokInt, tokFloat:
+		return true
+	case tokPunct:
+		return t.text == "(" || t.text == "{" || t.text == "@" || t.text == "$"
+	}
+	return false
+}
+
+// parseIndex parses an index "[n]" or a range "[lo:hi]", either of whose
+// bounds may be omitted.
+func (p *parser) parseIndex() (Query, error) {
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	lo, hasLo, err := p.parseOffset()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept(":") {
+		if !hasLo {
+			t := p.peek()
+			return nil, p.errorf(t, "got %s, want integer index", t)
+		} else if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return Index(lo), nil
+	}
+	hi, hasHi, err := p.parseOffset()
+	if err != nil {
+		return nil, err
+	} else if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	if !hasHi {
+		return rangeQuery{lo: lo, toEnd: true}, nil
+	}
+	return Range(lo, hi), nil
+}
+
+// parseOffset parses an optional integer offset, and reports whether one was
+// present.
+func (p *parser) parseOffset() (int, bool, error) {
+	t := p.peek()
+	if t.kind != tokInt {
+		return 0, false, nil
+	}
+	p.next()
+	n, err := strconv.Atoi(t.text)
+	if err != nil {
+		return 0, false, p.errorf(t, "invalid index: %v", err)
+	}
+	return n, true, nil
+}
+
+// parseStep parses a single step of a path.
+func (p *parser) parseStep() (Query, error) {
+	t := p.next()
+	switch t.kind {
+	case tokIdent:
+		if t.text == "self" {
+			return Self, nil
+		} else if t.text == "switch" && p.accept("(") {
+			return p.parseSwitch()
+		} else if t.text == "format" && p.accept("(") {
+			return p.parseFormat()
+		} else if (t.text == "default" || t.text == "defaultErr") && p.accept("(") {
+			return p.parseDefault(t.text == "defaultErr")
+		} else if t.text == "require" && p.accept("(") {
+			return p.parseRequire()
+		} else if b, ok := builtins[t.text]; ok {
+			// A combinator name is a call if it is followed by arguments.
+			if next := p.peek(); (next.kind == tokPunct && next.text == "(") ||
+				(!b.lit && b.min == 1 && startsStep(next)) {
+				return p.parseBuiltin(t)
+			}
+		}
+		return keyQuery{key: t.text}, nil
+
+	case tokString, tokInt, tokFloat:
+		lit, err := t.literal()
+		if err != nil {
+			return nil, p.errorf(t, "%v", err)
+		}
+		return keyQuery{key: lit}, nil
+
+	case tokPunct:
+		switch t.text {
+		case "(":
+			q, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			} else if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return q, nil
+
+		case "{":
+			return p.parseMap()
+
+		case "@":
+			name := p.next()
+			if name.kind != tokIdent {
+				return nil, p.errorf(name, "got %s, want function name", name)
+			}
+			q, err := p.funcs.bind(name.text)
+			if err != nil {
+				return nil, p.errorf(name, "%v", err)
+			}
+			return q, nil
+
+		case "$":
+			name, err := p.parseParam()
+			if err != nil {
+				return nil, err
+			}
+			return name, nil
+		}
+	}
+	return nil, p.errorf(t, "unexpected %s", t)
+}
+
+// parseBuiltin parses the arguments of the built-in combinator named by t.
+func (p *parser) parseBuiltin(t token) (Query, error) {
+	b := builtins[t.text]
+	if !p.accept("(") {
+		// A combinator taking a single query may be applied to one step.
+		arg, err := p.parseStep()
+		if err != nil {
+			return nil, err
+		}
+		return p.build(t, []interface{}{arg})
+	}
+
+	var args []interface{}
+	for !p.accept(")") {
+		if len(args) != 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		var arg interface{}
+		var err error
+		if b.lit {
+			arg, err = p.parseLiteral()
+		} else {
+			arg, err = p.parseExpr()
+		}
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if len(args) < b.min || (b.max >= 0 && len(args) > b.max) {
+		return nil, p.errorf(t, "wrong number of arguments to %s (%d)", t.text, len(args))
+	}
+	return p.build(t, args)
+}
+
+// build constructs the combinator named by t from args. A constructor that
+// rejects its arguments, as Match does for an invalid pattern, panics; the
+// panic is reported as an error at t.
+func (p *parser) build(t token, args []interface{}) (q Query, err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = p.errorf(t, "%v", x)
+		}
+	}()
+	return builtins[t.text].build(args), nil
+}
+
+// parseSwitch parses the discriminator and cases of a switch. The opening
+// parenthesis has already been consumed.
+func (p *parser) parseSwitch() (Query, error) {
+	on, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	s := Switch{On: on, Cases: make(map[interface{}]Query)}
+	for !p.accept(")") {
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		t := p.peek()
+		isDefault := t.kind == tokIdent && t.text == "default"
+		var key interface{}
+		if isDefault {
+			p.next()
+			if s.Default != nil {
+				return nil, p.errorf(t, "duplicate default case")
+			}
+		} else if key, err = p.parseLiteral(); err != nil {
+			return nil, err
+		} else if _, ok := key.(paramQuery); ok {
+			return nil, p.errorf(t, "case label must be a constant")
+		} else if _, ok := s.Cases[key]; ok {
+			return nil, p.errorf(t, "duplicate case %s", formatLiteral(key))
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		q, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if isDefault {
+			s.Default = q
+		} else {
+			s.Cases[key] = q
+		}
+	}
+	return s, nil
+}
+
+// parseFormat parses the format string and arguments of a format call. The
+// opening parenthesis has already been consumed.
+func (p *parser) parseFormat() (Query, error) {
+	t := p.next()
+	if t.kind != tokString {
+		return nil, p.errorf(t, "got %s, want format string", t)
+	}
+	lit, err := t.literal()
+	if err != nil {
+		return nil, p.errorf(t, "%v", err)
+	}
+	var args []Query
+	for !p.accept(")") {
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return Format(lit.(string), args...), nil
+}
+
+// parseDefault parses the query and fallback value of a default or defaultErr
+// call. The opening parenthesis has already been consumed.
+func (p *parser) parseDefault(onErr bool) (Query, error) {
+	q, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	} else if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	lit, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	} else if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return defaultQuery{q: q, fallback: lit, onErr: onErr}, nil
+}
+
+// parseRequire parses the query and message of a require call. The opening
+// parenthesis has already been consumed.
+func (p *parser) parseRequire() (Query, error) {
+	q, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	} else if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	t := p.next()
+	if t.kind != tokString {
+		return nil, p.errorf(t, "got %s, want message string", t)
+	}
+	msg, err := t.literal()
+	if err != nil {
+		return nil, p.errorf(t, "%v", err)
+	} else if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return Require(q, msg.(string)), nil
+}
+
+// parseMap parses a map of named subqueries. The opening brace has already
+// been consumed.
+func (p *parser) parseMap() (Query, error) {
+	m := make(Map)
+	for !p.accept("}") {
+		if len(m) != 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		t := p.next()
+		var name string
+		switch t.kind {
+		case tokIdent:
+			name = t.text
+		case tokString:
+			lit, err := t.literal()
+			if err != nil {
+				return nil, p.errorf(t, "%v", err)
+			}
+			name = lit.(string)
+		default:
+			return nil, p.errorf(t, "got %s, want field name", t)
+		}
+		if _, ok := m[name]; ok {
+			return nil, p.errorf(t, "duplicate field name %q", name)
+		} else if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		q, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		m[name] = q
+	}
+	return m, nil
+}
+
+// parseLiteral parses a constant literal value.
+func (p *parser) parseLiteral() (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString, tokInt, tokFloat:
+		lit, err := t.literal()
+		if err != nil {
+			return nil, p.errorf(t, "%v", err)
+		}
+		return lit, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "nil":
+			return nil, nil
+		}
+	case tokPunct:
+		if t.text == "$" {
+			return p.parseParam()
+		}
+	}
+	return nil, p.errorf(t, "got %s, want literal", t)
+}
+
+// parseParam parses the name of a parameter following "$".
+func (p *parser) parseParam() (paramQuery, error) {
+	name := p.next()
+	if name.kind != tokIdent {
+		return "", p.errorf(name, "got %s, want parameter name", name)
+	}
+	return paramQuery(name.text), nil
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokInt
+	tokFloat
+	tokPunct
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int // byte offset in the input
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of input"
+	}
+	return strconv.Quote(t.text)
+}
+
+// literal returns the constant value denoted by a string or number token.
+func (t token) literal() (interface{}, error) {
+	switch t.kind {
+	case tokString:
+		return strconv.Unquote(t.text)
+	case tokInt:
+		return strconv.Atoi(t.text)
+	case tokFloat:
+		return strconv.ParseFloat(t.text, 64)
+	}
+	return nil, fmt.Errorf("%s is not a literal", t)
+}
+
+// punctuation lists the punctuation tokens, longest first.
+var punctuation = []string{
+	"==", "!=", "<=", ">=", "<", ">",
+	".", "[", "]", "(", ")", "{", "}", ",", ":", "@", "$",
+}
+
+// lex splits s into tokens. The result always ends with a tokEOF token.
+func lex(s string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(s) && (isIdentStart(s[j]) || isDigit(s[j])) {
+				j++
+			}
+			toks = append(toks, token{kind: tokIdent, text: s[i:j], pos: i})
+			i = j
+			continue
+
+		case isDigit(c) || (c == '-' && i+1 < len(s) && isDigit(s[i+1])):
+			j := i + 1
+			for j < len(s) && isDigit(s[j]) {
+				j++
+			}
+			kind := tokInt
+			if j+1 < len(s) && s[j] == '.' && isDigit(s[j+1]) {
+				kind = tokFloat
+				for j++; j < len(s) && isDigit(s[j]); j++ {
+				}
+			}
+			toks = append(toks, token{kind: kind, text: s[i:j], pos: i})
+			i = j
+			continue
+
+		case c == '"' || c == '`':
+			j := i + 1
+			for j < len(s) && s[j] != c {
+				if s[j] == '\\' && c == '"' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("offset %d: unterminated string", i)
+			}
+			toks = append(toks, token{kind: tokString, text: s[i : j+1], pos: i})
+			i = j + 1
+			continue
+		}
+		ok := false
+		for _, p := range punctuation {
+			if strings.HasPrefix(s[i:], p) {
+				toks = append(toks, token{kind: tokPunct, text: p, pos: i})
+				i += len(p)
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("offset %d: unexpected character %q", i, c)
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(s)}), nil
+}
+
+func isIdentStart(c byte) bool { return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') }
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
@@ -0,0 +1,434 @@
+package vql_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/vql"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestParse(t *testing.T) {
+	type person struct {
+		Name  string
+		Title string
+		Age   int
+		Tags  map[string]string
+	}
+	input := struct {
+		Name   string
+		People []*person
+		Codes  map[int]string
+	}{
+		Name: "Stuff, Inc.",
+		People: []*person{
+			{Name: "Alice", Title: "CEO", Age: 35, Tags: map[string]string{"each": "x"}},
+			{Name: "Bob", Title: "MGR", Age: 38},
+			{Name: "Carol", Title: "MGR", Age: 19},
+		},
+		Codes: map[int]string{-1: "neg", 12: "twelve"},
+	}
+
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{``, input},
+		{`self`, input},
+		{`Name`, "Stuff, Inc."},
+		{`.Name`, "Stuff, Inc."},
+		{`"Name"`, "Stuff, Inc."},
+		{`Codes.12`, "twelve"},
+		{`Codes.-1`, "neg"},
+		{`Codes.key(12)`, "twelve"},
+		{`People[0].Name`, "Alice"},
+		{`People[-1].Name`, "Carol"},
+		{`People[1].(Name)`, "Bob"},
+		{`People[1:].each Name`, []interface{}{"Bob", "Carol"}},
+		{`People[:2].each Name`, []interface{}{"Alice", "Bob"}},
+		{`People[-2:-1].each Name`, []interface{}{"Bob"}},
+		{`People[:].count()`, 3},
+		{`People[0].Tags."each"`, "x"},
+		{`People[0].Tags.each`, "x"},
+		{`People.count()`, 3},
+		{`People.sum(Age)`, 92},
+		{`People.each Name`, []interface{}{"Alice", "Bob", "Carol"}},
+		{`People.each(Age)`, []interface{}{35, 38, 19}},
+		{`People.select(Title == "CEO").each Name`, []interface{}{"Alice"}},
+		{`People.select(Title, == "MGR").each(Name)`, []interface{}{"Bob", "Carol"}},
+		{"People.select(Title==`MGR`).each Name", []interface{}{"Bob", "Carol"}},
+		{`People.each(Age).select(< 30)`, []interface{}{19}},
+		{`People.each(Age).select(self > 30)`, []interface{}{35, 38}},
+		{`People.every(Age > 18)`, true},
+		{`People.any(Age > 40)`, false},
+		{`People.all(Age > 18)`, true},
+		{`People.none(Age > 40)`, true},
+		{`People.select(Title.in("CEO", "CTO")).each Name`, []interface{}{"Alice"}},
+		{`People[0].Tags.contains("each")`, true},
+		{`People.select(Name.match("^[AB]")).count()`, 2},
+		{`People.select(Name.glob("*o*")).each Name`, []interface{}{"Bob", "Carol"}},
+		{`People[2].Name.capture("(r)(o)")`, []interface{}{"ro", "r", "o"}},
+		{`People[0].Age <= 35`, true},
+		{`People.select(Tags.exists("each")).count()`, 1},
+		{`People[0].Tags.keys()`, []interface{}{"each"}},
+		{`Codes.vals()`, []interface{}{"neg", "twelve"}},
+		{`People[0].keyOr("Nick", "Name")`, "Alice"},
+		{`People[1].pick("Name", "Age")`, vql.Values{"Name": "Bob", "Age": 38}},
+		{`People.select(Title != "MGR").each Name`, []interface{}{"Alice"}},
+		{`People.select(and(Age > 20, Title == "MGR")).each Name`, []interface{}{"Bob"}},
+		{`People.select(orBool(Age < 20, Title == "CEO")).each Name`, []interface{}{"Alice", "Carol"}},
+		{`People.select(not(Age < 30)).count()`, 2},
+		{`People[0].Age == 35.0`, true}, // numeric conversion
+		{`People[0].Age < 35.5`, true},
+		{`People.select(Age.between(20, 36)).each Name`, []interface{}{"Alice"}},
+		{`People[0].Age.eqApprox(34, 1.5)`, true},
+		{`People[0].mul(Age, const(2))`, 70},
+		{`People[1].Age.toString()`, "38"},
+		{`People[0].default(Nope, "none")`, "none"},
+		{`cat(People, list(Name)).eachLenient(Age)`, []interface{}{35, 38, 19}},
+		{`People.eachIndexed(list(Key, Value.Name))[2]`, []interface{}{2, "Carol"}},
+		{`People.eachIndexed(Key == 1)`, []interface{}{false, true, false}},
+		{`People[0].or(Nope, Name, fail("no name"))`, "Alice"},
+		{`People[0].require(Age > 30, "too young").nonNil(Name)`, "Alice"},
+		{`People.each defaultErr([5], 0)`, []interface{}{0, 0, 0}},
+		{`switch(Name, default: default(Nope, 1))`, 1},
+		{`People.kindOf()`, "slice"},
+		{`People[0].Name.typeName()`, "string"},
+		{`People.each(Age.toFloat()).sum(self)`, 92.0},
+		{`People[1].format("%s (%d years)", Name, Age)`, "Bob (38 years)"},
+		{`People.each(Name.toUpper()).joinWith("+")`, "ALICE+BOB+CAROL"},
+		{`People.select(Name.hasPrefix("C")).each(Name.toLower().split("r"))`, []interface{}{[]interface{}{"ca", "ol"}}},
+		{`People.each(div(Age, const(10)))`, []interface{}{3, 3, 1}},
+		{`add(People[0].Age, People[1].Age, const(0.5))`, 73.5},
+		{`People.select(Age.betweenExclusive(19, 38)).count()`, 1},
+		{`Missing == nil`, true},
+		{`People[2].or(Nope, Title, Name)`, "MGR"},
+		{`People.first(Title == "MGR").Name`, "Bob"},
+		{`People.each switch(Title, "CEO": Age, default: Name)`, []interface{}{35, "Bob", "Carol"}},
+		{`list(Name, People[1].Name)`, []interface{}{"Stuff, Inc.", "Bob"}},
+		{`cat(Name, People.each Name)`, []interface{}{"Stuff, Inc.", "Alice", "Bob", "Carol"}},
+		{`const(true)`, true},
+		{`const("x")`, "x"},
+		{`memo(Name)`, "Stuff, Inc."},
+		{`descend(Age)`, []interface{}{35, 38, 19}},
+		{`People[1].{who: Name, "how old": Age}`, vql.Values{"who": "Bob", "how old": 38}},
+		{`People[0].@upper`, "ALICE"},
+	}
+	fns := map[string]interface{}{
+		"upper": func(p *person) string { return strings.ToUpper(p.Name) },
+	}
+	for _, test := range tests {
+		q, err := vql.Parse(test.input)
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error: %v", test.input, err)
+			continue
+		}
+		got, err := vql.EvalWithFuncs(q, input, fns)
+		if err != nil {
+			t.Errorf("Eval(%q): unexpected error: %v", test.input, err)
+		} else if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("Eval(%q): (-want, +got)\n%s", test.input, diff)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		`.`,
+		`A.`,
+		`A..B`,
+		`A B`,
+		`[x]`,
+		`[1`,
+		`[:`,
+		`[1:x]`,
+		`[]`,
+		`(A`,
+		`A)`,
+		`"unterminated`,
+		`A.#`,
+		`each()`,
+		`each(A, B)`,
+		`const(A)`,
+		`key()`,
+		`A == B`,
+		`== `,
+		`{a: A, a: B}`,
+		`{a A}`,
+		`@`,
+		`@"x"`,
+		`$`,
+		`match("(")`,
+		`glob("[")`,
+		`eqApprox("x", 1)`,
+		`capture()`,
+		`$"x"`,
+		`A > $1`,
+		`switch()`,
+		`switch(A, B)`,
+		`switch(A, 1: B, 1: C)`,
+		`switch(A, $x: B)`,
+		`switch(A, default: B, default: C)`,
+		`switch(A, "x" B)`,
+		`format(A)`,
+		`default(A)`,
+		`require(A)`,
+		`fail()`,
+		`require(A, B)`,
+		`default(A, B)`,
+		`defaultErr(A, 1`,
+		`format("x" A)`,
+	}
+	for _, test := range tests {
+		q, err := vql.Parse(test)
+		if err == nil {
+			t.Errorf("Parse(%q): got %v, want error", test, q)
+		} else {
+			t.Logf("Parse(%q): got expected error: %v", test, err)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	input := map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": []interface{}{
+				0, 1, map[string]interface{}{"c": "ok"}, [][]int{{1, 2}, {3, 4}},
+			},
+		},
+		"x.y": "dotted",
+		"x":   map[string]int{},
+	}
+	tests := []struct {
+		path string
+		want interface{}
+	}{
+		{"", input},
+		{"a.b[2].c", "ok"},
+		{"a.b[-1][1][0]", 3},
+		{"a.b[1]", 1},
+		{"a.nonesuch", nil},
+		{"x.y", nil}, // keys are split at periods
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(vql.Path(test.path), input)
+		if err != nil {
+			t.Errorf("Path(%q): unexpected error: %v", test.path, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Path(%q): (-want, +got)\n%s", test.path, diff)
+		}
+	}
+
+	for _, bad := range []string{".", "a.", "a..b", "a[", "a[x]", "a[1", "a[1]b", "a]", "[]"} {
+		func() {
+			defer func() {
+				if x := recover(); x != nil {
+					t.Logf("Path(%q): got expected panic: %v", bad, x)
+				}
+			}()
+			q := vql.Path(bad)
+			t.Errorf("Path(%q): got %v, want panic", bad, q)
+		}()
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		query vql.Query
+		want  string
+	}{
+		{vql.Self, `self`},
+		{vql.Seq{}, `self`},
+		{vql.Key("People"), `People`},
+		{vql.Key("People", 2, "Name"), `People.2.Name`},
+		{vql.Key("two words", "self", 1.5), `"two words"."self".1.5`},
+		{vql.Seq{vql.Key("People"), vql.Index(-1), vql.Key("Name")}, `People[-1].Name`},
+		{vql.Seq{vql.Index(0), vql.Range(1, 3), vql.Skip(2), vql.Take(4)}, `[0][1:3][2:][:4]`},
+		{vql.Seq{vql.Key("Age"), vql.Ge(21)}, `Age >= 21`},
+		{vql.Seq{vql.Key("Name"), vql.Not(vql.Eq("x"))}, `Name != "x"`},
+		{vql.Eq(2.0), `== 2.0`},
+		{vql.Seq{vql.Lt(3), vql.Not(vql.Self)}, `(< 3).not(self)`},
+		{vql.Seq{
+			vql.Key("People"),
+			vql.Select(vql.Key("Age"), vql.Gt(35)),
+			vql.Each(vql.Key("Name")),
+		}, `People.select(Age > 35).each(Name)`},
+		{vql.And(vql.Every(vql.Self), vql.Any(vql.Self)), `and(every(self), any(self))`},
+		{vql.OrBool(), `orBool()`},
+		{vql.None(vql.Eq(1)), `none(== 1)`},
+		{vql.List{vql.Contains("x"), vql.In(1, nil), vql.In()}, `list(contains("x"), in(1, nil), in())`},
+		{vql.Glob("*.go"), `glob("*.go")`},
+		{vql.EqApprox(1.5, 0.01), `eqApprox(1.5, 0.01)`},
+		{vql.Format("%v: %q", vql.Key("A"), vql.Self), `format("%v: %q", A, self)`},
+		{vql.List{vql.ToLower(), vql.ToUpper(), vql.TrimSpace(), vql.Split("")}, `list(toLower(), toUpper(), trimSpace(), split(""))`},
+		{vql.List{vql.ToInt(), vql.ToFloat(), vql.ToString()}, `list(toInt(), toFloat(), toString())`},
+		{vql.List{vql.KindOf(), vql.TypeName()}, `list(kindOf(), typeName())`},
+		{vql.List{vql.JoinWith(","), vql.HasPrefix("a"), vql.HasSuffix("b")}, `list(joinWith(","), hasPrefix("a"), hasSuffix("b"))`},
+		{vql.Sub(vql.Add(vql.Key("A"), vql.Const(1)), vql.Mod(vql.Mul(), vql.Div(vql.Key("B"), vql.Key("C")))),
+			`sub(add(A, const(1)), mod(mul(), div(B, C)))`},
+		{vql.List{vql.Between(1, 2.5), vql.BetweenExclusive("a", "b")}, `list(between(1, 2.5), betweenExclusive("a", "b"))`},
+		{vql.List{vql.Match(`^a\.b$`), vql.Capture(`"(.)"`)}, `list(match("^a\\.b$"), capture("\"(.)\""))`},
+		{vql.Map{"who": vql.Key("Name"), "how old": vql.Key("Age")}, `{"how old": Age, who: Name}`},
+		{vql.Or{vql.Key("A"), vql.Const(nil)}, `or(A, const(nil))`},
+		{vql.List{vql.Const(true), vql.Const("s")}, `list(const(true), const("s"))`},
+		{vql.Cat{vql.Memoize(vql.Descend(vql.Key("X")))}, `cat(memo(descend(X)))`},
+		{vql.Seq{vql.Key("S"), vql.SortBy(vql.Self), vql.GroupBy(vql.Key("K"))}, `S.sortBy(self).groupBy(K)`},
+		{vql.Distinct(), `distinct()`},
+		{vql.Distinct(vql.Key("A"), vql.Key("B")), `distinct(A, B)`},
+		{vql.List{vql.Count(), vql.Sum(vql.Self), vql.Min(vql.Self), vql.Max(vql.Self), vql.Mean(vql.Self)},
+			`list(count(), sum(self), min(self), max(self), mean(self))`},
+		{vql.List{vql.Keys(), vql.Vals(), vql.Entries()}, `list(keys(), vals(), entries())`},
+		{vql.KeyStrict("a", 1), `strict("a").strict(1)`},
+		{vql.KeyOr("a", "b"), `keyOr("a", "b")`},
+		{vql.TagKey("json", "a", "b"), `json("a").json("b")`},
+		{vql.TagKey("yaml", "a"), `tag("yaml", "a")`},
+		{vql.Exists("a", 2), `exists("a", 2)`},
+		{vql.Pick("a", "b"), `pick("a", "b")`},
+		{vql.Omit("c"), `omit("c")`},
+		{vql.Method("Label", "#", 3), `method("Label", "#", 3)`},
+		{vql.Seq{vql.Key("A"), vql.FuncRef("f")}, `A.@f`},
+		{vql.Func(strings.ToUpper), `func(string) string`},
+		{vql.Select(vql.TypeIs[fmt.Stringer]()), `select(typeIs(fmt.Stringer))`},
+		{vql.AsType(reflect.TypeOf(0)), `asType(int)`},
+		{vql.List{vql.Default(vql.Key("A"), 1), vql.DefaultErr(vql.Index(0), nil)}, `list(default(A, 1), defaultErr([0], nil))`},
+		{vql.DefaultErr(vql.Key("A"), "x", vql.ErrNoKey), `defaultErr(A, "x", "key not found")`},
+		{vql.Require(vql.NonNil(vql.Key("A")), "need A"), `require(nonNil(A), "need A")`},
+		{vql.Or{vql.Key("A"), vql.Failf("no %q", "A")}, `or(A, fail("no \"A\""))`},
+		{vql.EachLenient(vql.Key("A")), `eachLenient(A)`},
+		{vql.EachIndexed(vql.Key("Value")), `eachIndexed(Value)`},
+		{vql.EachTyped(vql.Key("A")), `eachTyped(A)`},
+		{vql.Flatten(-1), `flatten(-1)`},
+		{vql.Chunk(3), `chunk(3)`},
+		{vql.Zip(vql.Key("A"), vql.Key("B")), `zip(A, B)`},
+		{vql.Union(vql.Key("A"), vql.Key("B")), `union(A, B)`},
+		{vql.DifferenceBy(vql.Key("ID"), vql.Key("A"), vql.Key("B")), `differenceBy(ID, A, B)`},
+		{vql.Join{Left: vql.Key("A"), Right: vql.Key("B")}, `join(A, B)`},
+		{vql.Join{Left: vql.Key("A"), Right: vql.Key("B"), RightKey: vql.Key("ID"), Outer: true}, `outerJoin(A, B, self, ID)`},
+		{vql.Partition(vql.Key("A"), vql.Eq(1)), `partition(A, == 1)`},
+		{vql.SelectTyped(vql.Key("A")), `selectTyped(A)`},
+		{vql.EachN(vql.Key("A"), 4), `eachN(A, 4)`},
+		{vql.Set(vql.Key("A"), 1), `set(A)`},
+		{vql.Let("x", vql.Key("A"), vql.Var("x")), `let("x", A, var("x"))`},
+		{vql.Build(map[string]interface{}{"a": []interface{}{vql.Key("X"), 1}}), `build({"a": [X, 1]})`},
+		{vql.First(vql.Key("A"), vql.Eq(1)), `first(A == 1)`},
+		{vql.Param("p"), `$p`},
+		{vql.Switch{On: vql.Key("K"), Cases: map[interface{}]vql.Query{"a": vql.Key("A"), 1: vql.Self}},
+			`switch(K, "a": A, 1: self)`},
+		{vql.Switch{On: vql.Self, Cases: map[interface{}]vql.Query{nil: vql.Const(0)}, Default: vql.Key("B")},
+			`switch(self, nil: const(0), default: B)`},
+		{vql.Seq{vql.Key("Age"), vql.Ge(vql.Param("min"))}, `Age >= $min`},
+		{vql.Seq{vql.Key("A"), vql.Key(vql.Param("k"))}, `A.key($k)`},
+	}
+	for _, test := range tests {
+		got := fmt.Sprint(test.query)
+		if got != test.want {
+			t.Errorf("String: got %s, want %s", got, test.want)
+			continue
+		}
+
+		// Queries expressible in the text syntax should round-trip.
+		q, err := vql.Parse(got)
+		if err != nil {
+			t.Logf("Parse(%q): %v", got, err)
+		} else if s := fmt.Sprint(q); s != got {
+			t.Errorf("Parse(%q): got %s", got, s)
+		}
+	}
+}
+
+func TestParseFuncs(t *testing.T) {
+	vql.RegisterFunc("testDouble", func(n int) int { return 2 * n })
+
+	var reg vql.FuncRegistry
+	if err := reg.Register("shout", strings.ToUpper); err != nil {
+		t.Fatalf("Register: unexpected error: %v", err)
+	}
+	for _, bad := range []struct {
+		name string
+		fn   interface{}
+	}{
+		{"shout", strings.ToLower},    // duplicate name
+		{"bad name", strings.ToLower}, // invalid name
+		{"split", strings.Split},      // invalid signature
+		{"notFunc", "not a function"}, // not a function
+	} {
+		if err := reg.Register(bad.name, bad.fn); err == nil {
+			t.Errorf("Register(%q): got nil error, want error", bad.name)
+		}
+	}
+	if diff := cmp.Diff([]string{"shout"}, reg.Names()); diff != "" {
+		t.Errorf("Names: (-want, +got)\n%s", diff)
+	}
+
+	tests := []struct {
+		query string
+		input interface{}
+		want  interface{}
+	}{
+		{`Name.@shout`, map[string]string{"Name": "alice"}, "ALICE"},
+		{`each(@testDouble)`, []int{1, 2}, []interface{}{2, 4}},
+	}
+	for _, test := range tests {
+		q, err := vql.ParseFuncs(test.query, &reg)
+		if err != nil {
+			t.Errorf("ParseFuncs(%q): unexpected error: %v", test.query, err)
+			continue
+		}
+		if got := fmt.Sprint(q); got != test.query {
+			t.Errorf("ParseFuncs(%q): formats as %q", test.query, got)
+		}
+		got, err := vql.Eval(q, test.input)
+		if err != nil {
+			t.Errorf("Eval(%q): unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval(%q): (-want, +got)\n%s", test.query, diff)
+		}
+	}
+
+	// Unknown names and names registered both locally and globally are
+	// reported when parsing.
+	var dup vql.FuncRegistry
+	dup.Register("testDouble", func(n int) int { return n + n })
+	for _, bad := range []struct {
+		query string
+		reg   *vql.FuncRegistry
+	}{
+		{`@nonesuch`, &reg},
+		{`@shout`, nil},
+		{`@testDouble`, &dup},
+	} {
+		if q, err := vql.ParseFuncs(bad.query, bad.reg); err == nil {
+			t.Errorf("ParseFuncs(%q): got %v, want error", bad.query, q)
+		}
+	}
+
+	// Parse leaves references for evaluation, where the functions given to
+	// EvalWithFuncs are used instead of global functions.
+	q := vql.MustParse(`list(@testDouble, @triple)`)
+	if got := fmt.Sprint(q); got != `list(@testDouble, @triple)` {
+		t.Errorf("Parse: formats as %q", got)
+	}
+	got, err := vql.EvalWithFuncs(q, 5, map[string]interface{}{
+		"testDouble": func(n int) int { return n + 1 },
+		"triple":     func(n int) int { return 3 * n },
+	})
+	if err != nil {
+		t.Fatalf("EvalWithFuncs: unexpected error: %v", err)
+	} else if diff := cmp.Diff([]interface{}{6, 15}, got); diff != "" {
+		t.Errorf("EvalWithFuncs: (-want, +got)\n%s", diff)
+	}
+	got, err = vql.EvalWithFuncs(q, 5, map[string]interface{}{
+		"triple": func(n int) int { return 3 * n },
+	})
+	if err == nil {
+		t.Errorf("EvalWithFuncs: got %v, want error for a global function", got)
+	}
+
+	// Unbound references fall back to global functions at evaluation.
+	if got, err := vql.Eval(vql.FuncRef("testDouble"), 4); err != nil || got != 8 {
+		t.Errorf("Eval(FuncRef): got (%v, %v), want (8, nil)", got, err)
+	}
+}
@@ -0,0 +1,220 @@
+package vql
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// A PathValue is a value produced by a query, together with its location in
+// the input from which it was produced.
+type PathValue struct {
+	// Path is the sequence of Key, Index, and similar steps leading from the
+	// root of the input to the value. Evaluating Path on the input yields
+	// Value again, provided the input has not changed.
+	Path Seq
+
+	Value interface{}
+}
+
+// EvalPaths evaluates q starting from v, as EvalWith, and reports the location
+// in v of each result. If the value of q is a slice of elements selected from
+// v, such as the result of Each, Select, Range, or Descend, there is one
+// PathValue for each element; otherwise there is a single PathValue for the
+// value of q. An empty path denotes v itself.
+//
+// The location of a value computed from its input, for example by Func or
+// Const, is that of its input. The location of a map entry is that of its
+// value.
+func EvalPaths(q Query, v interface{}, opts ...Option) ([]PathValue, error) {
+	e := &env{ctx: context.Background(), paths: true}
+	for _, opt := range opts {
+		opt.apply(e)
+	}
+	res, err := evalValue(q, v, e)
+	if err != nil {
+		return nil, err
+	}
+	vs := reflect.ValueOf(res.val)
+	if vs.Kind() != reflect.Slice || res.elems == nil {
+		return []PathValue{{Path: res.path.seq(), Value: res.val}}, nil
+	}
+	out := make([]PathValue, vs.Len())
+	for i := range out {
+		out[i] = PathValue{Path: res.elems[i].seq(), Value: vs.Index(i).Interface()}
+	}
+	return out, nil
+}
+
+// EvalValue evaluates q starting from the value v, as EvalWith, for callers
+// that work with values through reflection. An invalid v is treated as nil,
+// and an invalid result denotes a nil value of q.
+//
+// If the value of q is a field or element of v, reached by Key and Index
+// steps through structs, arrays, slices, and pointers, the result refers to
+// that location in v and has its declared type. It is addressable if the
+// location is, for example when v is a pointer to a struct, so the caller can
+// modify it in place. Otherwise, the result holds a copy of the value of q.
+func EvalValue(q Query, v reflect.Value, opts ...Option) (reflect.Value, error) {
+	var obj interface{}
+	if v.IsValid() {
+		if !v.CanInterface() {
+			return reflect.Value{}, fmt.Errorf("value of type %v cannot be used without unexported access", v.Type())
+		}
+		obj = v.Interface()
+	}
+	e := &env{ctx: context.Background(), paths: true}
+	for _, opt := range opts {
+		opt.apply(e)
+	}
+	res, err := evalValue(q, obj, e)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if loc, ok := locate(v, res.path.seq()); ok && sameValue(loc, res.val) {
+		return loc, nil
+	}
+	return reflect.ValueOf(res.val), nil
+}
+
+// locate follows the Key and Index steps of path from rv, and returns the
+// location they reach. It reports false if path has any other kind of step,
+// or passes through a map, or reaches a location that does not exist.
+func locate(rv reflect.Value, path Seq) (reflect.Value, bool) {
+	for _, step := range path {
+		rv = indirect(rv)
+		switch t := step.(type) {
+		case keyQuery:
+			if name, ok := t.key.(string); ok && rv.Kind() == reflect.Struct {
+				f, ok := fieldByName(rv.Type(), name)
+				if !ok {
+					return reflect.Value{}, false
+				}
+				fv, err := rv.FieldByIndexErr(f.Index)
+				if err != nil {
+					return reflect.Value{}, false
+				}
+				rv = fv
+				continue
+			}
+			kv := reflect.ValueOf(t.key)
+			if !isIntLike(kv.Kind()) && !isUintLike(kv.Kind()) {
+				return reflect.Value{}, false
+			}
+			i := int(toInt64(kv))
+			if k := rv.Kind(); (k != reflect.Array && k != reflect.Slice) || i < 0 || i >= rv.Len() {
+				return reflect.Value{}, false
+			}
+			rv = rv.Index(i)
+		case indexQuery:
+			if k := rv.Kind(); (k != reflect.Array && k != reflect.Slice) || int(t) < 0 || int(t) >= rv.Len() {
+				return reflect.Value{}, false
+			}
+			rv = rv.Index(int(t))
+		default:
+			return reflect.Value{}, false
+		}
+	}
+	return rv, rv.IsValid() && rv.CanInterface()
+}
+
+// sameValue reports whether the location rv holds obj itself, rather than a
+// value computed from it. Slices, maps, and pointers must refer to the same
+// storage as obj, not merely have equal contents.
+func sameValue(rv reflect.Value, obj interface{}) bool {
+	if rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() || obj == nil {
+		return !rv.IsValid() && obj == nil
+	}
+	ov := reflect.ValueOf(obj)
+	if rv.Type() != ov.Type() {
+		return false
+	}
+	switch rv.Kind() {
+	case reflect.Slice:
+		return rv.Pointer() == ov.Pointer() && rv.Len() == ov.Len()
+	case reflect.Map, reflect.Ptr, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return rv.Pointer() == ov.Pointer()
+	}
+	return reflect.DeepEqual(rv.Interface(), obj)
+}
+
+// A pathStep is one step of the location of a value, recorded during an
+// evaluation by EvalPaths. Steps are linked from a value back to the root.
+type pathStep struct {
+	step   Query
+	parent *pathStep
+}
+
+// seq returns the steps leading to p from the root.
+func (p *pathStep) seq() Seq {
+	var out Seq
+	for ; p != nil; p = p.parent {
+		out = append(out, p.step)
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// child constructs a new value for obj with v as its parent, located by step
+// relative to v.
+func (v *value) child(step Query, obj interface{}) *value {
+	next := pushValue(v, v.env.unwrap(obj))
+	if v.env.paths {
+		if _, ok := v.val.(Entry); ok && step == (keyQuery{key: "Value"}) {
+			return next // the value of an entry is located at the entry
+		}
+		next.path = &pathStep{step: step, parent: v.path}
+	}
+	return next
+}
+
+// elem constructs a new value for obj, the element at offset i of v.
+func (v *value) elem(i int, obj interface{}) *value {
+	next := pushValue(v, v.env.unwrap(obj))
+	if v.env.paths {
+		next.path = v.elemPath(i)
+	}
+	return next
+}
+
+// elemPath returns the location of the element at offset i of v.
+func (v *value) elemPath(i int) *pathStep {
+	if v.elems != nil {
+		return v.elems[i]
+	}
+	return &pathStep{step: indexQuery(i), parent: v.path}
+}
+
+// pushResult constructs a new value for res, the result of a subquery
+// evaluated on v, with v as its parent. The new value has the location of res.
+func pushResult(v, res *value) *value {
+	next := pushValue(v, res.val)
+	next.path, next.elems = res.path, res.elems
+	return next
+}
+
+// appendElem appends the location p of an element of a collection built from
+// v to elems, if locations are being recorded, and returns elems.
+func (v *value) appendElem(elems []*pathStep, p *pathStep) []*pathStep {
+	if v.env.paths {
+		return append(elems, p)
+	}
+	return elems
+}
+
+// withElems sets the locations of the elements of v, if locations are being
+// recorded, and returns v.
+func (v *value) withElems(elems []*pathStep) *value {
+	if v.env.paths {
+		if elems == nil {
+			elems = []*pathStep{}
+		}
+		v.elems = elems
+	}
+	return v
+}
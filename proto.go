@@ -0,0 +1,261 @@
+package vql
+
+import (
+	"reflect"
+	"strings"
+)
+
+// The protoStruct, protoList, and protoValue interfaces are satisfied by the
+// well-known protobuf types Struct, ListValue, and Value from the structpb
+// package. Values of these types are unwrapped to plain Go values, so that
+// queries can traverse them without a dependency on the protobuf module.
+type (
+	protoStruct interface{ AsMap() map[string]interface{} }
+	protoList   interface{ AsSlice() []interface{} }
+	protoValue  interface{ AsInterface() interface{} }
+)
+
+// unwrap returns the value that queries traverse in place of obj. A protobuf
+// Struct, ListValue, or Value is converted to the equivalent map, slice, or
+// scalar value, as by its AsMap, AsSlice, or AsInterface method, and raw JSON
+// is decoded if e enables it. Other values are returned unchanged.
+func (e *env) unwrap(obj interface{}) interface{} {
+	switch t := obj.(type) {
+	case protoStruct:
+		if isProtoType(reflect.TypeOf(t)) {
+			return t.AsMap()
+		}
+	case protoList:
+		if isProtoType(reflect.TypeOf(t)) {
+			return t.AsSlice()
+		}
+	case protoValue:
+		if isProtoType(reflect.TypeOf(t)) {
+			return t.AsInterface()
+		}
+	}
+	return e.decodeRaw(obj)
+}
+
+var (
+	protoStructType = reflect.TypeOf((*protoStruct)(nil)).Elem()
+	protoListType   = reflect.TypeOf((*protoList)(nil)).Elem()
+	protoValueType  = reflect.TypeOf((*protoValue)(nil)).Elem()
+)
+
+// isProtoType reports whether t is a protobuf message type that is unwrapped
+// when it is evaluated, as described for unwrap.
+func isProtoType(t reflect.Type) bool {
+	if t == nil || t.Kind() == reflect.Interface {
+		return false
+	} else if _, ok := t.MethodByName("ProtoReflect"); !ok {
+		return false
+	}
+	return t.Implements(protoStructType) || t.Implements(protoListType) || t.Implements(protoValueType)
+}
+
+// isProtoStruct reports whether t is the struct type of a protobuf message,
+// whose fields can be looked up through protoreflect by their proto names.
+func isProtoStruct(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	_, ok := reflect.PtrTo(t).MethodByName("ProtoReflect")
+	return ok
+}
+
+// The fields of a protobuf message are found through the protoreflect API of
+// the message, which is called by reflection so that vql does not depend on
+// the protobuf module. The functions below use only these methods:
+//
+//	Message:           ProtoReflect, Descriptor, Has, Get, IsValid, Interface
+//	MessageDescriptor: Fields
+//	FieldDescriptors:  ByName, Len, Get
+//	FieldDescriptor:   Name, ContainingOneof
+//	OneofDescriptor:   IsSynthetic
+//	Value, MapKey:     Interface
+//	List:              Len, Get
+//	Map:               Range
+
+// protoField returns the value of the field of the message rv named by name,
+// either by its proto name or by the Go name generated for it. A member of a
+// oneof is found only if it is the one that is set. The result is invalid if
+// there is no such field, or if rv does not support protoreflect.
+func protoField(rv reflect.Value, name string) reflect.Value {
+	if rv.Kind() != reflect.Ptr {
+		if !rv.CanAddr() {
+			// Copy the message so that its pointer methods can be called.
+			cp := reflect.New(rv.Type()).Elem()
+			cp.Set(rv)
+			rv = cp
+		}
+		rv = rv.Addr()
+	}
+	msg, ok := callMethod(rv, "ProtoReflect")
+	if !ok {
+		return reflect.Value{}
+	}
+	fd, ok := protoFieldDesc(msg, name)
+	if !ok {
+		return reflect.Value{}
+	}
+	if od, ok := callMethod(fd, "ContainingOneof"); ok {
+		if synth, ok := callMethod(od, "IsSynthetic"); !ok || !synth.Bool() {
+			if has, ok := callMethod(msg, "Has", fd); !ok || !has.Bool() {
+				return reflect.Value{} // not the member that is set
+			}
+		}
+	}
+	val, ok := callMethod(msg, "Get", fd)
+	if !ok {
+		return reflect.Value{}
+	}
+	return reflect.ValueOf(protoReflectValue(val))
+}
+
+// protoFieldDesc returns the descriptor of the field of msg whose proto name
+// or generated Go name is name.
+func protoFieldDesc(msg reflect.Value, name string) (reflect.Value, bool) {
+	md, ok := callMethod(msg, "Descriptor")
+	if !ok {
+		return reflect.Value{}, false
+	}
+	fds, ok := callMethod(md, "Fields")
+	if !ok {
+		return reflect.Value{}, false
+	} else if fd, ok := callMethod(fds, "ByName", reflect.ValueOf(name)); ok {
+		return fd, true
+	}
+	n, ok := callMethod(fds, "Len")
+	if !ok {
+		return reflect.Value{}, false
+	}
+	for i := 0; i < int(n.Int()); i++ {
+		fd, ok := callMethod(fds, "Get", reflect.ValueOf(i))
+		if !ok {
+			continue
+		} else if fn, ok := callMethod(fd, "Name"); ok && goCamelCase(fn.String()) == name {
+			return fd, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// protoReflectValue converts the protoreflect.Value val to a value for queries
+// to traverse: A message is converted to its Go type, or nil if it is not set,
+// a list to a []interface{}, and a map to a map[interface{}]interface{}.
+// Scalars are returned as they are.
+func protoReflectValue(val reflect.Value) interface{} {
+	v, ok := callMethod(val, "Interface")
+	if !ok {
+		return nil
+	}
+	v = reflect.ValueOf(v.Interface()) // the concrete value
+	switch {
+	case hasMethods(v, "IsValid", "Descriptor", "Interface"): // a message
+		if valid, ok := callMethod(v, "IsValid"); !ok || !valid.Bool() {
+			return nil
+		} else if m, ok := callMethod(v, "Interface"); ok {
+			return m.Interface()
+		}
+		return nil
+
+	case hasMethods(v, "Range"): // a map
+		rng := v.MethodByName("Range")
+		ft := rng.Type().In(0)
+		out := make(map[interface{}]interface{})
+		rng.Call([]reflect.Value{reflect.MakeFunc(ft, func(args []reflect.Value) []reflect.Value {
+			if key, ok := callMethod(args[0], "Interface"); ok {
+				out[key.Interface()] = protoReflectValue(args[1])
+			}
+			return []reflect.Value{reflect.ValueOf(true)}
+		})})
+		return out
+
+	case hasMethods(v, "Len", "Get"): // a list
+		n, _ := callMethod(v, "Len")
+		out := make([]interface{}, 0, int(n.Int()))
+		for i := 0; i < int(n.Int()); i++ {
+			if elt, ok := callMethod(v, "Get", reflect.ValueOf(i)); ok {
+				out = append(out, protoReflectValue(elt))
+			}
+		}
+		return out
+	}
+	return v.Interface()
+}
+
+// callMethod calls the method of v with the given name on args, converted to
+// the types of its parameters, and returns its single result. It reports false
+// if there is no such method, if args do not match its parameters, or if the
+// result is a nil interface.
+func callMethod(v reflect.Value, name string, args ...reflect.Value) (reflect.Value, bool) {
+	if !v.IsValid() {
+		return reflect.Value{}, false
+	}
+	m := v.MethodByName(name)
+	if !m.IsValid() {
+		return reflect.Value{}, false
+	}
+	mt := m.Type()
+	if mt.NumIn() != len(args) || mt.NumOut() != 1 || mt.IsVariadic() {
+		return reflect.Value{}, false
+	}
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		pt := mt.In(i)
+		if arg.Type().AssignableTo(pt) {
+			in[i] = arg
+		} else if arg.Type().ConvertibleTo(pt) && arg.Kind() == pt.Kind() {
+			in[i] = arg.Convert(pt)
+		} else {
+			return reflect.Value{}, false
+		}
+	}
+	out := m.Call(in)[0]
+	if out.Kind() == reflect.Interface && out.IsNil() {
+		return reflect.Value{}, false
+	}
+	return out, true
+}
+
+// hasMethods reports whether v has methods with all the given names.
+func hasMethods(v reflect.Value, names ...string) bool {
+	for _, name := range names {
+		if !v.IsValid() || !v.MethodByName(name).IsValid() {
+			return false
+		}
+	}
+	return true
+}
+
+// goCamelCase returns the Go name generated for a field with the given proto
+// name, following the rules of protoc-gen-go: An underscore before a lowercase
+// letter is removed and the letter capitalized, a leading underscore becomes
+// "X", and the first letter of each word is capitalized.
+func goCamelCase(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '_' && i == 0:
+			b.WriteByte('X')
+		case c == '_' && i+1 < len(s) && isASCIILower(s[i+1]):
+			// Drop the underscore; the letter after it is capitalized below.
+		case isASCIIDigit(c):
+			b.WriteByte(c)
+		default:
+			if isASCIILower(c) {
+				c -= 'a' - 'A'
+			}
+			b.WriteByte(c)
+			for ; i+1 < len(s) && isASCIILower(s[i+1]); i++ {
+				b.WriteByte(s[i+1])
+			}
+		}
+	}
+	return b.String()
+}
+
+func isASCIILower(c byte) bool { return 'a' <= c && c <= 'z' }
+func isASCIIDigit(c byte) bool { return '0' <= c && c <= '9' }
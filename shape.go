@@ -0,0 +1,224 @@
+package vql
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Shape describes the structure of the results of a query, as reported by
+// InferShape and ValueShape. At most one of the fields of a Shape is set. A
+// Shape with no fields set describes values of unknown type.
+type Shape struct {
+	// If non-nil, values of this shape have this type.
+	Type reflect.Type
+
+	// If non-nil, values of this shape are slices of type []interface{}
+	// whose elements have shape Elem.
+	Elem *Shape
+
+	// If non-nil, values of this shape are Values whose entries have the
+	// names and shapes given by Fields.
+	Fields map[string]*Shape
+}
+
+// String renders s in a notation resembling Go types, such as "[]string" or
+// "Values{age: int, name: string}". A value of unknown type is "any".
+func (s *Shape) String() string {
+	switch {
+	case s == nil:
+		return "any"
+	case s.Type != nil:
+		return s.Type.String()
+	case s.Elem != nil:
+		return "[]" + s.Elem.String()
+	case s.Fields != nil:
+		names := make([]string, 0, len(s.Fields))
+		for name := range s.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		parts := make([]string, len(names))
+		for i, name := range names {
+			key := name
+			if !isIdent(name) {
+				key = strconv.Quote(name)
+			}
+			parts[i] = key + ": " + s.Fields[name].String()
+		}
+		return "Values{" + strings.Join(parts, ", ") + "}"
+	}
+	return "any"
+}
+
+// goType returns the Go type of values of shape s, or nil if it is unknown.
+func (s *Shape) goType() reflect.Type {
+	switch {
+	case s.Type != nil:
+		return s.Type
+	case s.Elem != nil:
+		return listType
+	case s.Fields != nil:
+		return valuesType
+	}
+	return nil
+}
+
+// elem returns the shape of the elements of values of shape s.
+func (s *Shape) elem() *Shape {
+	if s.Elem != nil {
+		return s.Elem
+	}
+	switch t := derefType(s.goType()); {
+	case t == nil:
+	case t == syncMapType, t.Kind() == reflect.Map:
+		return &Shape{Type: entryType}
+	case t.Kind() == reflect.Array, t.Kind() == reflect.Slice:
+		return typeShape(t.Elem())
+	}
+	return &Shape{}
+}
+
+// typeShape returns a Shape for values of type t.
+func typeShape(t reflect.Type) *Shape {
+	if t = staticType(t); t == listType {
+		return &Shape{Elem: &Shape{}}
+	}
+	return &Shape{Type: t}
+}
+
+// InferShape reports the shape of the results of evaluating q on inputs of
+// type t. It checks q against t as Check does, and reports the same errors.
+// Where Check reports only that a query such as Each or Map yields a value of
+// type []interface{} or Values, InferShape also describes the shapes of the
+// elements or entries of the value. Parts of the result whose types cannot be
+// determined statically have unknown shape.
+//
+// For example, given
+//
+//	type Person struct {
+//	   Name string
+//	   Age  int
+//	}
+//
+// the shape of Each(Map{"name": Key("Name"), "age": Key("Age")}) for inputs
+// of type []Person is "[]Values{age: int, name: string}".
+func InferShape(q Query, t reflect.Type) (*Shape, error) {
+	return inferShape(q, typeShape(t))
+}
+
+func inferShape(q Query, in *Shape) (*Shape, error) {
+	// Check q as compile does, to report the same errors.
+	_, rt, err := compile(q, in.goType())
+	if err != nil {
+		return nil, err
+	}
+
+	switch q := q.(type) {
+	case selfQuery:
+		return in, nil
+
+	case Seq:
+		for _, elt := range q {
+			if in, err = inferShape(elt, in); err != nil {
+				return nil, err
+			}
+		}
+		return in, nil
+
+	case keyQuery:
+		if in.Fields != nil {
+			if name, ok := q.key.(string); ok && in.Fields[name] != nil {
+				return in.Fields[name], nil
+			}
+			return &Shape{}, nil
+		}
+
+	case mapQuery, lenientMapQuery, parMapQuery, typedMapQuery:
+		out, err := inferShape(subqueries(q)[0], in.elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Shape{Elem: out}, nil
+
+	case selectQuery, typedSelectQuery, rangeQuery, sortQuery, distinctQuery:
+		return &Shape{Elem: in.elem()}, nil
+
+	case chunkQuery, partitionQuery:
+		return &Shape{Elem: &Shape{Elem: in.elem()}}, nil
+
+	case firstQuery:
+		return in.elem(), nil
+
+	case indexQuery:
+		if in.Elem != nil {
+			return in.Elem, nil
+		}
+
+	case Map:
+		out := &Shape{Fields: make(map[string]*Shape, len(q))}
+		for name, sub := range q {
+			fs, err := inferShape(sub, in)
+			if err != nil {
+				return nil, err
+			}
+			out.Fields[name] = fs
+		}
+		return out, nil
+
+	case List:
+		var elem *Shape
+		for i, sub := range q {
+			s, err := inferShape(sub, in)
+			if err != nil {
+				return nil, err
+			} else if i == 0 {
+				elem = s
+			} else if s.String() != elem.String() {
+				elem = &Shape{}
+			}
+		}
+		if elem == nil {
+			elem = &Shape{}
+		}
+		return &Shape{Elem: elem}, nil
+	}
+	return typeShape(rt), nil
+}
+
+// ValueShape reports the shape of the value v, such as the result of
+// evaluating a query on a sample input. The elements of a []interface{} have
+// the shape shared by all its non-nil elements, or unknown shape if they
+// differ; the entries of a Values have the shapes of their values. A nil
+// value has unknown shape.
+func ValueShape(v interface{}) *Shape {
+	switch t := v.(type) {
+	case nil:
+		return &Shape{}
+	case []interface{}:
+		var elem *Shape
+		for _, e := range t {
+			if e == nil {
+				continue
+			}
+			s := ValueShape(e)
+			if elem == nil {
+				elem = s
+			} else if s.String() != elem.String() {
+				return &Shape{Elem: &Shape{}}
+			}
+		}
+		if elem == nil {
+			elem = &Shape{}
+		}
+		return &Shape{Elem: elem}
+	case Values:
+		out := &Shape{Fields: make(map[string]*Shape, len(t))}
+		for name, e := range t {
+			out.Fields[name] = ValueShape(e)
+		}
+		return out
+	}
+	return &Shape{Type: reflect.TypeOf(v)}
+}
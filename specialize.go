@@ -0,0 +1,36 @@
+package vql
+
+// Specialize returns a Query equivalent to q, which uses fn to evaluate the
+// inputs fn can handle. For each input v, fn reports the result of q on v and
+// true, or false if it does not handle v, in which case q is evaluated on v
+// as usual. The caller is responsible for ensuring that fn is equivalent to q
+// for the inputs it handles.
+//
+// Specialize is meant for code generated by the vqlgen tool, which emits a
+// function for each of a set of fixed queries that accesses the fields of a
+// particular type directly, without using reflection. Such a function need
+// handle only the common cases, and can leave any others, such as a nil
+// pointer or an index out of range, to the general evaluator.
+//
+// The result formats and encodes as q does. The specialization is not used
+// when evaluation records the locations of its results, as for EvalPaths,
+// decodes raw JSON values, as for DecodeRawJSON, or is observed or limited by
+// the Observe, Trace, MaxSteps, or MaxDepth options, all of which need the
+// steps of q to be evaluated individually.
+func Specialize(q Query, fn func(v interface{}) (interface{}, bool)) Query {
+	return specialQuery{q: q, fn: fn}
+}
+
+type specialQuery struct {
+	q  Query
+	fn func(interface{}) (interface{}, bool)
+}
+
+func (s specialQuery) eval(v *value) (*value, error) {
+	if e := v.env; !e.paths && !e.rawJSON && len(e.observers) == 0 && e.limits == nil {
+		if out, ok := s.fn(v.val); ok {
+			return pushValue(v, out), nil
+		}
+	}
+	return s.q.eval(v)
+}
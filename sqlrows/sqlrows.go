@@ -0,0 +1,54 @@
+// Package sqlrows adapts the results of database/sql queries so that they can
+// be traversed by vql queries.
+//
+// Read materializes a result set as a slice of vql.Values, one per row,
+// mapping each column name to its value, so that the rows can be filtered,
+// projected, and grouped by vql queries, for example:
+//
+//	rows, err := db.QueryContext(ctx, "SELECT name, dept, salary FROM staff")
+//	...
+//	recs, err := sqlrows.Read(rows)
+//	...
+//	byDept, err := vql.Eval(vql.GroupBy(vql.Key("dept")), recs)
+package sqlrows
+
+import (
+	"database/sql"
+
+	"github.com/creachadair/vql"
+)
+
+// Read reads all the remaining rows of rs and closes it. Each row is returned
+// as a vql.Values mapping each column name to the value of that column, as
+// scanned into an interface{} by the Scan method of sql.Rows. The types of
+// the values therefore depend on the driver; a NULL column has the value nil.
+// If more than one column has the same name, the value of the first is used.
+//
+// If an error occurs while reading, Read returns the rows read before the
+// error, along with the error.
+func Read(rs *sql.Rows) ([]vql.Values, error) {
+	defer rs.Close()
+	cols, err := rs.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var out []vql.Values
+	vals := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rs.Next() {
+		if err := rs.Scan(ptrs...); err != nil {
+			return out, err
+		}
+		row := make(vql.Values, len(cols))
+		for i, name := range cols {
+			if _, ok := row[name]; !ok {
+				row[name] = vals[i]
+			}
+		}
+		out = append(out, row)
+	}
+	return out, rs.Err()
+}
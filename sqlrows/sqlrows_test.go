@@ -0,0 +1,86 @@
+package sqlrows_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/creachadair/vql"
+	"github.com/creachadair/vql/sqlrows"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeDriver serves a fixed result set for any query.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                               { return nil }
+func (fakeStmt) NumInput() int                              { return -1 }
+func (fakeStmt) Exec([]driver.Value) (driver.Result, error) { return nil, errors.New("not supported") }
+func (fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &fakeRows{data: [][]driver.Value{
+		{"alice", "eng", int64(120)},
+		{"bob", "ops", int64(90)},
+		{"carol", "eng", nil},
+	}}, nil
+}
+
+type fakeRows struct{ data [][]driver.Value }
+
+func (*fakeRows) Columns() []string { return []string{"name", "dept", "salary"} }
+func (*fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.data) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.data[0])
+	r.data = r.data[1:]
+	return nil
+}
+
+func init() { sql.Register("sqlrows-fake", fakeDriver{}) }
+
+func TestRead(t *testing.T) {
+	db, err := sql.Open("sqlrows-fake", "")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	rs, err := db.Query("SELECT name, dept, salary FROM staff")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	recs, err := sqlrows.Read(rs)
+	if err != nil {
+		t.Fatalf("Read: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]vql.Values{
+		{"name": "alice", "dept": "eng", "salary": int64(120)},
+		{"name": "bob", "dept": "ops", "salary": int64(90)},
+		{"name": "carol", "dept": "eng", "salary": nil},
+	}, recs); diff != "" {
+		t.Errorf("Read: (-want, +got)\n%s", diff)
+	}
+
+	got, err := vql.Eval(vql.Seq{
+		vql.Select(vql.Key("dept"), vql.Eq("eng")),
+		vql.Each(vql.Key("name")),
+	}, recs)
+	if err != nil {
+		t.Errorf("Eval: unexpected error: %v", err)
+	} else if diff := cmp.Diff([]interface{}{"alice", "carol"}, got); diff != "" {
+		t.Errorf("Eval: (-want, +got)\n%s", diff)
+	}
+}
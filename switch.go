@@ -0,0 +1,81 @@
+package vql
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Switch is a Query that selects a subquery to apply to its input based on the
+// value of a discriminator. The On query is evaluated on the input, and its
+// value is used to select a query from Cases, which is then evaluated on the
+// same input. A number matches a case whose key is a number of equal value,
+// as for Eq, so that float64(1) decoded from JSON matches the case 1. If no
+// case matches, Default is evaluated instead; if Default is nil, the result is
+// nil. It is an error if the value of On is not a valid map key.
+//
+// Switch is useful for variant records, in which a field of each value says
+// how the rest of it should be interpreted. For example:
+//
+//	vql.Switch{
+//	   On: vql.Key("kind"),
+//	   Cases: map[interface{}]vql.Query{
+//	      "circle": vql.Key("radius"),
+//	      "square": vql.Key("side"),
+//	   },
+//	   Default: vql.Const(0),
+//	}
+type Switch struct {
+	On      Query
+	Cases   map[interface{}]Query
+	Default Query
+}
+
+func (s Switch) eval(v *value) (*value, error) {
+	on, err := evalQuery(s.On, v)
+	if err != nil {
+		return nil, err
+	}
+	if !isHashable(on.val) {
+		return nil, fmt.Errorf("%w: value of type %T cannot be a case", ErrBadKey, on.val)
+	}
+	q, ok := s.lookup(on.val)
+	if !ok {
+		if s.Default == nil {
+			return pushValue(v, nil), nil
+		}
+		q = s.Default
+	}
+	res, err := evalQuery(q, v)
+	if err != nil {
+		return nil, err
+	}
+	return pushResult(v, res), nil
+}
+
+// lookup returns the query of the case of s that matches key, if any.
+func (s Switch) lookup(key interface{}) (Query, bool) {
+	if q, ok := s.Cases[key]; ok {
+		return q, true
+	} else if !isNumberKind(reflect.ValueOf(key).Kind()) {
+		return nil, false
+	}
+	for _, ck := range s.caseKeys() {
+		if isEqual(ck, key) {
+			return s.Cases[ck], true
+		}
+	}
+	return nil, false
+}
+
+// caseKeys returns the keys of the cases of s in a deterministic order.
+func (s Switch) caseKeys() []interface{} {
+	keys := make([]interface{}, 0, len(s.Cases))
+	for key := range s.Cases {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return formatLiteral(keys[i]) < formatLiteral(keys[j])
+	})
+	return keys
+}
@@ -0,0 +1,59 @@
+package vql
+
+import (
+	"fmt"
+	"sync"
+	"text/template"
+)
+
+// TemplateFuncs returns a map of functions that evaluate queries, for use
+// with the Funcs method of a text/template or html/template Template:
+//
+//	vql QUERY DATA           -- the value of QUERY on DATA
+//	vqlDefault QUERY X DATA  -- the value of Default(QUERY, X) on DATA
+//	vqlParse TEXT            -- the Query parsed from TEXT
+//
+// A QUERY may be a Query or a string in the syntax accepted by Parse. Query
+// strings are parsed once and cached for the lifetime of the map. An error in
+// parsing or evaluating a query stops execution of the template. For example:
+//
+//	t := template.Must(template.New("report").Funcs(vql.TemplateFuncs()).Parse(
+//	   `{{range vql "People.select(Age > 30)" .}}{{.Name}}{{end}}`,
+//	))
+func TemplateFuncs() template.FuncMap {
+	var cache sync.Map // query text → Query
+	parse := func(query interface{}) (Query, error) {
+		switch t := query.(type) {
+		case Query:
+			return t, nil
+		case string:
+			if q, ok := cache.Load(t); ok {
+				return q.(Query), nil
+			}
+			q, err := Parse(t)
+			if err != nil {
+				return nil, err
+			}
+			cache.Store(t, q)
+			return q, nil
+		}
+		return nil, fmt.Errorf("value of type %T is not a query", query)
+	}
+	return template.FuncMap{
+		"vql": func(query, data interface{}) (interface{}, error) {
+			q, err := parse(query)
+			if err != nil {
+				return nil, err
+			}
+			return Eval(q, data)
+		},
+		"vqlDefault": func(query, fallback, data interface{}) (interface{}, error) {
+			q, err := parse(query)
+			if err != nil {
+				return nil, err
+			}
+			return Eval(Default(q, fallback), data)
+		},
+		"vqlParse": func(text string) (Query, error) { return parse(text) },
+	}
+}
@@ -0,0 +1,222 @@
+package vql
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Match returns a Query that reports whether its input, which must be a
+// string, contains a match of the regular expression pattern. The pattern is
+// compiled when the query is constructed; Match panics if it is not a valid
+// regular expression, in the syntax accepted by the regexp package.
+//
+// For example, Seq{Key("Email"), Match(`@example\.com$`)} reports whether an
+// email address is in the example.com domain.
+func Match(pattern string) Query { return matchQuery{mustCompile("match", pattern)} }
+
+type matchQuery struct{ re *regexp.Regexp }
+
+func (m matchQuery) eval(v *value) (*value, error) {
+	s, err := stringValue(v.val)
+	if err != nil {
+		return nil, err
+	}
+	return pushValue(v, m.re.MatchString(s)), nil
+}
+
+// Capture returns a Query that matches its input, which must be a string,
+// against the regular expression pattern, and yields a slice of concrete type
+// []interface{} containing the text of the leftmost match followed by the text
+// of each of its parenthesized subexpressions. A subexpression that did not
+// participate in the match yields "". The result is nil if the input does not
+// match. The pattern is compiled when the query is constructed; Capture
+// panics if it is not a valid regular expression.
+//
+// For example, Capture(`^(\w+)@(.*)$`) applied to "joe@example.com" yields
+// []interface{}{"joe@example.com", "joe", "example.com"}.
+func Capture(pattern string) Query { return captureQuery{mustCompile("capture", pattern)} }
+
+type captureQuery struct{ re *regexp.Regexp }
+
+func (c captureQuery) eval(v *value) (*value, error) {
+	s, err := stringValue(v.val)
+	if err != nil {
+		return nil, err
+	}
+	m := c.re.FindStringSubmatch(s)
+	if m == nil {
+		return pushValue(v, nil), nil
+	}
+	out := make([]interface{}, len(m))
+	for i, sub := range m {
+		out[i] = sub
+	}
+	return pushValue(v, out), nil
+}
+
+// Glob returns a Query that reports whether its input, which must be a
+// string, matches the shell pattern, using the syntax of path.Match. In that
+// syntax "*" matches any sequence of characters other than "/", "?" matches
+// any single character other than "/", and "[...]" matches a character class.
+// Glob panics if pattern is malformed.
+//
+// For example, Seq{Key("Host"), Glob("*.example.com")} reports whether a host
+// name is a subdomain of example.com.
+func Glob(pattern string) Query {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic("glob: " + err.Error())
+	}
+	return globQuery(pattern)
+}
+
+type globQuery string
+
+func (g globQuery) eval(v *value) (*value, error) {
+	s, err := stringValue(v.val)
+	if err != nil {
+		return nil, err
+	}
+	ok, _ := path.Match(string(g), s) // the pattern was checked by Glob
+	return pushValue(v, ok), nil
+}
+
+// ToLower returns a Query that converts its input, which must be a string, to
+// lower case.
+func ToLower() Query { return stringQuery{name: "toLower"} }
+
+// ToUpper returns a Query that converts its input, which must be a string, to
+// upper case.
+func ToUpper() Query { return stringQuery{name: "toUpper"} }
+
+// TrimSpace returns a Query that removes leading and trailing white space
+// from its input, which must be a string.
+func TrimSpace() Query { return stringQuery{name: "trimSpace"} }
+
+// Split returns a Query that splits its input, which must be a string, at
+// each occurrence of sep, and yields a slice of concrete type []interface{}
+// containing the substrings between them, as strings.Split does.
+func Split(sep string) Query { return stringQuery{name: "split", arg: sep} }
+
+// JoinWith returns a Query that concatenates the elements of its input, which
+// must be an array or slice of strings, with sep between them, and yields the
+// resulting string.
+//
+// For example, Seq{Key("Tags"), JoinWith(", ")} renders a list of tags.
+func JoinWith(sep string) Query { return stringQuery{name: "joinWith", arg: sep} }
+
+// HasPrefix returns a Query that reports whether its input, which must be a
+// string, begins with prefix.
+func HasPrefix(prefix string) Query { return stringQuery{name: "hasPrefix", arg: prefix} }
+
+// HasSuffix returns a Query that reports whether its input, which must be a
+// string, ends with suffix.
+func HasSuffix(suffix string) Query { return stringQuery{name: "hasSuffix", arg: suffix} }
+
+// stringQuery is a string operation, identified by the name of its
+// combinator, with an optional string argument.
+type stringQuery struct {
+	name string
+	arg  string
+}
+
+func (q stringQuery) eval(v *value) (*value, error) {
+	if q.name == "joinWith" {
+		rv, err := seqValue(v.val)
+		if err != nil {
+			return nil, err
+		}
+		ss := make([]string, rv.Len())
+		for i := range ss {
+			s, err := stringValue(rv.Index(i).Interface())
+			if err != nil {
+				return nil, wrapError([]Query{indexQuery(i)}, rv.Index(i).Interface(), err)
+			}
+			ss[i] = s
+		}
+		return pushValue(v, strings.Join(ss, q.arg)), nil
+	}
+
+	s, err := stringValue(v.val)
+	if err != nil {
+		return nil, err
+	}
+	switch q.name {
+	case "toLower":
+		return pushValue(v, strings.ToLower(s)), nil
+	case "toUpper":
+		return pushValue(v, strings.ToUpper(s)), nil
+	case "trimSpace":
+		return pushValue(v, strings.TrimSpace(s)), nil
+	case "split":
+		parts := strings.Split(s, q.arg)
+		out := make([]interface{}, len(parts))
+		for i, part := range parts {
+			out[i] = part
+		}
+		return pushValue(v, out), nil
+	case "hasPrefix":
+		return pushValue(v, strings.HasPrefix(s, q.arg)), nil
+	case "hasSuffix":
+		return pushValue(v, strings.HasSuffix(s, q.arg)), nil
+	}
+	panic("unknown string operation " + q.name)
+}
+
+// Format returns a Query that evaluates each of args on its input, and yields
+// the string produced by fmt.Sprintf with the given format and the values of
+// args as its arguments.
+//
+// For example, Format("%s (%d years)", Key("Name"), Key("Age")) yields a
+// string like "Alice (35 years)".
+func Format(format string, args ...Query) Query { return sprintfQuery{format: format, args: args} }
+
+type sprintfQuery struct {
+	format string
+	args   []Query
+}
+
+func (f sprintfQuery) eval(v *value) (*value, error) {
+	vals := make([]interface{}, len(f.args))
+	for i, arg := range f.args {
+		w, err := evalQuery(arg, v)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = w.val
+	}
+	return pushValue(v, fmt.Sprintf(f.format, vals...)), nil
+}
+
+// stringOps maps the names of string operations to their constructors. The
+// constructors of operations without an argument ignore it.
+var stringOps = map[string]func(string) Query{
+	"toLower":   func(string) Query { return ToLower() },
+	"toUpper":   func(string) Query { return ToUpper() },
+	"trimSpace": func(string) Query { return TrimSpace() },
+	"split":     Split,
+	"joinWith":  JoinWith,
+	"hasPrefix": HasPrefix,
+	"hasSuffix": HasSuffix,
+}
+
+func mustCompile(name, pattern string) *regexp.Regexp {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		panic(name + ": " + err.Error())
+	}
+	return re
+}
+
+// stringValue returns the value of obj, which must be a string or have a
+// string as its underlying type.
+func stringValue(obj interface{}) (string, error) {
+	if s, ok := obj.(string); ok {
+		return s, nil
+	} else if rv := reflect.ValueOf(obj); rv.Kind() == reflect.String {
+		return rv.String(), nil
+	}
+	return "", fmt.Errorf("value of type %T is %w", obj, ErrNotString)
+}
@@ -0,0 +1,77 @@
+package vql
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Trace returns an Option that writes a log of the evaluation of a query to
+// w, to help find which step of a query does not behave as expected. Each
+// query observed as described by EvalObserver is logged on one line giving
+// the query, the type of its input, and its result or error, for example:
+//
+//	  people: map[string]interface {} => []interface {} [map[age:25 name:bob]]
+//	      age: map[string]interface {} => int 25
+//	      > 40: int => bool false
+//	    age > 40: map[string]interface {} => bool false
+//	  select(age > 40): []interface {} => []interface {} []
+//	  [0]: []interface {} => error: index out of range: 0 is not in 0..0
+//	people.select(age > 40)[0]: map[string]interface {} => error: ...
+//
+// Queries nested inside another query, such as the query of an Each, are
+// logged before the query that contains them, and indented by their depth.
+// Values are abbreviated to a short prefix of their formatted representation.
+// Errors writing to w are ignored.
+//
+// Logs from queries that evaluate steps concurrently, such as EachN,
+// may be interleaved.
+func Trace(w io.Writer) Option { return traceOption{w} }
+
+type traceOption struct{ w io.Writer }
+
+func (t traceOption) apply(e *env) { e.observers = append(e.observers, &tracer{w: t.w}) }
+
+// maxTraceValue is the maximum length of a value formatted in a trace.
+const maxTraceValue = 60
+
+// A tracer is an EvalObserver that writes a log of the steps of an
+// evaluation.
+type tracer struct {
+	mu    sync.Mutex
+	w     io.Writer
+	depth int
+}
+
+// OnEnter implements part of the EvalObserver interface.
+func (t *tracer) OnEnter(Query, interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.depth++
+	return nil
+}
+
+// OnExit implements part of the EvalObserver interface.
+func (t *tracer) OnExit(q Query, in, out interface{}, err error) {
+	var res string
+	if err != nil {
+		res = "error: " + err.Error()
+	} else if out == nil {
+		res = "<nil>"
+	} else {
+		res = fmt.Sprintf("%T %s", out, abbrev(fmt.Sprint(out), maxTraceValue))
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.depth--
+	fmt.Fprintf(t.w, "%s%v: %T => %s\n", strings.Repeat("  ", t.depth), q, in, res)
+}
+
+// abbrev returns s, truncated to at most n bytes if it is longer.
+func abbrev(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
@@ -0,0 +1,266 @@
+package vql
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// EvalAs evaluates q starting from v, as Eval, and returns the result as a
+// value of type T. It reports an error wrapping ErrResultType if the result
+// is not a T. A nil result is returned as the zero value of T if T is a type
+// that admits nil (such as a pointer, slice, map, or interface).
+func EvalAs[T any](q Query, v interface{}) (T, error) {
+	var zero T
+	res, err := Eval(q, v)
+	if err != nil {
+		return zero, err
+	}
+	return resultAs[T](res)
+}
+
+// MustEvalAs is as EvalAs, but panics if evaluation fails.
+func MustEvalAs[T any](q Query, v interface{}) T {
+	res, err := EvalAs[T](q, v)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// CollectAs evaluates q starting from v, as Eval, and returns the elements of
+// the resulting array or slice as values of type T, as EvalAs does for a
+// single result. A nil result yields a nil slice. It reports an error
+// wrapping ErrResultType if the result is not an array or slice, or if any
+// of its elements is not a T; in the latter case the error identifies the
+// offset of the first such element.
+func CollectAs[T any](q Query, v interface{}) ([]T, error) {
+	res, err := Eval(q, v)
+	if err != nil {
+		return nil, err
+	} else if res == nil {
+		return nil, nil
+	} else if ts, ok := res.([]T); ok {
+		return ts, nil
+	}
+	rv, err := seqValue(res)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %T is not a sequence", ErrResultType, res)
+	}
+	out := make([]T, rv.Len())
+	for i := range out {
+		t, err := resultAs[T](rv.Index(i).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
+		out[i] = t
+	}
+	return out, nil
+}
+
+// resultAs converts obj to type T, or reports an error wrapping ErrResultType.
+func resultAs[T any](obj interface{}) (T, error) {
+	var zero T
+	if t, ok := obj.(T); ok {
+		return t, nil
+	} else if obj == nil {
+		switch reflect.TypeOf(&zero).Elem().Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func, reflect.Chan:
+			return zero, nil
+		}
+	}
+	return zero, fmt.Errorf("%w: %T is not %v", ErrResultType, obj, reflect.TypeOf(&zero).Elem())
+}
+
+// EachTyped returns a Query that applies q to each element of an array,
+// slice, or map, as Each does, but yields a slice of the common concrete type
+// of the resulting values when they have one. For example, if q yields a
+// string for every element, the result has type []string. Nil values are
+// allowed if the common type admits nil, such as a pointer type. Otherwise,
+// or if the collection is empty, the result is a slice of type []interface{}
+// as for Each.
+func EachTyped(q Query) Query { return typedMapQuery{q} }
+
+type typedMapQuery struct{ Query }
+
+func (m typedMapQuery) eval(v *value) (*value, error) {
+	res, err := mapQuery{m.Query}.eval(v)
+	if err == nil {
+		res.val = typedSlice(res.val.([]interface{}))
+	}
+	return res, err
+}
+
+// SelectTyped returns a Query that selects the elements of an array, slice,
+// or map for which q is true, as Select does, but yields a slice of the common
+// concrete type of the selected elements, as described for EachTyped.
+func SelectTyped(q ...Query) Query { return typedSelectQuery{Seq(q)} }
+
+type typedSelectQuery struct{ Query }
+
+func (s typedSelectQuery) eval(v *value) (*value, error) {
+	res, err := selectQuery{s.Query}.eval(v)
+	if err == nil {
+		res.val = typedSlice(res.val.([]interface{}))
+	}
+	return res, err
+}
+
+// typedSlice returns a slice of the common concrete type of the elements of
+// vs, as described for EachTyped, or vs itself if they have none.
+func typedSlice(vs []interface{}) interface{} {
+	var t reflect.Type
+	var hasNil bool
+	for _, elt := range vs {
+		if elt == nil {
+			hasNil = true
+		} else if et := reflect.TypeOf(elt); t == nil {
+			t = et
+		} else if et != t {
+			return vs
+		}
+	}
+	if t == nil {
+		return vs
+	} else if hasNil {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Func, reflect.Chan:
+		default:
+			return vs
+		}
+	}
+	out := reflect.MakeSlice(reflect.SliceOf(t), len(vs), len(vs))
+	for i, elt := range vs {
+		if elt != nil {
+			out.Index(i).Set(reflect.ValueOf(elt))
+		}
+	}
+	return out.Interface()
+}
+
+// EvalInto evaluates q starting from v, as Eval, and stores the result in the
+// value pointed to by target, which must be a non-nil pointer. If the result
+// is assignable to the target, it is stored directly. Otherwise, it is
+// decoded into the target as follows:
+//
+//   - A nil result stores the zero value.
+//   - A number is converted to a target of another numeric type, provided
+//     the value is representable in that type.
+//   - A map with string keys, such as Values, or a struct is decoded into a
+//     struct target field by field. A field is matched by the name in its
+//     "json" struct tag, if it has one, and otherwise by its name. Entries
+//     with no matching field are ignored.
+//   - An array or slice is decoded into a slice or array target element by
+//     element.
+//   - A map is decoded into a map target entry by entry.
+//   - A pointer target is allocated and the result decoded into the value it
+//     points to.
+//
+// EvalInto reports an error wrapping ErrResultType if the result cannot be
+// decoded into the target.
+func EvalInto(q Query, v interface{}, target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("target of type %T is not a non-nil pointer", target)
+	}
+	res, err := Eval(q, v)
+	if err != nil {
+		return err
+	}
+	return decodeInto(res, rv.Elem())
+}
+
+// decodeInto decodes obj into the settable value rv, as described by EvalInto.
+func decodeInto(obj interface{}, rv reflect.Value) error {
+	if obj == nil {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+	ov := reflect.ValueOf(obj)
+	if ov.Type().AssignableTo(rv.Type()) {
+		rv.Set(ov)
+		return nil
+	}
+	bad := func() error {
+		return fmt.Errorf("%w: cannot decode %T into %v", ErrResultType, obj, rv.Type())
+	}
+	switch k := rv.Kind(); {
+	case k == reflect.Ptr:
+		p := reflect.New(rv.Type().Elem())
+		if err := decodeInto(obj, p.Elem()); err != nil {
+			return err
+		}
+		rv.Set(p)
+		return nil
+
+	case isNumberKind(k) && isNumberKind(ov.Kind()):
+		cv := ov.Convert(rv.Type())
+		if !reflect.DeepEqual(cv.Convert(ov.Type()).Interface(), obj) {
+			return fmt.Errorf("%w: %v is out of range for %v", ErrResultType, obj, rv.Type())
+		}
+		rv.Set(cv)
+		return nil
+
+	case k == reflect.Struct:
+		es, err := fieldEntries(obj)
+		if err != nil {
+			return bad()
+		}
+		fields := make(map[string]reflect.Value)
+		t := rv.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" { // exported
+				if name := tagName(f, "json"); name != "" {
+					fields[name] = rv.Field(i)
+				}
+			}
+		}
+		for _, e := range es {
+			name, ok := e.Key.(string)
+			if !ok {
+				return bad()
+			} else if f, ok := fields[name]; ok {
+				if err := decodeInto(e.Value, f); err != nil {
+					return fmt.Errorf("field %q: %w", name, err)
+				}
+			}
+		}
+		return nil
+
+	case k == reflect.Slice || k == reflect.Array:
+		if ok := ov.Kind(); ok != reflect.Slice && ok != reflect.Array {
+			return bad()
+		}
+		n := ov.Len()
+		if k == reflect.Slice {
+			rv.Set(reflect.MakeSlice(rv.Type(), n, n))
+		} else if n != rv.Len() {
+			return fmt.Errorf("%w: cannot decode %d elements into %v", ErrResultType, n, rv.Type())
+		}
+		for i := 0; i < n; i++ {
+			if err := decodeInto(ov.Index(i).Interface(), rv.Index(i)); err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+		return nil
+
+	case k == reflect.Map:
+		if ov.Kind() != reflect.Map {
+			return bad()
+		}
+		out := reflect.MakeMapWithSize(rv.Type(), ov.Len())
+		for _, key := range mapKeys(ov) {
+			kv := reflect.New(rv.Type().Key()).Elem()
+			if err := decodeInto(key.Interface(), kv); err != nil {
+				return fmt.Errorf("key %v: %w", key, err)
+			}
+			ev := reflect.New(rv.Type().Elem()).Elem()
+			if err := decodeInto(ov.MapIndex(key).Interface(), ev); err != nil {
+				return fmt.Errorf("key %v: %w", key, err)
+			}
+			out.SetMapIndex(kv, ev)
+		}
+		rv.Set(out)
+		return nil
+	}
+	return bad()
+}
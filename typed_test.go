@@ -0,0 +1,230 @@
+package vql_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/vql"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEvalAs(t *testing.T) {
+	input := map[string]interface{}{
+		"name": "alice",
+		"age":  25,
+		"tags": []string{"a", "b"},
+		"none": nil,
+	}
+
+	if got, err := vql.EvalAs[string](vql.Key("name"), input); err != nil || got != "alice" {
+		t.Errorf("EvalAs[string]: got (%q, %v), want (alice, nil)", got, err)
+	}
+	if got, err := vql.EvalAs[int](vql.Key("age"), input); err != nil || got != 25 {
+		t.Errorf("EvalAs[int]: got (%d, %v), want (25, nil)", got, err)
+	}
+	if got, err := vql.EvalAs[[]string](vql.Key("tags"), input); err != nil {
+		t.Errorf("EvalAs[[]string]: unexpected error: %v", err)
+	} else if diff := cmp.Diff([]string{"a", "b"}, got); diff != "" {
+		t.Errorf("EvalAs[[]string]: (-want, +got)\n%s", diff)
+	}
+	if got, err := vql.EvalAs[[]string](vql.Key("none"), input); err != nil || got != nil {
+		t.Errorf("EvalAs[[]string]: got (%v, %v), want (nil, nil)", got, err)
+	}
+	if got, err := vql.EvalAs[interface{}](vql.Key("age"), input); err != nil || got != 25 {
+		t.Errorf("EvalAs[interface{}]: got (%v, %v), want (25, nil)", got, err)
+	}
+
+	// Type mismatches are reported.
+	if got, err := vql.EvalAs[int](vql.Key("name"), input); !errors.Is(err, vql.ErrResultType) {
+		t.Errorf("EvalAs[int]: got (%v, %v), want %v", got, err, vql.ErrResultType)
+	}
+	if got, err := vql.EvalAs[string](vql.Key("none"), input); !errors.Is(err, vql.ErrResultType) {
+		t.Errorf("EvalAs[string]: got (%v, %v), want %v", got, err, vql.ErrResultType)
+	}
+
+	// Evaluation errors are propagated.
+	if _, err := vql.EvalAs[string](vql.Index(0), input); !errors.Is(err, vql.ErrNotSequence) {
+		t.Errorf("EvalAs[string]: got %v, want %v", err, vql.ErrNotSequence)
+	}
+
+	if got := vql.MustEvalAs[int](vql.Key("age"), input); got != 25 {
+		t.Errorf("MustEvalAs[int]: got %d, want 25", got)
+	}
+	func() {
+		defer func() {
+			if x := recover(); x == nil {
+				t.Error("MustEvalAs[bool]: did not panic")
+			}
+		}()
+		vql.MustEvalAs[bool](vql.Key("age"), input)
+	}()
+}
+
+func TestCollectAs(t *testing.T) {
+	input := map[string]interface{}{
+		"names": []interface{}{"a", "b"},
+		"mixed": []interface{}{"a", 2, "c"},
+		"ptrs":  []interface{}{nil, &struct{}{}},
+		"tags":  [2]string{"x", "y"},
+	}
+	if got, err := vql.CollectAs[string](vql.Key("names"), input); err != nil {
+		t.Errorf("CollectAs[string]: unexpected error: %v", err)
+	} else if diff := cmp.Diff([]string{"a", "b"}, got); diff != "" {
+		t.Errorf("CollectAs[string]: (-want, +got):\n%s", diff)
+	}
+	if got, err := vql.CollectAs[string](vql.Key("tags"), input); err != nil {
+		t.Errorf("CollectAs[string]: unexpected error: %v", err)
+	} else if diff := cmp.Diff([]string{"x", "y"}, got); diff != "" {
+		t.Errorf("CollectAs[string]: (-want, +got):\n%s", diff)
+	}
+	if got, err := vql.CollectAs[*struct{}](vql.Key("ptrs"), input); err != nil || len(got) != 2 || got[0] != nil {
+		t.Errorf("CollectAs[*struct{}]: got (%v, %v), want ([nil, ...], nil)", got, err)
+	}
+	if got, err := vql.CollectAs[int](vql.Key("missing"), input); err != nil || got != nil {
+		t.Errorf("CollectAs[int]: got (%v, %v), want (nil, nil)", got, err)
+	}
+
+	// The first element of the wrong type is reported.
+	_, err := vql.CollectAs[string](vql.Key("mixed"), input)
+	if !errors.Is(err, vql.ErrResultType) {
+		t.Errorf("CollectAs[string]: got %v, want %v", err, vql.ErrResultType)
+	} else if !strings.Contains(err.Error(), "element 1") {
+		t.Errorf("CollectAs[string]: error %q does not mention element 1", err)
+	}
+	if _, err := vql.CollectAs[string](vql.Const("x"), input); !errors.Is(err, vql.ErrResultType) {
+		t.Errorf("CollectAs[string]: got %v, want %v", err, vql.ErrResultType)
+	}
+}
+
+func TestEvalInto(t *testing.T) {
+	type contact struct {
+		Kind  string `json:"kind"`
+		Value string
+	}
+	type user struct {
+		Name     string
+		Age      int64
+		Score    float64 `json:"score"`
+		Contacts []contact
+		Primary  *contact
+		Labels   map[string]string
+		Skipped  string `json:"-"`
+		hidden   string
+	}
+	input := map[string]interface{}{
+		"people": []interface{}{
+			map[string]interface{}{
+				"name": "alice",
+				"age":  25,
+				"contacts": []interface{}{
+					map[string]interface{}{"kind": "email", "addr": "a@example.com"},
+					map[string]interface{}{"kind": "phone", "addr": "555-1212"},
+				},
+				"labels": map[string]interface{}{"team": "blue"},
+			},
+		},
+	}
+	q := vql.Seq{vql.Key("people"), vql.Index(0), vql.Map{
+		"Name":     vql.Key("name"),
+		"Age":      vql.Key("age"),
+		"score":    vql.Const(3),
+		"Skipped":  vql.Const("nope"),
+		"Unused":   vql.Const("ignored"),
+		"Labels":   vql.Key("labels"),
+		"Primary":  vql.Seq{vql.Key("contacts"), vql.Index(0), vql.Map{"kind": vql.Key("kind"), "Value": vql.Key("addr")}},
+		"Contacts": vql.Seq{vql.Key("contacts"), vql.Each(vql.Map{"kind": vql.Key("kind"), "Value": vql.Key("addr")})},
+	}}
+
+	var got user
+	if err := vql.EvalInto(q, input, &got); err != nil {
+		t.Fatalf("EvalInto: unexpected error: %v", err)
+	}
+	want := user{
+		Name:  "alice",
+		Age:   25,
+		Score: 3,
+		Contacts: []contact{
+			{Kind: "email", Value: "a@example.com"},
+			{Kind: "phone", Value: "555-1212"},
+		},
+		Primary: &contact{Kind: "email", Value: "a@example.com"},
+		Labels:  map[string]string{"team": "blue"},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(user{})); diff != "" {
+		t.Errorf("EvalInto: (-want, +got)\n%s", diff)
+	}
+
+	// Simple values and nil results.
+	var n uint8
+	if err := vql.EvalInto(vql.Const(200), nil, &n); err != nil || n != 200 {
+		t.Errorf("EvalInto(uint8): got (%d, %v), want (200, nil)", n, err)
+	}
+	s := []string{"x"}
+	if err := vql.EvalInto(vql.Const(nil), nil, &s); err != nil || s != nil {
+		t.Errorf("EvalInto(nil): got (%v, %v), want (nil, nil)", s, err)
+	}
+
+	// Errors.
+	for _, test := range []struct {
+		query  vql.Query
+		target interface{}
+	}{
+		{vql.Const("x"), &n},
+		{vql.Const(300), &n},        // out of range
+		{vql.Const(2.5), new(int)},  // not an integer
+		{vql.Const([]int{1}), &got}, // not a struct
+		{vql.Const([]int{1, 2}), new([3]int)},
+		{vql.Const(map[int]int{1: 2}), &got}, // non-string keys
+		{vql.Const(vql.Values{"Age": "old"}), &got},
+		{vql.Const(1), n},           // not a pointer
+		{vql.Const(1), (*int)(nil)}, // nil pointer
+		{vql.Key("x"), new(int)},    // evaluation fails
+	} {
+		if err := vql.EvalInto(test.query, 5, test.target); err == nil {
+			t.Errorf("EvalInto(%v, %T): got nil, want error", test.query, test.target)
+		} else {
+			t.Logf("EvalInto(%v, %T): got expected error: %v", test.query, test.target, err)
+		}
+	}
+}
+
+func TestEachTyped(t *testing.T) {
+	type item struct {
+		Name string
+		Next *item
+	}
+	last := &item{Name: "c"}
+	input := []*item{{Name: "a", Next: last}, {Name: "b"}, last}
+	tests := []struct {
+		query vql.Query
+		input interface{}
+		want  interface{}
+	}{
+		{vql.EachTyped(vql.Key("Name")), input, []string{"a", "b", "c"}},
+		{vql.EachTyped(vql.Key("Next")), input, []*item{last, nil, nil}},
+		{vql.EachTyped(vql.Self), []interface{}{1, "two"}, []interface{}{1, "two"}},
+		{vql.EachTyped(vql.Self), []interface{}{1, nil}, []interface{}{1, nil}},
+		{vql.EachTyped(vql.Self), []interface{}{nil, nil}, []interface{}{nil, nil}},
+		{vql.EachTyped(vql.Self), []int{}, []interface{}(nil)},
+		{vql.EachTyped(vql.Key("Value")), map[string]int{"x": 1, "y": 2}, []int{1, 2}},
+		{vql.SelectTyped(vql.Not(vql.Seq{vql.Key("Name"), vql.Eq("b")})), input, []*item{input[0], last}},
+		{vql.SelectTyped(vql.Gt(1)), []interface{}{1, 2, 3}, []int{2, 3}},
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(test.query, test.input)
+		if err != nil {
+			t.Errorf("Eval %v: unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval %v: result (-want, +got):\n%s", test.query, diff)
+		}
+	}
+
+	// Typed results still report the locations of their elements.
+	pvs, err := vql.EvalPaths(vql.EachTyped(vql.Key("Name")), input)
+	if err != nil {
+		t.Fatalf("EvalPaths: unexpected error: %v", err)
+	} else if len(pvs) != 3 || pvs[1].Path.String() != "[1].Name" || pvs[1].Value != "b" {
+		t.Errorf("EvalPaths: got %+v, want 3 results with [1].Name=b", pvs)
+	}
+}
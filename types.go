@@ -0,0 +1,105 @@
+package vql
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// TypeIs returns a Query that reports whether its input has dynamic type T.
+// If T is an interface type, it reports whether the input implements T. A nil
+// input has no type, and TypeIs reports false for it.
+//
+// TypeIs is useful to filter a collection of values of mixed types, for
+// example:
+//
+//	vql.Select(vql.TypeIs[*Circle]())
+func TypeIs[T any]() Query { return typeIsQuery{reflect.TypeOf((*T)(nil)).Elem()} }
+
+type typeIsQuery struct{ t reflect.Type }
+
+func (q typeIsQuery) eval(v *value) (*value, error) {
+	return pushValue(v, hasType(v.val, q.t)), nil
+}
+
+// hasType reports whether obj has dynamic type t, or implements t if t is an
+// interface type.
+func hasType(obj interface{}, t reflect.Type) bool {
+	if obj == nil {
+		return false
+	} else if t.Kind() == reflect.Interface {
+		return reflect.TypeOf(obj).Implements(t)
+	}
+	return reflect.TypeOf(obj) == t
+}
+
+// AsType returns a Query that converts its input to type t. If the input is
+// assignable to t it is yielded unchanged; otherwise, if it is convertible to
+// t according to the rules of the Go language, it is converted. A nil input
+// yields the zero value of t. It is an error, wrapping ErrArgType, if the
+// input cannot be converted to t. Conversions of numbers to strings, which
+// are rarely intended, are not permitted.
+//
+// For example, AsType(reflect.TypeOf(0.0)) converts numbers of any type to
+// float64. AsType panics if t is nil.
+func AsType(t reflect.Type) Query {
+	if t == nil {
+		panic("asType: nil type")
+	}
+	return asTypeQuery{t}
+}
+
+type asTypeQuery struct{ t reflect.Type }
+
+func (q asTypeQuery) eval(v *value) (*value, error) {
+	rv := reflect.ValueOf(v.val)
+	switch {
+	case !rv.IsValid():
+		return pushValue(v, reflect.Zero(q.t).Interface()), nil
+	case rv.Type().AssignableTo(q.t):
+		return pushValue(v, v.val), nil
+	case canConvert(rv, q.t):
+		return pushValue(v, rv.Convert(q.t).Interface()), nil
+	}
+	return nil, fmt.Errorf("%w: %T cannot be converted to %v", ErrArgType, v.val, q.t)
+}
+
+// canConvert reports whether rv can be converted to t. Unlike the
+// ConvertibleTo method, it excludes conversions of numbers to strings, and
+// conversions of slices to array pointers that would panic.
+func canConvert(rv reflect.Value, t reflect.Type) bool {
+	if !rv.Type().ConvertibleTo(t) {
+		return false
+	} else if t.Kind() == reflect.String {
+		return !isNumberKind(rv.Kind())
+	} else if rv.Kind() == reflect.Slice && t.Kind() == reflect.Ptr {
+		return rv.Len() >= t.Elem().Len()
+	}
+	return true
+}
+
+// KindOf returns a Query that yields the name of the reflect.Kind of its
+// input, such as "int", "string", "slice", or "struct". The kind of a nil
+// input is "invalid".
+//
+// KindOf is useful to explore decoded data of unknown structure, or to filter
+// values by kind, for example:
+//
+//	vql.Select(vql.KindOf(), vql.Eq("map"))
+func KindOf() Query { return typeInfoQuery("kindOf") }
+
+// TypeName returns a Query that yields the name of the concrete type of its
+// input, as formatted by the %T verb of the fmt package, such as
+// "map[string]interface {}" or "*main.Node". The type name of a nil input is
+// "<nil>".
+func TypeName() Query { return typeInfoQuery("typeName") }
+
+// typeInfoQuery is a type introspection query, identified by the name of its
+// combinator.
+type typeInfoQuery string
+
+func (q typeInfoQuery) eval(v *value) (*value, error) {
+	if q == "kindOf" {
+		return pushValue(v, reflect.ValueOf(v.val).Kind().String()), nil
+	}
+	return pushValue(v, fmt.Sprintf("%T", v.val)), nil
+}
@@ -17,56 +17,259 @@
 // # Queries
 //
 // To fetch a named field from a struct, or the value from a map, use vql.Key.
-// You can supply multiple keys to do compound lookups.
+// You can supply multiple keys to do compound lookups. To check whether a
+// field or key is present, use vql.Exists. To list the keys, values, or
+// entries of a struct or map, use vql.Keys, vql.Vals, or vql.Entries. To
+// report an error for a missing field or key, use vql.KeyStrict. To look up
+// the first of several alternative keys, use vql.KeyOr. To match struct
+// fields by the names in their struct tags, use vql.TagKey. Fields promoted
+// from embedded structs are found by their own names. To read unexported
+// fields when debugging, pass the vql.AllowUnexported option to vql.EvalWith.
 //
-// To index into a slice of values, use vql.Index.
+// To index into a slice of values, use vql.Index. To select a range of
+// elements from a slice, use vql.Range, vql.Take, or vql.Skip. Queries over
+// arrays, slices, and maps follow pointers to the values they refer to, so
+// that for example vql.Index applies to a *[]string as well as a []string.
+// An integer vql.Key indexes a slice, and vql.Index looks up an integer key
+// in a map, so that either accepts a list or a map keyed by position. A
+// *sync.Map is treated as a map with interface keys, and the protobuf Struct,
+// ListValue, and Value types are unwrapped to the equivalent Go maps, slices,
+// and scalars. Other container types can support these queries by
+// implementing vql.Keyer and vql.Seqer.
 //
-// To walk sequentially into the structure of a value, use vql.Seq.
+// To walk sequentially into the structure of a value, use vql.Seq. For a
+// simple path of keys and indices, vql.Path is a convenient shorthand.
 //
-// To apply a subquery to the elements of a slice, use vql.Each.
+// To apply a subquery to the elements of a slice, use vql.Each, or vql.EachN to
+// evaluate the elements concurrently. To skip elements for which the subquery
+// fails, use vql.EachLenient, and to give the subquery the position of each
+// element as well as its value, use vql.EachIndexed. The entries of a map are
+// visited in the order of their keys, as described for vql.Entry.
 //
-// To filter the elements of a slice based on a subquery, use vql.Select.
+// To apply a subquery to every value nested inside a value, use vql.Descend.
 //
-// To extract subqueries from a value, use vql.Bind.
+// To filter the elements of a slice based on a subquery, use vql.Select, or
+// vql.First to find only the first matching element. To filter the entries of
+// a map and keep the result as a map, use vql.SelectMap. To collect the
+// results of Each or Select as a slice of their common type, such as
+// []string, use vql.EachTyped or vql.SelectTyped.
+// To transform the values or keys of a map, use vql.MapValues or vql.MapKeys.
 //
-// To apply a functional transformation to a value, use vql.As.
+// To count the elements of a slice, or to compute the sum, minimum, maximum,
+// or mean of subquery values over its elements, use vql.Count, vql.Sum,
+// vql.Min, vql.Max, or vql.Mean.
+//
+// To sort the elements of a slice, use vql.SortBy or vql.Sort. To group them
+// by the value of a subquery, use vql.GroupBy. To remove duplicates, use
+// vql.Distinct. To split a slice into groups of a fixed size, use vql.Chunk,
+// and to split it into the elements that do and do not satisfy a subquery,
+// use vql.Partition. To combine slices as sets, use vql.Union, vql.Intersect,
+// or vql.Difference, or their variants that compare elements by key.
+//
+// To check whether every, any, or no element of a slice satisfies a subquery,
+// use vql.Every (or its synonym vql.All), vql.Any, or vql.None. To combine the
+// results of predicate subqueries, use vql.Not, vql.And, or vql.OrBool.
+//
+// To check whether a value is in a range, use vql.Between or
+// vql.BetweenExclusive. To compare floating-point values with a tolerance, use
+// vql.EqApprox. To define how the values of a type are compared, use
+// vql.RegisterCompare. To check whether a value contains or is one of a set
+// of values, use vql.Contains or vql.In. To match a string against a regular
+// expression, use vql.Match, or vql.Capture to extract submatches. To match
+// it against a shell-style wildcard pattern, use vql.Glob.
+//
+// To extract named subqueries from a value, use vql.Map. To project a struct
+// or map down to some of its fields, use vql.Pick or vql.Omit. To construct
+// a nested document from the values of subqueries, use vql.Build.
+//
+// To combine numeric values arithmetically, use vql.Add, vql.Sub, vql.Mul,
+// vql.Div, or vql.Mod. To manipulate strings, use vql.ToLower, vql.ToUpper,
+// vql.TrimSpace, vql.Split, vql.JoinWith, vql.HasPrefix, or vql.HasSuffix. To
+// format values as a string, use vql.Format. To convert loosely-typed values
+// between numbers and strings, use vql.ToInt, vql.ToFloat, or vql.ToString.
+//
+// To apply a functional transformation to a value, use vql.Func.  To bind the
+// function at evaluation time instead, use vql.FuncRef with vql.EvalWithFuncs.
+// To pass additional arguments to the function, use vql.FuncArgs. To make
+// functions available by name to queries in text form, register them in a
+// vql.FuncRegistry and parse with vql.ParseFuncs. To call a method of a value,
+// use vql.Method.
+//
+// To test the dynamic type of a value, use vql.TypeIs, and to convert a value
+// to a specific type, use vql.AsType. To describe the type of a value, use
+// vql.KindOf or vql.TypeName.
 //
 // To construct a list of subquery values, use vql.List, or vql.Cat to flatten
-// list-valued subqueries.
+// list-valued subqueries. To flatten nested lists to any depth, use
+// vql.Flatten. To pair up the elements of parallel lists, use vql.Zip, and to
+// match the elements of two lists by key, as in a relational join, use
+// vql.Join.
+//
+// To select one of a sequence of subqueries to apply, use vql.Or. To select a
+// subquery based on the value of a discriminator, use vql.Switch. To supply a
+// value in place of a missing one, use vql.Default or vql.DefaultErr.
+//
+// To fail unless a value meets a requirement, use vql.Require or vql.NonNil,
+// and to fail unconditionally, use vql.Fail or vql.Failf. To check a value
+// against many requirements and report every failure, use vql.Validate.
+//
+// To bind the value of a subquery to a name for use later in a query, use
+// vql.Let, and to refer to it, use vql.Var.
+//
+// To cache the results of an expensive subquery, use vql.Memoize.
+//
+// To modify the contents of a value in place, use vql.Set, vql.Delete, or
+// vql.Update.
+//
+// To encode a query as JSON for storage, use vql.MarshalQuery, and to decode
+// it again, use vql.UnmarshalQuery. To inspect the structure of a query, use
+// vql.Walk.
+//
+// To compile a query from its text representation, use vql.Parse. Queries
+// format themselves in the same syntax when printed. The jsonpath subpackage
+// translates JSONPath expressions into queries. To prepare a query for
+// repeated evaluation on inputs of a known type, use vql.Compile, and to check
+// a query against a type without evaluating it, use vql.Check. To describe
+// the shape of the results of a query, use vql.InferShape.
+//
+// To bound the time spent evaluating a query, use vql.EvalContext. To bound
+// the work it does, use the vql.MaxSteps and vql.MaxDepth options. To find
+// where in the input the results of a query were found, use vql.EvalPaths.
+// To log the steps of an evaluation for debugging, use the vql.Trace option.
+// To be notified as each query is evaluated, for example to collect metrics,
+// implement a vql.EvalObserver and use the vql.Observe option.
 //
-// To select one of a sequence of subqueries to apply, use vql.Or.
+// To leave a constant in a query to be supplied at evaluation time, use
+// vql.Param, and supply its value with the vql.Args option to vql.EvalWith.
+// To traverse into JSON blobs embedded in the input as json.RawMessage
+// values, use the vql.DecodeRawJSON option.
 //
-// TODO: Add more descriptive errors.
+// To evaluate a query and convert its result to a specific type, use
+// vql.EvalAs, or vql.CollectAs to convert each element of a slice result. To
+// decode the result into a typed value, such as a struct, use vql.EvalInto.
+// To evaluate a query on a reflect.Value, and get back the location of its
+// result in the input, use vql.EvalValue.
+//
+// To run queries from a text/template or html/template template, add the
+// functions returned by vql.TemplateFuncs to the template. To serve queries
+// over a document from an HTTP endpoint, use the httpquery subpackage.
+//
+// To decode a JSON document and evaluate a query on it in one step, use
+// vql.EvalJSON. The yaml subpackage provides the same for YAML documents,
+// and the csv subpackage adapts tables in CSV format to be queried by row.
+// The sqlrows subpackage reads the results of a database/sql query as a slice
+// of vql.Values for further processing.
+//
+// # Errors
+//
+// When evaluation of a query fails, Eval reports an error of concrete type
+// *vql.Error, which records the steps of the query leading to the failure.
+// Where applicable, the underlying error wraps one of the sentinel errors
+// defined by this package, such as ErrNotStruct or ErrBadIndex.
 package vql
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
 )
 
 // Eval evaluates q starting from v, and returns the object described.
 func Eval(q Query, v interface{}) (interface{}, error) {
-	result, err := q.eval(newValue(v))
+	return evalEnv(q, v, &env{ctx: context.Background(), memo: true})
+}
+
+// EvalContext evaluates q starting from v, as Eval. If ctx ends before
+// evaluation is complete, evaluation stops and EvalContext reports an error
+// wrapping the error from ctx. Functions applied by Func that accept a
+// context.Context receive ctx.
+func EvalContext(ctx context.Context, q Query, v interface{}) (interface{}, error) {
+	return evalEnv(q, v, &env{ctx: ctx})
+}
+
+// EvalWithFuncs evaluates q starting from v, as Eval, using fns to resolve the
+// names of functions referenced by FuncRef queries in q. Each value in fns
+// must be a function with a signature accepted by Func.
+func EvalWithFuncs(q Query, v interface{}, fns map[string]interface{}) (interface{}, error) {
+	return evalEnv(q, v, &env{ctx: context.Background(), funcs: prepareFuncs(fns)})
+}
+
+func evalEnv(q Query, v interface{}, e *env) (interface{}, error) {
+	result, err := evalValue(q, v, e)
 	if err != nil {
 		return nil, err
 	}
 	return result.val, nil
 }
 
+func evalValue(q Query, v interface{}, e *env) (*value, error) {
+	result, err := evalQuery(q, newValue(v, e))
+	if _, ok := err.(*Error); ok {
+		return nil, err
+	} else if err != nil {
+		return nil, wrapError([]Query{q}, v, err)
+	}
+	return result, nil
+}
+
 // A value carries a value through a query, encapsulating the current state of
 // query expansion (val) and the parent value from which it was produced.  The
-// initial input to a query has parent == nil.
+// initial input to a query has parent == nil. All the values produced during a
+// single evaluation share the same env. The vars of a value are the variables
+// bound by Let in the scope where it was produced.
+//
+// When evaluation is recording locations for EvalPaths, path is the location
+// of the value in the input, and if the value is a slice of elements of the
+// input, elems gives the location of each element.
 type value struct {
 	val    interface{}
 	parent *value
+	env    *env
+	vars   *binding
+	path   *pathStep
+	elems  []*pathStep
+}
+
+// A binding associates a value with a variable name. Bindings form a chain,
+// with inner bindings shadowing outer bindings of the same name.
+type binding struct {
+	name string
+	val  interface{}
+	next *binding
+}
+
+// An env carries settings that apply to a single evaluation of a query.
+type env struct {
+	ctx   context.Context        // governs the lifetime of evaluation
+	funcs map[string]envFunc     // if non-nil, the functions available to FuncRef
+	args  map[string]interface{} // parameter values available to Param
+	paths bool                   // record the locations of values
+	errs  *errorLog              // if non-nil, records errors skipped by EachLenient
+
+	memo       bool // results depend only on inputs, and may be shared by Memoize
+	unexported bool // allow Key to read unexported struct fields
+	rawJSON    bool // decode raw JSON values reached by traversal
+	cycles     bool // detect cycles in the input of Descend
+
+	observers []EvalObserver // notified of the evaluation of each query
+
+	limits *evalLimits // if non-nil, limits on the cost of evaluation
+	depth  int         // the current depth of nesting, if limits != nil
 }
 
 // newValue constructs a value for obj with no parent.
-func newValue(obj interface{}) *value { return &value{val: obj} }
+func newValue(obj interface{}, e *env) *value { return &value{val: e.unwrap(obj), env: e} }
 
 // pushValue constructs a new value for obj with v as its parent.
 func pushValue(v *value, obj interface{}) *value {
-	return &value{val: obj, parent: v}
+	return &value{val: obj, parent: v, env: v.env, vars: v.vars, path: v.path}
 }
 
 // A Query evalutes a query starting at the specified value, returning the
@@ -83,11 +286,17 @@ type selfQuery struct{}
 func (selfQuery) eval(v *value) (*value, error) { return v, nil }
 
 // Const returns a Query whose value is the fixed constant obj.
-func Const(obj interface{}) Query { return constQuery{newValue(obj)} }
+func Const(obj interface{}) Query { return constQuery{obj} }
 
-type constQuery struct{ *value }
+type constQuery struct{ obj interface{} }
 
-func (c constQuery) eval(v *value) (*value, error) { return c.value, nil }
+func (c constQuery) eval(v *value) (*value, error) {
+	obj, err := resolveArg(v, c.obj)
+	if err != nil {
+		return nil, err
+	}
+	return pushValue(v, obj), nil
+}
 
 // Seq is a Query that sequentially composes other Queries.  An empty Seq
 // yields its input unmodified; otherwise the result from the first Query is
@@ -95,20 +304,51 @@ func (c constQuery) eval(v *value) (*value, error) { return c.value, nil }
 type Seq []Query
 
 func (s Seq) eval(v *value) (*value, error) {
-	for _, elt := range s {
-		next, err := elt.eval(v)
-		if err != nil {
-			return v, err
+	for i, elt := range s {
+		if err := v.env.ctx.Err(); err != nil {
+			return v, wrapError(s[:i], v.val, err)
+		}
+		next, err := s.evalStep(elt, v)
+		if _, ok := err.(*Error); ok {
+			return v, wrapError(s[:i], v.val, err)
+		} else if err != nil {
+			return v, wrapError(s[:i+1], v.val, err)
 		}
 		v = next
 	}
 	return v, nil
 }
 
+// evalStep evaluates a step of s on v. The steps of a Seq of more than one
+// step are observed; a single step is observed as the Seq itself.
+func (s Seq) evalStep(elt Query, v *value) (*value, error) {
+	if len(s) == 1 {
+		return elt.eval(v)
+	}
+	return evalQuery(elt, v)
+}
+
 // Key returns a Query that returns the value of the specified sequence of
 // field lookups on a struct, or entry in a map. The result is nil if no such
 // field or key exists. It is an error if the value type is not a struct or a
 // map with a compatible key type.
+//
+// A struct field is found by its name as in a Go selector expression, so the
+// fields of an embedded struct are found by their own names when they are not
+// shadowed, as well as through the name of the embedded field. A field
+// reached through a nil embedded pointer is treated as missing, as is an
+// unexported field unless the AllowUnexported option is given. The fields of
+// a protobuf message may also be named by their proto names, such as
+// "user_name", or by their generated Go names, as found by the protoreflect
+// API of the message; a oneof member other than the one that is set is
+// treated as missing. Messages, lists, and maps found this way are converted
+// to the generated message type, []interface{}, and map[interface{}]interface{}.
+//
+// An integer key applied to an array or slice selects the element at that
+// offset, as Index does, except that an offset out of range is treated as a
+// missing key. Together with Index, which looks up integer keys in a map,
+// this allows a query to accept data that may be either a list or a map with
+// integer keys, as often happens with decoded YAML.
 func Key(keys ...interface{}) Query {
 	q := make(Seq, len(keys))
 	for i, key := range keys {
@@ -117,31 +357,290 @@ func Key(keys ...interface{}) Query {
 	return q
 }
 
+// KeyStrict returns a Query that returns the value of the specified sequence
+// of field lookups on a struct, or entry in a map, as Key. Unlike Key, it is
+// an error wrapping ErrNoKey if no such field or key exists.
+func KeyStrict(keys ...interface{}) Query {
+	q := make(Seq, len(keys))
+	for i, key := range keys {
+		q[i] = keyQuery{key: key, strict: true}
+	}
+	return q
+}
+
 type keyQuery struct {
-	key interface{}
+	key    interface{}
+	strict bool // report an error if key is not found
 }
 
 func (k keyQuery) eval(v *value) (*value, error) {
-	rv := reflect.Indirect(reflect.ValueOf(v.val))
-	var f reflect.Value
+	key, err := resolveArg(v, k.key)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := genericMap(v.val); ok {
+		if s, ok := key.(string); ok {
+			elt, ok := m[s]
+			if !ok && k.strict {
+				return nil, fmt.Errorf("%w: %#v", ErrNoKey, key)
+			}
+			return v.child(keyQuery{key: key}, elt), nil
+		}
+	}
+	if rv, i, ok := keyOffset(v.val, key); ok {
+		if i < 0 {
+			i += rv.Len()
+		}
+		if i >= 0 && i < rv.Len() {
+			return v.elem(i, rv.Index(i).Interface()), nil
+		} else if k.strict {
+			return nil, fmt.Errorf("%w: %#v", ErrNoKey, key)
+		}
+		return v.child(keyQuery{key: key}, nil), nil
+	}
+	f, err := lookupKey(v.val, key, v.env.unexported)
+	if err != nil {
+		return nil, err
+	} else if !f.IsValid() {
+		if k.strict {
+			return nil, fmt.Errorf("%w: %#v", ErrNoKey, key)
+		}
+		return v.child(keyQuery{key: key}, nil), nil
+	}
+	return v.child(keyQuery{key: key}, f.Interface()), nil
+}
+
+// keyOffset reports whether key is an integer and obj is an array, slice, or
+// Seqer that is not a Keyer, and if so returns the sequence and the value of
+// key as an offset.
+func keyOffset(obj, key interface{}) (reflect.Value, int, bool) {
+	kv := reflect.ValueOf(key)
+	if !isIntLike(kv.Kind()) && !isUintLike(kv.Kind()) {
+		return reflect.Value{}, 0, false
+	} else if _, ok := obj.(Keyer); ok {
+		return reflect.Value{}, 0, false
+	}
+	rv, err := seqValue(obj)
+	if err != nil {
+		return reflect.Value{}, 0, false
+	}
+	return rv, int(toInt64(kv)), true
+}
+
+// lookupKey returns the value of the field or map entry of obj named by key.
+// The result is invalid if no such field or entry exists. Unexported fields
+// are visible only if unexported is true.
+func lookupKey(obj, key interface{}, unexported bool) (reflect.Value, error) {
+	if k, ok := obj.(Keyer); ok {
+		val, ok := k.QueryKey(key)
+		if !ok {
+			return reflect.Value{}, nil
+		}
+		return reflect.ValueOf(&val).Elem(), nil
+	}
+	if m, ok := obj.(*sync.Map); ok {
+		if key != nil && !reflect.TypeOf(key).Comparable() {
+			return reflect.Value{}, fmt.Errorf("%w: value of type %T cannot be a key in a sync.Map", ErrBadKey, key)
+		}
+		val, ok := m.Load(key)
+		if !ok {
+			return reflect.Value{}, nil
+		}
+		return reflect.ValueOf(&val).Elem(), nil
+	}
+	rv := reflect.Indirect(reflect.ValueOf(obj))
 	if rv.Kind() == reflect.Struct {
-		if s, ok := k.key.(string); ok {
-			f = rv.FieldByName(s)
-		} else {
-			return nil, fmt.Errorf("value of type %T cannot be a field name", k.key)
+		if s, ok := key.(string); ok {
+			return structField(rv, s, unexported), nil
 		}
+		return reflect.Value{}, fmt.Errorf("%w: value of type %T cannot be a field name", ErrBadKey, key)
 	} else if rv.Kind() == reflect.Map {
-		if !reflect.TypeOf(k.key).AssignableTo(rv.Type().Key()) {
-			return nil, fmt.Errorf("value of type %T cannot be a key in this map", k.key)
+		if key == nil {
+			// Only a map with interface keys can have a nil key.
+			if rv.Type().Key().Kind() != reflect.Interface {
+				return reflect.Value{}, nil
+			}
+			return rv.MapIndex(reflect.Zero(rv.Type().Key())), nil
+		} else if !reflect.TypeOf(key).AssignableTo(rv.Type().Key()) {
+			return reflect.Value{}, fmt.Errorf("%w: value of type %T cannot be a key in this map", ErrBadKey, key)
 		}
-		f = rv.MapIndex(reflect.ValueOf(k.key))
-	} else {
-		return nil, fmt.Errorf("value of type %T is not a struct or map", v.val)
+		return rv.MapIndex(reflect.ValueOf(key)), nil
 	}
-	if !f.IsValid() {
-		return pushValue(v, nil), nil
+	return reflect.Value{}, fmt.Errorf("value of type %T is %w", obj, ErrNotStruct)
+}
+
+// fieldCache maps a fieldKey to the result of looking up that field, as a
+// *cachedField, so that repeated lookups of a field on values of the same
+// type do not search the fields of the struct each time.
+var fieldCache sync.Map
+
+type fieldKey struct {
+	t    reflect.Type
+	name string
+}
+
+type cachedField struct {
+	f  reflect.StructField
+	ok bool
+}
+
+// fieldByName returns the field of the struct type t with the given name, as
+// t.FieldByName does, using and updating the shared cache of previous results.
+// The caller must not modify the Index of the field.
+func fieldByName(t reflect.Type, name string) (reflect.StructField, bool) {
+	key := fieldKey{t: t, name: name}
+	if c, ok := fieldCache.Load(key); ok {
+		return c.(*cachedField).f, c.(*cachedField).ok
+	}
+	f, ok := t.FieldByName(name)
+	fieldCache.Store(key, &cachedField{f: f, ok: ok})
+	return f, ok
+}
+
+// structField returns the value of the field of rv with the given name, which
+// may be promoted from an embedded struct. The result is invalid if there is
+// no such field, if it is reached through a nil embedded pointer, or if it is
+// not exported and unexported is false. A field of type sync.Map is returned
+// as a pointer when possible, so that it is not copied.
+func structField(rv reflect.Value, name string, unexported bool) reflect.Value {
+	f, ok := fieldByName(rv.Type(), name)
+	if !ok {
+		if isProtoStruct(rv.Type()) {
+			return protoField(rv, name)
+		}
+		return reflect.Value{}
+	}
+	fv, err := rv.FieldByIndexErr(f.Index)
+	if err == nil && f.Type == syncMapType && fv.CanAddr() && fv.CanInterface() {
+		return fv.Addr()
+	} else if err != nil || fv.CanInterface() {
+		return fv // err != nil means a nil embedded pointer
+	} else if !unexported {
+		return reflect.Value{}
+	}
+	if !rv.CanAddr() {
+		// Copy the struct so that its fields have addresses.
+		cp := reflect.New(rv.Type()).Elem()
+		cp.Set(rv)
+		fv, _ = cp.FieldByIndexErr(f.Index)
+	}
+	return reflect.NewAt(fv.Type(), unsafe.Pointer(fv.UnsafeAddr())).Elem()
+}
+
+// KeyOr returns a Query that returns the value of the first of the given
+// alternative keys that is present as a field of a struct or an entry in a
+// map. The result is nil if none of the keys is present. A key whose type is
+// not compatible with the value is treated as absent. It is an error if the
+// value is not a struct or map.
+//
+// For example, KeyOr("userName", "user_name") finds a user name in records
+// that spell the key either way.
+func KeyOr(keys ...interface{}) Query { return keyOrQuery(keys) }
+
+type keyOrQuery []interface{}
+
+func (k keyOrQuery) eval(v *value) (*value, error) {
+	if kind := reflect.Indirect(reflect.ValueOf(v.val)).Kind(); kind != reflect.Struct && kind != reflect.Map {
+		return nil, fmt.Errorf("value of type %T is %w", v.val, ErrNotStruct)
+	}
+	for _, key := range k {
+		if f, err := lookupKey(v.val, key, v.env.unexported); err == nil && f.IsValid() {
+			return v.child(keyQuery{key: key}, f.Interface()), nil
+		}
 	}
-	return pushValue(v, f.Interface()), nil
+	return pushValue(v, nil), nil
+}
+
+// TagKey returns a Query that returns the value of the specified sequence of
+// field lookups on a struct, or entry in a map, as Key, except that a struct
+// field is matched by the name given in its struct tag for the specified tag
+// key, as encoding/json and similar packages do. For example, with tag
+// "json", the key "user_id" matches a field tagged `json:"user_id"`. A field
+// whose tag does not specify a name is matched by its Go name, and a field
+// whose tag name is "-" is never matched.
+func TagKey(tag string, keys ...interface{}) Query {
+	q := make(Seq, len(keys))
+	for i, key := range keys {
+		q[i] = tagKeyQuery{tag: tag, key: key}
+	}
+	return q
+}
+
+type tagKeyQuery struct {
+	tag string
+	key interface{}
+}
+
+func (k tagKeyQuery) eval(v *value) (*value, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v.val))
+	if rv.Kind() != reflect.Struct {
+		return keyQuery{key: k.key}.eval(v)
+	}
+	name, ok := k.key.(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: value of type %T cannot be a field name", ErrBadKey, k.key)
+	}
+	for _, f := range reflect.VisibleFields(rv.Type()) {
+		if f.PkgPath != "" || tagName(f, k.tag) != name {
+			continue
+		}
+		fv, err := rv.FieldByIndexErr(f.Index)
+		if err != nil {
+			break // a nil embedded pointer; treat the field as missing
+		}
+		return v.child(k, fv.Interface()), nil
+	}
+	return v.child(k, nil), nil
+}
+
+// tagName returns the name of field f according to the struct tag with the
+// given key, or "" if the field is not visible by that name.
+func tagName(f reflect.StructField, key string) string {
+	tag, ok := f.Tag.Lookup(key)
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		tag = tag[:i]
+	}
+	if tag == "-" {
+		return ""
+	} else if tag != "" {
+		return tag
+	} else if f.Anonymous && !ok {
+		// An untagged embedded field is visible only through its fields.
+		if t := f.Type; t.Kind() == reflect.Struct || (t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct) {
+			return ""
+		}
+	}
+	return f.Name
+}
+
+// Exists returns a Query that yields true if the specified sequence of field
+// lookups on a struct, or entries in a map, is present, and false otherwise.
+// Unlike Key, which yields nil for both, Exists distinguishes a missing field
+// or key from one whose value is nil. The result is false if a value along
+// the path is nil. As with Key, it is an error if a non-nil value along the
+// path is not a struct or a map with a compatible key type.
+func Exists(path ...interface{}) Query { return existsQuery(path) }
+
+type existsQuery []interface{}
+
+func (e existsQuery) eval(v *value) (*value, error) {
+	cur := v.val
+	for _, key := range e {
+		if cur == nil {
+			return pushValue(v, false), nil
+		}
+		f, err := lookupKey(cur, key, v.env.unexported)
+		if err != nil {
+			return nil, err
+		} else if !f.IsValid() {
+			return pushValue(v, false), nil
+		}
+		cur = f.Interface()
+		if rv := reflect.ValueOf(cur); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			cur = nil
+		}
+	}
+	return pushValue(v, true), nil
 }
 
 // Each returns a Query that applies q to each element of an array, slice, or
@@ -154,17 +653,100 @@ type mapQuery struct{ Query }
 
 func (m mapQuery) eval(v *value) (*value, error) {
 	var vs []interface{}
-	err := forEach(v.val, func(obj interface{}) error {
-		next, err := m.Query.eval(pushValue(v, obj))
+	var elems []*pathStep
+	err := forEachValue(v, func(elt *value) error {
+		next, err := evalQuery(m.Query, elt)
+		if err == nil {
+			vs = append(vs, next.val)
+			elems = v.appendElem(elems, next.path)
+		}
+		return err
+	})
+	return pushValue(v, vs).withElems(elems), err
+}
+
+// EachLenient returns a Query that applies q to each element of an array,
+// slice, or map, as Each does, but skips any element for which q reports an
+// error. The result is a slice of type []interface{} containing the values
+// for the remaining elements, in order. It is still an error if the input is
+// not a collection, or if evaluation is cancelled by its context. To find out
+// which elements were skipped and why, use the CollectErrors option.
+//
+// EachLenient is useful for collections of mixed values, where a few elements
+// not having the expected structure should not prevent processing the rest.
+func EachLenient(q Query) Query { return lenientMapQuery{q} }
+
+type lenientMapQuery struct{ Query }
+
+func (m lenientMapQuery) eval(v *value) (*value, error) {
+	vs := []interface{}{}
+	var elems []*pathStep
+	err := forEachValue(v, func(elt *value) error {
+		next, err := evalQuery(m.Query, elt)
+		if err == nil {
+			vs = append(vs, next.val)
+			elems = v.appendElem(elems, next.path)
+		} else if cerr := v.env.ctx.Err(); cerr != nil {
+			return cerr
+		} else if v.env.errs != nil {
+			path := []Query(elt.path.seq())
+			if _, ok := err.(*Error); !ok {
+				path = append(path, m.Query)
+			}
+			v.env.errs.add(wrapError(path, elt.val, err))
+		}
+		return nil
+	})
+	return pushValue(v, vs).withElems(elems), err
+}
+
+// EachIndexed returns a Query that applies q to each element of an array,
+// slice, or map, as Each does, but gives q inputs of concrete type Entry that
+// record the position of each element as well as its value. For an array or
+// slice, the Key of each Entry is the offset of the element, as an int; for a
+// map, it is the key of the entry.
+//
+// For example, EachIndexed(List{Key("Key"), Key("Value", "Name")}) yields a
+// list of [offset, name] pairs for a slice of structs.
+func EachIndexed(q Query) Query { return indexedMapQuery{q} }
+
+type indexedMapQuery struct{ Query }
+
+func (m indexedMapQuery) eval(v *value) (*value, error) {
+	isMap := isMapValue(v.val)
+	var vs []interface{}
+	var elems []*pathStep
+	i := 0
+	err := forEachValue(v, func(elt *value) error {
+		in := elt
+		if !isMap {
+			in = pushValue(elt, Entry{Key: i, Value: elt.val})
+			i++
+		}
+		next, err := evalQuery(m.Query, in)
 		if err == nil {
 			vs = append(vs, next.val)
+			elems = v.appendElem(elems, next.path)
 		}
 		return err
 	})
-	return pushValue(v, vs), err
+	return pushValue(v, vs).withElems(elems), err
 }
 
 // Entry is the concrete type of input values to a selector query for a map.
+//
+// Queries that iterate over a map, such as Each, Select, and Descend, visit
+// its entries in a deterministic order of their keys. Keys that are strings,
+// numbers, or bools are visited in increasing order, with false before true.
+// Keys of interface type are ordered by the kinds of their values, with nil
+// first, followed by bools, numbers, and strings, each in increasing order,
+// and values of other kinds last. The order of other keys is unspecified.
+//
+// A *sync.Map is treated as a map with interface keys: Key loads an entry by
+// its key, and Each, Select, Count, Entries, and similar queries visit a
+// snapshot of its entries taken by its Range method, in the order described
+// above. A struct field of type sync.Map is yielded as a *sync.Map when the
+// struct is addressable, as it is when reached through a pointer.
 type Entry struct {
 	Key, Value interface{}
 }
@@ -182,18 +764,156 @@ type selectQuery struct {
 
 func (s selectQuery) eval(v *value) (*value, error) {
 	var vs []interface{}
-	err := forEach(v.val, func(obj interface{}) error {
-		v, err := s.Query.eval(newValue(obj))
+	var elems []*pathStep
+	err := forEachValue(v, func(elt *value) error {
+		v, err := evalQuery(s.Query, elt)
 		if err != nil {
 			return err
 		} else if keep, ok := v.val.(bool); !ok {
-			return fmt.Errorf("select query yielded %T, not bool", v.val)
+			return fmt.Errorf("select query yielded %T, %w", v.val, ErrNotBool)
 		} else if keep {
-			vs = append(vs, obj) // N.B. keep the subquery input, not the result
+			vs = append(vs, elt.val) // N.B. keep the subquery input, not the result
+			elems = v.appendElem(elems, elt.path)
+		}
+		return nil
+	})
+	return pushValue(v, vs).withElems(elems), err
+}
+
+// First returns a Query that evaluates q for each element of an array, slice,
+// or map in order, and yields the first element for which the value of q is
+// true. Evaluation stops as soon as such an element is found. The result is
+// nil if there is no such element. It is an error if q does not yield a bool.
+// If the input value is a map, the selector is given inputs of concrete type
+// Entry.
+func First(q ...Query) Query { return firstQuery{Seq(q)} }
+
+type firstQuery struct{ Query }
+
+func (f firstQuery) eval(v *value) (*value, error) {
+	var found *value
+	err := forEachValue(v, func(elt *value) error {
+		w, err := evalQuery(f.Query, elt)
+		if err != nil {
+			return err
+		} else if ok, isBool := w.val.(bool); !isBool {
+			return fmt.Errorf("first query yielded %T, %w", w.val, ErrNotBool)
+		} else if ok {
+			found = elt
+			return errStop
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		return nil, err
+	} else if found == nil {
+		return pushValue(v, nil), nil
+	}
+	return pushResult(v, found), nil
+}
+
+// Every returns a Query that evaluates q for each element of an array, slice,
+// or map, and yields true if q yields true for every element. The result is
+// true for an empty input. Evaluation stops at the first element for which q
+// yields false. It is an error if q does not yield a bool. If the input value
+// is a map, q is given inputs of concrete type Entry.
+func Every(q Query) Query { return quantQuery{Query: q, stopOn: false, name: "every"} }
+
+// Any returns a Query that evaluates q for each element of an array, slice, or
+// map, and yields true if q yields true for at least one element. The result
+// is false for an empty input. Evaluation stops at the first element for which
+// q yields true. It is an error if q does not yield a bool. If the input value
+// is a map, q is given inputs of concrete type Entry.
+func Any(q Query) Query { return quantQuery{Query: q, stopOn: true, name: "any"} }
+
+// All returns a Query that reports whether q yields true for every element of
+// an array, slice, or map. It is a synonym for Every.
+func All(q Query) Query { return Every(q) }
+
+// None returns a Query that evaluates q for each element of an array, slice,
+// or map, and yields true if q yields true for no element. The result is true
+// for an empty input. Evaluation stops at the first element for which q yields
+// true. It is an error if q does not yield a bool. If the input value is a
+// map, q is given inputs of concrete type Entry.
+func None(q Query) Query { return quantQuery{Query: q, stopOn: true, invert: true, name: "none"} }
+
+type quantQuery struct {
+	Query
+	stopOn bool   // stop when q yields this value
+	invert bool   // negate the result
+	name   string // for diagnostics
+}
+
+func (s quantQuery) eval(v *value) (*value, error) {
+	found := false
+	err := forEach(v, func(obj interface{}) error {
+		w, err := evalQuery(s.Query, pushValue(v, obj))
+		if err != nil {
+			return err
+		} else if ok, isBool := w.val.(bool); !isBool {
+			return fmt.Errorf("%s query yielded %T, %w", s.name, w.val, ErrNotBool)
+		} else if ok == s.stopOn {
+			found = true
+			return errStop
 		}
 		return nil
 	})
-	return pushValue(v, vs), err
+	if err != nil && err != errStop {
+		return nil, err
+	}
+	return pushValue(v, (found == s.stopOn) != s.invert), nil
+}
+
+// Not returns a Query that evaluates q on its input and yields the logical
+// negation of its result. It is an error if q does not yield a bool.
+func Not(q Query) Query { return notQuery{q} }
+
+type notQuery struct{ Query }
+
+func (n notQuery) eval(v *value) (*value, error) {
+	w, err := evalQuery(n.Query, v)
+	if err != nil {
+		return nil, err
+	} else if ok, isBool := w.val.(bool); !isBool {
+		return nil, fmt.Errorf("not query yielded %T, %w", w.val, ErrNotBool)
+	} else {
+		return pushValue(v, !ok), nil
+	}
+}
+
+// And returns a Query that evaluates each of qs on its input in order, and
+// yields true if all of them yield true. The result is true if no queries are
+// given. Evaluation stops at the first query that yields false. It is an error
+// if a query does not yield a bool.
+func And(qs ...Query) Query { return logicQuery{qs: qs, stopOn: false, name: "and"} }
+
+// OrBool returns a Query that evaluates each of qs on its input in order, and
+// yields true if any of them yields true. The result is false if no queries
+// are given. Evaluation stops at the first query that yields true. It is an
+// error if a query does not yield a bool.
+//
+// Unlike Or, which selects among the values of its subqueries, OrBool
+// combines the results of predicates.
+func OrBool(qs ...Query) Query { return logicQuery{qs: qs, stopOn: true, name: "orBool"} }
+
+type logicQuery struct {
+	qs     []Query
+	stopOn bool   // stop when a query yields this value
+	name   string // for diagnostics
+}
+
+func (l logicQuery) eval(v *value) (*value, error) {
+	for _, q := range l.qs {
+		w, err := evalQuery(q, v)
+		if err != nil {
+			return nil, err
+		} else if ok, isBool := w.val.(bool); !isBool {
+			return nil, fmt.Errorf("%s query yielded %T, %w", l.name, w.val, ErrNotBool)
+		} else if ok == l.stopOn {
+			return pushValue(v, l.stopOn), nil
+		}
+	}
+	return pushValue(v, !l.stopOn), nil
 }
 
 // Values represents the values bound by application of a Map query.
@@ -209,9 +929,9 @@ type Map map[string]Query
 func (m Map) eval(v *value) (*value, error) {
 	result := make(Values)
 	for key, q := range m {
-		val, err := q.eval(v)
+		val, err := evalQuery(q, v)
 		if err != nil {
-			return nil, fmt.Errorf("evaluating subquery %q: %v", key, err)
+			return nil, fmt.Errorf("evaluating subquery %q: %w", key, err)
 		}
 		result[key] = val.val
 	}
@@ -223,41 +943,365 @@ func (m Map) eval(v *value) (*value, error) {
 //
 //	func(T) U
 //	func(T) (U, error)
+//	func(context.Context, T) U
+//	func(context.Context, T) (U, error)
+//
+// Otherwise, Func will panic. If v reports an error, that error is propagated
+// through the query chain. If v panics, evaluation recovers and reports an
+// error wrapping a *PanicError. If v accepts a context.Context, it is passed
+// the context governing the evaluation (see EvalContext).
 //
-// Otherwise, Func will panic. If v has the second form and reports an error,
-// that error is propagated through the query chain.
+// The input must be assignable to T, or it is an error wrapping ErrArgType.
+// If T is an interface type, an input whose pointer type implements T, but
+// which is not itself a pointer, is passed as a pointer to a copy of the
+// input. A nil input is passed as the zero value of T.
 func Func(v interface{}) Query {
+	q, err := newFnQuery(v, nil)
+	if err != nil {
+		panic("func: " + err.Error())
+	}
+	return q
+}
+
+// FuncArgs returns a Query whose value is the result of applying the function
+// v to its input, followed by the additional arguments args. The value of v
+// must be a function whose signature is one of those accepted by Func, with
+// additional parameters after the input parameter, for example:
+//
+//	func(T, A, B) U
+//	func(context.Context, T, A, B) (U, error)
+//	func(T, ...A) U
+//
+// The args supply the additional parameters, as the arguments of Method do:
+// Numeric arguments are converted to the corresponding parameter type, and
+// other arguments must be assignable to it. FuncArgs panics if v does not have
+// such a signature, or if args do not match its additional parameters.
+//
+// For example, given
+//
+//	func truncate(s string, n int) string
+//
+// the query FuncArgs(truncate, 10) truncates its input to 10 bytes.
+func FuncArgs(v interface{}, args ...interface{}) Query {
+	q, err := newFnQuery(v, args)
+	if err != nil {
+		panic("funcArgs: " + err.Error())
+	}
+	return q
+}
+
+// newFnQuery constructs a fnQuery for v with the additional arguments args,
+// or reports an error if v does not have a signature accepted by FuncArgs.
+func newFnQuery(v interface{}, args []interface{}) (fnQuery, error) {
 	fn := reflect.ValueOf(v)
+	if fn.Kind() != reflect.Func {
+		return fnQuery{}, errors.New("value is not a function")
+	}
 	t := fn.Type()
+	params := make([]reflect.Type, t.NumIn())
+	for i := range params {
+		params[i] = t.In(i)
+	}
+	hasCtx := len(params) > 1 && params[0] == ctxType
+	if hasCtx {
+		params = params[1:]
+	}
 	switch {
-	case t.Kind() != reflect.Func:
-		panic("func: value is not a function")
-	case t.NumIn() != 1:
-		panic("func: wrong number of arguments")
+	case len(params) == 0:
+		return fnQuery{}, errors.New("wrong number of arguments")
 	case t.NumOut() < 1, t.NumOut() > 2:
-		panic("func: wrong number of returns")
+		return fnQuery{}, errors.New("wrong number of returns")
 	case t.NumOut() == 2 && t.Out(1) != errType:
-		panic("func: last return value is not error")
+		return fnQuery{}, errors.New("last return value is not error")
+	}
+
+	// The parameters after the input are supplied by args. If fn is
+	// variadic and its input is the variadic parameter, the input is passed
+	// as the slice of variadic arguments.
+	rest := params[1:]
+	variadic := t.IsVariadic() && len(rest) != 0
+	if n := len(rest); len(args) < n-1 || (!variadic && len(args) != n) {
+		return fnQuery{}, fmt.Errorf("wrong number of arguments (%d)", len(args))
 	}
-	return fnQuery{fn: fn, argType: t.In(0)}
+	var extra []reflect.Value
+	for i, arg := range args {
+		var pt reflect.Type
+		if last := len(rest) - 1; i >= last && variadic {
+			pt = rest[last].Elem()
+		} else {
+			pt = rest[i]
+		}
+		av, err := argValue(arg, pt)
+		if err != nil {
+			return fnQuery{}, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		extra = append(extra, av)
+	}
+	return fnQuery{
+		fn:      fn,
+		argType: params[0],
+		hasCtx:  hasCtx,
+		spread:  t.IsVariadic() && !variadic,
+		extra:   extra,
+	}, nil
 }
 
-var errType = reflect.TypeOf((*error)(nil)).Elem()
+var (
+	errType = reflect.TypeOf((*error)(nil)).Elem()
+	ctxType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
 
 type fnQuery struct {
 	fn      reflect.Value
 	argType reflect.Type
+	hasCtx  bool            // whether fn accepts a context.Context
+	spread  bool            // whether the input is the variadic parameter of fn
+	extra   []reflect.Value // additional arguments following the input
 }
 
 func (a fnQuery) eval(v *value) (*value, error) {
-	arg := reflect.ValueOf(v.val)
-	if !arg.IsValid() {
-		arg = reflect.New(a.argType).Elem()
-	} else if !arg.Type().AssignableTo(a.argType) {
-		return nil, fmt.Errorf("argument %T is not assignable to %v", v.val, a.argType)
-	}
-	res := a.fn.Call([]reflect.Value{arg})
-	if len(res) == 2 {
+	arg, err := fnArg(v.val, a.argType)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]reflect.Value, 0, 2+len(a.extra))
+	if a.hasCtx {
+		args = append(args, reflect.ValueOf(v.env.ctx))
+	}
+	args = append(append(args, arg), a.extra...)
+	res, err := callFunc(a.fn, a.spread, args, func() string { return funcName(a.fn) })
+	if err != nil {
+		return nil, err
+	} else if len(res) == 2 {
+		if err := res[1].Interface(); err != nil {
+			return nil, err.(error)
+		}
+	}
+	return pushValue(v, res[0].Interface()), nil
+}
+
+// fnArg converts obj to an argument of type t for a function applied by Func.
+// A nil obj is converted to the zero value of t. If t is an interface type
+// that obj does not implement, but a pointer to obj does, the argument is a
+// pointer to a copy of obj, as for the receiver of Method.
+func fnArg(obj interface{}, t reflect.Type) (reflect.Value, error) {
+	rv := reflect.ValueOf(obj)
+	if !rv.IsValid() {
+		return reflect.Zero(t), nil
+	} else if rv.Type().AssignableTo(t) {
+		return rv, nil
+	} else if fnArgType(rv.Type(), t) {
+		ptr := reflect.New(rv.Type())
+		ptr.Elem().Set(rv)
+		return ptr, nil
+	} else if t.Kind() == reflect.Interface {
+		return reflect.Value{}, fmt.Errorf("%w: %T does not implement %v", ErrArgType, obj, t)
+	}
+	return reflect.Value{}, fmt.Errorf("%w: %T is not assignable to %v", ErrArgType, obj, t)
+}
+
+// fnArgType reports whether values of type t can be converted to arguments of
+// type argType by fnArg.
+func fnArgType(t, argType reflect.Type) bool {
+	if t.AssignableTo(argType) {
+		return true
+	}
+	return argType.Kind() == reflect.Interface && t.Kind() != reflect.Ptr &&
+		reflect.PtrTo(t).Implements(argType)
+}
+
+// Memoize returns a Query that evaluates q and caches its results, so that
+// subsequent evaluations on the same input do not re-evaluate q.  Inputs are
+// identified by their value: pointers by address, and other comparable
+// scalars (bool, numbers, strings) by equality. Inputs of other types, and
+// evaluations that report an error, are not cached.
+//
+// The cache belongs to the returned query and is safe for concurrent use. To
+// avoid pinning an unbounded number of values in memory, the cache holds at
+// most MemoizeLimit entries; when that limit is reached it is cleared.
+//
+// Because the cache is shared by all evaluations of the query, it is used
+// only when the result depends on nothing but the input: only by Eval and by
+// EvalWith with no options, and not within the body of a Let. Evaluations by
+// EvalContext, EvalWithFuncs, EvalPaths, or with any option bypass the cache.
+//
+// Inputs are identified by address, not by content, so if a value is modified
+// in place after a result for it is cached, as by Set or Update, the cached
+// result is stale. Construct a new Memoize query to discard the cache.
+func Memoize(q Query) Query { return &memoQuery{Query: q} }
+
+// MemoizeLimit is the maximum number of entries retained by the cache of a
+// query constructed by Memoize.
+const MemoizeLimit = 1024
+
+type memoQuery struct {
+	Query
+	cache sync.Map // :: input → result
+	size  int64    // approximate number of entries in cache (atomic)
+}
+
+func (m *memoQuery) eval(v *value) (*value, error) {
+	if !isMemoKey(v.val) || !v.env.memo {
+		return evalQuery(m.Query, v)
+	} else if res, ok := m.cache.Load(v.val); ok {
+		return pushValue(v, res), nil
+	}
+	next, err := evalQuery(m.Query, v)
+	if err != nil {
+		return nil, err
+	}
+	if atomic.AddInt64(&m.size, 1) > MemoizeLimit {
+		m.cache.Range(func(key, _ interface{}) bool {
+			m.cache.Delete(key)
+			return true
+		})
+		atomic.StoreInt64(&m.size, 1)
+	}
+	m.cache.Store(v.val, next.val)
+	return pushValue(v, next.val), nil
+}
+
+// unshared returns an env like e whose results may not be shared by Memoize.
+func (e *env) unshared() *env {
+	if !e.memo {
+		return e
+	}
+	f := *e
+	f.memo = false
+	return &f
+}
+
+// isMemoKey reports whether obj can be used as a key in a memoization cache.
+func isMemoKey(obj interface{}) bool {
+	switch reflect.ValueOf(obj).Kind() {
+	case reflect.Bool, reflect.String, reflect.Ptr, reflect.UnsafePointer, reflect.Chan,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	}
+	return false
+}
+
+// FuncRef returns a Query whose value is the result of applying the function
+// bound to name to its input. Unlike Func, the function is not resolved until
+// the query is evaluated: The function for name is found in the map passed to
+// EvalWithFuncs or the Funcs option. If the evaluation is given no such map,
+// the function is found in DefaultFuncs instead. It is an error if name is not
+// bound, or if the function bound to it does not have a signature accepted by
+// Func.
+func FuncRef(name string) Query { return funcRefQuery(name) }
+
+type funcRefQuery string
+
+func (f funcRefQuery) eval(v *value) (*value, error) {
+	if v.env.funcs == nil {
+		if q, ok := DefaultFuncs.lookup(string(f)); ok {
+			return q.eval(v)
+		}
+		return nil, fmt.Errorf("function %q is not defined", string(f))
+	}
+	fn, ok := v.env.funcs[string(f)]
+	if !ok {
+		return nil, fmt.Errorf("function %q is not defined", string(f))
+	} else if fn.err != nil {
+		return nil, fmt.Errorf("function %q: %v", string(f), fn.err)
+	}
+	return fn.q.eval(v)
+}
+
+// An envFunc is a function supplied for an evaluation, prepared for FuncRef.
+// If the function does not have a signature accepted by Func, err reports why,
+// and the error is reported when the function is referenced.
+type envFunc struct {
+	q   fnQuery
+	err error
+}
+
+// prepareFuncs returns the functions of fns prepared for FuncRef. The result
+// is not nil, even if fns is empty.
+func prepareFuncs(fns map[string]interface{}) map[string]envFunc {
+	out := make(map[string]envFunc, len(fns))
+	for name, fn := range fns {
+		q, err := newFnQuery(fn, nil)
+		out[name] = envFunc{q: q, err: err}
+	}
+	return out
+}
+
+// Method returns a Query whose value is the result of calling the exported
+// method with the given name on its input, passing args as the arguments.
+// If the input is not a pointer and the method has a pointer receiver, the
+// method is called on a pointer to a copy of the input. The method must
+// return a single value, or a value and an error; if it reports an error,
+// that error is propagated through the query chain, and if it panics,
+// evaluation recovers and reports an error wrapping a *PanicError. If the
+// first parameter of the method is a context.Context, it is passed the
+// context governing the evaluation, and args supply the remaining parameters.
+//
+// It is an error if the input has no such method, or if args do not match
+// its parameters. Numeric arguments are converted to the corresponding
+// parameter type; other arguments must be assignable to it.
+func Method(name string, args ...interface{}) Query {
+	return methodQuery{name: name, args: args}
+}
+
+type methodQuery struct {
+	name string
+	args []interface{}
+}
+
+func (m methodQuery) eval(v *value) (*value, error) {
+	rv := reflect.ValueOf(v.val)
+	if !rv.IsValid() {
+		return nil, fmt.Errorf("cannot call method %q on nil", m.name)
+	}
+	fn := rv.MethodByName(m.name)
+	if !fn.IsValid() && rv.Kind() != reflect.Ptr {
+		ptr := reflect.New(rv.Type())
+		ptr.Elem().Set(rv)
+		fn = ptr.MethodByName(m.name)
+	}
+	if !fn.IsValid() {
+		return nil, fmt.Errorf("type %T has no method %q", v.val, m.name)
+	}
+	t := fn.Type()
+	switch {
+	case t.NumOut() < 1, t.NumOut() > 2:
+		return nil, fmt.Errorf("method %q: wrong number of returns", m.name)
+	case t.NumOut() == 2 && t.Out(1) != errType:
+		return nil, fmt.Errorf("method %q: last return value is not error", m.name)
+	}
+
+	var args []reflect.Value
+	params := make([]reflect.Type, t.NumIn())
+	for i := range params {
+		params[i] = t.In(i)
+	}
+	if len(params) != 0 && params[0] == ctxType {
+		args = append(args, reflect.ValueOf(v.env.ctx))
+		params = params[1:]
+	}
+	if n := len(params); len(m.args) < n-1 || (!t.IsVariadic() && len(m.args) != n) {
+		return nil, fmt.Errorf("method %q: wrong number of arguments (%d)", m.name, len(m.args))
+	}
+	for i, arg := range m.args {
+		var pt reflect.Type
+		if last := len(params) - 1; i >= last && t.IsVariadic() {
+			pt = params[last].Elem()
+		} else {
+			pt = params[i]
+		}
+		av, err := argValue(arg, pt)
+		if err != nil {
+			return nil, fmt.Errorf("method %q argument %d: %w", m.name, i+1, err)
+		}
+		args = append(args, av)
+	}
+	res, err := callFunc(fn, false, args, func() string { return fmt.Sprintf("method %q", m.name) })
+	if err != nil {
+		return nil, err
+	} else if len(res) == 2 {
 		if err := res[1].Interface(); err != nil {
 			return nil, err.(error)
 		}
@@ -265,15 +1309,55 @@ func (a fnQuery) eval(v *value) (*value, error) {
 	return pushValue(v, res[0].Interface()), nil
 }
 
+// argValue converts obj to a value of type t for use as a function argument.
+// A nil obj is converted to the zero value of t.
+func argValue(obj interface{}, t reflect.Type) (reflect.Value, error) {
+	if obj == nil {
+		return reflect.Zero(t), nil
+	}
+	rv := reflect.ValueOf(obj)
+	if rv.Type().AssignableTo(t) {
+		return rv, nil
+	} else if isNumberKind(rv.Kind()) && isNumberKind(t.Kind()) {
+		return rv.Convert(t), nil
+	}
+	return reflect.Value{}, fmt.Errorf("%w: %T is not assignable to %v", ErrArgType, obj, t)
+}
+
+func isNumberKind(k reflect.Kind) bool { return isIntLike(k) || isUintLike(k) || isFloatLike(k) }
+
 // Index returns a Query that selects the item at a specified offset in an
 // array or slice. Offsets are 0-based, with negative offsets referring to
 // offsets from the end of the sequence. An offset outside the range of the
 // sequence report an error.
+//
+// Index applied to a map whose keys are integers, or of interface type,
+// selects the entry whose key is i, and it is an error wrapping ErrBadIndex if
+// there is no such entry. See also Key.
 func Index(i int) Query { return indexQuery(i) }
 
 type indexQuery int
 
 func (q indexQuery) eval(v *value) (*value, error) {
+	if s, ok := v.val.([]interface{}); ok {
+		offset := int(q)
+		if offset < 0 {
+			offset += len(s)
+		}
+		if offset >= len(s) || offset < 0 {
+			return nil, fmt.Errorf("%w: %d is not in 0..%d", ErrBadIndex, offset, len(s))
+		}
+		return v.elem(offset, s[offset]), nil
+	}
+	if rv := indirect(reflect.ValueOf(v.val)); rv.Kind() == reflect.Map && !isSeqer(v.val) {
+		if key, ok := intMapKey(rv.Type().Key(), int(q)); ok {
+			elt := rv.MapIndex(key)
+			if !elt.IsValid() {
+				return nil, fmt.Errorf("%w: no entry for key %d", ErrBadIndex, int(q))
+			}
+			return v.child(keyQuery{key: key.Interface()}, elt.Interface()), nil
+		}
+	}
 	rv, err := seqValue(v.val)
 	if err != nil {
 		return nil, err
@@ -283,26 +1367,173 @@ func (q indexQuery) eval(v *value) (*value, error) {
 		offset += rv.Len()
 	}
 	if offset >= rv.Len() || offset < 0 {
-		return nil, fmt.Errorf("index %d is out of range for 0..%d", offset, rv.Len())
+		return nil, fmt.Errorf("%w: %d is not in 0..%d", ErrBadIndex, offset, rv.Len())
+	}
+	return v.elem(offset, rv.Index(offset).Interface()), nil
+}
+
+// intMapKey returns the value of i as a key of type kt, and reports whether kt
+// is an integer or interface type that can represent i.
+func intMapKey(kt reflect.Type, i int) (reflect.Value, bool) {
+	key := reflect.New(kt).Elem()
+	switch k := kt.Kind(); {
+	case isIntLike(k) && !key.OverflowInt(int64(i)):
+		key.SetInt(int64(i))
+	case isUintLike(k) && i >= 0 && !key.OverflowUint(uint64(i)):
+		key.SetUint(uint64(i))
+	case k == reflect.Interface && reflect.TypeOf(i).Implements(kt):
+		key.Set(reflect.ValueOf(i))
+	default:
+		return reflect.Value{}, false
+	}
+	return key, true
+}
+
+// Range returns a Query that selects the items at offsets lo through hi-1 of
+// an array or slice, and yields a slice of concrete type []interface{}
+// containing them. As with Index, negative offsets refer to offsets from the
+// end of the sequence. Unlike Index, offsets outside the range of the
+// sequence are clamped to its bounds, so the result may be empty.
+func Range(lo, hi int) Query { return rangeQuery{lo: lo, hi: hi} }
+
+// Take returns a Query that selects the first n items of an array or slice,
+// and yields a slice of concrete type []interface{} containing them. If the
+// sequence has fewer than n items, all of them are selected.
+func Take(n int) Query {
+	if n < 0 {
+		n = 0
+	}
+	return rangeQuery{hi: n}
+}
+
+// Skip returns a Query that selects all but the first n items of an array or
+// slice, and yields a slice of concrete type []interface{} containing them. If
+// the sequence has fewer than n items, the result is empty.
+func Skip(n int) Query {
+	if n < 0 {
+		n = 0
+	}
+	return rangeQuery{lo: n, toEnd: true}
+}
+
+type rangeQuery struct {
+	lo, hi int
+	toEnd  bool // ignore hi and select through the end of the sequence
+}
+
+func (q rangeQuery) eval(v *value) (*value, error) {
+	rv, err := seqValue(v.val)
+	if err != nil {
+		return nil, err
+	}
+	n := rv.Len()
+	lo, hi := clampOffset(q.lo, n), n
+	if !q.toEnd {
+		hi = clampOffset(q.hi, n)
+	}
+	vs := []interface{}{}
+	var elems []*pathStep
+	for i := lo; i < hi; i++ {
+		vs = append(vs, rv.Index(i).Interface())
+		if v.env.paths {
+			elems = append(elems, v.elemPath(i))
+		}
+	}
+	return pushValue(v, vs).withElems(elems), nil
+}
+
+// clampOffset converts a possibly-negative offset into a sequence of length n
+// to an offset in 0..n.
+func clampOffset(offset, n int) int {
+	if offset < 0 {
+		offset += n
+	}
+	if offset < 0 {
+		return 0
+	} else if offset > n {
+		return n
 	}
-	return pushValue(v, rv.Index(offset).Interface()), nil
+	return offset
 }
 
 // Or is a Query that yields the first non-nil value among the given queries in
 // left-to-right order. If no queries are given, the result is nil.  Errors in
-// evaluating subqueries are ignored.
+// evaluating subqueries are ignored, except that if the last query is a Fail,
+// its error is reported when no earlier query yields a value.
 type Or []Query
 
 func (o Or) eval(v *value) (*value, error) {
-	for _, q := range o {
-		next, err := q.eval(v)
+	for i, q := range o {
+		next, err := evalQuery(q, v)
 		if err == nil && next.val != nil {
-			return pushValue(v, next.val), nil
+			return pushResult(v, next), nil
+		} else if _, ok := q.(failQuery); ok && i == len(o)-1 {
+			return nil, err
 		}
 	}
 	return pushValue(v, nil), nil
 }
 
+// Default returns a Query that yields the value of q, or fallback if the value
+// of q is nil. Errors reported by q are not affected. The fallback may be a
+// Param, whose value is substituted when the query is evaluated.
+//
+// For example, Default(Key("Port"), 80) yields 80 for an input whose "Port"
+// key is absent or nil.
+func Default(q Query, fallback interface{}) Query {
+	return defaultQuery{q: q, fallback: fallback}
+}
+
+// DefaultErr returns a Query that yields the value of q, or fallback if the
+// value of q is nil or if q reports an error matching one of targets, as
+// reported by errors.Is. If no targets are given, fallback replaces any error.
+// Errors not matching any target are reported as usual. Unlike Or, this
+// allows a query to tolerate the absence of a value without also hiding
+// unexpected failures.
+//
+// For example, DefaultErr(KeyStrict("Port"), 80, ErrNoKey) yields 80 for an
+// input without a "Port" key, but still reports an error if the input is not
+// a struct or map.
+func DefaultErr(q Query, fallback interface{}, targets ...error) Query {
+	return defaultQuery{q: q, fallback: fallback, onErr: true, targets: targets}
+}
+
+type defaultQuery struct {
+	q        Query
+	fallback interface{}
+	onErr    bool    // whether to replace errors
+	targets  []error // if onErr, the errors to replace (empty means all)
+}
+
+func (d defaultQuery) eval(v *value) (*value, error) {
+	res, err := evalQuery(d.q, v)
+	if err != nil && !d.replaces(err) {
+		return nil, err
+	} else if err == nil && res.val != nil {
+		return pushResult(v, res), nil
+	}
+	obj, err := resolveArg(v, d.fallback)
+	if err != nil {
+		return nil, err
+	}
+	return pushValue(v, obj), nil
+}
+
+// replaces reports whether d yields its fallback in place of err.
+func (d defaultQuery) replaces(err error) bool {
+	if !d.onErr {
+		return false
+	} else if len(d.targets) == 0 {
+		return true
+	}
+	for _, target := range d.targets {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
 // List is a Query that accumulates the values of the given queries in a slice
 // of type []interface{}. If no queries are given, the slice is empty.
 type List []Query
@@ -310,7 +1541,7 @@ type List []Query
 func (q List) eval(v *value) (*value, error) {
 	var vs []interface{}
 	for _, elt := range q {
-		next, err := elt.eval(v)
+		next, err := evalQuery(elt, v)
 		if err != nil {
 			return nil, err
 		}
@@ -327,7 +1558,7 @@ type Cat []Query
 func (c Cat) eval(v *value) (*value, error) {
 	var vs []interface{}
 	for _, elt := range c {
-		next, err := elt.eval(v)
+		next, err := evalQuery(elt, v)
 		if err != nil {
 			return nil, err
 		}
@@ -343,10 +1574,33 @@ func (c Cat) eval(v *value) (*value, error) {
 	return pushValue(v, vs), nil
 }
 
-type cmpQuery func(*value) (bool, error)
+// A cmpQuery compares its input to a fixed needle. The op is the operator
+// used for the comparison in the text syntax.
+type cmpQuery struct {
+	op     string
+	needle interface{}
+}
 
 func (c cmpQuery) eval(v *value) (*value, error) {
-	w, err := c(v)
+	needle, err := resolveArg(v, c.needle)
+	if err != nil {
+		return nil, err
+	}
+	var w bool
+	switch c.op {
+	case "==":
+		w = isEqual(v.val, needle)
+	case "<":
+		w, err = isLessThan(v.val, needle, false)
+	case "<=":
+		w, err = isLessThan(v.val, needle, true)
+	case ">":
+		w, err = isLessThan(needle, v.val, false)
+	case ">=":
+		w, err = isLessThan(needle, v.val, true)
+	default:
+		panic("unknown comparison " + c.op)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -354,47 +1608,85 @@ func (c cmpQuery) eval(v *value) (*value, error) {
 }
 
 // Eq returns a Query that reports whether the input equals needle.
-func Eq(needle interface{}) Query {
-	return cmpQuery(func(v *value) (bool, error) { return v.val == needle, nil })
-}
+//
+// The comparison queries Eq, Lt, Le, Gt, and Ge compare numbers by their
+// numeric values, regardless of their concrete types, so that Eq(1) matches
+// a float64 1 decoded from JSON as well as an int 1. Integers and floats are
+// compared exactly, without rounding. A NaN is not equal to, less than, or
+// greater than any number. Strings are ordered lexicographically. Values of
+// type time.Time are ordered chronologically, and are equal if they denote the
+// same instant, even in different locations. A time.Duration is a number, and
+// is compared with other numbers as a count of nanoseconds. Values of types
+// registered with RegisterCompare are compared by the registered function.
+// Other values are equal if they are equal as interface values, and cannot be
+// ordered.
+func Eq(needle interface{}) Query { return cmpQuery{op: "==", needle: needle} }
 
 // Lt returns a Query that reports whether the input is less than needle.
-func Lt(needle interface{}) Query {
-	return cmpQuery(func(v *value) (bool, error) { return isLessThan(v.val, needle, false) })
-}
+func Lt(needle interface{}) Query { return cmpQuery{op: "<", needle: needle} }
 
 // Le returns a Query that reports whether the input is less than or equal to needle.
-func Le(needle interface{}) Query {
-	return cmpQuery(func(v *value) (bool, error) { return isLessThan(v.val, needle, true) })
-}
+func Le(needle interface{}) Query { return cmpQuery{op: "<=", needle: needle} }
 
 // Gt returns a Query that reports whether the input is greater than needle.
-func Gt(needle interface{}) Query {
-	return cmpQuery(func(v *value) (bool, error) { return isLessThan(needle, v.val, false) })
-}
+func Gt(needle interface{}) Query { return cmpQuery{op: ">", needle: needle} }
 
 // Ge returns a Query that reports whether the input is greater than or equal to needle.
-func Ge(needle interface{}) Query {
-	return cmpQuery(func(v *value) (bool, error) { return isLessThan(needle, v.val, true) })
+func Ge(needle interface{}) Query { return cmpQuery{op: ">=", needle: needle} }
+
+// Between returns a Query that reports whether the input is greater than or
+// equal to lo and less than or equal to hi. Values are compared as by Lt.
+//
+// For example, Seq{Key("Age"), Between(18, 65)} selects working-age people.
+func Between(lo, hi interface{}) Query { return betweenQuery{lo: lo, hi: hi} }
+
+// BetweenExclusive returns a Query that reports whether the input is strictly
+// greater than lo and strictly less than hi. Values are compared as by Lt.
+func BetweenExclusive(lo, hi interface{}) Query {
+	return betweenQuery{lo: lo, hi: hi, exclusive: true}
+}
+
+type betweenQuery struct {
+	lo, hi    interface{}
+	exclusive bool // exclude the bounds from the range
 }
 
-func isLessThan(x, y interface{}, ifEQ bool) (bool, error) {
-	if x == y {
-		return ifEQ, nil
+func (b betweenQuery) eval(v *value) (*value, error) {
+	lo, err := resolveArg(v, b.lo)
+	if err != nil {
+		return nil, err
 	}
-	vx, vy := reflect.ValueOf(x), reflect.ValueOf(y)
-	kx, ky := vx.Kind(), vy.Kind()
-	switch {
-	case isIntLike(kx) && isIntLike(ky):
-		return vx.Int() < vy.Int(), nil
-	case isUintLike(kx) && isUintLike(ky):
-		return vx.Uint() < vy.Uint(), nil
-	case kx == reflect.String && ky == reflect.String:
-		return vx.String() < vy.String(), nil
-	case isFloatLike(kx) && isFloatLike(ky):
-		return vx.Float() < vy.Float(), nil
+	hi, err := resolveArg(v, b.hi)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := isLessThan(lo, v.val, !b.exclusive)
+	if err == nil && ok {
+		ok, err = isLessThan(v.val, hi, !b.exclusive)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pushValue(v, ok), nil
+}
+
+// EqApprox returns a Query that reports whether the input is a number within
+// epsilon of want, that is, whether |input - want| <= epsilon. The input may
+// be a number of any kind. It is an error if the input is not a number.
+//
+// EqApprox is useful for comparing floating-point values, such as numbers
+// decoded from JSON, for which an exact comparison with Eq may fail due to
+// rounding.
+func EqApprox(want, epsilon float64) Query { return approxQuery{want: want, epsilon: epsilon} }
+
+type approxQuery struct{ want, epsilon float64 }
+
+func (a approxQuery) eval(v *value) (*value, error) {
+	f, ok := toFloat(v.val)
+	if !ok {
+		return nil, fmt.Errorf("value of type %T is %w", v.val, ErrNotNumber)
 	}
-	return false, fmt.Errorf("cannot compare %T and %T", x, y)
+	return pushValue(v, math.Abs(f-a.want) <= a.epsilon), nil
 }
 
 func isIntLike(k reflect.Kind) bool {
@@ -415,38 +1707,150 @@ func isUintLike(k reflect.Kind) bool {
 
 func isFloatLike(k reflect.Kind) bool { return k == reflect.Float64 || k == reflect.Float32 }
 
-func forEach(v interface{}, f func(interface{}) error) error {
-	rv := reflect.ValueOf(v)
+// errStop is a sentinel error used by forEach callbacks to end iteration
+// early. It is never reported to the caller of a query.
+var errStop = errors.New("stop iteration")
+
+// forEach calls f with each element of the array, map, or slice v.val.  If
+// v.val is a map, f is given values of type Entry in the order described for
+// Entry. If f reports an error, or the context governing evaluation ends,
+// iteration stops and the error is returned, wrapped with the position of the
+// element.
+func forEach(v *value, f func(interface{}) error) error {
+	return forEachValue(v, func(elt *value) error { return f(elt.val) })
+}
+
+// forEachValue calls f with a value for each element of the array, map, or
+// slice v.val, as forEach.
+func forEachValue(v *value, f func(*value) error) error {
+	ctx := v.env.ctx
+	if v.env.limits != nil {
+		visit := f
+		f = func(elt *value) error {
+			if err := elt.env.descend(); err != nil {
+				return err
+			}
+			defer elt.env.leave()
+			return visit(elt)
+		}
+	}
+	if m, ok := v.val.(*sync.Map); ok {
+		return forEachEntry(v, syncMapEntries(m), f)
+	} else if m, ok := genericMap(v.val); ok {
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		es := make([]Entry, len(keys))
+		for i, key := range keys {
+			es[i] = Entry{Key: key, Value: m[key]}
+		}
+		return forEachEntry(v, es, f)
+	} else if s, ok := v.val.([]interface{}); ok {
+		for i, elt := range s {
+			if err := ctx.Err(); err != nil {
+				return wrapError([]Query{indexQuery(i)}, v.val, err)
+			}
+			if err := f(v.elem(i, elt)); err == errStop {
+				return err
+			} else if err != nil {
+				return wrapError([]Query{indexQuery(i)}, elt, err)
+			}
+		}
+		return nil
+	}
+	rv := indirect(reflect.ValueOf(v.val))
+	if s, ok := v.val.(Seqer); ok {
+		rv = reflect.ValueOf(s.QuerySeq())
+	}
 	switch rv.Kind() {
 	case reflect.Array, reflect.Slice:
 		for i := 0; i < rv.Len(); i++ {
-			if err := f(rv.Index(i).Interface()); err != nil {
+			if err := ctx.Err(); err != nil {
+				return wrapError([]Query{indexQuery(i)}, v.val, err)
+			}
+			elt := rv.Index(i).Interface()
+			if err := f(v.elem(i, elt)); err == errStop {
 				return err
+			} else if err != nil {
+				return wrapError([]Query{indexQuery(i)}, elt, err)
 			}
 		}
 	case reflect.Map:
-		for _, key := range rv.MapKeys() {
-			if err := f(Entry{
-				Key:   key.Interface(),
-				Value: rv.MapIndex(key).Interface(),
-			}); err != nil {
-				return err
-			}
+		es := make([]Entry, 0, rv.Len())
+		for _, key := range mapKeys(rv) {
+			es = append(es, Entry{Key: key.Interface(), Value: rv.MapIndex(key).Interface()})
 		}
+		return forEachEntry(v, es, f)
 	default:
-		return fmt.Errorf("value of type %T is not an array, map, or slice", v)
+		return fmt.Errorf("value of type %T is %w", v.val, ErrNotCollection)
+	}
+	return nil
+}
+
+// forEachEntry calls f for each of the entries es of the map v, in order, as
+// forEachValue does.
+func forEachEntry(v *value, es []Entry, f func(*value) error) error {
+	for _, elt := range es {
+		if err := v.env.ctx.Err(); err != nil {
+			return wrapError([]Query{keyQuery{key: elt.Key}}, v.val, err)
+		}
+		if err := f(v.child(keyQuery{key: elt.Key}, elt)); err == errStop {
+			return err
+		} else if err != nil {
+			return wrapError([]Query{keyQuery{key: elt.Key}}, elt, err)
+		}
 	}
 	return nil
 }
 
 func seqValue(v interface{}) (reflect.Value, error) {
-	rv := reflect.ValueOf(v)
+	if s, ok := v.(Seqer); ok {
+		return reflect.ValueOf(s.QuerySeq()), nil
+	}
+	rv := indirect(reflect.ValueOf(v))
 	if k := rv.Kind(); k != reflect.Array && k != reflect.Slice {
-		return reflect.Value{}, fmt.Errorf("value of type %T is not an array or slice", v)
+		return reflect.Value{}, fmt.Errorf("value of type %T is %w", v, ErrNotSequence)
 	}
 	return rv, nil
 }
 
+// genericMap reports whether obj is a map[string]interface{} or Values, as
+// produced by decoding JSON or by a Map query, and if so returns it. Lookups
+// and iteration over these common types do not need reflection.
+func genericMap(obj interface{}) (map[string]interface{}, bool) {
+	switch t := obj.(type) {
+	case map[string]interface{}:
+		return t, true
+	case Values:
+		return t, true
+	}
+	return nil, false
+}
+
+// isMapValue reports whether obj is a map or a *sync.Map, possibly through
+// pointers, and not a Seqer, whose elements are visited by forEachValue as entries.
+func isMapValue(obj interface{}) bool {
+	switch obj.(type) {
+	case Seqer:
+		return false
+	case *sync.Map:
+		return true
+	}
+	return indirect(reflect.ValueOf(obj)).Kind() == reflect.Map
+}
+
+// indirect follows pointers and interfaces from rv to the value they refer
+// to, so that queries over sequences and maps accept pointers to them. A nil
+// pointer or interface yields the zero reflect.Value.
+func indirect(rv reflect.Value) reflect.Value {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	return rv
+}
+
 // IsNil is a Func that reports whether obj is nil, as a bool.
 func IsNil(obj interface{}) bool { return obj == nil }
 
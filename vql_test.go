@@ -1,9 +1,18 @@
 package vql_test
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"text/template"
+	"time"
 
 	"github.com/creachadair/vql"
 	"github.com/google/go-cmp/cmp"
@@ -37,6 +46,7 @@ func TestQueries(t *testing.T) {
 		10: "ten",
 		12: "twelve",
 	}
+	t0 := time.Date(2020, 2, 29, 12, 0, 0, 0, time.UTC)
 
 	tests := []struct {
 		query       vql.Query
@@ -67,6 +77,109 @@ func TestQueries(t *testing.T) {
 		{vql.Seq{vql.Key("T"), vql.Key("A")}, t1, "bar"},
 		{vql.Seq{vql.Key("T"), vql.Key("B")}, t1, 25},
 		{vql.Seq{vql.Key("T"), vql.Key("C")}, t1, nil},
+		{vql.Exists("T", "A"), t1, true},
+		{vql.Exists("T", "C"), t1, false},
+		{vql.Exists("T", "T"), t1, true}, // present but nil
+		{vql.Exists("T", "T", "A"), t1, false},
+		{vql.Exists("x"), map[string]interface{}{"x": nil}, true},
+		{vql.Exists(nil), map[string]interface{}{"x": nil}, false},
+		{vql.Exists(nil), map[interface{}]int{nil: 1}, true},
+		{vql.Key(nil), map[interface{}]int{nil: 1}, 1},
+		{vql.Exists("y"), map[string]interface{}{"x": nil}, false},
+		{vql.Exists("x", "y"), map[string]interface{}{"x": nil}, false},
+		{vql.Exists(), 5, true},
+		{vql.KeyStrict("T", "A"), t1, "bar"},
+		{vql.KeyStrict("T", "T"), t1, (*thingy)(nil)},
+		{vql.KeyStrict("x"), map[string]interface{}{"x": nil}, nil},
+		{vql.KeyOr("C", "B", "A"), t1, 17},
+		{vql.KeyOr("C", "D"), t1, nil},
+		{vql.KeyOr(), t1, nil},
+		{vql.KeyOr(1, "user_name", "userName"), map[string]string{
+			"userName": "x", "user_name": "y",
+		}, "y"},
+
+		// Map-preserving selection.
+		{vql.SelectMap(vql.Key("Key"), vql.Gt(10)), zm, map[int]string{12: "twelve"}},
+		{vql.SelectMap(vql.Const(false)), sm, map[string]string{}},
+		{vql.Seq{
+			vql.SelectMap(vql.Key("Value"), vql.Eq("pooh")),
+			vql.Key("said"),
+		}, sm, "pooh"},
+		{vql.SelectMap(vql.Key("Value"), vql.Func(vql.IsNil)), map[string]interface{}{
+			"a": nil, "b": 1,
+		}, map[string]interface{}{"a": nil}},
+
+		// Map transformations.
+		{vql.MapValues(vql.Func(strings.ToUpper)), sm, map[string]interface{}{
+			"oh": "BOTHER", "said": "POOH",
+		}},
+		{vql.MapValues(vql.Self), map[int]string{}, map[int]interface{}{}},
+		{vql.MapValues(vql.Self), map[interface{}]int{nil: 1, "a": 2}, map[interface{}]interface{}{nil: 1, "a": 2}},
+		{vql.MapKeys(vql.Func(func(n int) string { return fmt.Sprint(n) })), zm, map[interface{}]string{
+			"10": "ten", "12": "twelve",
+		}},
+		{vql.Seq{
+			vql.MapKeys(vql.Func(strings.ToUpper)),
+			vql.MapValues(vql.Func(func(s string) int { return len(s) })),
+			vql.Key("SAID"),
+		}, sm, 4},
+
+		// Projection.
+		{vql.Pick("A", "B", "C"), t1, vql.Values{"A": "foo", "B": 17, "C": nil}},
+		{vql.Pick(), t1, vql.Values{}},
+		{vql.Pick("oh"), sm, vql.Values{"oh": "bother"}},
+		{vql.Omit("S", "T"), &t1, vql.Values{"A": "foo", "B": 17}},
+		{vql.Omit("oh", "nonesuch"), sm, vql.Values{"said": "pooh"}},
+		{vql.Seq{vql.Omit(), vql.Key("said")}, sm, "pooh"},
+
+		// Construction.
+		{vql.Build(nil), t1, nil},
+		{vql.Build(vql.Key("A")), t1, "foo"},
+		{vql.Build(map[string]interface{}{
+			"name": vql.Key("A"),
+			"info": vql.Values{
+				"count": vql.Seq{vql.Key("S"), vql.Count()},
+				"tags":  []interface{}{"v1", vql.Key("T", "A"), []interface{}{vql.Key("B")}},
+			},
+			"version": 2,
+		}), t1, map[string]interface{}{
+			"name": "foo",
+			"info": vql.Values{
+				"count": 3,
+				"tags":  []interface{}{"v1", "bar", []interface{}{17}},
+			},
+			"version": 2,
+		}},
+		{vql.Each(vql.Build([]interface{}{vql.Key("A"), vql.Key("B")})), []*thingy{&t1, t2},
+			[]interface{}{[]interface{}{"foo", 17}, []interface{}{"bar", 25}}},
+
+		// Variable bindings.
+		{vql.Let("x", vql.Key("A"), vql.Var("x")), t1, "foo"},
+		{vql.Let("x", vql.Key("A"), vql.Seq{vql.Key("T"), vql.List{vql.Key("A"), vql.Var("x")}}), t1,
+			[]interface{}{"bar", "foo"}},
+		{vql.Let("n", vql.Seq{vql.Key("S"), vql.Count()}, vql.Seq{
+			vql.Key("S"),
+			vql.Each(vql.Map{"s": vql.Self, "of": vql.Var("n")}),
+			vql.Index(0),
+		}), t1, vql.Values{"s": "pear", "of": 3}},
+		{vql.Let("x", vql.Const(1), vql.Let("x", vql.Const(2), vql.Var("x"))), nil, 2}, // shadowing
+		{vql.Let("x", vql.Const(1), vql.List{
+			vql.Let("y", vql.Const(2), vql.Var("y")), vql.Var("x"),
+		}), nil, []interface{}{2, 1}},
+
+		// Keys, values, and entries.
+		{vql.Keys(), sm, []interface{}{"oh", "said"}},
+		{vql.Vals(), sm, []interface{}{"bother", "pooh"}},
+		{vql.Entries(), zm, []interface{}{
+			vql.Entry{Key: 10, Value: "ten"},
+			vql.Entry{Key: 12, Value: "twelve"},
+		}},
+		{vql.Keys(), map[string]int{}, []interface{}{}},
+		{vql.Keys(), t2, []interface{}{"A", "B", "S", "T"}},
+		{vql.Seq{vql.Key("T"), vql.Vals()}, t1, []interface{}{
+			"bar", 25, []string{"apple", "pie"}, (*thingy)(nil),
+		}},
+		{vql.Seq{vql.Entries(), vql.Index(1)}, t1, vql.Entry{Key: "B", Value: 17}},
 		{vql.Seq{vql.Key("T"), vql.Key("T")}, t1, (*thingy)(nil)},
 		{vql.Key("T", "A"), t1, "bar"},
 		{vql.Key("T", "B"), t1, 25},
@@ -81,6 +194,275 @@ func TestQueries(t *testing.T) {
 			vql.Each(vql.Key("Key")),
 		}, map[string]int{"yes": 4, "sí": 3, "да": 2, "はい": 1}, []interface{}{"yes"}},
 
+		// Quantifiers.
+		{vql.Every(vql.Gt(2)), []int{3, 5, 7}, true},
+		{vql.Every(vql.Gt(2)), []int{3, 1, 7}, false},
+		{vql.Every(vql.Gt(2)), []int{}, true},
+		{vql.Any(vql.Gt(6)), []int{3, 5, 7}, true},
+		{vql.Any(vql.Gt(9)), []int{3, 5, 7}, false},
+		{vql.Any(vql.Gt(2)), []int{}, false},
+		{vql.Every(vql.Key("Value")), map[string]bool{"a": true, "b": true}, true},
+		{vql.Any(vql.Key("Value")), map[string]bool{"a": false, "b": false}, false},
+		{vql.Any(vql.Key("B")), []interface{}{}, false},
+		{vql.Contains(5), []int{3, 5, 7}, true},
+		{vql.Contains(4), []int{3, 5, 7}, false},
+		{vql.Contains("5"), []int{3, 5, 7}, false},
+		{vql.Contains([]int{1}), []interface{}{[]int{1}}, false}, // incomparable
+		{vql.Contains("pl"), "apple", true},
+		{vql.Contains("q"), "apple", false},
+		{vql.Contains(1), "apple", false},
+		{vql.Contains(12), zm, true},
+		{vql.Contains(11), zm, false},
+		{vql.Contains("12"), zm, false},
+		{vql.Seq{vql.Key("S"), vql.Contains("plum")}, t1, true},
+		{vql.In("a", "b"), "b", true},
+		{vql.In("a", "b"), "c", false},
+		{vql.In(), "c", false},
+		{vql.In(1, "1"), 1, true},
+		{vql.Seq{vql.Key("S"), vql.Select(vql.In("pear", "cherry"))}, t1, []interface{}{"pear", "cherry"}},
+		{vql.Eq(1), 1.0, true},
+		{vql.Eq(1.0), uint8(1), true},
+		{vql.Eq(1), 1.5, false},
+		{vql.Eq(-1), uint(1 << 63), false},
+		{vql.Eq(1 << 53), float64(1<<53 + 1), true}, // the float is rounded
+		{vql.Eq(1<<53 + 1), float64(1<<53 + 1), false},
+		{vql.Eq(math.NaN()), math.NaN(), false},
+		{vql.Lt(2), 1.5, true},
+		{vql.Lt(-2), -1.5, false},
+		{vql.Gt(-2), -2.5, false},
+		{vql.Le(int8(3)), 3.0, true},
+		{vql.Ge(uint(2)), -1, false},
+		{vql.Lt(1e300), int64(math.MaxInt64), true},
+		{vql.Gt(-1e300), int64(math.MinInt64), true},
+		{vql.Gt(1e20), uint64(math.MaxUint64), false},
+		{vql.Lt(math.NaN()), 1, false},
+		{vql.Ge(math.NaN()), 1, false},
+		{vql.Seq{vql.Key("B"), vql.Eq(17.0)}, t1, true},
+		{vql.In(1.0, "x"), 1, true},
+		{vql.Contains(2), []float64{1.5, 2}, true},
+		{vql.Eq([]int{1}), []int{1}, false}, // incomparable
+		{vql.Eq(t0), t0.In(time.FixedZone("X", 3600)), true},
+		{vql.Eq(t0), t0.Add(time.Second), false},
+		{vql.Lt(t0), t0.Add(-time.Second), true},
+		{vql.Le(t0), t0, true},
+		{vql.Gt(t0), t0, false},
+		{vql.Ge(t0.Add(time.Hour)), t0.In(time.FixedZone("Y", 7200)), false},
+		{vql.Between(t0, t0.Add(time.Hour)), t0.Add(time.Minute), true},
+		{vql.Seq{vql.Select(vql.Gt(t0)), vql.Count()}, []time.Time{t0.Add(-1), t0, t0.Add(1)}, 1},
+		{vql.Min(vql.Self), []time.Time{t0.Add(time.Hour), t0, t0.Add(time.Minute)}, t0},
+		{vql.Gt(time.Second), 2 * time.Second, true},
+		{vql.Eq(1500 * time.Millisecond), int64(1.5e9), true},
+		{vql.Lt(time.Minute), 59.5e9, true},
+		{vql.EqApprox(0.3, 1e-9), 0.1 + 0.2, true},
+		{vql.EqApprox(0.3, 0), 0.1 + 0.2, true}, // constant arithmetic is exact
+		{vql.EqApprox(2, 0.5), 3, false},
+		{vql.EqApprox(2, 0.5), uint8(2), true},
+		{vql.EqApprox(2, 0.5), math.NaN(), false},
+		{vql.Format("%s is %d", vql.Key("A"), vql.Key("B")), t1, "foo is 17"},
+		{vql.Format("none"), nil, "none"},
+		{vql.Each(vql.Format("<%v>", vql.Self)), []int{1, 2}, []interface{}{"<1>", "<2>"}},
+		{vql.Select(vql.TypeIs[int]()), []interface{}{1, "a", 2, nil, 3.0}, []interface{}{1, 2}},
+		{vql.Select(vql.TypeIs[fmt.Stringer]()), []interface{}{1, time.Second, nil}, []interface{}{time.Second}},
+		{vql.TypeIs[*thingy](), t2, true},
+		{vql.TypeIs[thingy](), t2, false},
+		{vql.AsType(reflect.TypeOf(0.0)), int32(3), 3.0},
+		{vql.AsType(reflect.TypeOf(time.Duration(0))), int64(5), time.Duration(5)},
+		{vql.AsType(reflect.TypeOf([]byte(nil))), "hi", []byte("hi")},
+		{vql.AsType(reflect.TypeOf("")), nil, ""},
+		{vql.AsType(reflect.TypeOf((*fmt.Stringer)(nil)).Elem()), time.Second, time.Second},
+		{vql.KindOf(), t2, "ptr"},
+		{vql.KindOf(), nil, "invalid"},
+		{vql.Each(vql.KindOf()), []interface{}{1, "a", []int{}, map[string]int{}}, []interface{}{"int", "string", "slice", "map"}},
+		{vql.TypeName(), t2, "*vql_test.thingy"},
+		{vql.TypeName(), nil, "<nil>"},
+		{vql.Seq{vql.Select(vql.TypeName(), vql.Eq("string")), vql.Count()}, []interface{}{"a", 1, "b"}, 2},
+		{vql.Default(vql.Key("A"), "x"), t1, "foo"},
+		{vql.Default(vql.Key("nope"), "x"), sm, "x"},
+		{vql.Default(vql.Key("T", "A"), "x"), t1, "bar"},
+		{vql.Each(vql.Default(vql.Self, 0)), []interface{}{1, nil, 3}, []interface{}{1, 0, 3}},
+		{vql.DefaultErr(vql.Index(5), -1), []int{1}, -1},
+		{vql.DefaultErr(vql.Index(5), -1, vql.ErrBadIndex), []int{1}, -1},
+		{vql.DefaultErr(vql.KeyStrict("Q"), "none", vql.ErrNotSequence, vql.ErrNoKey), t1, "none"},
+		{vql.DefaultErr(vql.Key("Q"), "none", vql.ErrNoKey), t1, "none"},
+		{vql.Require(vql.Seq{vql.Key("B"), vql.Gt(10)}, "B is too small"), t1, t1},
+		{vql.Or{vql.Key("A"), vql.Failf("no A")}, t1, "foo"},
+		{vql.Or{vql.Fail(vql.ErrNoKey), vql.Key("A")}, t1, "foo"},
+		{vql.Switch{On: vql.Key("B"), Cases: map[interface{}]vql.Query{17: vql.Const("x")}, Default: vql.Failf("no")}, t1, "x"},
+		{vql.Seq{vql.Require(vql.Exists("T"), "no T"), vql.Key("T", "A")}, t1, "bar"},
+		{vql.NonNil(vql.Key("A")), t1, "foo"},
+		{vql.Each(vql.NonNil(vql.Self)), []int{0, 1}, []interface{}{0, 1}},
+		{vql.EachLenient(vql.Key("A")), []interface{}{t1, 5, t2, nil}, []interface{}{"foo", "bar"}},
+		{vql.EachLenient(vql.Index(0)), []interface{}{"x", 1}, []interface{}{}},
+		{vql.EachLenient(vql.Seq{vql.Key("Value"), vql.ToInt()}), map[string]string{"a": "1", "b": "x"}, []interface{}{int64(1)}},
+		{vql.Each(vql.Key("Key")), map[string]int{"c": 1, "a": 2, "b": 3}, []interface{}{"a", "b", "c"}},
+		{vql.Each(vql.Key("Value")), map[float64]string{2.5: "x", -1: "y", 0: "z"}, []interface{}{"y", "z", "x"}},
+		{vql.Each(vql.Key("Key")), map[interface{}]int{"b": 0, 2: 0, "a": 0, false: 0, 1.5: 0, nil: 0, true: 0, uint8(1): 0},
+			[]interface{}{nil, false, true, uint8(1), 1.5, 2, "a", "b"}},
+		{vql.Seq{vql.Select(vql.Key("Value"), vql.Gt(1)), vql.Each(vql.Key("Key"))}, map[int]int{3: 3, 1: 2, 2: 1}, []interface{}{1, 3}},
+		{vql.EachIndexed(vql.List{vql.Key("Key"), vql.Key("Value")}), []string{"a", "b"},
+			[]interface{}{[]interface{}{0, "a"}, []interface{}{1, "b"}}},
+		{vql.EachIndexed(vql.Key("Key")), map[string]int{"y": 1, "x": 2}, []interface{}{"x", "y"}},
+		{vql.Seq{vql.Key("S"), vql.EachIndexed(vql.Key("Key"))}, t1, []interface{}{0, 1, 2}},
+		{vql.EachIndexed(vql.Self), []int{}, []interface{}(nil)},
+		{vql.Index(1), &t1.S, "plum"},
+		{vql.Range(0, 2), &[2]int{3, 4}, []interface{}{3, 4}},
+		{vql.Each(vql.ToUpper()), &t2.S, []interface{}{"APPLE", "PIE"}},
+		{vql.Select(vql.HasPrefix("p")), &t1.S, []interface{}{"pear", "plum"}},
+		{vql.Count(), &sm, 2},
+		{vql.Contains("oh"), &sm, true},
+		{vql.Sum(vql.Self), &[]int{1, 2}, 3},
+		{vql.Each(vql.Key("Key")), &zm, []interface{}{10, 12}},
+		{vql.ToLower(), "MiXeD", "mixed"},
+		{vql.ToInt(), int8(-3), int64(-3)},
+		{vql.ToInt(), uint(3), int64(3)},
+		{vql.ToInt(), -2.7, int64(-2)},
+		{vql.ToInt(), " 42 ", int64(42)},
+		{vql.ToInt(), "2.5e3", int64(2500)},
+		{vql.ToFloat(), 3, 3.0},
+		{vql.ToFloat(), float32(0.5), 0.5},
+		{vql.ToFloat(), "1.25", 1.25},
+		{vql.ToString(), "s", "s"},
+		{vql.ToString(), []byte("b"), "b"},
+		{vql.ToString(), -12, "-12"},
+		{vql.ToString(), 0.1, "0.1"},
+		{vql.ToString(), true, "true"},
+		{vql.ToString(), time.Duration(1500) * time.Millisecond, "1.5s"},
+		{vql.Seq{vql.Each(vql.ToInt()), vql.Sum(vql.Self)}, []interface{}{1, "2", 3.5}, int64(6)},
+		{vql.ToUpper(), "MiXeD", "MIXED"},
+		{vql.TrimSpace(), "  padded \n", "padded"},
+		{vql.Split(","), "a,b,,c", []interface{}{"a", "b", "", "c"}},
+		{vql.Split(","), "", []interface{}{""}},
+		{vql.JoinWith(", "), []string{"a", "b", "c"}, "a, b, c"},
+		{vql.JoinWith(", "), []interface{}{}, ""},
+		{vql.Seq{vql.Key("S"), vql.Each(vql.ToUpper()), vql.JoinWith("/")}, t1, "PEAR/PLUM/CHERRY"},
+		{vql.HasPrefix("ch"), "cherry", true},
+		{vql.HasSuffix("ch"), "cherry", false},
+		{vql.Seq{vql.Key("S"), vql.Select(vql.HasSuffix("y"))}, t1, []interface{}{"cherry"}},
+		{vql.Add(), nil, 0},
+		{vql.Mul(), nil, 1},
+		{vql.Add(vql.Self), 5, 5},
+		{vql.Add(vql.Key("B"), vql.Key("T", "B")), t1, 42},
+		{vql.Add(vql.Self, vql.Const(int8(1))), int8(2), int8(3)},
+		{vql.Add(vql.Self, vql.Const(1)), int8(2), int64(3)},
+		{vql.Add(vql.Self, vql.Const(0.5)), 2, 2.5},
+		{vql.Add(vql.Self, vql.Const(float32(0.5))), float32(2), float32(2.5)},
+		{vql.Sub(vql.Self, vql.Const(5)), 2, -3},
+		{vql.Sub(vql.Self, vql.Const(uint(5))), uint(7), uint(2)},
+		{vql.Mul(vql.Key("Price"), vql.Key("Qty")), map[string]interface{}{"Price": 2.5, "Qty": 4}, 10.0},
+		{vql.Mul(vql.Self, vql.Self, vql.Self), 3, 27},
+		{vql.Div(vql.Self, vql.Const(2)), 7, 3},
+		{vql.Div(vql.Self, vql.Const(2.0)), 7, 3.5},
+		{vql.Div(vql.Self, vql.Const(0.0)), 1.0, math.Inf(1)},
+		{vql.Mod(vql.Self, vql.Const(3)), -7, -1},
+		{vql.Mod(vql.Self, vql.Const(2)), 7.5, 1.5},
+		{vql.Each(vql.Mul(vql.Self, vql.Const(2))), []int{1, 2, 3}, []interface{}{2, 4, 6}},
+		{vql.Between(1, 3), 1, true},
+		{vql.Between(1, 3), 3.0, true},
+		{vql.Between(1, 3), 3.5, false},
+		{vql.Between("b", "d"), "c", true},
+		{vql.Between("b", "d"), "a", false},
+		{vql.BetweenExclusive(1, 3), 1, false},
+		{vql.BetweenExclusive(1, 3), 2.5, true},
+		{vql.BetweenExclusive(1, 3), 3, false},
+		{vql.Seq{vql.Key("S"), vql.Select(vql.Between("p", "q")), vql.Count()}, t1, 2},
+		{vql.Match(`^p`), "pear", true},
+		{vql.Match(`^p`), "apple", false},
+		{vql.Seq{vql.Key("S"), vql.Select(vql.Match(`e`)), vql.Count()}, t1, 2},
+		{vql.Capture(`^(\w+)@(.*)$`), "joe@example.com", []interface{}{"joe@example.com", "joe", "example.com"}},
+		{vql.Capture(`^(\w+)@(x)?`), "joe@example.com", []interface{}{"joe@", "joe", ""}},
+		{vql.Capture(`^(\w+)@`), "nobody", nil},
+		{vql.Seq{vql.Key("A"), vql.Capture(`o+`), vql.Index(0)}, t1, "oo"},
+		{vql.Glob("*.example.com"), "www.example.com", true},
+		{vql.Glob("*.example.com"), "example.com", false},
+		{vql.Glob("/etc/*.conf"), "/etc/x/y.conf", false},
+		{vql.Glob("h?st[0-9]"), "host7", true},
+		{vql.All(vql.Lt(8)), []int{3, 5, 7}, true},
+		{vql.None(vql.Gt(6)), []int{3, 5, 7}, false},
+		{vql.None(vql.Gt(9)), []int{3, 5, 7}, true},
+		{vql.None(vql.Gt(2)), []int{}, true},
+		{vql.None(vql.Gt(2)), []interface{}{3, "x"}, false}, // stops before "x"
+		{vql.Seq{vql.Key("S"), vql.None(vql.Eq("kiwi"))}, t1, true},
+
+		// Recursive descent.
+		{vql.Descend(vql.Key("A")), t1, []interface{}{"foo", "bar"}},
+		{vql.Descend(vql.Key("id")), map[string]interface{}{
+			"id": 1,
+			"items": []interface{}{
+				map[string]interface{}{"id": 2, "name": "x"},
+				map[string]interface{}{"name": "y"},
+				map[string]interface{}{"id": 3, "sub": map[string]interface{}{"id": 4}},
+			},
+			"more": map[string]interface{}{"id": 5},
+		}, []interface{}{1, 2, 3, 4, 5}},
+		{vql.Descend(vql.Key("nonesuch")), []interface{}{1, "two", nil}, []interface{}(nil)},
+		{vql.Seq{vql.Descend(vql.Key("B")), vql.Index(-1)}, &t1, 25},
+
+		// Sorting.
+		{vql.SortBy(vql.Self), []int{5, 2, 8, 1}, []interface{}{1, 2, 5, 8}},
+		{vql.SortBy(vql.Self), []string{}, []interface{}{}},
+		{vql.SortBy(vql.Key("B")), []*thingy{&t1, t2}, []interface{}{&t1, t2}},
+		{vql.SortBy(vql.Key("A")), []*thingy{&t1, t2}, []interface{}{t2, &t1}},
+		{vql.Seq{vql.SortBy(vql.Key("Key")), vql.Each(vql.Key("Value"))}, zm, []interface{}{"ten", "twelve"}},
+		{vql.Seq{
+			vql.SortBy(vql.Func(func(s string) int { return len(s) })),
+		}, []string{"ccc", "a", "bb", "d"}, []interface{}{"a", "d", "bb", "ccc"}}, // stable
+		{vql.Sort(vql.Func(func(p []interface{}) bool {
+			return p[0].(int) > p[1].(int) // descending
+		})), []int{3, 1, 4, 1, 5}, []interface{}{5, 4, 3, 1, 1}},
+
+		// Grouping.
+		{vql.GroupBy(vql.Func(func(s string) int { return len(s) })),
+			[]string{"a", "bb", "c", "dd", "eee"},
+			vql.Groups{1: {"a", "c"}, 2: {"bb", "dd"}, 3: {"eee"}}},
+		{vql.GroupBy(vql.Key("T")), []*thingy{&t1, t2, t2}, vql.Groups{
+			t2:             {&t1},
+			(*thingy)(nil): {t2, t2},
+		}},
+		{vql.GroupBy(vql.Self), []int{}, vql.Groups{}},
+		{vql.Seq{
+			vql.GroupBy(vql.Func(func(n int) bool { return n%2 == 0 })),
+			vql.Key(true),
+		}, []int{1, 2, 3, 4}, []interface{}{2, 4}},
+
+		// Aggregation.
+		{vql.Count(), []int{1, 2, 3}, 3},
+		{vql.Count(), sm, 2},
+		{vql.Count(), [0]int{}, 0},
+		{vql.Sum(vql.Self), []int{1, 2, 3}, 6},
+		{vql.Sum(vql.Self), []float64{0.5, 2}, 2.5},
+		{vql.Sum(vql.Self), []interface{}{1, uint8(2), int16(3)}, int64(6)},
+		{vql.Sum(vql.Self), []interface{}{1, 2.5, nil}, 3.5},
+		{vql.Sum(vql.Self), []int{}, int64(0)},
+		{vql.Sum(vql.Key("B")), []*thingy{&t1, t2}, 42},
+		{vql.Min(vql.Key("B")), []*thingy{&t1, t2}, 17},
+		{vql.Max(vql.Key("B")), []*thingy{&t1, t2}, 25},
+		{vql.Min(vql.Key("A")), []*thingy{&t1, t2}, "bar"},
+		{vql.Max(vql.Self), []int{}, nil},
+		{vql.Max(vql.Key("Key")), zm, 12},
+		{vql.Mean(vql.Self), []int{1, 2, 3, 4}, 2.5},
+		{vql.Mean(vql.Key("Value")), map[string]float64{"a": 1, "b": 2}, 1.5},
+		{vql.Mean(vql.Self), []int{}, nil},
+		{vql.Sum(vql.Self), []int8{100, 20}, int8(120)},
+		{vql.Sum(vql.Self), []interface{}{int8(100), int8(100), 1}, int64(201)},
+		{vql.Sum(vql.Self), []uint64{1 << 63, 1 << 62}, uint64(3 << 62)},
+		{vql.Sum(vql.Self), []interface{}{uint64(1 << 63), -1}, int64(1<<63 - 1)},
+		{vql.Mean(vql.Self), []uint8{200, 200}, 200.0},
+		{vql.Mean(vql.Self), []int8{100, 100}, 100.0},
+		{vql.Mean(vql.Self), []int8{-128, -128}, -128.0},
+		{vql.Mean(vql.Self), []uint64{1 << 63, 1 << 63}, float64(1 << 63)},
+
+		// Deduplication.
+		{vql.Distinct(), []int{3, 1, 3, 2, 1}, []interface{}{3, 1, 2}},
+		{vql.Distinct(), []string{}, []interface{}{}},
+		{vql.Distinct(vql.Key("T")), []*thingy{&t1, t2, t2}, []interface{}{&t1, t2}},
+		{vql.Distinct(), []interface{}{
+			[]int{1, 2}, "x", []int{1, 2}, []int{2, 1}, "x",
+		}, []interface{}{[]int{1, 2}, "x", []int{2, 1}}},
+		{vql.Seq{
+			vql.Cat{vql.Key("S"), vql.Key("T", "S"), vql.Key("S")},
+			vql.Distinct(vql.Func(func(s string) byte { return s[0] })),
+		}, t1, []interface{}{"pear", "cherry", "apple"}},
+
 		// Order comparisons.
 		{vql.Lt(25), 16, true},
 		{vql.Gt(25), 16, false},
@@ -97,6 +479,33 @@ func TestQueries(t *testing.T) {
 
 		{vql.Seq{vql.Key("T", "S"), vql.Index(-1)}, t1, "pie"},
 		{vql.Seq{vql.Key("S"), vql.Index(1)}, t1, "plum"},
+		{vql.Not(vql.Eq(25)), 25, false},
+		{vql.Not(vql.Gt(25)), 20, true},
+		{vql.And(), "whatever", true},
+		{vql.OrBool(), "whatever", false},
+		{vql.And(vql.Gt(10), vql.Lt(20)), 15, true},
+		{vql.And(vql.Gt(10), vql.Lt(20)), 25, false},
+		{vql.And(vql.Gt(10), vql.Key("X")), 5, false}, // stops before error
+		{vql.OrBool(vql.Lt(10), vql.Gt(20)), 15, false},
+		{vql.OrBool(vql.Lt(10), vql.Gt(20)), 25, true},
+		{vql.OrBool(vql.Lt(10), vql.Key("X")), 5, true}, // stops before error
+		{vql.Seq{
+			vql.Key("T", "S"),
+			vql.Select(vql.And(
+				vql.Not(vql.Eq("apple")),
+				vql.Func(func(s string) bool { return len(s) < 6 }),
+			)),
+		}, t1, []interface{}{"pie"}},
+		{vql.Range(1, 3), []int{1, 2, 3, 4}, []interface{}{2, 3}},
+		{vql.Range(-2, 10), []int{1, 2, 3, 4}, []interface{}{3, 4}},
+		{vql.Range(-10, -3), [4]int{1, 2, 3, 4}, []interface{}{1}},
+		{vql.Range(3, 1), []int{1, 2, 3, 4}, []interface{}{}},
+		{vql.Take(2), []string{"a", "b", "c"}, []interface{}{"a", "b"}},
+		{vql.Take(5), []string{"a", "b"}, []interface{}{"a", "b"}},
+		{vql.Take(-1), []string{"a", "b"}, []interface{}{}},
+		{vql.Skip(2), []string{"a", "b", "c"}, []interface{}{"c"}},
+		{vql.Skip(5), []string{"a", "b"}, []interface{}{}},
+		{vql.Seq{vql.Key("S"), vql.Skip(1), vql.Take(2)}, t1, []interface{}{"plum", "cherry"}},
 
 		{vql.Seq{
 			vql.Key("S"),
@@ -105,6 +514,13 @@ func TestQueries(t *testing.T) {
 			})),
 		}, t1, []interface{}{"pear", "plum"}},
 
+		{vql.Seq{vql.Key("S"), vql.First(vql.Func(func(s string) bool {
+			return strings.HasPrefix(s, "p")
+		}))}, t1, "pear"},
+		{vql.First(vql.Gt(1)), []interface{}{1, 2, "x"}, 2}, // stops before "x"
+		{vql.First(vql.Gt(5)), []int{1, 2, 3}, nil},
+		{vql.First(vql.Key("Value"), vql.Eq("ten")), zm, vql.Entry{Key: 10, Value: "ten"}},
+
 		{vql.Map{
 			"first":  vql.Key("B"),
 			"second": vql.Seq{vql.Key("T"), vql.Key("B")},
@@ -152,12 +568,74 @@ func TestQueries(t *testing.T) {
 		{vql.Cat{vql.Self}, "x", []interface{}{"x"}},
 		{vql.Cat{vql.Self}, []interface{}{"x"}, []interface{}{"x"}},
 		{vql.Cat{vql.Self}, []string{"a", "b"}, []interface{}{"a", "b"}},
+
+		{vql.Flatten(1), []interface{}{[]int{1, 2}, "x", [][]int{{3}}}, []interface{}{1, 2, "x", []int{3}}},
+		{vql.Flatten(-1), []interface{}{[]interface{}{1, []int{2}}, 3, [1][]string{{"y"}}}, []interface{}{1, 2, 3, "y"}},
+		{vql.Flatten(0), [][]int{{1}, {2}}, []interface{}{[]int{1}, []int{2}}},
+		{vql.Flatten(2), []interface{}{[][]int{{1}, {2, 3}}, [][][]int{{{4}}}}, []interface{}{1, 2, 3, []int{4}}},
+		{vql.Flatten(-1), []int{}, []interface{}{}},
+
+		{vql.Chunk(2), []int{1, 2, 3, 4, 5}, []interface{}{[]interface{}{1, 2}, []interface{}{3, 4}, []interface{}{5}}},
+		{vql.Chunk(3), [3]string{"a", "b", "c"}, []interface{}{[]interface{}{"a", "b", "c"}}},
+		{vql.Chunk(4), []int{}, []interface{}{}},
+		{vql.Partition(vql.Gt(2)), []int{1, 3, 2, 4}, []interface{}{[]interface{}{3, 4}, []interface{}{1, 2}}},
+		{vql.Partition(vql.Key("Value"), vql.Eq(0)), map[string]int{"a": 0, "b": 1, "c": 0}, []interface{}{
+			[]interface{}{vql.Entry{Key: "a", Value: 0}, vql.Entry{Key: "c", Value: 0}},
+			[]interface{}{vql.Entry{Key: "b", Value: 1}},
+		}},
+		{vql.Partition(vql.Eq(1)), []int{}, []interface{}{[]interface{}{}, []interface{}{}}},
+
+		{vql.Zip(vql.Key("Names"), vql.Key("Scores")), map[string]interface{}{
+			"Names":  []string{"a", "b", "c"},
+			"Scores": []interface{}{1, 2.5},
+		}, []interface{}{[]interface{}{"a", 1}, []interface{}{"b", 2.5}}},
+		{vql.Zip(vql.Self, vql.Range(1, 3), vql.Self), []int{7, 8, 9}, []interface{}{[]interface{}{7, 8, 7}, []interface{}{8, 9, 8}}},
+		{vql.Zip(vql.Self), []int{}, []interface{}{}},
+		{vql.Zip(), "whatever", []interface{}{}},
 		{vql.Cat{
 			vql.Key("A"),
 			vql.Key("T", "B"),
 			vql.Key("S"),
 			vql.Key("T", "S"),
 		}, t1, []interface{}{"foo", 25, "pear", "plum", "cherry", "apple", "pie"}},
+
+		// Switch selects a case by the value of a discriminator.
+		{vql.Each(vql.Switch{
+			On: vql.Key("kind"),
+			Cases: map[interface{}]vql.Query{
+				"circle": vql.Key("r"),
+				"square": vql.Key("side"),
+			},
+			Default: vql.Const(0),
+		}), []map[string]interface{}{
+			{"kind": "square", "side": 3},
+			{"kind": "circle", "r": 2},
+			{"kind": "blob"},
+			{},
+		}, []interface{}{3, 2, 0, 0}},
+		{vql.Switch{On: vql.Key("B"), Cases: map[interface{}]vql.Query{17: vql.Key("A")}}, t1, "foo"},
+		{vql.Switch{On: vql.Key("B"), Cases: map[interface{}]vql.Query{25: vql.Key("A")}}, t1, nil},
+		{vql.Switch{On: vql.Key("C"), Cases: map[interface{}]vql.Query{nil: vql.Const("none")}}, t1, "none"},
+		{vql.Switch{On: vql.Key("k"), Cases: map[interface{}]vql.Query{1: vql.Const("one"), "1": vql.Const("s")}},
+			map[string]interface{}{"k": 1.0}, "one"},
+		{vql.Switch{On: vql.Key("k"), Cases: map[interface{}]vql.Query{1.0: vql.Const("one")}},
+			map[string]interface{}{"k": uint8(1)}, "one"},
+		{vql.Switch{On: vql.Key("k"), Cases: map[interface{}]vql.Query{1: vql.Const("one")}, Default: vql.Const("other")},
+			map[string]interface{}{"k": 1.5}, "other"},
+
+		// Key indexes sequences, and Index looks up integer map keys.
+		{vql.Key(1), []string{"a", "b", "c"}, "b"},
+		{vql.Key(-1), &[]string{"a", "b", "c"}, "c"},
+		{vql.Key(uint8(0)), [2]int{5, 6}, 5},
+		{vql.Key(3), []string{"a", "b", "c"}, nil},
+		{vql.Key("T", "S", -1), t1, "pie"},
+		{vql.Index(2), map[int]string{1: "one", 2: "two"}, "two"},
+		{vql.Index(-1), map[int8]string{-1: "neg"}, "neg"},
+		{vql.Index(3), map[uint]string{3: "three"}, "three"},
+		{vql.Index(0), map[interface{}]string{0: "zero", "0": "str"}, "zero"},
+		{vql.Seq{vql.Key("list"), vql.Index(1)}, map[string]interface{}{
+			"list": map[interface{}]interface{}{0: "x", 1: "y"},
+		}, "y"},
 	}
 	for _, test := range tests {
 		got, err := vql.Eval(test.query, test.input)
@@ -169,4 +647,2077 @@ func TestQueries(t *testing.T) {
 	}
 }
 
-// TODO: Add tests for error conditions.
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		query vql.Query
+		input interface{}
+	}{
+		{vql.Key("A"), 25},
+		{vql.Index(5), []int{1, 2, 3}},
+		{vql.Each(vql.Self), "not a slice"},
+		{vql.Exists("A"), 25},
+		{vql.Exists(1), map[string]int{}},
+		{vql.Keys(), []int{1, 2}},
+		{vql.Build([]interface{}{1, vql.Key("x")}), 5},
+		{vql.Var("x"), 5},                                                // unbound
+		{vql.Seq{vql.Let("x", vql.Const(1), vql.Self), vql.Var("x")}, 5}, // out of scope
+		{vql.Let("x", vql.Key("A"), vql.Var("x")), 5},                    // binding fails
+		{vql.Pick("A"), []int{1, 2}},
+		{vql.Omit("A"), map[int]int{1: 2}},                          // non-string keys
+		{vql.MapValues(vql.Self), []int{1, 2}},                      // not a map
+		{vql.MapKeys(vql.Const(1)), map[string]int{"a": 1, "b": 2}}, // duplicate keys
+		{vql.MapKeys(vql.Const([]int{1})), map[string]int{"a": 1}},  // unhashable key
+		{vql.MapValues(vql.Key("x")), map[string]int{"a": 1}},       // not a struct
+		{vql.SelectMap(vql.Const(true)), []int{1, 2}},               // not a map
+		{vql.SelectMap(vql.Key("Key")), map[string]int{"a": 1}},     // non-bool result
+		{vql.KeyStrict("C"), struct{ A int }{}},
+		{vql.KeyOr("A"), []int{1}},
+		{vql.KeyStrict("y"), map[string]int{"x": 1}},
+		{vql.Not(vql.Self), 25},                  // non-bool result
+		{vql.And(vql.Eq(1), vql.Self), 1},        // non-bool result
+		{vql.OrBool(vql.Eq(1), vql.Key("X")), 2}, // not a struct
+		{vql.Take(1), map[string]int{"a": 1}},
+
+		{vql.Every(vql.Self), []int{1, 2}},            // non-bool result
+		{vql.None(vql.Self), []int{1, 2}},             // non-bool result
+		{vql.Contains(1), 1},                          // not a collection
+		{vql.Match(`x`), 1},                           // not a string
+		{vql.Between(1, 3), "2"},                      // not comparable
+		{vql.Between("a", 3), "b"},                    // not comparable
+		{vql.Lt(time.Time{}), 5},                      // not comparable
+		{vql.EqApprox(1, 1), "1"},                     // not a number
+		{vql.Add(vql.Self), "1"},                      // not a number
+		{vql.ToLower(), 1},                            // not a string
+		{vql.AsType(reflect.TypeOf("")), 65},          // number to string
+		{vql.AsType(reflect.TypeOf(0)), "1"},          // not convertible
+		{vql.Default(vql.Index(0), 1), 5},             // not a sequence
+		{vql.ToInt(), "12x"},                          // not a number
+		{vql.ToInt(), 1e20},                           // out of range
+		{vql.ToInt(), uint64(math.MaxUint64)},         // out of range
+		{vql.ToInt(), math.NaN()},                     // out of range
+		{vql.ToInt(), nil},                            // not a number
+		{vql.ToFloat(), "x"},                          // not a number
+		{vql.ToFloat(), true},                         // not a number
+		{vql.ToString(), []int{1}},                    // not a string
+		{vql.Format("%v", vql.Key("x")), 1},           // argument fails
+		{vql.JoinWith(","), "a,b"},                    // not a sequence
+		{vql.JoinWith(","), []interface{}{"a", 1}},    // not a string
+		{vql.Mul(vql.Const(2), vql.Self), nil},        // not a number
+		{vql.Div(vql.Self, vql.Const(0)), 1},          // division by zero
+		{vql.Mod(vql.Self, vql.Const(uint(0))), 1},    // division by zero
+		{vql.Sub(vql.Key("x"), vql.Self), 1},          // operand fails
+		{vql.Capture(`x`), []byte("x")},               // not a string
+		{vql.Glob(`x`), nil},                          // not a string
+		{vql.Any(vql.Self), []string{"x"}},            // non-bool result
+		{vql.Every(vql.Self), []interface{}{true, 5}}, // non-bool result
+		{vql.Any(vql.Self), 17},                       // not a collection
+		{vql.SortBy(vql.Self), []interface{}{1, "x"}}, // incomparable keys
+		{vql.SortBy(vql.Index(0)), []int{1, 2}},       // key fails
+		{vql.Sort(vql.Const(1)), []int{1, 2}},         // non-bool result
+		{vql.GroupBy(vql.Self), [][]int{{1}}},         // unhashable key
+		{vql.Count(), "not a collection"},
+		{vql.Sum(vql.Self), []string{"a"}}, // not a number
+		{vql.Mean(vql.Self), []bool{true}}, // not a number
+		{vql.Sum(vql.Self), []int8{100, 100}},
+		{vql.Sum(vql.Self), []uint8{200, 200}},
+		{vql.Sum(vql.Self), []uint64{1 << 63, 1 << 63}},
+		{vql.Sum(vql.Self), []interface{}{uint64(1 << 63), 1}},
+		{vql.Min(vql.Self), []interface{}{1, "a"}},
+		{vql.GroupBy(vql.Self), []interface{}{[1]interface{}{[]int{}}}},
+		{vql.First(vql.Self), []int{1}},                      // non-bool result
+		{vql.First(vql.Const(false)), 5},                     // not a collection
+		{vql.Switch{On: vql.Self}, []int{1}},                 // unhashable case
+		{vql.Switch{On: vql.Key("x")}, 5},                    // discriminator fails
+		{vql.Switch{On: vql.Self, Default: vql.Key("x")}, 5}, // default fails
+
+		// A struct whose dynamic field value cannot be a case.
+		{vql.Switch{On: vql.Self}, struct{ X interface{} }{X: []int{1}}},
+
+		{vql.AsType(reflect.TypeOf((*error)(nil)).Elem()), 1}, // not convertible
+		{vql.DefaultErr(vql.Index(0), 1, vql.ErrNoKey), 5},    // not a sequence
+		{vql.Require(vql.Self, "msg"), 1},                     // non-bool result
+		{vql.Require(vql.Eq(1), "msg"), 2},                    // requirement fails
+		{vql.NonNil(vql.Key("x")), map[string]int{}},          // nil result
+		{vql.Failf("bad %d", 1), 1},                           // always fails
+		{vql.EachLenient(vql.Self), 1},                        // not a collection
+		{vql.EachIndexed(vql.Index(0)), []int{1}},             // not a sequence
+		{vql.Flatten(1), map[string]int{}},                    // not a sequence
+		{vql.Chunk(0), []int{1}},                              // size not positive
+		{vql.Chunk(2), "ab"},                                  // not a sequence
+		{vql.Partition(vql.Self), []int{1}},                   // non-bool result
+		{vql.Partition(vql.Eq(1)), 5},                         // not a collection
+		{vql.Zip(vql.Self, vql.Const(1)), []int{1}},           // not a sequence
+		{vql.Index(0), (*[]int)(nil)},                         // not a sequence
+		{vql.Or{vql.Key("x"), vql.Fail(vql.ErrNoKey)}, 1},     // last arm fails
+		{vql.KeyStrict(5), []int{1, 2}},                       // index out of range
+		{vql.Index(1), map[int]string{0: "a"}},                // missing int key
+		{vql.Index(-1), map[uint]string{0: "a"}},              // negative uint key
+		{vql.Index(300), map[int8]string{}},                   // key overflows
+		{vql.Index(0), map[string]int{"0": 1}},                // non-integer keys
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(test.query, test.input)
+		if err == nil {
+			t.Errorf("Eval(%v): got %v, want error", test.query, got)
+		} else {
+			t.Logf("Eval(%v): got expected error: %v", test.query, err)
+		}
+	}
+}
+
+// A version is a dotted version number, for testing RegisterCompare.
+type version struct{ major, minor int }
+
+func TestRegisterCompare(t *testing.T) {
+	v := func(major, minor int) version { return version{major, minor} }
+	input := []version{v(1, 10), v(1, 2), v(0, 9), v(2, 0)}
+
+	// Before registration, versions are not ordered.
+	if got, err := vql.Eval(vql.Min(vql.Self), input); err == nil {
+		t.Fatalf("Eval(min): got %v, want error", got)
+	}
+
+	vql.RegisterCompare(func(a, b version) int {
+		if a.major != b.major {
+			return a.major - b.major
+		}
+		return a.minor - b.minor
+	})
+	tests := []struct {
+		query vql.Query
+		want  interface{}
+	}{
+		{vql.Min(vql.Self), v(0, 9)},
+		{vql.Max(vql.Self), v(2, 0)},
+		{vql.SortBy(vql.Self), []interface{}{v(0, 9), v(1, 2), v(1, 10), v(2, 0)}},
+		{vql.Seq{vql.Select(vql.Ge(v(1, 2))), vql.Count()}, 3},
+		{vql.Seq{vql.Select(vql.Between(v(1, 0), v(1, 99))), vql.Count()}, 2},
+		{vql.Seq{vql.Index(1), vql.Lt(v(1, 10))}, true},
+		{vql.Contains(v(2, 0)), true},
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(test.query, input)
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got, cmp.AllowUnexported(version{})); diff != "" {
+			t.Errorf("Eval(%v): (-want, +got)\n%s", test.query, diff)
+		}
+	}
+	// Values of different types are not compared by the function.
+	if got, err := vql.Eval(vql.Lt(v(1, 0)), 5); err == nil {
+		t.Errorf("Eval: got %v, want error", got)
+	}
+
+	// Interface types cannot be registered.
+	defer func() {
+		if x := recover(); x == nil {
+			t.Error("RegisterCompare[fmt.Stringer] did not panic")
+		}
+	}()
+	vql.RegisterCompare(func(a, b fmt.Stringer) int { return 0 })
+}
+
+func TestPatternInvalid(t *testing.T) {
+	for _, fn := range []func(string) vql.Query{vql.Match, vql.Capture, vql.Glob} {
+		func() {
+			defer func() {
+				if x := recover(); x == nil {
+					t.Error("Invalid pattern did not panic")
+				} else {
+					t.Logf("Got expected panic: %v", x)
+				}
+			}()
+			fn(`([unclosed`)
+		}()
+	}
+}
+
+func TestTagKey(t *testing.T) {
+	type Meta struct {
+		Created string `json:"created_at"`
+	}
+	type user struct {
+		Meta
+		ID     int    `json:"user_id" yaml:"id"`
+		Name   string `json:",omitempty"`
+		Secret string `json:"-"`
+		Next   *user  `json:"next"`
+	}
+	input := &user{
+		Meta: Meta{Created: "today"},
+		ID:   101, Name: "alice", Secret: "xyzzy",
+		Next: &user{ID: 102},
+	}
+	tests := []struct {
+		query       vql.Query
+		input, want interface{}
+	}{
+		{vql.TagKey("json", "user_id"), input, 101},
+		{vql.TagKey("yaml", "id"), input, 101},
+		{vql.TagKey("json", "ID"), input, nil},       // renamed by tag
+		{vql.TagKey("yaml", "Name"), input, "alice"}, // no tag, Go name
+		{vql.TagKey("json", "Name"), input, "alice"}, // tag without a name
+		{vql.TagKey("json", "Secret"), input, nil},
+		{vql.TagKey("json", "created_at"), input, "today"},
+		{vql.TagKey("json", "Meta"), input, nil},
+		{vql.TagKey("json", "next", "user_id"), input, 102},
+		{vql.TagKey("json", "x", "y"), map[string]interface{}{
+			"x": map[string]int{"y": 5},
+		}, 5}, // maps are not affected
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(test.query, test.input)
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval(%v): (-want, +got)\n%s", test.query, diff)
+		}
+	}
+}
+
+type account struct {
+	owner   string
+	balance int64
+}
+
+func (a account) Owner() string { return a.owner }
+
+func (a *account) Balance() int64 { return a.balance }
+
+func (a account) Label(prefix string, n int64) string {
+	return fmt.Sprintf("%s%s-%d", prefix, a.owner, n)
+}
+
+func (a *account) Withdraw(n int64) (int64, error) {
+	if n > a.balance {
+		return 0, errors.New("insufficient funds")
+	}
+	a.balance -= n
+	return a.balance, nil
+}
+
+func (a account) Join(sep string, parts ...string) string { return strings.Join(parts, sep) }
+
+func (a account) Context(ctx context.Context) bool { return ctx != nil }
+
+func TestMethod(t *testing.T) {
+	acct := &account{owner: "alice", balance: 100}
+	tests := []struct {
+		query       vql.Query
+		input, want interface{}
+	}{
+		{vql.Method("Owner"), acct, "alice"},
+		{vql.Method("Owner"), *acct, "alice"},
+		{vql.Method("Balance"), acct, int64(100)},
+		{vql.Method("Balance"), *acct, int64(100)}, // pointer method on a copy
+		{vql.Method("Label", "#", 3), acct, "#alice-3"},
+		{vql.Method("Withdraw", 30), acct, int64(70)},
+		{vql.Method("Join", "/"), acct, ""},
+		{vql.Method("Join", "/", "a", "b"), acct, "a/b"},
+		{vql.Method("Context"), acct, true},
+		{vql.Each(vql.Method("Owner")), []account{{owner: "x"}, {owner: "y"}}, []interface{}{"x", "y"}},
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(test.query, test.input)
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval(%v): (-want, +got)\n%s", test.query, diff)
+		}
+	}
+
+	for _, test := range []struct {
+		query vql.Query
+		input interface{}
+	}{
+		{vql.Method("Nonesuch"), acct},
+		{vql.Method("Owner"), nil},
+		{vql.Method("owner"), acct}, // unexported
+		{vql.Method("Owner", 1), acct},
+		{vql.Method("Label", "#"), acct},
+		{vql.Method("Label", 1, 2), acct},
+		{vql.Method("Withdraw", 1000), acct},
+	} {
+		got, err := vql.Eval(test.query, test.input)
+		if err == nil {
+			t.Errorf("Eval(%v): got %v, want error", test.query, got)
+		}
+	}
+}
+
+func TestSyncMap(t *testing.T) {
+	type registry struct {
+		Name  string
+		Items sync.Map
+	}
+	reg := &registry{Name: "r"}
+	reg.Items.Store("b", 2)
+	reg.Items.Store("a", 1)
+	reg.Items.Store(3, "three")
+	reg.Items.Store("nil", nil)
+
+	tests := []struct {
+		query vql.Query
+		want  interface{}
+	}{
+		{vql.Key("Items", "a"), 1},
+		{vql.Key("Items", 3), "three"},
+		{vql.Key("Items", "nonesuch"), nil},
+		{vql.Exists("Items", "nil"), true},
+		{vql.Exists("Items", "nonesuch"), false},
+		{vql.Seq{vql.Key("Items"), vql.Count()}, 4},
+		{vql.Seq{vql.Key("Items"), vql.Keys()}, []interface{}{3, "a", "b", "nil"}},
+		{vql.Seq{vql.Key("Items"), vql.Each(vql.Key("Value"))}, []interface{}{"three", 1, 2, nil}},
+		{vql.Seq{vql.Key("Items"), vql.EachN(vql.Key("Key"), 2)}, []interface{}{3, "a", "b", "nil"}},
+		{vql.Seq{vql.Key("Items"), vql.Select(vql.Key("Value"), vql.Eq(2)), vql.Each(vql.Key("Key"))}, []interface{}{"b"}},
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(test.query, reg)
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval(%v): (-want, +got)\n%s", test.query, diff)
+		}
+	}
+
+	// A compiled query defers sync.Map lookups to evaluation.
+	p, err := vql.Compile(vql.Seq{vql.Key("Items"), vql.Each(vql.Key("Key"))}, reflect.TypeOf(reg))
+	if err != nil {
+		t.Fatalf("Compile: unexpected error: %v", err)
+	}
+	if got, err := p.Eval(reg); err != nil {
+		t.Errorf("Eval: unexpected error: %v", err)
+	} else if diff := cmp.Diff([]interface{}{3, "a", "b", "nil"}, got); diff != "" {
+		t.Errorf("Eval: (-want, +got)\n%s", diff)
+	}
+
+	if got, err := vql.Eval(vql.Key("Items", []int{1}), reg); !errors.Is(err, vql.ErrBadKey) {
+		t.Errorf("Eval: got (%v, %v), want %v", got, err, vql.ErrBadKey)
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	type person struct {
+		Name  string
+		Age   int
+		Title string
+	}
+	data := map[string]interface{}{
+		"People": []person{
+			{Name: "Alice", Age: 35, Title: "CEO"},
+			{Name: "Bob", Age: 28},
+			{Name: "Carol", Age: 41},
+		},
+		"Roles": []map[string]string{{"title": "CEO"}, {}, {"title": "CFO"}},
+	}
+	tests := []struct {
+		text, want string
+	}{
+		{`{{vql "People.count()" .}}`, "3"},
+		{`{{range vql "People.select(Age > 30).each Name" .}}[{{.}}]{{end}}`, "[Alice][Carol]"},
+		{`{{. | vql "People[0].Title"}}`, "CEO"},
+		{`{{range .Roles}}{{vqlDefault "title" "staff" .}} {{end}}`, "CEO staff CFO "},
+		{`{{$q := vqlParse "Age"}}{{range .People}}{{vql $q .}},{{end}}`, "35,28,41,"},
+	}
+	for _, test := range tests {
+		tmpl, err := template.New("test").Funcs(vql.TemplateFuncs()).Parse(test.text)
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error: %v", test.text, err)
+			continue
+		}
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, data); err != nil {
+			t.Errorf("Execute(%q): unexpected error: %v", test.text, err)
+		} else if got := sb.String(); got != test.want {
+			t.Errorf("Execute(%q): got %q, want %q", test.text, got, test.want)
+		}
+	}
+
+	for _, text := range []string{
+		`{{vql "People[" .}}`,
+		`{{vql "People.strict(Nonesuch)" .}}`,
+		`{{vql 5 .}}`,
+		`{{vqlParse "x("}}`,
+	} {
+		tmpl := template.Must(template.New("test").Funcs(vql.TemplateFuncs()).Parse(text))
+		if err := tmpl.Execute(io.Discard, data); err == nil {
+			t.Errorf("Execute(%q): got nil, want error", text)
+		}
+	}
+}
+
+func TestEvalJSON(t *testing.T) {
+	const doc = `{"people": [{"name": "alice", "age": 30}, {"name": "bob", "age": 25}]}`
+	got, err := vql.EvalJSON(vql.Seq{
+		vql.Key("people"),
+		vql.Select(vql.Key("age"), vql.Gt(26)),
+		vql.Each(vql.Key("name")),
+	}, []byte(doc))
+	if err != nil {
+		t.Errorf("EvalJSON: unexpected error: %v", err)
+	} else if diff := cmp.Diff([]interface{}{"alice"}, got); diff != "" {
+		t.Errorf("EvalJSON: (-want, +got)\n%s", diff)
+	}
+
+	// Options are applied to the evaluation.
+	got, err = vql.EvalJSON(vql.Key(vql.Param("k")), []byte(doc), vql.Args{"k": "people"})
+	if err != nil {
+		t.Errorf("EvalJSON: unexpected error: %v", err)
+	} else if n := len(got.([]interface{})); n != 2 {
+		t.Errorf("EvalJSON: got %d people, want 2", n)
+	}
+
+	// Decoding errors are distinct from query errors.
+	var derr *vql.DecodeError
+	if got, err := vql.EvalJSON(vql.Self, []byte(`{"bad":`)); !errors.As(err, &derr) {
+		t.Errorf("EvalJSON: got (%v, %v), want *DecodeError", got, err)
+	} else if derr.Format != "JSON" {
+		t.Errorf("DecodeError format: got %q, want JSON", derr.Format)
+	}
+	var qerr *vql.Error
+	if got, err := vql.EvalJSON(vql.KeyStrict("nonesuch"), []byte(doc)); !errors.As(err, &qerr) || errors.As(err, &derr) {
+		t.Errorf("EvalJSON: got (%v, %v), want *Error", got, err)
+	}
+}
+
+func TestDecodeRawJSON(t *testing.T) {
+	type event struct {
+		Kind    string
+		Payload json.RawMessage
+		Blobs   [][]byte
+	}
+	ev := event{
+		Kind:    "login",
+		Payload: json.RawMessage(`{"user": {"id": 7, "tags": ["a", "b"]}}`),
+		Blobs:   [][]byte{[]byte(`[1, 2]`), []byte(`"text"`), []byte(`{bad`)},
+	}
+	tests := []struct {
+		query vql.Query
+		input interface{}
+		want  interface{}
+	}{
+		{vql.Key("Payload", "user", "id"), ev, 7.0},
+		{vql.Seq{vql.Key("Payload", "user", "tags"), vql.Index(-1)}, ev, "b"},
+		{vql.Seq{vql.Key("Payload"), vql.Descend(vql.Key("id"))}, ev, []interface{}{7.0}},
+		{vql.Key("Blobs", 0, 1), ev, 2.0},
+		{vql.Key("Blobs", 1), ev, []byte(`"text"`)}, // not an object or array
+		{vql.Key("Blobs", 2), ev, []byte(`{bad`)},   // not valid JSON
+		{vql.Key("x"), json.RawMessage(`{"x": true}`), true},
+		{vql.Key("Kind"), ev, "login"},
+	}
+	for _, test := range tests {
+		got, err := vql.EvalWith(test.query, test.input, vql.DecodeRawJSON())
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval(%v): (-want, +got)\n%s", test.query, diff)
+		}
+	}
+
+	// Without the option, raw values are not decoded.
+	if got, err := vql.Eval(vql.Key("Payload", "user"), ev); err == nil {
+		t.Errorf("Eval: got %v, want error", got)
+	}
+}
+
+// The fakeStruct, fakeList, and fakeValue types have the methods of the
+// protobuf Struct, ListValue, and Value types that vql relies on.
+type (
+	fakeStruct struct{ fields map[string]*fakeValue }
+	fakeList   struct{ values []*fakeValue }
+	fakeValue  struct{ kind interface{} } // scalar, *fakeStruct, or *fakeList
+)
+
+func (*fakeStruct) ProtoReflect() struct{} { return struct{}{} }
+func (*fakeList) ProtoReflect() struct{}   { return struct{}{} }
+func (*fakeValue) ProtoReflect() struct{}  { return struct{}{} }
+
+func (s *fakeStruct) AsMap() map[string]interface{} {
+	m := make(map[string]interface{})
+	for key, val := range s.fields {
+		m[key] = val.AsInterface()
+	}
+	return m
+}
+
+func (l *fakeList) AsSlice() []interface{} {
+	out := make([]interface{}, len(l.values))
+	for i, val := range l.values {
+		out[i] = val.AsInterface()
+	}
+	return out
+}
+
+func (v *fakeValue) AsInterface() interface{} {
+	switch t := v.kind.(type) {
+	case *fakeStruct:
+		return t.AsMap()
+	case *fakeList:
+		return t.AsSlice()
+	}
+	return v.kind
+}
+
+// A notProto has an AsMap method but is not a protobuf message.
+type notProto struct{ Name string }
+
+func (notProto) AsMap() map[string]interface{} { return nil }
+
+func TestProtoStruct(t *testing.T) {
+	st := &fakeStruct{fields: map[string]*fakeValue{
+		"name": {kind: "alice"},
+		"tags": {kind: &fakeList{values: []*fakeValue{{kind: "a"}, {kind: "b"}}}},
+		"meta": {kind: &fakeStruct{fields: map[string]*fakeValue{"n": {kind: 3.0}}}},
+	}}
+	type request struct {
+		Method string
+		Body   *fakeStruct
+	}
+	req := &request{Method: "GET", Body: st}
+	tests := []struct {
+		query vql.Query
+		input interface{}
+		want  interface{}
+	}{
+		{vql.Key("name"), st, "alice"},
+		{vql.Key("meta", "n"), st, 3.0},
+		{vql.Seq{vql.Key("tags"), vql.Index(-1)}, st, "b"},
+		{vql.Seq{vql.Key("tags"), vql.Each(vql.Func(strings.ToUpper))}, st, []interface{}{"A", "B"}},
+		{vql.Key("Body", "tags", 0), req, "a"},
+		{vql.Seq{vql.Key("Body"), vql.Keys()}, req, []interface{}{"meta", "name", "tags"}},
+		{vql.Index(1), &fakeList{values: []*fakeValue{{kind: 1.0}, {kind: 2.0}}}, 2.0},
+		{vql.Key("x"), &fakeValue{kind: &fakeStruct{fields: map[string]*fakeValue{"x": {kind: true}}}}, true},
+		{vql.Key("Name"), notProto{Name: "bob"}, "bob"},
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(test.query, test.input)
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval(%v): (-want, +got)\n%s", test.query, diff)
+		}
+	}
+
+	// Compiled queries defer to evaluation for protobuf values.
+	for _, test := range []struct {
+		query vql.Query
+		input interface{}
+	}{
+		{vql.Key("Body", "meta", "n"), req},
+		{vql.Key("meta", "n"), st},
+	} {
+		p, err := vql.Compile(test.query, reflect.TypeOf(test.input))
+		if err != nil {
+			t.Errorf("Compile(%v): unexpected error: %v", test.query, err)
+		} else if got, err := p.Eval(test.input); err != nil || got != 3.0 {
+			t.Errorf("Eval(%v): got (%v, %v), want 3", test.query, got, err)
+		}
+	}
+}
+
+// The fakeMessage type resembles the Go type generated for a protobuf message
+// with a oneof, as:
+//
+//	message FakeMessage {
+//	  string user_name = 1;
+//	  repeated int32 lucky_numbers = 2;
+//	  oneof contact {
+//	    string email_address = 3;
+//	    int64 phone_number = 4;
+//	  }
+//	  map<string, int32> pet_counts = 5;
+//	  FakeMessage best_friend = 6;
+//	}
+//
+// Its ProtoReflect method returns a fake with the subset of the protoreflect
+// API that vql uses.
+type fakeMessage struct {
+	state int // stands in for the unexported generated fields
+
+	UserName     string
+	LuckyNumbers []int32
+	// Types that are assignable to Contact:
+	//
+	//	*FakeMessage_EmailAddress
+	//	*FakeMessage_PhoneNumber
+	Contact    isFakeMessage_Contact
+	PetCounts  map[string]int32
+	BestFriend *fakeMessage
+}
+
+type isFakeMessage_Contact interface{ isFakeMessage_Contact() }
+
+type FakeMessage_EmailAddress struct{ EmailAddress string }
+
+type FakeMessage_PhoneNumber struct{ PhoneNumber int64 }
+
+func (*FakeMessage_EmailAddress) isFakeMessage_Contact() {}
+func (*FakeMessage_PhoneNumber) isFakeMessage_Contact()  {}
+
+func (m *fakeMessage) ProtoReflect() fakeReflectMessage {
+	vals := map[fakeName]interface{}{
+		"user_name":  m.UserName,
+		"pet_counts": fakeReflectMap(m.PetCounts),
+	}
+	lucky := make(fakeReflectList, len(m.LuckyNumbers))
+	for i, n := range m.LuckyNumbers {
+		lucky[i] = n
+	}
+	vals["lucky_numbers"] = lucky
+	switch c := m.Contact.(type) {
+	case *FakeMessage_EmailAddress:
+		vals["email_address"] = c.EmailAddress
+	case *FakeMessage_PhoneNumber:
+		vals["phone_number"] = c.PhoneNumber
+	}
+	vals["best_friend"] = &fakeReflect{} // not set
+	if m.BestFriend != nil {
+		vals["best_friend"] = m.BestFriend.ProtoReflect()
+	}
+	return &fakeReflect{vals: vals, msg: m, fields: fakeMessageFields}
+}
+
+// A dynMessage is a protobuf message that is not generated from a struct with
+// a field for each proto field, as with the dynamicpb package.
+type dynMessage struct{ vals map[fakeName]interface{} }
+
+func (d *dynMessage) ProtoReflect() fakeReflectMessage {
+	return &fakeReflect{vals: d.vals, msg: d, fields: fakeFields{{name: "title"}, {name: "page_count"}}}
+}
+
+// The following types fake the parts of the protoreflect API used by vql.
+type (
+	fakeName string
+
+	fakeReflectMessage interface {
+		Descriptor() fakeDescriptor
+		Has(fakeFieldDesc) bool
+		Get(fakeFieldDesc) fakeReflectValue
+		IsValid() bool
+		Interface() interface{}
+	}
+	fakeReflect struct {
+		vals   map[fakeName]interface{} // nil if the message is not set
+		msg    interface{}
+		fields fakeFields
+	}
+
+	fakeDescriptor struct{ fields fakeFields }
+	fakeFields     []*fakeField
+
+	fakeFieldDesc interface {
+		Name() fakeName
+		ContainingOneof() fakeOneofDesc
+	}
+	fakeField struct {
+		name  fakeName
+		oneof bool
+	}
+
+	fakeOneofDesc interface{ IsSynthetic() bool }
+	fakeOneof     struct{}
+
+	fakeReflectValue struct{ v interface{} }
+	fakeReflectList  []interface{}
+	fakeReflectMap   map[string]int32
+	fakeMapKey       struct{ k string }
+)
+
+var fakeMessageFields = fakeFields{
+	{name: "user_name"},
+	{name: "lucky_numbers"},
+	{name: "email_address", oneof: true},
+	{name: "phone_number", oneof: true},
+	{name: "pet_counts"},
+	{name: "best_friend"},
+}
+
+func (r *fakeReflect) Descriptor() fakeDescriptor { return fakeDescriptor{fields: r.fields} }
+func (r *fakeReflect) Has(fd fakeFieldDesc) bool  { _, ok := r.vals[fd.Name()]; return ok }
+func (r *fakeReflect) Get(fd fakeFieldDesc) fakeReflectValue {
+	if v, ok := r.vals[fd.Name()]; ok {
+		return fakeReflectValue{v: v}
+	}
+	return fakeReflectValue{}
+}
+func (r *fakeReflect) IsValid() bool          { return r.vals != nil }
+func (r *fakeReflect) Interface() interface{} { return r.msg }
+
+func (d fakeDescriptor) Fields() fakeFields { return d.fields }
+
+func (fs fakeFields) Len() int                { return len(fs) }
+func (fs fakeFields) Get(i int) fakeFieldDesc { return fs[i] }
+func (fs fakeFields) ByName(name fakeName) fakeFieldDesc {
+	for _, f := range fs {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (f *fakeField) Name() fakeName { return f.name }
+func (f *fakeField) ContainingOneof() fakeOneofDesc {
+	if f.oneof {
+		return fakeOneof{}
+	}
+	return nil
+}
+
+func (fakeOneof) IsSynthetic() bool { return false }
+
+func (v fakeReflectValue) Interface() interface{} { return v.v }
+
+func (l fakeReflectList) Len() int                   { return len(l) }
+func (l fakeReflectList) Get(i int) fakeReflectValue { return fakeReflectValue{v: l[i]} }
+
+func (m fakeReflectMap) Range(f func(fakeMapKey, fakeReflectValue) bool) {
+	for k, v := range m {
+		if !f(fakeMapKey{k: k}, fakeReflectValue{v: v}) {
+			return
+		}
+	}
+}
+
+func (k fakeMapKey) Interface() interface{} { return k.k }
+
+func TestProtoFields(t *testing.T) {
+	friend := &fakeMessage{UserName: "bob"}
+	msg := &fakeMessage{
+		UserName:     "alice",
+		LuckyNumbers: []int32{3, 7},
+		Contact:      &FakeMessage_EmailAddress{EmailAddress: "a@example.com"},
+		PetCounts:    map[string]int32{"cat": 2},
+		BestFriend:   friend,
+	}
+	tests := []struct {
+		query vql.Query
+		want  interface{}
+	}{
+		{vql.Key("UserName"), "alice"},
+		{vql.Key("user_name"), "alice"},
+		{vql.Key("lucky_numbers", -1), int32(7)},
+		{vql.Key("email_address"), "a@example.com"},
+		{vql.Key("EmailAddress"), "a@example.com"},
+		{vql.Key("phone_number"), nil}, // not the member that is set
+		{vql.Key("PhoneNumber"), nil},  // not the member that is set
+		{vql.Key("userName"), nil},     // JSON names are not matched
+		{vql.Key("pet_counts", "cat"), int32(2)},
+		{vql.Key("best_friend", "user_name"), "bob"},
+		{vql.Key("best_friend", "best_friend"), nil}, // not set
+		{vql.Exists("user_name"), true},
+		{vql.Exists("phone_number"), false},
+		{vql.Pick("user_name", "email_address"), vql.Values{"user_name": "alice", "email_address": "a@example.com"}},
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(test.query, msg)
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval(%v): (-want, +got)\n%s", test.query, diff)
+		}
+
+		p, err := vql.Compile(test.query, reflect.TypeOf(msg))
+		if err != nil {
+			t.Errorf("Compile(%v): unexpected error: %v", test.query, err)
+		} else if got, err := p.Eval(msg); err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", p, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval(%v): (-want, +got)\n%s", p, diff)
+		}
+	}
+
+	// A message need not have a Go field for each proto field.
+	dyn := &dynMessage{vals: map[fakeName]interface{}{"title": "Dune", "page_count": int32(412)}}
+	for _, test := range []struct {
+		query vql.Query
+		want  interface{}
+	}{
+		{vql.Key("title"), "Dune"},
+		{vql.Key("PageCount"), int32(412)},
+		{vql.Key("author"), nil},
+	} {
+		got, err := vql.Eval(test.query, dyn)
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval(%v): (-want, +got)\n%s", test.query, diff)
+		}
+	}
+}
+
+// An orderedMap is a Keyer and Seqer that preserves insertion order.
+type orderedMap struct {
+	keys []string
+	vals map[string]interface{}
+}
+
+func (m *orderedMap) set(key string, val interface{}) *orderedMap {
+	if m.vals == nil {
+		m.vals = make(map[string]interface{})
+	}
+	if _, ok := m.vals[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.vals[key] = val
+	return m
+}
+
+func (m *orderedMap) QueryKey(key interface{}) (interface{}, bool) {
+	s, ok := key.(string)
+	if !ok {
+		return nil, false
+	}
+	val, ok := m.vals[s]
+	return val, ok
+}
+
+func (m *orderedMap) QuerySeq() []interface{} {
+	out := make([]interface{}, len(m.keys))
+	for i, key := range m.keys {
+		out[i] = vql.Entry{Key: key, Value: m.vals[key]}
+	}
+	return out
+}
+
+// A countdown is a Seqer whose elements are generated on demand.
+type countdown int
+
+func (c countdown) QuerySeq() []interface{} {
+	out := make([]interface{}, c)
+	for i := range out {
+		out[i] = int(c) - i
+	}
+	return out
+}
+
+func TestAdapters(t *testing.T) {
+	m := new(orderedMap).set("z", 26).set("a", 1).set("m", countdown(3))
+	tests := []struct {
+		query vql.Query
+		input interface{}
+		want  interface{}
+	}{
+		{vql.Key("a"), m, 1},
+		{vql.Key("nonesuch"), m, nil},
+		{vql.Key(0), m, nil}, // a Keyer handles integer keys itself
+		{vql.Exists("z"), m, true},
+		{vql.Pick("a", "z"), m, vql.Values{"a": 1, "z": 26}},
+		{vql.Each(vql.Key("Key")), m, []interface{}{"z", "a", "m"}},
+		{vql.Seq{vql.Index(-1), vql.Key("Key")}, m, "m"},
+		{vql.Count(), m, 3},
+		{vql.Key("m", 0), m, 3},
+		{vql.Seq{vql.Key("m"), vql.Index(-1)}, m, 1},
+		{vql.Seq{vql.Key("m"), vql.Select(vql.Gt(1))}, m, []interface{}{3, 2}},
+		{vql.Seq{vql.Key("m"), vql.Range(1, 3)}, m, []interface{}{2, 1}},
+		{vql.Seq{vql.Key("m"), vql.Count()}, m, 3},
+		{vql.Seq{vql.Key("m"), vql.EachN(vql.Self, 2)}, m, []interface{}{3, 2, 1}},
+		{vql.Sum(vql.Self), countdown(4), 10},
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(test.query, test.input)
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval(%v): (-want, +got)\n%s", test.query, diff)
+		}
+	}
+
+	// Compiled queries defer to the adapters when they are evaluated.
+	p, err := vql.Compile(vql.Seq{vql.Each(vql.Key("Value")), vql.Index(1)}, reflect.TypeOf(m))
+	if err != nil {
+		t.Fatalf("Compile: unexpected error: %v", err)
+	}
+	if got, err := p.Eval(m); err != nil || got != 1 {
+		t.Errorf("Eval: got (%v, %v), want 1", got, err)
+	}
+}
+
+type ledger struct {
+	account // embedded, with unexported fields
+	*Audit  // embedded pointer, possibly nil
+	Owner   string
+	entries []int
+}
+
+type Audit struct {
+	By    string
+	notes string
+}
+
+func TestEmbeddedFields(t *testing.T) {
+	full := ledger{
+		account: account{owner: "alice", balance: 100},
+		Audit:   &Audit{By: "bob", notes: "ok"},
+		Owner:   "carol",
+		entries: []int{1, 2},
+	}
+	bare := &ledger{Owner: "dave"}
+	tests := []struct {
+		query       vql.Query
+		input, want interface{}
+		unexported  bool
+	}{
+		// Promoted fields are found by their own names or through the
+		// embedded field.
+		{vql.Key("By"), full, "bob", false},
+		{vql.Key("Audit", "By"), full, "bob", false},
+		{vql.Key("Owner"), full, "carol", false},
+		{vql.Exists("By"), full, true, false},
+		{vql.Pick("Owner", "By"), full, vql.Values{"Owner": "carol", "By": "bob"}, false},
+
+		// A field reached through a nil embedded pointer is missing.
+		{vql.Key("By"), bare, nil, false},
+		{vql.Exists("By"), bare, false, false},
+
+		// Unexported fields are missing unless allowed.
+		{vql.Key("entries"), full, nil, false},
+		{vql.Key("owner"), full, nil, false},
+		{vql.Exists("notes"), full, false, false},
+		{vql.Key("entries"), full, []int{1, 2}, true},
+		{vql.Key("entries", -1), &full, 2, true},
+		{vql.Key("owner"), full, "alice", true},
+		{vql.Key("account", "balance"), full, int64(100), true},
+		{vql.Key("notes"), &full, "ok", true},
+		{vql.Key("notes"), bare, nil, true},
+		{vql.Exists("notes"), full, true, true},
+	}
+	for _, test := range tests {
+		var opts []vql.Option
+		if test.unexported {
+			opts = append(opts, vql.AllowUnexported())
+		}
+		got, err := vql.EvalWith(test.query, test.input, opts...)
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval(%v): (-want, +got)\n%s", test.query, diff)
+		}
+	}
+
+	if got, err := vql.Eval(vql.KeyStrict("entries"), full); !errors.Is(err, vql.ErrNoKey) {
+		t.Errorf("KeyStrict: got (%v, %v), want %v", got, err, vql.ErrNoKey)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	q := vql.Seq{
+		vql.Key("People"),
+		vql.Select(vql.And(
+			vql.Seq{vql.Key("Age"), vql.Gt(20)},
+			vql.Seq{vql.Key("Name"), vql.FuncRef("valid")},
+		)),
+		vql.Map{
+			"b": vql.Each(vql.FuncRef("second")),
+			"a": vql.Or{vql.FuncRef("first"), vql.Memoize(vql.FuncRef("skipped"))},
+		},
+		vql.Build([]interface{}{1, map[string]interface{}{"x": vql.FuncRef("third")}}),
+	}
+	var visited, refs []string
+	vql.Walk(q, func(q vql.Query) bool {
+		s := fmt.Sprint(q)
+		visited = append(visited, s)
+		if strings.HasPrefix(s, "@") {
+			refs = append(refs, s)
+		}
+		return !strings.HasPrefix(s, "memo(")
+	})
+	if diff := cmp.Diff([]string{"@valid", "@first", "@second", "@third"}, refs); diff != "" {
+		t.Errorf("Walk: wrong refs (-want, +got)\n%s", diff)
+	}
+	if got, want := len(visited), 22; got != want {
+		t.Errorf("Walk: visited %d queries, want %d:\n%s", got, want, strings.Join(visited, "\n"))
+	}
+
+	// Returning false at the root prevents further visits.
+	n := 0
+	vql.Walk(q, func(vql.Query) bool { n++; return false })
+	if n != 1 {
+		t.Errorf("Walk: visited %d queries, want 1", n)
+	}
+}
+
+func TestCallsMethod(t *testing.T) {
+	tests := []struct {
+		query vql.Query
+		want  bool
+	}{
+		{vql.Method("Reset"), true},
+		{vql.Seq{vql.Key("Servers"), vql.Each(vql.Method("Reset"))}, true},
+		{vql.Map{"a": vql.Key("x"), "b": vql.Memoize(vql.Method("Reset"))}, true},
+		{vql.Key("method(Reset)"), false},
+		{vql.Const("method(Reset)"), false},
+		{vql.Seq{vql.Key("Servers"), vql.Each(vql.Key("Name"))}, false},
+	}
+	for _, test := range tests {
+		if got := vql.CallsMethod(test.query); got != test.want {
+			t.Errorf("CallsMethod(%v): got %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestMemoize(t *testing.T) {
+	var calls int
+	q := vql.Memoize(vql.Func(func(s string) int {
+		calls++
+		return len(s)
+	}))
+
+	inputs := []string{"apple", "pear", "apple", "apple", "pear", "plum"}
+	got, err := vql.Eval(vql.Each(q), inputs)
+	if err != nil {
+		t.Fatalf("Eval: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]interface{}{5, 4, 5, 5, 4, 4}, got); diff != "" {
+		t.Errorf("Eval: (-want, +got)\n%s", diff)
+	}
+	if calls != 3 {
+		t.Errorf("Memoized function called %d times, want 3", calls)
+	}
+
+	// Non-comparable inputs are not cached.
+	calls = 0
+	s := vql.Memoize(vql.Func(func(ss []string) int {
+		calls++
+		return len(ss)
+	}))
+	for i := 0; i < 3; i++ {
+		if _, err := vql.Eval(s, inputs); err != nil {
+			t.Fatalf("Eval: unexpected error: %v", err)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("Uncacheable function called %d times, want 3", calls)
+	}
+
+	// Results that depend on the evaluation's bindings are not shared.
+	ref := vql.Memoize(vql.FuncRef("f"))
+	for _, want := range []string{"A", "B"} {
+		got, err := vql.EvalWithFuncs(ref, "x", map[string]interface{}{
+			"f": func(string) string { return want },
+		})
+		if err != nil || got != want {
+			t.Errorf("EvalWithFuncs: got (%v, %v), want (%s, nil)", got, err, want)
+		}
+	}
+	param := vql.Memoize(vql.Seq{vql.Param("p"), vql.ToString()})
+	for _, want := range []string{"1", "2"} {
+		got, err := vql.EvalWith(param, "x", vql.Args(map[string]interface{}{"p": want}))
+		if err != nil || got != want {
+			t.Errorf("EvalWith: got (%v, %v), want (%s, nil)", got, err, want)
+		}
+	}
+	let := vql.Memoize(vql.Var("v"))
+	for _, want := range []int{1, 2} {
+		got, err := vql.Eval(vql.Let("v", vql.Const(want), let), "x")
+		if err != nil || got != want {
+			t.Errorf("Eval: got (%v, %v), want (%d, nil)", got, err, want)
+		}
+	}
+	type ctxKey struct{}
+	byCtx := vql.Memoize(vql.Func(func(ctx context.Context, s string) string {
+		return ctx.Value(ctxKey{}).(string)
+	}))
+	for _, want := range []string{"A", "B"} {
+		ctx := context.WithValue(context.Background(), ctxKey{}, want)
+		got, err := vql.EvalContext(ctx, byCtx, "x")
+		if err != nil || got != want {
+			t.Errorf("EvalContext: got (%v, %v), want (%s, nil)", got, err, want)
+		}
+	}
+}
+
+func TestFuncRef(t *testing.T) {
+	q := vql.Seq{vql.Key("S"), vql.Each(vql.FuncRef("check"))}
+	input := struct{ S []string }{S: []string{"alice", "bob"}}
+
+	for _, test := range []struct {
+		fn   interface{}
+		want []interface{}
+	}{
+		{strings.ToUpper, []interface{}{"ALICE", "BOB"}},
+		{func(s string) int { return len(s) }, []interface{}{5, 3}},
+		{func(s string) (bool, error) { return s == "bob", nil }, []interface{}{false, true}},
+	} {
+		got, err := vql.EvalWithFuncs(q, input, map[string]interface{}{"check": test.fn})
+		if err != nil {
+			t.Errorf("EvalWithFuncs: unexpected error: %v", err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("EvalWithFuncs: (-want, +got)\n%s", diff)
+		}
+	}
+
+	// Unbound or invalid functions are reported at evaluation time. Global
+	// functions are not used when functions are supplied.
+	vql.RegisterFunc("testGlobalCheck", func(string) bool { return true })
+	gq := vql.Seq{vql.Key("S"), vql.Index(0), vql.FuncRef("testGlobalCheck")}
+	if got, err := vql.Eval(gq, input); err != nil || got != true {
+		t.Errorf("Eval: got (%v, %v), want (true, nil)", got, err)
+	}
+	if got, err := vql.EvalWithFuncs(gq, input, map[string]interface{}{}); err == nil {
+		t.Errorf("EvalWithFuncs: got %v, want error", got)
+	}
+	for _, fns := range []map[string]interface{}{
+		nil,
+		{"other": strings.ToUpper},
+		{"check": "not a function"},
+		{"check": func(a, b string) bool { return a == b }},
+	} {
+		got, err := vql.EvalWithFuncs(q, input, fns)
+		if err == nil {
+			t.Errorf("EvalWithFuncs(%v): got %v, want error", fns, got)
+		}
+	}
+}
+
+func TestParam(t *testing.T) {
+	type person struct {
+		Name, Title string
+		Age         int
+	}
+	input := []person{{"Alice", "CEO", 35}, {"Bob", "MGR", 38}, {"Carol", "MGR", 19}}
+
+	// The same query is evaluated with different parameter values.
+	q := vql.Seq{vql.Select(vql.Key("Age"), vql.Ge(vql.Param("min"))), vql.Each(vql.Key(vql.Param("field")))}
+	pq, err := vql.Parse(`select(Age >= $min).each(key($field))`)
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	for _, test := range []struct {
+		args vql.Args
+		want []interface{}
+	}{
+		{vql.Args{"min": 30, "field": "Name"}, []interface{}{"Alice", "Bob"}},
+		{vql.Args{"min": 0, "field": "Title"}, []interface{}{"CEO", "MGR", "MGR"}},
+		{vql.Args{"min": 38, "field": "Age"}, []interface{}{38}},
+	} {
+		for _, q := range []vql.Query{q, pq} {
+			got, err := vql.EvalWith(q, input, test.args)
+			if err != nil {
+				t.Errorf("EvalWith(%v, %v): unexpected error: %v", q, test.args, err)
+			} else if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("EvalWith(%v, %v): (-want, +got)\n%s", q, test.args, diff)
+			}
+		}
+	}
+
+	// A parameter may supply a default value.
+	got, err := vql.EvalWith(vql.Seq{vql.Index(2), vql.Default(vql.Key("Nope"), vql.Param("name"))}, input,
+		vql.Args{"name": "anonymous"})
+	if err != nil {
+		t.Errorf("EvalWith: unexpected error: %v", err)
+	} else if got != "anonymous" {
+		t.Errorf("EvalWith: got %v, want anonymous", got)
+	}
+
+	// Multiple Args options are merged.
+	got, err = vql.EvalWith(vql.List{vql.Param("a"), vql.Param("b")}, nil,
+		vql.Args{"a": 1, "b": 2}, vql.Args{"b": 3})
+	if err != nil {
+		t.Errorf("EvalWith: unexpected error: %v", err)
+	} else if diff := cmp.Diff([]interface{}{1, 3}, got); diff != "" {
+		t.Errorf("EvalWith: (-want, +got)\n%s", diff)
+	}
+
+	// Unbound parameters are reported at evaluation time.
+	for _, args := range []vql.Args{nil, {"min": 30}, {"field": "Name"}} {
+		got, err := vql.EvalWith(q, input, args)
+		if err == nil {
+			t.Errorf("EvalWith(%v): got %v, want error", args, got)
+		}
+	}
+}
+
+func TestEvalPaths(t *testing.T) {
+	type person struct {
+		Name string
+		Age  int
+		Tags map[string]string
+	}
+	input := map[string]interface{}{
+		"People": []*person{
+			{Name: "Alice", Age: 35, Tags: map[string]string{"role": "ceo"}},
+			{Name: "Bob", Age: 38},
+			{Name: "Carol", Age: 19, Tags: map[string]string{"role": "intern", "team": "x"}},
+		},
+		"Count": 3,
+	}
+	tests := []struct {
+		query    vql.Query
+		computed bool     // the value is computed, not found in the input
+		want     []string // path and value, as "path=value"
+	}{
+		{vql.Self, false, []string{"self=map[Count:3 People:[...]]"}},
+		{vql.Key("Count"), false, []string{"Count=3"}},
+		{vql.Seq{vql.Key("People"), vql.Index(-1), vql.Key("Name")}, false, []string{"People[2].Name=Carol"}},
+		{vql.Seq{vql.Key("People"), vql.Each(vql.Key("Name"))}, false, []string{
+			"People[0].Name=Alice", "People[1].Name=Bob", "People[2].Name=Carol",
+		}},
+		{vql.Seq{vql.Key("People"), vql.Select(vql.Key("Age"), vql.Gt(30)), vql.Each(vql.Key("Age"))}, false, []string{
+			"People[0].Age=35", "People[1].Age=38",
+		}},
+		{vql.Seq{vql.Key("People"), vql.EachLenient(vql.Seq{vql.KeyStrict("Tags"), vql.KeyStrict("role")})}, false, []string{
+			"People[0].Tags.role=ceo", "People[2].Tags.role=intern",
+		}},
+		{vql.Seq{vql.Key("People"), vql.EachIndexed(vql.Key("Value", "Age"))}, false, []string{
+			"People[0].Age=35", "People[1].Age=38", "People[2].Age=19",
+		}},
+		{vql.Seq{vql.Key("People"), vql.Skip(1), vql.Index(1), vql.Key("Name")}, false, []string{"People[2].Name=Carol"}},
+		{vql.Seq{vql.Key("People"), vql.Key(1), vql.Key("Name")}, false, []string{"People[1].Name=Bob"}},
+		{vql.Seq{vql.Key("People"), vql.Descend(vql.Key("role"))}, false, []string{
+			"People[0].Tags.role=ceo", "People[2].Tags.role=intern",
+		}},
+		{vql.Seq{vql.Key("People"), vql.Index(0), vql.Key("Tags"), vql.Each(vql.Key("Value"))}, false, []string{
+			"People[0].Tags.role=ceo",
+		}},
+		{vql.Seq{vql.Key("People"), vql.Each(vql.Key("Name")), vql.Count()}, true, []string{"People=3"}},
+		{vql.Seq{vql.Key("People"), vql.Select(vql.Key("Age"), vql.Gt(40))}, false, nil},
+		{vql.Let("x", vql.Self, vql.Or{vql.Key("Nope"), vql.Key("Count")}), false, []string{"Count=3"}},
+	}
+	for _, test := range tests {
+		pvs, err := vql.EvalPaths(test.query, input)
+		if err != nil {
+			t.Errorf("EvalPaths(%v): unexpected error: %v", test.query, err)
+			continue
+		}
+		var got []string
+		for _, pv := range pvs {
+			val := fmt.Sprint(pv.Value)
+			if _, ok := pv.Value.(map[string]interface{}); ok {
+				val = "map[Count:3 People:[...]]"
+			}
+			got = append(got, fmt.Sprintf("%v=%s", pv.Path, val))
+
+			// Evaluating the path on the input finds the value again.
+			if v, err := vql.Eval(pv.Path, input); err != nil {
+				t.Errorf("Eval(%v): unexpected error: %v", pv.Path, err)
+			} else if diff := cmp.Diff(pv.Value, v); diff != "" && !test.computed {
+				t.Errorf("Eval(%v): (-want, +got)\n%s", pv.Path, diff)
+			}
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("EvalPaths(%v): (-want, +got)\n%s", test.query, diff)
+		}
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	type person struct{ Name, Title interface{} }
+	input := map[string]interface{}{
+		"People": []interface{}{
+			person{Name: "Alice", Title: "CEO"},
+			person{Name: "Bob", Title: "MGR"},
+			"Carol",
+		},
+	}
+	tests := []struct {
+		query    vql.Query
+		path     string
+		value    interface{}
+		sentinel error
+	}{
+		{vql.Seq{vql.Key("People"), vql.Each(vql.Key("Title"))},
+			`People[2].Title`, "Carol", vql.ErrNotStruct},
+		{vql.Seq{vql.Key("People"), vql.Index(3), vql.Key("Title")},
+			`People[3]`, input["People"], vql.ErrBadIndex},
+		{vql.Key("People", "Title"),
+			`People.Title`, input["People"], vql.ErrNotStruct},
+		{vql.Seq{vql.Key("People"), vql.Select(vql.Key("Name"))},
+			`People[0]`, person{Name: "Alice", Title: "CEO"}, vql.ErrNotBool},
+		{vql.Index(0), `[0]`, input, vql.ErrNotSequence},
+		{vql.Seq{vql.Key("People"), vql.Each(vql.Key(1))},
+			`People[0].1`, person{Name: "Alice", Title: "CEO"}, vql.ErrBadKey},
+		{vql.Seq{vql.Key("People"), vql.Index(1), vql.KeyStrict("Age")},
+			`People[1].strict("Age")`, person{Name: "Bob", Title: "MGR"}, vql.ErrNoKey},
+		{vql.Seq{vql.Key("People"), vql.Index(1), vql.Require(vql.Seq{vql.Key("Title"), vql.Eq("CEO")}, "not the boss")},
+			`People[1].require(Title == "CEO", "not the boss")`, person{Name: "Bob", Title: "MGR"}, vql.ErrFailed},
+		{vql.Seq{vql.Key("People"), vql.Each(vql.NonNil(vql.Key("Name")))},
+			`People[2].Name`, "Carol", vql.ErrNotStruct},
+		{vql.Seq{vql.Key("People"), vql.NonNil(vql.Key("Nope"))},
+			`People.Nope`, input["People"], vql.ErrNotStruct},
+		{vql.NonNil(vql.Key("Nope")), `nonNil(Nope)`, input, vql.ErrFailed},
+		{vql.Seq{vql.Key("People"), vql.Each(vql.Or{vql.Key("Title"), vql.Fail(vql.ErrNoKey)})},
+			`People[2]`, "Carol", vql.ErrNoKey},
+	}
+	for _, test := range tests {
+		_, err := vql.Eval(test.query, input)
+		var e *vql.Error
+		if !errors.As(err, &e) {
+			t.Errorf("Eval(%v): got error %v, want *vql.Error", test.query, err)
+			continue
+		}
+		if want := "at " + test.path + ": "; !strings.HasPrefix(e.Error(), want) {
+			t.Errorf("Eval(%v): got %q, want prefix %q", test.query, e.Error(), want)
+		}
+		if diff := cmp.Diff(test.value, e.Value); diff != "" {
+			t.Errorf("Eval(%v): wrong value (-want, +got)\n%s", test.query, diff)
+		}
+		if !errors.Is(err, test.sentinel) {
+			t.Errorf("Eval(%v): got %v, want %v", test.query, err, test.sentinel)
+		}
+	}
+}
+
+func TestCollectErrors(t *testing.T) {
+	type person struct{ Name, Title interface{} }
+	input := map[string]interface{}{
+		"People": []interface{}{
+			person{Name: "Alice", Title: "CEO"},
+			"Bob",
+			person{Name: "Carol"},
+		},
+	}
+	q := vql.Seq{vql.Key("People"), vql.EachLenient(vql.Seq{vql.Key("Title"), vql.NonNil(vql.Self)})}
+
+	var errs vql.Errors
+	got, err := vql.EvalWith(q, input, vql.CollectErrors(&errs))
+	if err != nil {
+		t.Fatalf("EvalWith: unexpected error: %v", err)
+	} else if diff := cmp.Diff([]interface{}{"CEO"}, got); diff != "" {
+		t.Errorf("EvalWith: (-want, +got)\n%s", diff)
+	}
+	t.Logf("Collected errors: %v", errs)
+
+	want := []struct {
+		path     string
+		sentinel error
+	}{
+		{`People[1].Title`, vql.ErrNotStruct},
+		{`People[2].Title.nonNil(self)`, vql.ErrFailed},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Got %d errors, want %d", len(errs), len(want))
+	}
+	for i, w := range want {
+		var e *vql.Error
+		if !errors.As(errs[i], &e) {
+			t.Errorf("Error %d: got %T, want *vql.Error", i, errs[i])
+		} else if got := vql.Seq(e.Path).String(); got != w.path {
+			t.Errorf("Error %d: got path %q, want %q", i, got, w.path)
+		}
+		if !errors.Is(errs[i], w.sentinel) {
+			t.Errorf("Error %d: got %v, want %v", i, errs[i], w.sentinel)
+		}
+	}
+	if !errors.Is(errs, vql.ErrNotStruct) || !errors.Is(errs, vql.ErrFailed) {
+		t.Errorf("Errors %v do not match their elements", errs)
+	}
+	wrapped := fmt.Errorf("loading people: %w", errs)
+	if !errors.Is(wrapped, vql.ErrFailed) || errors.Is(wrapped, vql.ErrLimit) {
+		t.Errorf("Wrapped errors %v do not match their elements", wrapped)
+	}
+	var qerr *vql.Error
+	if !errors.As(wrapped, &qerr) || qerr != errs[0] {
+		t.Errorf("As: got %v, want %v", qerr, errs[0])
+	}
+
+	// The Is and As methods do not depend on support for Unwrap() []error.
+	if !errs.Is(vql.ErrFailed) || errs.Is(vql.ErrLimit) {
+		t.Errorf("Errors.Is: wrong result for %v", errs)
+	}
+	if qerr = nil; !errs.As(&qerr) || qerr != errs[0] {
+		t.Errorf("Errors.As: got %v, want %v", qerr, errs[0])
+	}
+
+	// Without the option, the same result is produced.
+	if got, err := vql.Eval(q, input); err != nil {
+		t.Errorf("Eval: unexpected error: %v", err)
+	} else if diff := cmp.Diff([]interface{}{"CEO"}, got); diff != "" {
+		t.Errorf("Eval: (-want, +got)\n%s", diff)
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "prefix")
+
+	q := vql.Each(vql.Func(func(ctx context.Context, s string) (string, error) {
+		return ctx.Value(ctxKey{}).(string) + "-" + s, nil
+	}))
+	got, err := vql.EvalContext(ctx, q, []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("EvalContext: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]interface{}{"prefix-a", "prefix-b"}, got); diff != "" {
+		t.Errorf("EvalContext: (-want, +got)\n%s", diff)
+	}
+
+	// Cancellation stops evaluation part way through.
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var seen []int
+	stop := vql.Each(vql.Func(func(n int) int {
+		seen = append(seen, n)
+		if n == 2 {
+			cancel()
+		}
+		return n
+	}))
+	got, err = vql.EvalContext(cctx, stop, []int{1, 2, 3, 4})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("EvalContext: got (%v, %v), want %v", got, err, context.Canceled)
+	}
+	if diff := cmp.Diff([]int{1, 2}, seen); diff != "" {
+		t.Errorf("Elements visited: (-want, +got)\n%s", diff)
+	}
+
+	// A context-aware function works with plain Eval, too.
+	got, err = vql.Eval(vql.Func(func(ctx context.Context, s string) bool {
+		return ctx != nil
+	}), "x")
+	if err != nil || got != true {
+		t.Errorf("Eval: got (%v, %v), want (true, nil)", got, err)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	input := map[string]interface{}{
+		"people": []interface{}{
+			map[string]interface{}{"name": "ann", "age": 30},
+			map[string]interface{}{"name": "bob", "age": 25},
+		},
+	}
+	q := vql.Seq{
+		vql.Key("people"),
+		vql.Select(vql.Key("age"), vql.Gt(40)),
+		vql.Index(0),
+	}
+	var buf strings.Builder
+	if got, err := vql.EvalWith(q, input, vql.Trace(&buf)); err == nil {
+		t.Fatalf("EvalWith: got %v, want error", got)
+	}
+	const want = `  people: map[string]interface {} => []interface {} [map[age:30 name:ann] map[age:25 name:bob]]
+      age: map[string]interface {} => int 30
+      > 40: int => bool false
+    age > 40: map[string]interface {} => bool false
+      age: map[string]interface {} => int 25
+      > 40: int => bool false
+    age > 40: map[string]interface {} => bool false
+  select(age > 40): []interface {} => []interface {} []
+  [0]: []interface {} => error: index out of range: 0 is not in 0..0
+people.select(age > 40)[0]: map[string]interface {} => error: at people.select(age > 40)[0]: index out of range: 0 is not in 0..0
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("Trace: (-want, +got)\n%s", diff)
+	}
+
+	// Long values are abbreviated.
+	buf.Reset()
+	if _, err := vql.EvalWith(vql.Self, strings.Repeat("x", 100), vql.Trace(&buf)); err != nil {
+		t.Fatalf("EvalWith: unexpected error: %v", err)
+	}
+	if got, want := buf.String(), "self: string => string "+strings.Repeat("x", 57)+"...\n"; got != want {
+		t.Errorf("Trace: got %q, want %q", got, want)
+	}
+}
+
+// countObserver is an EvalObserver that counts the queries evaluated, and
+// rejects queries that call methods.
+type countObserver struct {
+	enter, exit, errs int
+}
+
+func (c *countObserver) OnEnter(q vql.Query, in interface{}) error {
+	c.enter++
+	if strings.HasPrefix(fmt.Sprint(q), "method(") {
+		return errors.New("methods are not allowed")
+	}
+	return nil
+}
+
+func (c *countObserver) OnExit(q vql.Query, in, out interface{}, err error) {
+	c.exit++
+	if err != nil {
+		c.errs++
+	}
+}
+
+func TestObserve(t *testing.T) {
+	var c countObserver
+	q := vql.Seq{vql.Key("xs"), vql.Each(vql.Mul(vql.Self, vql.Const(2))), vql.Sum(vql.Self)}
+	got, err := vql.EvalWith(q, map[string][]int{"xs": {1, 2, 3}}, vql.Observe(&c))
+	if err != nil {
+		t.Fatalf("EvalWith: unexpected error: %v", err)
+	} else if got != 12 {
+		t.Errorf("EvalWith: got %v, want 12", got)
+	}
+	if c.enter == 0 || c.enter != c.exit || c.errs != 0 {
+		t.Errorf("Observer: got enter=%d exit=%d errs=%d, want enter == exit > 0, no errors",
+			c.enter, c.exit, c.errs)
+	}
+
+	// An observer can stop evaluation of a query.
+	c = countObserver{}
+	m := vql.Seq{vql.Key("Acct"), vql.Method("Label", "#", 1)}
+	if got, err := vql.EvalWith(m, map[string]interface{}{"Acct": account{owner: "bob"}}, vql.Observe(&c)); err == nil {
+		t.Errorf("EvalWith: got %v, want error", got)
+	} else if !strings.Contains(err.Error(), "methods are not allowed") {
+		t.Errorf("EvalWith: got error %v, want policy error", err)
+	}
+	if c.errs == 0 {
+		t.Error("Observer: got no errors, want errors")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	grid := make([][]int, 20)
+	for i := range grid {
+		grid[i] = make([]int, 20)
+	}
+	nested := vql.Each(vql.Each(vql.Add(vql.Self, vql.Const(1))))
+
+	tests := []struct {
+		name string
+		q    vql.Query
+		opts []vql.Option
+		ok   bool
+	}{
+		{"NoLimits", nested, nil, true},
+		{"StepsOK", nested, []vql.Option{vql.MaxSteps(10000)}, true},
+		{"StepsExceeded", nested, []vql.Option{vql.MaxSteps(100)}, false},
+		{"DepthOK", nested, []vql.Option{vql.MaxDepth(10)}, true},
+		{"DepthExceeded", nested, []vql.Option{vql.MaxDepth(2)}, false},
+		{"Parallel", vql.EachN(vql.Each(vql.Self), 4), []vql.Option{vql.MaxDepth(5)}, true},
+		{"ParallelDepth", vql.EachN(vql.Each(vql.Self), 4), []vql.Option{vql.MaxDepth(4)}, false},
+		{"ParallelSteps", vql.EachN(vql.Each(vql.Self), 4), []vql.Option{vql.MaxSteps(50)}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := vql.EvalWith(test.q, grid, test.opts...)
+			if test.ok && err != nil {
+				t.Errorf("EvalWith: unexpected error: %v", err)
+			} else if !test.ok && !errors.Is(err, vql.ErrLimit) {
+				t.Errorf("EvalWith: got error %v, want %v", err, vql.ErrLimit)
+			}
+		})
+	}
+
+	// The depth of traversal counts toward MaxDepth, even if the query is
+	// shallow.
+	type node struct {
+		V    int
+		Next *node
+	}
+	var list *node
+	for i := 0; i < 1000; i++ {
+		list = &node{V: i, Next: list}
+	}
+	if _, err := vql.EvalWith(vql.Descend(vql.Key("V")), list, vql.MaxDepth(3)); !errors.Is(err, vql.ErrLimit) {
+		t.Errorf("EvalWith(Descend): got error %v, want %v", err, vql.ErrLimit)
+	}
+	if got, err := vql.EvalWith(vql.Descend(vql.Key("V")), list, vql.MaxDepth(5000)); err != nil {
+		t.Errorf("EvalWith(Descend): unexpected error: %v", err)
+	} else if n := len(got.([]interface{})); n != 1000 {
+		t.Errorf("EvalWith(Descend): got %d values, want 1000", n)
+	}
+}
+
+type graphNode struct {
+	ID    int
+	Edges []*graphNode
+}
+
+func TestDetectCycles(t *testing.T) {
+	a := &graphNode{ID: 1}
+	b := &graphNode{ID: 2}
+	c := &graphNode{ID: 3}
+	a.Edges = []*graphNode{b, c}
+	b.Edges = []*graphNode{c, a} // cycle through a
+	c.Edges = []*graphNode{c}    // self-loop
+
+	self := []interface{}{"x", nil}
+	self[1] = self
+
+	ids := vql.Descend(vql.Key("ID"))
+	tests := []struct {
+		query vql.Query
+		input interface{}
+		want  interface{}
+	}{
+		// c is shared by a and b, so it is visited in each place.
+		{ids, a, []interface{}{1, 2, 3, 3}},
+		{ids, map[string]*graphNode{"p": c, "q": c}, []interface{}{3, 3}},
+		{vql.Descend(vql.Select(vql.TypeIs[string]())), self, []interface{}{[]interface{}{"x"}}},
+	}
+	for _, test := range tests {
+		got, err := vql.EvalWith(test.query, test.input, vql.DetectCycles())
+		if err != nil {
+			t.Errorf("EvalWith: unexpected error: %v", err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("EvalWith: (-want, +got)\n%s", diff)
+		}
+	}
+}
+
+func (a account) Explode(msg string) string { panic(msg) }
+
+func TestPanicRecovery(t *testing.T) {
+	errBoom := errors.New("boom")
+	tests := []struct {
+		name  string
+		query vql.Query
+		input interface{}
+		fn    string // substring of PanicError.Func
+		value interface{}
+	}{
+		{"Func", vql.Seq{vql.Key("xs"), vql.Each(vql.Func(func(n int) int { return 10 / n }))},
+			map[string][]int{"xs": {1, 0}}, "TestPanicRecovery", nil},
+		{"FuncError", vql.Func(func(string) bool { panic(errBoom) }), "x", "TestPanicRecovery", errBoom},
+		{"Method", vql.Seq{vql.Key("Acct"), vql.Method("Explode", "ouch")},
+			map[string]account{"Acct": {owner: "zed"}}, `method "Explode"`, "ouch"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := vql.Eval(test.query, test.input)
+			var perr *vql.PanicError
+			if !errors.As(err, &perr) {
+				t.Fatalf("Eval: got (%v, %v), want a *PanicError", got, err)
+			}
+			if !strings.Contains(perr.Func, test.fn) {
+				t.Errorf("PanicError.Func: got %q, want it to contain %q", perr.Func, test.fn)
+			}
+			if test.value != nil && perr.Value != test.value {
+				t.Errorf("PanicError.Value: got %v, want %v", perr.Value, test.value)
+			}
+			if len(perr.Stack) == 0 {
+				t.Error("PanicError.Stack is empty")
+			}
+			var qerr *vql.Error
+			if !errors.As(err, &qerr) || len(qerr.Path) == 0 {
+				t.Errorf("Eval: error %v does not record a query path", err)
+			}
+		})
+	}
+	if err := errors.Unwrap(&vql.PanicError{Value: errBoom}); err != errBoom {
+		t.Errorf("Unwrap: got %v, want %v", err, errBoom)
+	}
+}
+
+func TestFuncArgs(t *testing.T) {
+	truncate := func(s string, n int) string {
+		if len(s) > n {
+			return s[:n]
+		}
+		return s
+	}
+	scale := func(ctx context.Context, x float64, by float64) (float64, error) {
+		return x * by, ctx.Err()
+	}
+	join := func(s string, rest ...string) string { return strings.Join(append([]string{s}, rest...), "-") }
+	sum := func(xs ...int) int {
+		var n int
+		for _, x := range xs {
+			n += x
+		}
+		return n
+	}
+
+	tests := []struct {
+		query vql.Query
+		input interface{}
+		want  interface{}
+	}{
+		{vql.FuncArgs(truncate, 3), "abcdef", "abc"},
+		{vql.FuncArgs(truncate, int64(10)), "abc", "abc"}, // numeric conversion
+		{vql.FuncArgs(scale, 2), 1.5, 3.0},
+		{vql.FuncArgs(join), "a", "a"},
+		{vql.FuncArgs(join, "b", "c"), "a", "a-b-c"},
+		{vql.Func(sum), []int{1, 2, 3}, 6},
+		{vql.Each(vql.FuncArgs(truncate, 1)), []string{"xy", "zw"}, []interface{}{"x", "z"}},
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(test.query, test.input)
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval(%v): (-want, +got)\n%s", test.query, diff)
+		}
+	}
+
+	for _, bad := range []func(){
+		func() { vql.FuncArgs(truncate) },                // too few arguments
+		func() { vql.FuncArgs(truncate, 1, 2) },          // too many arguments
+		func() { vql.FuncArgs(truncate, "x") },           // wrong argument type
+		func() { vql.Func(truncate) },                    // Func does not supply arguments
+		func() { vql.FuncArgs(func() int { return 1 }) }, // no input parameter
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("FuncArgs did not panic")
+				}
+			}()
+			bad()
+		}()
+	}
+}
+
+type ptrStringer struct{ name string }
+
+func (p *ptrStringer) String() string { return "ptr:" + p.name }
+
+func TestFuncInterface(t *testing.T) {
+	describe := vql.Func(func(s fmt.Stringer) string {
+		if s == nil {
+			return "<none>"
+		}
+		return s.String()
+	})
+
+	tests := []struct {
+		input interface{}
+		want  interface{}
+	}{
+		{time.Duration(90 * time.Second), "1m30s"}, // value receiver
+		{&ptrStringer{"a"}, "ptr:a"},               // pointer receiver
+		{ptrStringer{"b"}, "ptr:b"},                // pointer to a copy
+		{nil, "<none>"},                            // nil input
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(describe, test.input)
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", test.input, err)
+		} else if got != test.want {
+			t.Errorf("Eval(%v): got %v, want %v", test.input, got, test.want)
+		}
+	}
+
+	// An input that does not implement the interface is an error.
+	if got, err := vql.Eval(describe, 25); !errors.Is(err, vql.ErrArgType) {
+		t.Errorf("Eval(25): got (%v, %v), want %v", got, err, vql.ErrArgType)
+	} else if !strings.Contains(err.Error(), "does not implement fmt.Stringer") {
+		t.Errorf("Eval(25): got error %v, want it to mention fmt.Stringer", err)
+	}
+
+	// Compile accepts a static type whose pointer implements the interface.
+	if _, err := vql.Compile(describe, reflect.TypeOf(ptrStringer{})); err != nil {
+		t.Errorf("Compile: unexpected error: %v", err)
+	}
+	if _, err := vql.Compile(describe, reflect.TypeOf(0)); !errors.Is(err, vql.ErrArgType) {
+		t.Errorf("Compile: got %v, want %v", err, vql.ErrArgType)
+	}
+}
+
+func TestSpecialize(t *testing.T) {
+	calls := 0
+	q := vql.Specialize(vql.Key("Name"), func(v interface{}) (interface{}, bool) {
+		if p, ok := v.(*account); ok {
+			calls++
+			return "fast:" + p.owner, true
+		}
+		return nil, false
+	})
+	if got, want := fmt.Sprint(q), "Name"; got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+
+	// The function handles the inputs it accepts.
+	if got, err := vql.Eval(q, &account{owner: "ann"}); err != nil || got != "fast:ann" {
+		t.Errorf("Eval: got (%v, %v), want (fast:ann, nil)", got, err)
+	}
+	// Other inputs are evaluated by the original query.
+	if got, err := vql.Eval(q, map[string]string{"Name": "bob"}); err != nil || got != "bob" {
+		t.Errorf("Eval: got (%v, %v), want (bob, nil)", got, err)
+	}
+	// Observed evaluations use the original query.
+	var buf strings.Builder
+	if got, err := vql.EvalWith(q, &account{owner: "cy"}, vql.Trace(&buf)); err != nil || got != nil {
+		t.Errorf("EvalWith: got (%v, %v), want (nil, nil)", got, err)
+	}
+	if calls != 1 {
+		t.Errorf("Specialized function called %d times, want 1", calls)
+	}
+}
+
+func TestGenericJSON(t *testing.T) {
+	var input interface{}
+	if err := json.Unmarshal([]byte(`{
+  "name": "root",
+  "items": [{"id": 1, "tag": "a"}, {"id": 2}, {"tag": "c"}],
+  "meta": {"z": true, "a": null}
+}`), &input); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	tests := []struct {
+		query vql.Query
+		want  interface{}
+	}{
+		{vql.Key("name"), "root"},
+		{vql.Key("missing"), nil},
+		{vql.Key("items", 0, "id"), 1.0},
+		{vql.Key("items", -3, "tag"), "a"},
+		{vql.Key("items", 5), nil},
+		{vql.Seq{vql.Key("items"), vql.Index(-2), vql.Key("id")}, 2.0},
+		{vql.Seq{vql.Key("items"), vql.Each(vql.Key("id"))}, []interface{}{1.0, 2.0, nil}},
+		{vql.Seq{vql.Key("meta"), vql.Each(vql.Key("Key"))}, []interface{}{"a", "z"}},
+		{vql.Seq{vql.Map{"x": vql.Key("name")}, vql.Key("x")}, "root"},
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(test.query, input)
+		if err != nil {
+			t.Errorf("Eval %v: unexpected error: %v", test.query, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Eval %v: got %#v, want %#v", test.query, got, test.want)
+		}
+	}
+
+	if _, err := vql.Eval(vql.KeyStrict("missing"), input); !errors.Is(err, vql.ErrNoKey) {
+		t.Errorf("KeyStrict: got %v, want %v", err, vql.ErrNoKey)
+	}
+	if _, err := vql.Eval(vql.Seq{vql.Key("items"), vql.Index(3)}, input); !errors.Is(err, vql.ErrBadIndex) {
+		t.Errorf("Index: got %v, want %v", err, vql.ErrBadIndex)
+	}
+}
+
+func BenchmarkGenericJSON(b *testing.B) {
+	var input interface{}
+	if err := json.Unmarshal([]byte(`{"items": [{"id": 1}, {"id": 2}, {"id": 3}, {"id": 4}]}`), &input); err != nil {
+		b.Fatalf("Unmarshal: %v", err)
+	}
+	q := vql.Seq{vql.Key("items"), vql.Each(vql.Key("id"))}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		vql.Eval(q, input)
+	}
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	type item struct {
+		ID    int
+		Label string
+	}
+	input := make([]*item, 1000)
+	for i := range input {
+		input[i] = &item{ID: i, Label: fmt.Sprint("item", i)}
+	}
+	q := vql.Seq{
+		vql.Select(vql.Key("ID"), vql.Func(func(id int) bool { return id%2 == 0 })),
+		vql.Each(vql.Key("Label")),
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := vql.Eval(q, input); err != nil {
+			b.Fatalf("Eval: %v", err)
+		}
+	}
+}
+
+func TestEvalValue(t *testing.T) {
+	type item struct {
+		Name  string
+		Count int
+	}
+	type order struct {
+		ID    string
+		Items []item
+		Meta  map[string]string
+		Note  interface{}
+	}
+	input := &order{
+		ID:    "o1",
+		Items: []item{{Name: "widget", Count: 2}, {Name: "gadget", Count: 1}},
+		Meta:  map[string]string{"src": "web"},
+	}
+
+	// A field reached through a pointer is addressable, and can be modified.
+	got, err := vql.EvalValue(vql.Key("Items", 1, "Count"), reflect.ValueOf(input))
+	if err != nil {
+		t.Fatalf("EvalValue: unexpected error: %v", err)
+	} else if !got.CanSet() {
+		t.Fatalf("EvalValue: result %v is not settable", got)
+	}
+	got.SetInt(5)
+	if input.Items[1].Count != 5 {
+		t.Errorf("After SetInt: Count is %d, want 5", input.Items[1].Count)
+	}
+
+	// The result has the declared type of its location.
+	if got, err := vql.EvalValue(vql.Key("Note"), reflect.ValueOf(input)); err != nil {
+		t.Errorf("EvalValue: unexpected error: %v", err)
+	} else if got.Kind() != reflect.Interface || !got.CanSet() {
+		t.Errorf("EvalValue: got %v (%v), want a settable interface{}", got, got.Kind())
+	}
+
+	// Values not located in the input are copies.
+	tests := []struct {
+		query vql.Query
+		want  interface{}
+	}{
+		{vql.Seq{vql.Key("Items"), vql.Each(vql.Key("Name"))}, []interface{}{"widget", "gadget"}},
+		{vql.Key("Meta", "src"), "web"},
+		{vql.Seq{vql.Key("ID"), vql.Func(strings.ToUpper)}, "O1"},
+		{vql.Key("Missing"), nil},
+	}
+	for _, test := range tests {
+		got, err := vql.EvalValue(test.query, reflect.ValueOf(input))
+		if err != nil {
+			t.Errorf("EvalValue %v: unexpected error: %v", test.query, err)
+			continue
+		} else if got.CanAddr() {
+			t.Errorf("EvalValue %v: result is addressable", test.query)
+		}
+		var obj interface{}
+		if got.IsValid() {
+			obj = got.Interface()
+		}
+		if !reflect.DeepEqual(obj, test.want) {
+			t.Errorf("EvalValue %v: got %#v, want %#v", test.query, obj, test.want)
+		}
+	}
+
+	// An input that is not addressable gives results that are not.
+	if got, err := vql.EvalValue(vql.Key("ID"), reflect.ValueOf(*input)); err != nil {
+		t.Errorf("EvalValue: unexpected error: %v", err)
+	} else if got.String() != "o1" || got.CanSet() {
+		t.Errorf("EvalValue: got %v (settable %v), want o1 (not settable)", got, got.CanSet())
+	}
+}
+
+func BenchmarkKeyField(b *testing.B) {
+	type base struct {
+		A, B, C, D, E, F, G, H string
+		Last                   int
+	}
+	type wide struct {
+		base
+		I, J, K, L, M, N, O, P string
+	}
+	input := make([]wide, 100)
+	for i := range input {
+		input[i].Last = i
+	}
+	q := vql.Each(vql.Key("Last"))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := vql.Eval(q, input); err != nil {
+			b.Fatalf("Eval: %v", err)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	input := map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"id": 1, "name": "ann"},
+			map[string]interface{}{"id": 2, "name": "bob"},
+			map[string]interface{}{"id": 3, "name": "cy"},
+			map[string]interface{}{"name": "dee"},
+		},
+		"roles": []interface{}{
+			map[string]interface{}{"user_id": 2, "role": "admin"},
+			map[string]interface{}{"user_id": 1, "role": "dev"},
+			map[string]interface{}{"user_id": 2, "role": "dev"},
+			map[string]interface{}{"role": "guest"},
+		},
+		"tags": map[string][]string{"a": {"x"}, "b": {"y"}},
+	}
+	pairs := vql.Each(vql.List{vql.Key("Left", "name"), vql.Key("Right", "role")})
+	tests := []struct {
+		query vql.Query
+		want  interface{}
+	}{
+		{vql.Seq{vql.Join{
+			Left:     vql.Key("users"),
+			Right:    vql.Key("roles"),
+			LeftKey:  vql.Key("id"),
+			RightKey: vql.Key("user_id"),
+		}, pairs}, []interface{}{
+			[]interface{}{"ann", "dev"},
+			[]interface{}{"bob", "admin"},
+			[]interface{}{"bob", "dev"},
+		}},
+		{vql.Seq{vql.Join{
+			Left:     vql.Key("users"),
+			Right:    vql.Key("roles"),
+			LeftKey:  vql.Key("id"),
+			RightKey: vql.Key("user_id"),
+			Outer:    true,
+		}, vql.Each(vql.List{
+			vql.Key("Left", "name"),
+			vql.DefaultErr(vql.Key("Right", "role"), nil, vql.ErrNotStruct),
+		})}, []interface{}{
+			[]interface{}{"ann", "dev"},
+			[]interface{}{"bob", "admin"},
+			[]interface{}{"bob", "dev"},
+			[]interface{}{"cy", nil},
+			[]interface{}{"dee", nil},
+		}},
+
+		// With no keys, the elements themselves are matched.
+		{vql.Join{Left: vql.Const([]int{1, 2, 3}), Right: vql.Const([]int{3, 1, 1})}, []interface{}{
+			vql.Values{"Left": 1, "Right": 1},
+			vql.Values{"Left": 1, "Right": 1},
+			vql.Values{"Left": 3, "Right": 3},
+		}},
+
+		// Keys that are not hashable are compared by value.
+		{vql.Seq{vql.Join{
+			Left:    vql.Key("tags"),
+			Right:   vql.Key("tags"),
+			LeftKey: vql.Key("Value"),
+		}, vql.Each(vql.List{vql.Key("Left", "Key"), vql.Key("Right", "Key")})}, []interface{}{
+			[]interface{}{"a", "a"},
+			[]interface{}{"b", "b"},
+		}},
+		{vql.Join{Left: vql.Const([]int{}), Right: vql.Const([]int{1})}, []interface{}{}},
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(test.query, input)
+		if err != nil {
+			t.Errorf("Eval %v: unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval %v: result (-want, +got):\n%s", test.query, diff)
+		}
+	}
+
+	if _, err := vql.Eval(vql.Join{Left: vql.Key("users"), Right: vql.Const(5)}, input); !errors.Is(err, vql.ErrNotCollection) {
+		t.Errorf("Join: got %v, want %v", err, vql.ErrNotCollection)
+	}
+}
+
+func TestSetOps(t *testing.T) {
+	type host struct{ Name, Zone string }
+	input := map[string]interface{}{
+		"a":     []string{"x", "y", "z", "y"},
+		"b":     []interface{}{"y", "w"},
+		"c":     [2]string{"y", "z"},
+		"hosts": []host{{"m1", "east"}, {"m2", "west"}, {"m3", "east"}},
+		"live":  []interface{}{map[string]interface{}{"name": "m2"}, map[string]interface{}{"name": "m3"}},
+		"lists": []interface{}{[]int{1}, []int{2}, []int{1}},
+	}
+	tests := []struct {
+		query vql.Query
+		want  interface{}
+	}{
+		{vql.Union(vql.Key("a"), vql.Key("b")), []interface{}{"x", "y", "z", "w"}},
+		{vql.Intersect(vql.Key("a"), vql.Key("b"), vql.Key("c")), []interface{}{"y"}},
+		{vql.Intersect(vql.Key("a"), vql.Key("c")), []interface{}{"y", "z"}},
+		{vql.Difference(vql.Key("a"), vql.Key("b")), []interface{}{"x", "z"}},
+		{vql.Difference(vql.Key("a"), vql.Key("b"), vql.Key("c")), []interface{}{"x"}},
+		{vql.Difference(vql.Key("a")), []interface{}{"x", "y", "z"}},
+		{vql.Union(), []interface{}{}},
+		{vql.Intersect(), []interface{}{}},
+
+		// Elements that are not hashable are compared by value.
+		{vql.Union(vql.Key("lists")), []interface{}{[]int{1}, []int{2}}},
+
+		// Keys select the values compared.
+		{vql.UnionBy(vql.Key("Zone"), vql.Key("hosts")), []interface{}{host{"m1", "east"}, host{"m2", "west"}}},
+		{vql.DifferenceBy(vql.Or{vql.Key("Name"), vql.Key("name")}, vql.Key("hosts"), vql.Key("live")),
+			[]interface{}{host{"m1", "east"}}},
+		{vql.IntersectBy(vql.Or{vql.Key("Name"), vql.Key("name")}, vql.Key("hosts"), vql.Key("live")),
+			[]interface{}{host{"m2", "west"}, host{"m3", "east"}}},
+	}
+	for _, test := range tests {
+		got, err := vql.Eval(test.query, input)
+		if err != nil {
+			t.Errorf("Eval %v: unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval %v: result (-want, +got):\n%s", test.query, diff)
+		}
+	}
+
+	if _, err := vql.Eval(vql.Union(vql.Key("a"), vql.Const(1)), input); !errors.Is(err, vql.ErrNotCollection) {
+		t.Errorf("Union: got %v, want %v", err, vql.ErrNotCollection)
+	}
+}
@@ -0,0 +1,165 @@
+package vql
+
+// Walk visits q and each of the subqueries nested within it in depth-first
+// order, calling fn for each query before visiting its subqueries. If fn
+// returns false, the subqueries of that query are not visited. The subqueries
+// of a Map are visited in order of their names.
+//
+// Walk allows tools to inspect the structure of a query, for example to find
+// the names of the functions it refers to with FuncRef. Each query visited may
+// be rendered with its String method or encoded with MarshalQuery.
+func Walk(q Query, fn func(Query) bool) {
+	if q == nil || !fn(q) {
+		return
+	}
+	for _, sub := range subqueries(q) {
+		Walk(sub, fn)
+	}
+}
+
+// subqueries returns the queries nested directly within q, if any.
+func subqueries(q Query) []Query {
+	switch t := q.(type) {
+	case Seq:
+		return t
+	case Or:
+		return t
+	case List:
+		return t
+	case Cat:
+		return t
+	case zipQuery:
+		return t
+	case logicQuery:
+		return t.qs
+	case arithQuery:
+		return t.qs
+	case sprintfQuery:
+		return t.args
+	case defaultQuery:
+		return []Query{t.q}
+	case requireQuery:
+		return []Query{t.q}
+	case nonNilQuery:
+		return []Query{t.Query}
+	case mapQuery:
+		return []Query{t.Query}
+	case lenientMapQuery:
+		return []Query{t.Query}
+	case indexedMapQuery:
+		return []Query{t.Query}
+	case typedMapQuery:
+		return []Query{t.Query}
+	case typedSelectQuery:
+		return []Query{t.Query}
+	case parMapQuery:
+		return []Query{t.Query}
+	case selectQuery:
+		return []Query{t.Query}
+	case selectMapQuery:
+		return []Query{t.Query}
+	case partitionQuery:
+		return []Query{t.Query}
+	case firstQuery:
+		return []Query{t.Query}
+	case remapQuery:
+		return []Query{t.Query}
+	case quantQuery:
+		return []Query{t.Query}
+	case notQuery:
+		return []Query{t.Query}
+	case *memoQuery:
+		return []Query{t.Query}
+	case descendQuery:
+		return []Query{t.Query}
+	case groupQuery:
+		return []Query{t.Query}
+	case distinctQuery:
+		return []Query{t.Query}
+	case aggQuery:
+		return []Query{t.Query}
+	case sortQuery:
+		if t.key != nil {
+			return []Query{t.key}
+		}
+		return []Query{t.less}
+	case mutateQuery:
+		return []Query{t.path}
+	case Prepared:
+		return []Query{t.q}
+	case specialQuery:
+		return []Query{t.q}
+	case Map:
+		subs := make([]Query, 0, len(t))
+		for _, name := range sortedKeys(t) {
+			subs = append(subs, t[name])
+		}
+		return subs
+	case letQuery:
+		return []Query{t.q, t.body}
+	case buildQuery:
+		return templateQueries(t.tmpl)
+	case Join:
+		return t.args()
+	case setQuery:
+		if t.key != nil {
+			return append([]Query{t.key}, t.qs...)
+		}
+		return t.qs
+	case Switch:
+		subs := []Query{t.On}
+		for _, key := range t.caseKeys() {
+			subs = append(subs, t.Cases[key])
+		}
+		if t.Default != nil {
+			subs = append(subs, t.Default)
+		}
+		return subs
+	}
+	return nil
+}
+
+// templateQueries returns the queries within tmpl, in order.
+func templateQueries(tmpl interface{}) []Query {
+	var m map[string]interface{}
+	switch t := tmpl.(type) {
+	case Query:
+		return []Query{t}
+	case map[string]interface{}:
+		m = t
+	case Values:
+		m = t
+	case []interface{}:
+		var qs []Query
+		for _, elt := range t {
+			qs = append(qs, templateQueries(elt)...)
+		}
+		return qs
+	default:
+		return nil
+	}
+	var qs []Query
+	for _, name := range sortedKeys(m) {
+		qs = append(qs, templateQueries(m[name])...)
+	}
+	return qs
+}
+
+// CallsMethod reports whether q or any of the subqueries nested within it
+// calls a method of its input, as a Method query does. Methods may have
+// side-effects, so a service that evaluates queries from untrusted sources
+// may use CallsMethod to reject them.
+func CallsMethod(q Query) bool {
+	found := false
+	Walk(q, func(sub Query) bool {
+		found = isMethod(sub)
+		return !found
+	})
+	return found
+}
+
+// isMethod reports whether q is a Method query.
+func isMethod(q Query) bool {
+	_, ok := q.(methodQuery)
+	return ok
+}
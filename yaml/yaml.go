@@ -0,0 +1,24 @@
+// Package yaml evaluates vql queries on YAML documents.
+package yaml
+
+import (
+	"github.com/creachadair/vql"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Eval decodes data as a YAML document and evaluates q starting from the
+// decoded value, as vql.EvalWith. The document is decoded as by the
+// gopkg.in/yaml.v3 package into an interface{}, so mappings become
+// map[string]interface{}, or map[interface{}]interface{} if any of their keys
+// is not a string, and sequences become []interface{}. If data contains
+// several documents, only the first is used.
+//
+// If data cannot be decoded, Eval reports a *vql.DecodeError; if the query
+// fails, it reports a *vql.Error, as vql.Eval does.
+func Eval(q vql.Query, data []byte, opts ...vql.Option) (interface{}, error) {
+	var v interface{}
+	if err := yamlv3.Unmarshal(data, &v); err != nil {
+		return nil, &vql.DecodeError{Format: "YAML", Err: err}
+	}
+	return vql.EvalWith(q, v, opts...)
+}
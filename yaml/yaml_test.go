@@ -0,0 +1,57 @@
+package yaml_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/creachadair/vql"
+	"github.com/creachadair/vql/yaml"
+	"github.com/google/go-cmp/cmp"
+)
+
+const doc = `
+services:
+  web:
+    image: nginx
+    ports: [80, 443]
+  db:
+    image: postgres
+    ports: [5432]
+codes:
+  1: one
+  2: two
+`
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		query vql.Query
+		want  interface{}
+	}{
+		{vql.Key("services", "web", "image"), "nginx"},
+		{vql.Seq{vql.Key("services", "web", "ports"), vql.Index(-1)}, 443},
+		{vql.Seq{vql.Key("services"), vql.Each(vql.Key("Key"))}, []interface{}{"db", "web"}},
+		{vql.Seq{vql.Key("codes"), vql.Index(2)}, "two"},
+	}
+	for _, test := range tests {
+		got, err := yaml.Eval(test.query, []byte(doc))
+		if err != nil {
+			t.Errorf("Eval(%v): unexpected error: %v", test.query, err)
+		} else if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Eval(%v): (-want, +got)\n%s", test.query, diff)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var derr *vql.DecodeError
+	if got, err := yaml.Eval(vql.Self, []byte("a: [1, 2")); !errors.As(err, &derr) {
+		t.Errorf("Eval: got (%v, %v), want *DecodeError", got, err)
+	} else if derr.Format != "YAML" {
+		t.Errorf("DecodeError format: got %q, want YAML", derr.Format)
+	}
+
+	var qerr *vql.Error
+	if got, err := yaml.Eval(vql.KeyStrict("nonesuch"), []byte(doc)); !errors.As(err, &qerr) {
+		t.Errorf("Eval: got (%v, %v), want *Error", got, err)
+	}
+}